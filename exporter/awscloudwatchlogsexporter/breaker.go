@@ -0,0 +1,140 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"errors"
+	"time"
+)
+
+// breakerState is the state of the circuit breaker, recorded as the value of the breaker state metric
+type breakerState int64
+
+const (
+	// breakerClosed lets the pushes through
+	breakerClosed breakerState = iota
+	// breakerOpen rejects the pushes until the cool-down elapses
+	breakerOpen
+	// breakerHalfOpen lets a single push through to probe CloudWatch Logs
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// errCircuitOpen is returned instead of pushing while the breaker is open. It is retryable, so the exporter
+// helper retries the batch later, by which time the breaker may let it through.
+var errCircuitOpen = errors.New("circuit breaker is open, CloudWatch Logs is failing")
+
+// circuitBreaker stops the pushes to CloudWatch Logs after consecutive failures, so that a persistent outage
+// does not waste requests and flood the logs. It is not safe for concurrent use, the exporter guards it with the
+// pusher lock.
+type circuitBreaker struct {
+	failureThreshold int
+	coolDown         time.Duration
+	// onStateChange is called when the breaker moves to another state
+	onStateChange func(breakerState)
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// probing is set while the push probing CloudWatch Logs in the half-open state is in flight
+	probing bool
+}
+
+func newCircuitBreaker(settings CircuitBreakerSettings, onStateChange func(breakerState)) *circuitBreaker {
+	if settings.FailureThreshold == 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		failureThreshold: settings.FailureThreshold,
+		coolDown:         settings.CoolDown,
+		onStateChange:    onStateChange,
+	}
+}
+
+// allow reports whether the events of an export may be pushed. Once the cool-down elapses, the breaker half-opens
+// and lets a single export through until the outcome of its push is recorded. A nil breaker allows every export.
+func (b *circuitBreaker) allow() bool {
+	if b.blocked() {
+		return false
+	}
+	if b == nil || b.state == breakerClosed {
+		return true
+	}
+	// the breaker is half-open
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// blocked reports whether the breaker is open and its cool-down has not elapsed, half-opening it otherwise.
+// Unlike allow, it does not take the probe of the half-open state, it lets the events pending already be pushed.
+func (b *circuitBreaker) blocked() bool {
+	if b == nil {
+		return false
+	}
+	if b.state == breakerOpen && now().Sub(b.openedAt) >= b.coolDown {
+		b.setState(breakerHalfOpen)
+	}
+	return b.state == breakerOpen
+}
+
+// record updates the breaker with the outcome of a push. A success closes it, and a failure opens it when it
+// is half-open or when the failures reach the threshold.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = now()
+		b.setState(breakerOpen)
+	}
+}
+
+// cancel releases the probe of the half-open breaker when the push was cancelled, as its outcome is unknown.
+func (b *circuitBreaker) cancel() {
+	if b == nil {
+		return
+	}
+	b.probing = false
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
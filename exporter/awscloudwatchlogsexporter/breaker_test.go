@@ -0,0 +1,198 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+var errPutLogEvents = errors.New("ServiceUnavailableException: service unavailable")
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := time.Unix(1609763415, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return clock }
+
+	var states []breakerState
+	b := newCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 3, CoolDown: time.Minute}, func(state breakerState) {
+		states = append(states, state)
+	})
+
+	// a success resets the consecutive failures
+	for _, err := range []error{errPutLogEvents, errPutLogEvents, nil, errPutLogEvents, errPutLogEvents} {
+		require.True(t, b.allow())
+		b.record(err)
+	}
+	assert.Equal(t, breakerClosed, b.state)
+	require.True(t, b.allow())
+	b.record(errPutLogEvents)
+	assert.Equal(t, breakerOpen, b.state)
+
+	// the exports are rejected until the cool-down elapses
+	clock = clock.Add(59 * time.Second)
+	assert.False(t, b.allow())
+	assert.True(t, b.blocked())
+
+	// then a single export probes CloudWatch Logs, and opens the breaker again when it fails
+	clock = clock.Add(time.Second)
+	assert.True(t, b.allow())
+	assert.Equal(t, breakerHalfOpen, b.state)
+	assert.False(t, b.allow())
+	b.record(errPutLogEvents)
+	assert.Equal(t, breakerOpen, b.state)
+	assert.False(t, b.allow())
+
+	// a successful probe closes the breaker
+	clock = clock.Add(time.Minute)
+	assert.False(t, b.blocked())
+	assert.True(t, b.allow())
+	b.record(nil)
+	assert.Equal(t, breakerClosed, b.state)
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+
+	assert.Equal(t, []breakerState{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}, states)
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreakerSettings{CoolDown: time.Minute}, nil)
+	assert.Nil(t, b)
+	for i := 0; i < 10; i++ {
+		assert.True(t, b.allow())
+		b.record(errPutLogEvents)
+	}
+	assert.False(t, b.blocked())
+}
+
+// failingPusher fails its flushes while err is set
+type failingPusher struct {
+	err     error
+	flushes int
+}
+
+func (p *failingPusher) AddLogEntry(*cwlogs.Event) error {
+	return nil
+}
+
+func (p *failingPusher) ForceFlush(_ context.Context) error {
+	p.flushes++
+	return p.err
+}
+
+func TestConsumeLogsCircuitBreaker(t *testing.T) {
+	require.NoError(t, view.Register(MetricViews()...))
+	clock := time.Unix(1609763415, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return clock }
+
+	ctx := context.Background()
+	pusher := &failingPusher{err: errPutLogEvents}
+	exp := &exporter{
+		Config: &Config{
+			ExporterSettings: config.NewExporterSettings(config.NewComponentIDWithName(typeStr, "breaker")),
+			CircuitBreaker:   CircuitBreakerSettings{FailureThreshold: 2, CoolDown: time.Minute},
+		},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	exp.breaker = newCircuitBreaker(exp.Config.CircuitBreaker, exp.onBreakerStateChange)
+
+	assert.Equal(t, errPutLogEvents, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Equal(t, errPutLogEvents, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Equal(t, float64(breakerOpen), breakerStateValue(t, "awscloudwatchlogs/breaker"))
+
+	// CloudWatch Logs is not called while the breaker is open
+	assert.Equal(t, errCircuitOpen, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Equal(t, 2, pusher.flushes)
+
+	clock = clock.Add(time.Minute)
+	pusher.err = nil
+	require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Equal(t, 3, pusher.flushes)
+	assert.Equal(t, float64(breakerClosed), breakerStateValue(t, "awscloudwatchlogs/breaker"))
+}
+
+func TestConsumeLogsCircuitBreakerCoalescing(t *testing.T) {
+	clock := time.Unix(1609763415, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return clock }
+
+	ctx := context.Background()
+	pusher := &failingPusher{err: errPutLogEvents}
+	exp := &exporter{
+		Config: &Config{Coalescing: CoalescingSettings{Window: time.Hour}},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	exp.breaker = newCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 1, CoolDown: time.Minute}, nil)
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+	defer exp.Shutdown(ctx)
+
+	require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Error(t, exp.flush(context.Background(), takePushers(exp)))
+	assert.Equal(t, errCircuitOpen, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+
+	// the export probing CloudWatch Logs is coalesced, the breaker waits for its push
+	clock = clock.Add(time.Minute)
+	require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Equal(t, errCircuitOpen, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	pusher.err = nil
+	exp.pusherLock.Lock()
+	assert.False(t, exp.breaker.blocked())
+	exp.pusherLock.Unlock()
+	require.NoError(t, exp.flush(context.Background(), takePushers(exp)))
+	require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+}
+
+func TestConsumeLogsCircuitBreakerCancelled(t *testing.T) {
+	exp := &exporter{
+		Config: &Config{},
+		logger: zap.NewNop(),
+	}
+	exp.breaker = newCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 1, CoolDown: time.Minute}, nil)
+
+	// the cancelled push is not a failure of CloudWatch Logs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pusher := &failingPusher{err: ctx.Err()}
+	assert.Equal(t, context.Canceled, exp.flush(ctx, []keyedPusher{{exp.defaultPusherKey(), pusher}}))
+	assert.Equal(t, breakerClosed, exp.breaker.state)
+	assert.Equal(t, 0, exp.breaker.failures)
+}
+
+func breakerStateValue(t *testing.T, exporter string) float64 {
+	rows, err := view.RetrieveData(mCircuitBreakerState.Name())
+	require.NoError(t, err)
+	for _, row := range rows {
+		if len(row.Tags) == 1 && row.Tags[0] == (tag.Tag{Key: exporterTagKey, Value: exporter}) {
+			return row.Data.(*view.LastValueData).Value
+		}
+	}
+	t.Fatalf("no circuit breaker state recorded for %s", exporter)
+	return 0
+}
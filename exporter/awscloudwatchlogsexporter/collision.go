@@ -0,0 +1,45 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+const (
+	// FieldCollisionPrefix writes the promoted attributes named after a fixed field under a prefixed name
+	FieldCollisionPrefix = "prefix"
+	// FieldCollisionSkip leaves the promoted attributes named after a fixed field out of the events
+	FieldCollisionSkip = "skip"
+	// FieldCollisionOverwrite writes the promoted attributes named after a fixed field in place of the fixed field
+	FieldCollisionOverwrite = "overwrite"
+)
+
+// collisionPrefix is the prefix of the name of the promoted attributes named after a fixed field, with the prefix
+// collision policy
+const collisionPrefix = "attr_"
+
+// promotedFieldName returns the name of the top-level field promoting the attribute key, after the field collision
+// policy when the key is the name of one of the reserved fixed fields, or false when the field is skipped
+func (config *Config) promotedFieldName(key string, reserved map[string]bool) (string, bool) {
+	if !reserved[key] {
+		return key, true
+	}
+	switch config.FieldCollision {
+	case FieldCollisionSkip:
+		return "", false
+	case FieldCollisionOverwrite:
+		// MarshalJSON omits the fixed field
+		return key, true
+	default:
+		return collisionPrefix + key, true
+	}
+}
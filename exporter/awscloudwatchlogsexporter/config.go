@@ -16,7 +16,13 @@ package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelem
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 	"go.uber.org/zap"
@@ -32,27 +38,442 @@ type Config struct {
 
 	// LogGroupName is the name of CloudWatch log group which defines group of log streams
 	// that share the same retention, monitoring, and access control settings.
+	// It can hold tokens resolved from the resource attributes of the records, e.g.
+	// /otel/{resource.service.namespace}/{resource.service.name}.
 	LogGroupName string `mapstructure:"log_group_name"`
 
+	// LogGroupNameFallback is the log group of the records missing an attribute referenced by the tokens of
+	// LogGroupName.
+	// Required when LogGroupName has tokens.
+	LogGroupNameFallback string `mapstructure:"log_group_name_fallback"`
+
 	// LogStreamName is the name of CloudWatch log stream which is a sequence of log events
-	// that share the same source.
+	// that share the same source. It can hold tokens resolved from the resource attributes or the attributes
+	// of the records, e.g. {resource.k8s.pod.name} or {attributes.stream}.
 	LogStreamName string `mapstructure:"log_stream_name"`
 
-	// Endpoint is the CloudWatch Logs service endpoint which the requests
-	// are forwarded to. https://docs.aws.amazon.com/general/latest/gr/cwl_region.html
-	// e.g. logs.us-east-1.amazonaws.com
-	// Optional.
-	Endpoint string `mapstructure:"endpoint"`
+	// LogStreamNameFallback is the log stream of the records missing an attribute referenced by the tokens of
+	// LogStreamName.
+	// Required when LogStreamName has tokens.
+	LogStreamNameFallback string `mapstructure:"log_stream_name_fallback"`
+
+	// RegionFallback is the region of the records missing an attribute referenced by the tokens of the region, which
+	// can hold tokens resolved from the resource attributes of the records, e.g. {resource.cloud.region}.
+	// Optional, the region is resolved like when it is not set, e.g. from AWS_REGION, when it is empty.
+	RegionFallback string `mapstructure:"region_fallback"`
 
 	// QueueSettings is a subset of exporterhelper.QueueSettings,
 	// because only QueueSize is user-settable due to how AWS CloudWatch API works
 	QueueSettings QueueSettings `mapstructure:"sending_queue"`
 
+	// IsolateLogGroups gives every log group its own sending queue of QueueSettings.QueueSize batches, retried on its
+	// own, so that a throttled or failing log group does not hold up the records of the other log groups. The queues
+	// are created for the log groups resolved from the attributes on first use.
+	// Optional.
+	IsolateLogGroups bool `mapstructure:"isolate_log_groups"`
+
+	// CollectorID identifies this collector instance. Set it to a value that is stable across restarts,
+	// e.g. the pod name, when events are correlated or deduplicated on it.
+	// Optional, a random identifier is generated once per collector process when it is empty.
+	CollectorID string `mapstructure:"collector_id"`
+
+	// IncludeCollectorID adds the identifier of this collector instance, CollectorID or the generated one, to the
+	// events, so that the events of a deployment with several collectors are attributed to the collector sending them.
+	// Optional.
+	IncludeCollectorID bool `mapstructure:"include_collector_id"`
+
+	// CollectorIDField is the name of the top-level field added by IncludeCollectorID.
+	// Optional, "collector_id" when it is empty.
+	CollectorIDField string `mapstructure:"collector_id_field"`
+
+	// TimestampAttribute is the name of a log record attribute holding the time of the event, used as the
+	// CloudWatch event timestamp instead of the record timestamp. Its value can be an epoch in milliseconds
+	// or nanoseconds, or an RFC3339 string. The record timestamp is used when the attribute is missing or invalid.
+	// Optional.
+	TimestampAttribute string `mapstructure:"timestamp_attribute"`
+
+	// TimestampSource is where the CloudWatch event timestamp comes from when TimestampAttribute is not set or
+	// missing: "record" uses the record timestamp, falling back to the time of the export when it is not set,
+	// and "now" always uses the time of the export.
+	// Optional, the record timestamp is used as is, even when it is not set, by default.
+	TimestampSource string `mapstructure:"timestamp_source"`
+
+	// TimestampOutOfRange is what happens to the events CloudWatch Logs would reject for their timestamp, more
+	// than 14 days in the past or more than 2 hours in the future, which fail their whole batch: "keep" sends
+	// them as is, "clamp" moves their timestamp to the nearest accepted one, and "drop" leaves them out.
+	// Optional, "keep" by default.
+	TimestampOutOfRange string `mapstructure:"timestamp_out_of_range"`
+
+	// ClockSkewCorrection shifts the range of timestamps accepted by CloudWatch Logs by the skew between the local
+	// clock and the clock of the CloudWatch Logs servers, measured from the Date header of their responses, so that
+	// a skewed collector does not drop or clamp valid events. It applies to TimestampOutOfRange.
+	// Optional.
+	ClockSkewCorrection bool `mapstructure:"clock_skew_correction"`
+
+	// Format is the layout of the events. By default, events are JSON objects holding the fields of the
+	// OpenTelemetry log record. Set it to "cwagent" to mimic the events of the CloudWatch agent instead.
+	// Optional.
+	Format string `mapstructure:"format"`
+
+	// RawLog sends the body of the records as the message of the events, instead of a JSON object holding the
+	// fields of the record, so that plain text logs are not quoted. Bodies that are not strings are sent as their
+	// JSON representation.
+	// Optional.
+	RawLog bool `mapstructure:"raw_log"`
+
+	// SampledField is the name of the top-level boolean field holding the sampled bit of the trace flags of the
+	// record, so that sampled logs can be filtered in Logs Insights, e.g. "sampled". The names of the fixed fields
+	// of the events and of the other configured fields are not accepted.
+	// Optional, the field is omitted when it is empty.
+	SampledField string `mapstructure:"sampled_field"`
+
+	// AttributeFormatters maps the keys of resource and log record attributes holding numbers to the way they are
+	// rendered, so that they are readable in CloudWatch: "duration" for nanoseconds, "rfc3339" for nanoseconds since
+	// the epoch, or "bytes" for a size. Values that are not numbers are left as is.
+	// Optional.
+	AttributeFormatters map[string]string `mapstructure:"attribute_formatters"`
+
+	// FieldExtractors maps the names of top-level fields to JSONPath expressions, e.g. $.request.status, selecting
+	// the values of the fields in the log body, so that they can be queried in Logs Insights. They are evaluated
+	// against map bodies and against string bodies holding a JSON object or array. Paths missing from the body
+	// are skipped. The names of the fixed fields of the events are not accepted.
+	// Optional.
+	FieldExtractors map[string]string `mapstructure:"field_extractors"`
+
+	// CompactJSON makes the JSON events smaller: <, > and & are written as is instead of being escaped, and the
+	// severity number is left out when the record has a severity text. Zero values are always left out.
+	// Optional.
+	CompactJSON bool `mapstructure:"compact_json"`
+
+	// FlattenAttributes flattens the nested maps and arrays of the resource and record attributes into dotted
+	// keys, e.g. {"http": {"method": "GET"}} into {"http.method": "GET"}, and {"ids": [1, 2]} into
+	// {"ids.0": 1, "ids.1": 2}.
+	// Optional.
+	FlattenAttributes bool `mapstructure:"flatten_attributes"`
+
+	// MaxFlattenDepth is the number of levels of nested attributes flattened by FlattenAttributes, the deeper
+	// values are kept as is.
+	// Optional, 5 when it is 0.
+	MaxFlattenDepth int `mapstructure:"max_flatten_depth"`
+
+	// SeverityAsLevel adds a top-level level field to the events, holding the conventional name of the severity
+	// level of the record, i.e. TRACE, DEBUG, INFO, WARN, ERROR or FATAL, derived from its severity number.
+	// Optional.
+	SeverityAsLevel bool `mapstructure:"severity_as_level"`
+
+	// OmitRawSeverity leaves the severity number and the severity text of the records out of the events, when
+	// SeverityAsLevel is set.
+	// Optional.
+	OmitRawSeverity bool `mapstructure:"omit_raw_severity"`
+
+	// ResourceAttributeInclude are the keys of the only resource attributes written to the events. All of them
+	// are written when it is empty.
+	// Optional.
+	ResourceAttributeInclude []string `mapstructure:"resource_attribute_include"`
+
+	// ResourceAttributeExclude are the keys of the resource attributes left out of the events, after
+	// ResourceAttributeInclude is applied, so that a key in both lists is left out.
+	// Optional.
+	ResourceAttributeExclude []string `mapstructure:"resource_attribute_exclude"`
+
+	// DropResourceAttributes are the keys of the resource attributes left out of the events, e.g. bulky
+	// Kubernetes metadata. They are still used to name the log groups and log streams, and to sample the records.
+	// Optional.
+	DropResourceAttributes []string `mapstructure:"drop_resource_attributes"`
+
+	// RedactAttributes are the keys of the resource and log record attributes whose values are replaced with
+	// RedactionMask in the events, e.g. to hide personal data. They are still used to name the log groups and log
+	// streams, to sample the records and as EMF dimensions.
+	// Optional.
+	RedactAttributes []string `mapstructure:"redact_attributes"`
+
+	// RedactionMask replaces the values of the RedactAttributes.
+	// Optional, "[REDACTED]" when it is empty.
+	RedactionMask string `mapstructure:"redaction_mask"`
+
+	// DropNilAttributes leaves out the resource and log record attributes without a value,
+	// e.g. of the empty type, instead of writing them as null.
+	// Optional.
+	DropNilAttributes bool `mapstructure:"drop_nil_attributes"`
+
+	// AppendNewline adds a trailing newline to the message of every event, for tools tailing the log streams.
+	// The newline counts toward the event size limit.
+	// Optional.
+	AppendNewline bool `mapstructure:"append_newline"`
+
+	// MaxEventSizeBytes is the largest message of an event. The bodies of the records whose event would be larger
+	// are cut to fit, instead of CloudWatch Logs rejecting the whole batch.
+	// Optional, 262118 when it is 0, the largest message CloudWatch Logs accepts.
+	MaxEventSizeBytes int `mapstructure:"max_event_size_bytes"`
+
+	// TruncatedSuffix is appended to the bodies cut to fit MaxEventSizeBytes, to mark them in the events.
+	// Optional.
+	TruncatedSuffix string `mapstructure:"truncated_suffix"`
+
+	// PipelineLatency adds a pipeline_latency_ms field to the events, holding the time elapsed between the
+	// timestamp of the record and its export. It is left out for records without a timestamp.
+	// Optional.
+	PipelineLatency bool `mapstructure:"pipeline_latency"`
+
+	// ExportedAt adds an exported_at field to the events, holding the time in milliseconds since the epoch
+	// at which the exporter built the event to send it, to tell export delays apart from collection delays.
+	// Optional.
+	ExportedAt bool `mapstructure:"exported_at"`
+
+	// PropagatedContext adds trace_state and baggage fields to the events, holding the members of the W3C trace
+	// state and baggage found in the tracestate and baggage attributes of the record, so that logs can be queried
+	// by the business context propagated with their trace. The fields are left out when the attributes are missing.
+	// Optional.
+	PropagatedContext bool `mapstructure:"propagated_context"`
+
+	// TraceIDField is the name of the top-level field holding the trace ID of the record, e.g. aws.xray.trace_id to
+	// correlate the logs with the X-Ray traces. The names of the fixed fields of the events are not accepted.
+	// Optional, "trace_id" when it is empty.
+	TraceIDField string `mapstructure:"trace_id_field"`
+
+	// SpanIDField is the name of the top-level field holding the span ID of the record. It must differ from
+	// TraceIDField and from the names of the fixed fields of the events.
+	// Optional, "span_id" when it is empty.
+	SpanIDField string `mapstructure:"span_id_field"`
+
+	// XRayTraceFormat writes the trace ID in the X-Ray format, 1-<8 hex digits>-<24 hex digits>, instead of the
+	// 32 hex digits of the W3C format.
+	// Optional.
+	XRayTraceFormat bool `mapstructure:"xray_trace_format"`
+
+	// FieldNaming is the naming convention of the fixed fields of the events, "snake_case", e.g. severity_number, or
+	// "camelCase", e.g. severityNumber, for the Logs Insights queries written for camelCase events. The fields with
+	// a configurable name keep it.
+	// Optional, "snake_case" when it is empty.
+	FieldNaming string `mapstructure:"field_naming"`
+
+	// FieldCollision is the policy applied to the attributes promoted to top-level fields, i.e. the emf dimensions,
+	// and to the emf metrics, whose name is the name of a fixed field of the events: "prefix" writes them under
+	// their name prefixed with attr_, "skip" leaves them out, with the metrics using them, and "overwrite" writes
+	// them in place of the fixed field.
+	// Optional, "prefix" when it is empty.
+	FieldCollision string `mapstructure:"field_collision"`
+
+	// SortByTimestamp sorts the events of a PutLogEvents request by timestamp, as CloudWatch Logs rejects the
+	// requests out of chronological order. Disable it when the records are known to arrive in order, to save the sort.
+	// Optional, true by default.
+	SortByTimestamp bool `mapstructure:"sort_by_timestamp"`
+
+	// Deduplicate skips the PutLogEvents requests identical to the last request accepted on their log stream, e.g.
+	// when an export is retried after the push to another log stream failed.
+	// Optional.
+	Deduplicate bool `mapstructure:"deduplicate"`
+
+	// RotateStreamOnThrottling moves to a new log stream when CloudWatch Logs throttles the current one,
+	// appending a numeric suffix to the log stream name: <log_stream_name>-1, then <log_stream_name>-2, etc.
+	// The throttled batch is retried on the new stream. Combined with a log stream named after the pod,
+	// e.g. ${POD_NAME}, this spreads the events of a busy collector over several streams.
+	// Optional.
+	RotateStreamOnThrottling bool `mapstructure:"rotate_stream_on_throttling"`
+
+	// LogStreamRotation sends the events to a log stream per period of their timestamp, named after the log stream
+	// with the UTC date of the period: "daily", e.g. app-2024-06-01, or "hourly", e.g. app-2024-06-01-15. The
+	// pushers of the log streams are dropped once their period ended.
+	// Optional, "none" when it is empty.
+	LogStreamRotation string `mapstructure:"log_stream_rotation"`
+
+	// PusherIdleTimeout drops the pushers of the log groups and log streams resolved from the attributes once they
+	// received no event for the timeout, after flushing them, so that short-lived log streams do not accumulate.
+	// Optional, the pushers are kept when it is 0.
+	PusherIdleTimeout time.Duration `mapstructure:"pusher_idle_timeout"`
+
+	// MaxPushers caps the number of pushers of the log groups and log streams resolved from the attributes, so that
+	// high-cardinality log stream names do not exhaust the memory of the exporter. The default pusher and the
+	// shards of the log streams are not counted. The pushers are not capped when it is 0.
+	// Optional.
+	MaxPushers int `mapstructure:"max_pushers"`
+
+	// MaxPushersStrategy is what happens when the events of a new log stream need a pusher while MaxPushers are in
+	// use: "evict" flushes and drops the least recently used pusher, "reject" drops the events of the new log
+	// stream, counted by the awscloudwatchlogs_rejected_log_records metric.
+	// Optional, "evict" when it is empty.
+	MaxPushersStrategy string `mapstructure:"max_pushers_strategy"`
+
+	// MaxConcurrentCreations bounds the number of log groups and log streams being created at the same time by
+	// the exporters sharing a client, so that many streams starting together do not get throttled. Pushes are
+	// not bounded. Creations are unbounded when it is 0.
+	// Optional.
+	MaxConcurrentCreations int `mapstructure:"max_concurrent_creations"`
+
+	// RequestCompression gzips the bodies of the PutLogEvents requests, which are sent uncompressed once the endpoint
+	// rejects them.
+	// Optional.
+	RequestCompression bool `mapstructure:"request_compression"`
+
+	// RequestCompressionThreshold is the minimum size in bytes of the request bodies gzipped by RequestCompression.
+	// Optional, 1024 when it is 0.
+	RequestCompressionThreshold int `mapstructure:"request_compression_threshold"`
+
+	// LogRetention is the number of days the events of the log groups created by the exporter are kept, one of the
+	// periods accepted by CloudWatch Logs, e.g. 7, 30 or 365.
+	// Optional, the events are kept forever when it is 0.
+	LogRetention int `mapstructure:"log_retention"`
+
+	// ForceRetention sets LogRetention on the log groups that already exist too, once per log group.
+	// Optional.
+	ForceRetention bool `mapstructure:"force_retention"`
+
+	// KMSKeyARN is the ARN of the KMS key the log groups created by the exporter are encrypted with.
+	// Optional, the log groups are not encrypted with a customer managed key when it is empty.
+	KMSKeyARN string `mapstructure:"kms_key_arn"`
+
+	// Tags are the tags of the log groups created by the exporter, e.g. for cost allocation. At most 50 tags are
+	// allowed, with keys of up to 128 characters and values of up to 256 characters.
+	// Optional, the log groups that already exist are not tagged.
+	Tags map[string]string `mapstructure:"tags"`
+
+	// StreamSharding spreads the events of a log stream over additional log streams when CloudWatch Logs
+	// throttles it for exceeding its ingestion quota.
+	StreamSharding StreamShardingSettings `mapstructure:"stream_sharding"`
+
+	// Sampling exports a deterministic fraction of the log records.
+	Sampling SamplingSettings `mapstructure:"sampling"`
+
+	// Coalescing merges the log records of successive exports into fuller PutLogEvents requests.
+	Coalescing CoalescingSettings `mapstructure:"coalescing"`
+
+	// CircuitBreaker stops pushing to CloudWatch Logs for a while after consecutive failures.
+	CircuitBreaker CircuitBreakerSettings `mapstructure:"circuit_breaker"`
+
+	// EMF embeds CloudWatch metrics taken from the attributes of the records in the events, using the embedded
+	// metric format.
+	EMF EMFSettings `mapstructure:"emf"`
+
+	// EMFPassthrough sends the string bodies already in the embedded metric format as the message of the events,
+	// instead of wrapping them in the JSON object holding the fields of the record, so that CloudWatch extracts
+	// their metrics. The other records of the batch are sent as usual.
+	// Optional.
+	EMFPassthrough bool `mapstructure:"emf_passthrough"`
+
+	// DryRun logs the events at info level instead of sending them to CloudWatch Logs, to check their messages
+	// without AWS credentials. The AWS session is not created.
+	// Optional.
+	DryRun bool `mapstructure:"dry_run"`
+
+	// DryRunPath is the file the events are appended to in dry run, one JSON object per line, instead of logging
+	// them.
+	// Optional, the events are logged when it is empty.
+	DryRunPath string `mapstructure:"dry_run_path"`
+
 	logger *zap.Logger
+	// collectorID is CollectorID, or the identifier generated when it is empty, set when the exporter is created
+	collectorID string
+	// bodyTransform changes the body of every event right before it is marshaled, after the redaction, e.g. to mask
+	// the values matching a pattern. It is set programmatically, nil by default.
+	bodyTransform func(*cwLogBody)
 
 	awsutil.AWSSessionSettings `mapstructure:",squash"`
 }
 
+const (
+	// FormatCWAgent lays events out like the CloudWatch agent, with @timestamp and @message fields
+	FormatCWAgent = "cwagent"
+)
+
+const (
+	// TimestampSourceRecord uses the record timestamp, or the time of the export when it is not set
+	TimestampSourceRecord = "record"
+	// TimestampSourceNow uses the time of the export
+	TimestampSourceNow = "now"
+
+	// TimestampOutOfRangeKeep sends the events with a timestamp out of range as is
+	TimestampOutOfRangeKeep = "keep"
+	// TimestampOutOfRangeClamp moves the timestamp of the events out of range to the nearest accepted one
+	TimestampOutOfRangeClamp = "clamp"
+	// TimestampOutOfRangeDrop leaves out the events with a timestamp out of range
+	TimestampOutOfRangeDrop = "drop"
+)
+
+// SamplingSettings configures the sampling of the log records by the exporter.
+type SamplingSettings struct {
+	// Enabled turns sampling on.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Ratio is the fraction of the log records to export, between 0 and 1.
+	Ratio float64 `mapstructure:"ratio"`
+
+	// Key is the name of the attribute whose value decides whether a record is exported, looked up in the
+	// record attributes first, then in the resource attributes. Records with the same value are either all
+	// exported or all dropped. Records without the attribute are always exported.
+	// Optional, the trace ID of the record is used by default.
+	Key string `mapstructure:"key"`
+}
+
+// CoalescingSettings configures the coalescing of the exports. By default, every export is pushed to
+// CloudWatch Logs right away, so high-frequency receivers delivering small batches make many small requests.
+type CoalescingSettings struct {
+	// Window is the maximum time the events of an export wait for others before they are pushed.
+	// Coalescing is disabled when it is 0.
+	Window time.Duration `mapstructure:"window"`
+
+	// MaxEvents pushes the pending events as soon as there are that many of them, before the window elapses.
+	// Optional, the events are only pushed early when they reach the PutLogEvents limits by default.
+	MaxEvents int `mapstructure:"max_events"`
+}
+
+// CircuitBreakerSettings configures the circuit breaker around PutLogEvents. After FailureThreshold consecutive
+// failed pushes the breaker opens, and the exports fail with a retryable error without calling CloudWatch Logs.
+// Once CoolDown elapses, it half-opens and lets one export through: the breaker closes if its push succeeds,
+// and opens again otherwise.
+type CircuitBreakerSettings struct {
+	// FailureThreshold is the number of consecutive failed pushes opening the breaker.
+	// The breaker is disabled when it is 0.
+	FailureThreshold int `mapstructure:"failure_threshold"`
+
+	// CoolDown is the time the breaker stays open before it lets an export through to probe CloudWatch Logs.
+	// Optional, 30s by default.
+	CoolDown time.Duration `mapstructure:"cool_down"`
+}
+
+// StreamShardingSettings configures the log streams added to the log streams over their ingestion quota.
+type StreamShardingSettings struct {
+	// MaxShards is the maximum number of log streams the events of a log stream are spread over, including itself.
+	// Optional, sharding is disabled when it is 0 or 1.
+	MaxShards int `mapstructure:"max_shards"`
+}
+
+// EMFSettings configures the metrics CloudWatch extracts from the events, see
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format.html
+type EMFSettings struct {
+	// Namespace is the CloudWatch namespace of the metrics. It must be set when there are metrics.
+	Namespace string `mapstructure:"namespace"`
+
+	// Metrics are the metrics extracted from the events. No metric is embedded when it is empty.
+	Metrics []EMFMetric `mapstructure:"metrics"`
+}
+
+// EMFMetric maps the attributes of the records to a CloudWatch metric.
+type EMFMetric struct {
+	// Name is the name of the metric, and of the top-level field holding its value.
+	Name string `mapstructure:"name"`
+
+	// ValueAttribute is the name of the record attribute holding the value of the metric, which must be a number.
+	// Optional, the name of the metric by default.
+	ValueAttribute string `mapstructure:"value_attribute"`
+
+	// Unit is the CloudWatch unit of the metric, e.g. Milliseconds.
+	// Optional.
+	Unit string `mapstructure:"unit"`
+
+	// Dimensions are the names of the record or resource attributes whose values are the dimensions of the metric.
+	// At most 10 dimensions are allowed.
+	// Optional.
+	Dimensions []string `mapstructure:"dimensions"`
+}
+
+// valueAttribute is the name of the record attribute holding the value of the metric
+func (metric EMFMetric) valueAttribute() string {
+	if metric.ValueAttribute != "" {
+		return metric.ValueAttribute
+	}
+	return metric.Name
+}
+
 type QueueSettings struct {
 	// QueueSize set the length of the sending queue
 	QueueSize int `mapstructure:"queue_size"`
@@ -65,22 +486,333 @@ func (config *Config) Validate() error {
 	if config.LogGroupName == "" {
 		return errors.New("'log_group_name' must be set")
 	}
+	if _, err := parseNameTemplate(config.LogGroupName, "", resourceTokenPrefix); err != nil {
+		return fmt.Errorf("'log_group_name' has %w", err)
+	}
+	if isTemplated(config.LogGroupName) && config.LogGroupNameFallback == "" {
+		return errors.New("'log_group_name_fallback' must be set when 'log_group_name' has tokens")
+	}
+	if isTemplated(config.LogGroupNameFallback) {
+		return errors.New("'log_group_name_fallback' must not have tokens")
+	}
+	if name := literalName(config.LogGroupName); name != "" {
+		if err := validateLogGroupName(name); err != nil {
+			return fmt.Errorf("'log_group_name' is invalid: %w", err)
+		}
+	}
+	if config.LogGroupNameFallback != "" {
+		if err := validateLogGroupName(config.LogGroupNameFallback); err != nil {
+			return fmt.Errorf("'log_group_name_fallback' is invalid: %w", err)
+		}
+	}
 	if config.LogStreamName == "" {
 		return errors.New("'log_stream_name' must be set")
 	}
+	if _, err := parseNameTemplate(config.LogStreamName, "", resourceTokenPrefix, attributesTokenPrefix); err != nil {
+		return fmt.Errorf("'log_stream_name' has %w", err)
+	}
+	if name := literalName(config.LogStreamName); name != "" {
+		if err := validateLogStreamName(name); err != nil {
+			return fmt.Errorf("'log_stream_name' is invalid: %w", err)
+		}
+	}
+	if isTemplated(config.LogStreamName) && config.LogStreamNameFallback == "" {
+		return errors.New("'log_stream_name_fallback' must be set when 'log_stream_name' has tokens")
+	}
+	if config.LogStreamNameFallback != "" {
+		if err := validateLogStreamName(config.LogStreamNameFallback); err != nil {
+			return fmt.Errorf("'log_stream_name_fallback' is invalid: %w", err)
+		}
+		if isTemplated(config.LogStreamNameFallback) {
+			return errors.New("'log_stream_name_fallback' must not have tokens")
+		}
+	}
+	if _, err := parseNameTemplate(config.Region, "", resourceTokenPrefix); err != nil {
+		return fmt.Errorf("'region' has %w", err)
+	}
+	if config.RegionFallback != "" {
+		if !isTemplated(config.Region) {
+			return errors.New("'region_fallback' requires 'region' with tokens")
+		}
+		if !isRegion(config.RegionFallback) {
+			return fmt.Errorf("'region_fallback' must be an AWS region, got %q", config.RegionFallback)
+		}
+	}
+	if config.RoleARN != "" && !isRoleARN(config.RoleARN) {
+		return fmt.Errorf("'role_arn' must be the ARN of an IAM role, got %q", config.RoleARN)
+	}
+	if config.ExternalID != "" && config.RoleARN == "" {
+		return errors.New("'external_id' requires 'role_arn'")
+	}
 	if config.QueueSettings.QueueSize < 1 {
 		return errors.New("'sending_queue.queue_size' must be 1 or greater")
 	}
+	if config.Sampling.Enabled && (config.Sampling.Ratio < 0 || config.Sampling.Ratio > 1) {
+		return errors.New("'sampling.ratio' must be between 0 and 1")
+	}
+	if config.MaxConcurrentCreations < 0 {
+		return errors.New("'max_concurrent_creations' must not be negative")
+	}
+	if config.LogRetention != 0 && !isValidRetention(config.LogRetention) {
+		return fmt.Errorf("'log_retention' must be one of %v, got %d", validRetentionDays, config.LogRetention)
+	}
+	if config.ForceRetention && config.LogRetention == 0 {
+		return errors.New("'force_retention' requires 'log_retention'")
+	}
+	if config.KMSKeyARN != "" && !isKMSKeyARN(config.KMSKeyARN) {
+		return fmt.Errorf("'kms_key_arn' must be the ARN of a KMS key, got %q", config.KMSKeyARN)
+	}
+	if err := validateTags(config.Tags); err != nil {
+		return err
+	}
+	if config.Coalescing.Window < 0 {
+		return errors.New("'coalescing.window' must not be negative")
+	}
+	if config.Coalescing.MaxEvents < 0 {
+		return errors.New("'coalescing.max_events' must not be negative")
+	}
+	if config.RedactionMask != "" && len(config.RedactAttributes) == 0 {
+		return errors.New("'redaction_mask' requires 'redact_attributes'")
+	}
+	if config.CollectorIDField != "" && !config.IncludeCollectorID {
+		return errors.New("'collector_id_field' requires 'include_collector_id'")
+	}
+	if config.DryRunPath != "" && !config.DryRun {
+		return errors.New("'dry_run_path' requires 'dry_run'")
+	}
+	if config.PusherIdleTimeout < 0 {
+		return errors.New("'pusher_idle_timeout' must not be negative")
+	}
+	if config.MaxPushers < 0 {
+		return errors.New("'max_pushers' must not be negative")
+	}
+	switch config.MaxPushersStrategy {
+	case "", MaxPushersEvict, MaxPushersReject:
+	default:
+		return fmt.Errorf("'max_pushers_strategy' must be %q or %q", MaxPushersEvict, MaxPushersReject)
+	}
+	if config.StreamSharding.MaxShards < 0 {
+		return errors.New("'stream_sharding.max_shards' must not be negative")
+	}
+	if config.CircuitBreaker.FailureThreshold < 0 {
+		return errors.New("'circuit_breaker.failure_threshold' must not be negative")
+	}
+	if config.CircuitBreaker.CoolDown < 0 {
+		return errors.New("'circuit_breaker.cool_down' must not be negative")
+	}
+	for key, formatter := range config.AttributeFormatters {
+		if _, ok := formatters[formatter]; !ok {
+			return fmt.Errorf("'attribute_formatters' has unknown formatter %q for %q", formatter, key)
+		}
+	}
+	for field, expr := range config.FieldExtractors {
+		if field == "" {
+			return errors.New("'field_extractors' must not have an empty field name")
+		}
+		if _, err := parseJSONPath(expr); err != nil {
+			return fmt.Errorf("'field_extractors' has invalid path %q for %q: %w", expr, field, err)
+		}
+	}
+	if config.Format != "" && config.Format != FormatCWAgent {
+		return fmt.Errorf("'format' must be empty or %q", FormatCWAgent)
+	}
+	switch config.TimestampSource {
+	case "", TimestampSourceRecord, TimestampSourceNow:
+	default:
+		return fmt.Errorf("'timestamp_source' must be %q or %q", TimestampSourceRecord, TimestampSourceNow)
+	}
+	switch config.TimestampOutOfRange {
+	case "", TimestampOutOfRangeKeep, TimestampOutOfRangeClamp, TimestampOutOfRangeDrop:
+	default:
+		return fmt.Errorf("'timestamp_out_of_range' must be %q, %q or %q", TimestampOutOfRangeKeep, TimestampOutOfRangeClamp, TimestampOutOfRangeDrop)
+	}
+	if config.MaxFlattenDepth < 0 {
+		return errors.New("'max_flatten_depth' must not be negative")
+	}
+	if config.MaxEventSizeBytes < 0 || config.MaxEventSizeBytes > maxEventSizeBytes {
+		return fmt.Errorf("'max_event_size_bytes' must be between 0 and %d, got %d", maxEventSizeBytes, config.MaxEventSizeBytes)
+	}
+	if len(config.TruncatedSuffix) >= config.eventSizeLimit() {
+		return errors.New("'truncated_suffix' must be shorter than 'max_event_size_bytes'")
+	}
+	if config.OmitRawSeverity && !config.SeverityAsLevel {
+		return errors.New("'omit_raw_severity' requires 'severity_as_level'")
+	}
+	if config.RawLog && config.Format != "" {
+		return errors.New("'raw_log' cannot be combined with 'format'")
+	}
+	switch config.LogStreamRotation {
+	case "", LogStreamRotationNone, LogStreamRotationDaily, LogStreamRotationHourly:
+	default:
+		return fmt.Errorf("'log_stream_rotation' must be %q, %q or %q", LogStreamRotationNone, LogStreamRotationDaily, LogStreamRotationHourly)
+	}
+	switch config.FieldNaming {
+	case "", FieldNamingSnakeCase, FieldNamingCamelCase:
+	default:
+		return fmt.Errorf("'field_naming' must be %q or %q", FieldNamingSnakeCase, FieldNamingCamelCase)
+	}
+	switch config.FieldCollision {
+	case "", FieldCollisionPrefix, FieldCollisionSkip, FieldCollisionOverwrite:
+	default:
+		return fmt.Errorf("'field_collision' must be %q, %q or %q", FieldCollisionPrefix, FieldCollisionSkip, FieldCollisionOverwrite)
+	}
+	if config.traceIDField() == config.spanIDField() {
+		return errors.New("'trace_id_field' and 'span_id_field' must be different")
+	}
+	if err := config.validateFieldNames(); err != nil {
+		return err
+	}
+	if err := config.EMF.validate(); err != nil {
+		return err
+	}
+	if len(config.EMF.Metrics) > 0 && (config.RawLog || config.Format != "") {
+		return errors.New("'emf' cannot be combined with 'raw_log' or 'format'")
+	}
 	return nil
 }
 
+// defaultLogGroupName is the log group of the records when the log group name is not templated,
+// and of the records missing an attribute otherwise
+func (config *Config) defaultLogGroupName() string {
+	if isTemplated(config.LogGroupName) {
+		return config.LogGroupNameFallback
+	}
+	return config.LogGroupName
+}
+
+// defaultLogStreamName is the log stream of the records when the log stream name is not templated,
+// and of the records missing an attribute otherwise
+func (config *Config) defaultLogStreamName() string {
+	if isTemplated(config.LogStreamName) {
+		return config.LogStreamNameFallback
+	}
+	return config.LogStreamName
+}
+
+// withRegion returns a copy of the configuration in the region, to create the session of the region
+func (config *Config) withRegion(region string) *Config {
+	regionConfig := *config
+	regionConfig.Region = region
+	return &regionConfig
+}
+
+// eventSizeLimit is the largest message of an event
+func (config *Config) eventSizeLimit() int {
+	if config.MaxEventSizeBytes == 0 {
+		return maxEventSizeBytes
+	}
+	return config.MaxEventSizeBytes
+}
+
+// traceIDField is the name of the field holding the trace ID of the record
+func (config *Config) traceIDField() string {
+	if config.TraceIDField == "" {
+		return defaultTraceIDField
+	}
+	return config.TraceIDField
+}
+
+// collectorIDField is the name of the field holding the identifier of the collector instance
+func (config *Config) collectorIDField() string {
+	if config.CollectorIDField == "" {
+		return defaultCollectorIDField
+	}
+	return config.CollectorIDField
+}
+
+// spanIDField is the name of the field holding the span ID of the record
+func (config *Config) spanIDField() string {
+	if config.SpanIDField == "" {
+		return defaultSpanIDField
+	}
+	return config.SpanIDField
+}
+
+// maxFlattenDepth is the number of levels of nested attributes flattened by FlattenAttributes
+func (config *Config) maxFlattenDepth() int {
+	if config.MaxFlattenDepth == 0 {
+		return defaultMaxFlattenDepth
+	}
+	return config.MaxFlattenDepth
+}
+
 func (config *Config) enforcedQueueSettings() exporterhelper.QueueSettings {
 	return exporterhelper.QueueSettings{
 		Enabled: true,
-		// due to the sequence token, there can be only one request in flight
+		// the exports share the pushers of their log streams and the sequence tokens order the requests to every
+		// log stream, so there can be only one export in flight, or one for every log group with IsolateLogGroups
 		NumConsumers: 1,
 		QueueSize:    config.QueueSettings.QueueSize,
 	}
 }
 
-// TODO(jbd): Add ARN role to config.
+const (
+	maxTags           = 50
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// validateTags checks the tags against the limits of AWS, see
+// https://docs.aws.amazon.com/general/latest/gr/aws_tagging.html#tag-conventions
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("'tags' must have at most %d tags, got %d", maxTags, len(tags))
+	}
+	for key, value := range tags {
+		if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength {
+			return fmt.Errorf("'tags' keys must have between 1 and %d characters, got %q", maxTagKeyLength, key)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return fmt.Errorf("'tags' keys must not start with \"aws:\", got %q", key)
+		}
+		if utf8.RuneCountInString(value) > maxTagValueLength {
+			return fmt.Errorf("'tags' values must have at most %d characters, got %d for %q", maxTagValueLength, utf8.RuneCountInString(value), key)
+		}
+	}
+	return nil
+}
+
+// validRetentionDays are the retention periods accepted by CloudWatch Logs, see
+// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutRetentionPolicy.html
+var validRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557,
+	2922, 3288, 3653}
+
+func isValidRetention(days int) bool {
+	for _, valid := range validRetentionDays {
+		if days == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoleARN reports whether s is the ARN of an IAM role, e.g. arn:aws:iam::123456789012:role/logs-writer.
+func isRoleARN(s string) bool {
+	return isResourceARN(s, "iam", "role/")
+}
+
+// isKMSKeyARN reports whether s is the ARN of a KMS key, e.g.
+// arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab. Aliases are not accepted by CloudWatch
+// Logs.
+func isKMSKeyARN(s string) bool {
+	return isResourceARN(s, "kms", "key/")
+}
+
+// isResourceARN reports whether s is the ARN of a resource of the service owned by an account, whose resource part
+// starts with the resource type prefix.
+func isResourceARN(s string, service string, resourcePrefix string) bool {
+	parsed, err := arn.Parse(s)
+	if err != nil {
+		return false
+	}
+	return parsed.Service == service && parsed.AccountID != "" && strings.HasPrefix(parsed.Resource, resourcePrefix)
+}
+
+// regionPattern matches the names of the AWS regions, e.g. us-east-1, us-gov-west-1 or ap-southeast-2
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// isRegion reports whether s is the name of an AWS region. The regions are not checked against the ones known to
+// the AWS SDK, so that new regions are accepted.
+func isRegion(s string) bool {
+	return regionPattern.MatchString(s)
+}
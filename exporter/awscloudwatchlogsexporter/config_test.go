@@ -15,7 +15,9 @@
 package awscloudwatchlogsexporter
 
 import (
+	"fmt"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,16 +53,21 @@ func TestLoadConfig(t *testing.T) {
 			RetrySettings:      defaultRetrySettings,
 			LogGroupName:       "test-1",
 			LogStreamName:      "testing",
-			Endpoint:           "",
 			AWSSessionSettings: awsutil.CreateDefaultSessionConfig(),
 			QueueSettings: QueueSettings{
 				QueueSize: exporterhelper.DefaultQueueSettings().QueueSize,
 			},
+			SortByTimestamp: true,
+			CircuitBreaker: CircuitBreakerSettings{
+				CoolDown: defaultCoolDown,
+			},
 		},
 		e1,
 	)
 
 	e2 := cfg.Exporters[config.NewComponentIDWithName(typeStr, "e2-no-retries-short-queue")].(*Config)
+	e2SessionSettings := awsutil.CreateDefaultSessionConfig()
+	e2SessionSettings.Endpoint = "https://logs.example.com"
 
 	assert.Equal(t,
 		&Config{
@@ -71,12 +78,16 @@ func TestLoadConfig(t *testing.T) {
 				MaxInterval:     defaultRetrySettings.MaxInterval,
 				MaxElapsedTime:  defaultRetrySettings.MaxElapsedTime,
 			},
-			AWSSessionSettings: awsutil.CreateDefaultSessionConfig(),
+			AWSSessionSettings: e2SessionSettings,
 			LogGroupName:       "test-2",
 			LogStreamName:      "testing",
 			QueueSettings: QueueSettings{
 				QueueSize: 2,
 			},
+			SortByTimestamp: true,
+			CircuitBreaker: CircuitBreakerSettings{
+				CoolDown: defaultCoolDown,
+			},
 		},
 		e2,
 	)
@@ -98,6 +109,264 @@ func TestFailedLoadConfig(t *testing.T) {
 	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_queue_size.yaml"), factories)
 	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'sending_queue.queue_size' must be 1 or greater")
 
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_format.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'format' must be empty or \"cwagent\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_sampling_ratio.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'sampling.ratio' must be between 0 and 1")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_coalescing_window.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'coalescing.window' must not be negative")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_circuit_breaker.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'circuit_breaker.failure_threshold' must not be negative")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_max_concurrent_creations.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'max_concurrent_creations' must not be negative")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_log_group_name.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'log_group_name' has unknown token \"{attributes.service.name}\", tokens must be {resource.<attribute>}")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "missing_log_group_name_fallback.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'log_group_name_fallback' must be set when 'log_group_name' has tokens")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_log_stream_name_fallback.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'log_stream_name_fallback' is invalid: log stream names must not contain ':'")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_raw_log.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'raw_log' cannot be combined with 'format'")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_stream_sharding.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'stream_sharding.max_shards' must not be negative")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_emf_dimensions.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'emf.metrics' has 11 dimensions for \"Latency\", at most 10 are allowed")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_timestamp_out_of_range.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'timestamp_out_of_range' must be \"keep\", \"clamp\" or \"drop\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_role_arn.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'role_arn' must be the ARN of an IAM role, got \"arn:aws:s3:::logs-bucket\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_log_retention.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'log_retention' must be one of [1 3 5 7 14 30 60 90 120 150 180 365 400 545 731 1096 1827 2192 2557 2922 3288 3653], got 10")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_omit_raw_severity.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'omit_raw_severity' requires 'severity_as_level'")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_max_flatten_depth.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'max_flatten_depth' must not be negative")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_timestamp_source.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'timestamp_source' must be \"record\" or \"now\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_max_event_size_bytes.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'max_event_size_bytes' must be between 0 and 262118, got 300000")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_trace_id_field.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'trace_id_field' and 'span_id_field' must be different")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_field_naming.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'field_naming' must be \"snake_case\" or \"camelCase\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_region_fallback.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'region_fallback' must be an AWS region, got \"us-east\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_log_stream_rotation.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'log_stream_rotation' must be \"none\", \"daily\" or \"hourly\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_pusher_idle_timeout.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'pusher_idle_timeout' must not be negative")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_dry_run_path.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'dry_run_path' requires 'dry_run'")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_collector_id_field.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'collector_id_field' requires 'include_collector_id'")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_redaction_mask.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'redaction_mask' requires 'redact_attributes'")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_field_collision.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'field_collision' must be \"prefix\", \"skip\" or \"overwrite\"")
+
+	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_max_pushers_strategy.yaml"), factories)
+	assert.EqualError(t, err, "exporter \"awscloudwatchlogs\" has invalid configuration: 'max_pushers_strategy' must be \"evict\" or \"reject\"")
+
 	_, err = servicetest.LoadConfigAndValidate(path.Join(".", "testdata", "invalid_queue_setting.yaml"), factories)
 	assert.EqualError(t, err, "error reading exporters configuration for \"awscloudwatchlogs\": 1 error(s) decoding:\n\n* 'sending_queue' has invalid keys: enabled, num_consumers")
 }
+
+func TestValidateARNs(t *testing.T) {
+	tests := []struct {
+		name       string
+		roleARN    string
+		externalID string
+		kmsKeyARN  string
+		err        string
+	}{
+		{name: "no role"},
+		{name: "role", roleARN: "arn:aws:iam::123456789012:role/logs-writer"},
+		{name: "role with path", roleARN: "arn:aws-cn:iam::123456789012:role/logging/logs-writer", externalID: "central-logging"},
+		{name: "malformed", roleARN: "logs-writer", err: "'role_arn' must be the ARN of an IAM role, got \"logs-writer\""},
+		{name: "user", roleARN: "arn:aws:iam::123456789012:user/logs-writer", err: "'role_arn' must be the ARN of an IAM role, got \"arn:aws:iam::123456789012:user/logs-writer\""},
+		{name: "no account", roleARN: "arn:aws:iam:::role/logs-writer", err: "'role_arn' must be the ARN of an IAM role, got \"arn:aws:iam:::role/logs-writer\""},
+		{name: "external id without role", externalID: "central-logging", err: "'external_id' requires 'role_arn'"},
+		{name: "kms key", kmsKeyARN: "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+		{name: "kms alias", kmsKeyARN: "arn:aws:kms:us-east-1:123456789012:alias/logs", err: "'kms_key_arn' must be the ARN of a KMS key, got \"arn:aws:kms:us-east-1:123456789012:alias/logs\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.LogGroupName = "group"
+			cfg.LogStreamName = "stream"
+			cfg.RoleARN = tt.roleARN
+			cfg.ExternalID = tt.externalID
+			cfg.KMSKeyARN = tt.kmsKeyARN
+			err := cfg.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateNames(t *testing.T) {
+	tests := []struct {
+		name          string
+		logGroup      string
+		groupFallback string
+		logStream     string
+		err           string
+	}{
+		{name: "valid", logGroup: "/aws/eks/cluster-1#app", logStream: "pod-a/stdout"},
+		{name: "templated", logGroup: "/aws/{resource.service.name}", groupFallback: "/aws/default", logStream: "{attributes.stream}/stdout"},
+		{name: "log group", logGroup: "/aws/my app", logStream: "stream", err: "'log_group_name' is invalid: log group names must not contain ' ', only letters, digits, '.', '-', '_', '/' and '#'"},
+		{name: "templated log group", logGroup: "/aws/{resource.service.name}*", groupFallback: "/aws/default", logStream: "stream", err: "'log_group_name' is invalid: log group names must not contain '*', only letters, digits, '.', '-', '_', '/' and '#'"},
+		{name: "log group fallback", logGroup: "/aws/{resource.service.name}", groupFallback: "/aws/default:1", logStream: "stream", err: "'log_group_name_fallback' is invalid: log group names must not contain ':', only letters, digits, '.', '-', '_', '/' and '#'"},
+		{name: "long log group", logGroup: strings.Repeat("a", 513), logStream: "stream", err: "'log_group_name' is invalid: log group names must not be longer than 512 characters"},
+		{name: "log stream", logGroup: "group", logStream: "pod:a", err: "'log_stream_name' is invalid: log stream names must not contain ':'"},
+		{name: "templated log stream", logGroup: "group", logStream: "{attributes.stream}*", err: "'log_stream_name' is invalid: log stream names must not contain '*'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.LogGroupName = tt.logGroup
+			cfg.LogGroupNameFallback = tt.groupFallback
+			cfg.LogStreamName = tt.logStream
+			cfg.LogStreamNameFallback = "default"
+			err := cfg.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := 0; i <= 50; i++ {
+		tooMany[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	tests := []struct {
+		name string
+		tags map[string]string
+		err  string
+	}{
+		{name: "no tags"},
+		{name: "tags", tags: map[string]string{"team": "payments", "cost-center": "", strings.Repeat("é", 128): strings.Repeat("é", 256)}},
+		{name: "too many tags", tags: tooMany, err: "'tags' must have at most 50 tags, got 51"},
+		{name: "empty key", tags: map[string]string{"": "payments"}, err: "'tags' keys must have between 1 and 128 characters, got \"\""},
+		{name: "long key", tags: map[string]string{strings.Repeat("k", 129): "payments"}, err: "'tags' keys must have between 1 and 128 characters, got \"" + strings.Repeat("k", 129) + "\""},
+		{name: "reserved key", tags: map[string]string{"AWS:team": "payments"}, err: "'tags' keys must not start with \"aws:\", got \"AWS:team\""},
+		{name: "long value", tags: map[string]string{"team": strings.Repeat("v", 257)}, err: "'tags' values must have at most 256 characters, got 257 for \"team\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.LogGroupName = "group"
+			cfg.LogStreamName = "stream"
+			cfg.Tags = tt.tags
+			err := cfg.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateEventSize(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxEventSize    int
+		truncatedSuffix string
+		err             string
+	}{
+		{name: "default"},
+		{name: "limit", maxEventSize: 1024, truncatedSuffix: "[Truncated...]"},
+		{name: "negative limit", maxEventSize: -1, err: "'max_event_size_bytes' must be between 0 and 262118, got -1"},
+		{name: "limit above CloudWatch's", maxEventSize: 262119, err: "'max_event_size_bytes' must be between 0 and 262118, got 262119"},
+		{name: "suffix filling the event", maxEventSize: 4, truncatedSuffix: "[..]", err: "'truncated_suffix' must be shorter than 'max_event_size_bytes'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.LogGroupName = "group"
+			cfg.LogStreamName = "stream"
+			cfg.MaxEventSizeBytes = tt.maxEventSize
+			cfg.TruncatedSuffix = tt.truncatedSuffix
+			err := cfg.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		region   string
+		fallback string
+		err      string
+	}{
+		{name: "default"},
+		{name: "region", region: "us-east-1"},
+		{name: "templated", region: "{resource.cloud.region}"},
+		{name: "templated with fallback", region: "{resource.cloud.region}", fallback: "us-gov-west-1"},
+		{name: "record attribute", region: "{attributes.region}", err: "'region' has unknown token \"{attributes.region}\", tokens must be {resource.<attribute>}"},
+		{name: "fallback without tokens", region: "us-east-1", fallback: "us-east-2", err: "'region_fallback' requires 'region' with tokens"},
+		{name: "invalid fallback", region: "{resource.cloud.region}", fallback: "{resource.region}", err: "'region_fallback' must be an AWS region, got \"{resource.region}\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.LogGroupName = "group"
+			cfg.LogStreamName = "stream"
+			cfg.Region = tt.region
+			cfg.RegionFallback = tt.fallback
+			err := cfg.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEnforcedQueueSettings(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	assert.Equal(t, exporterhelper.QueueSettings{Enabled: true, NumConsumers: 1, QueueSize: 5000}, cfg.enforcedQueueSettings())
+
+	cfg.QueueSettings = QueueSettings{QueueSize: 20}
+	assert.Equal(t, exporterhelper.QueueSettings{Enabled: true, NumConsumers: 1, QueueSize: 20}, cfg.enforcedQueueSettings())
+}
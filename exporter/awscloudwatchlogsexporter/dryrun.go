@@ -0,0 +1,80 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"go.uber.org/zap"
+)
+
+// dryRunEvent is the line written to DryRunPath for every event
+type dryRunEvent struct {
+	LogGroupName  string `json:"log_group_name"`
+	LogStreamName string `json:"log_stream_name"`
+	Region        string `json:"region,omitempty"`
+	Timestamp     int64  `json:"timestamp"`
+	Message       string `json:"message"`
+}
+
+// openDryRunFile opens the file the events are appended to in dry run, when DryRunPath is set
+func (e *exporter) openDryRunFile() error {
+	if !e.Config.DryRun || e.Config.DryRunPath == "" {
+		return nil
+	}
+	file, err := os.OpenFile(e.Config.DryRunPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open 'dry_run_path': %w", err)
+	}
+	e.dryRunFile = file
+	return nil
+}
+
+// dryRun writes the events to DryRunPath, one JSON object per line, or logs them at info level, instead of pushing
+// them to CloudWatch Logs
+func (e *exporter) dryRun(events []cwLogEvent) error {
+	if e.dryRunFile == nil {
+		for _, event := range events {
+			e.logger.Info("Dry run, the event is not sent to CloudWatch Logs",
+				zap.String("log_group_name", event.logGroupName),
+				zap.String("log_stream_name", event.logStreamName),
+				zap.String("region", event.region),
+				zap.Int64("timestamp", aws.Int64Value(event.Timestamp)),
+				zap.String("message", aws.StringValue(event.Message)))
+		}
+		return nil
+	}
+	var lines []byte
+	for _, event := range events {
+		line, err := json.Marshal(dryRunEvent{
+			LogGroupName:  event.logGroupName,
+			LogStreamName: event.logStreamName,
+			Region:        event.region,
+			Timestamp:     aws.Int64Value(event.Timestamp),
+			Message:       aws.StringValue(event.Message),
+		})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	e.dryRunLock.Lock()
+	defer e.dryRunLock.Unlock()
+	_, err := e.dryRunFile.Write(lines)
+	return err
+}
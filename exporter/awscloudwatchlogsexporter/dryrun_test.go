@@ -0,0 +1,84 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newDryRunLogs() pdata.Logs {
+	ld := pdata.NewLogs()
+	record := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty()
+	record.SetTimestamp(pdata.Timestamp(1609763415000000000))
+	record.Body().SetStringVal("hello")
+	return ld
+}
+
+func TestConsumeLogsDryRun(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	params := componenttest.NewNopExporterCreateSettings()
+	params.Logger = zap.New(core)
+	// without a region, creating the AWS session fails
+	cfg := createDefaultConfig().(*Config)
+	cfg.LogGroupName = "group"
+	cfg.LogStreamName = "stream"
+	cfg.DryRun = true
+	exp, err := newCwLogsPusher(cfg, params)
+	require.NoError(t, err)
+	assert.Nil(t, exp.(*exporter).svcStructuredLog)
+	assert.Nil(t, exp.(*exporter).pusher)
+
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newDryRunLogs()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	entries := logs.FilterMessage("Dry run, the event is not sent to CloudWatch Logs").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "group", fields["log_group_name"])
+	assert.Equal(t, "stream", fields["log_stream_name"])
+	assert.Equal(t, int64(1609763415000), fields["timestamp"])
+	assert.Equal(t, `{"body":"hello"}`, fields["message"])
+}
+
+func TestConsumeLogsDryRunPath(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.LogGroupName = "group"
+	cfg.LogStreamName = "stream"
+	cfg.DryRun = true
+	cfg.DryRunPath = filepath.Join(t.TempDir(), "events.jsonl")
+	exp, err := newCwLogsPusher(cfg, componenttest.NewNopExporterCreateSettings())
+	require.NoError(t, err)
+
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newDryRunLogs()))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newDryRunLogs()))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	line := `{"log_group_name":"group","log_stream_name":"stream","timestamp":1609763415000,"message":"{\"body\":\"hello\"}"}` + "\n"
+	content, err := os.ReadFile(cfg.DryRunPath)
+	require.NoError(t, err)
+	assert.Equal(t, line+line, string(content))
+}
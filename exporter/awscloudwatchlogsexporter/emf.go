@@ -0,0 +1,189 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	// emfMetadataField is the top-level field holding the metadata from which CloudWatch extracts the metrics of
+	// an event, see https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+	emfMetadataField = "_aws"
+
+	// maxEMFDimensions is the maximum number of dimensions of a CloudWatch metric
+	maxEMFDimensions = 10
+)
+
+// emfMetadata is the _aws field of an event in the embedded metric format
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// emfDirective tells CloudWatch to extract metrics from the event, with the given dimensions
+type emfDirective struct {
+	Namespace  string              `json:"Namespace"`
+	Dimensions [][]string          `json:"Dimensions"`
+	Metrics    []emfMetricMetadata `json:"Metrics"`
+}
+
+type emfMetricMetadata struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit,omitempty"`
+}
+
+// validate checks the metrics have names, a value and at most the number of dimensions CloudWatch allows
+func (settings EMFSettings) validate() error {
+	if len(settings.Metrics) == 0 {
+		return nil
+	}
+	if settings.Namespace == "" {
+		return errors.New("'emf.namespace' must be set when 'emf.metrics' are")
+	}
+	names := map[string]bool{}
+	for _, metric := range settings.Metrics {
+		if metric.Name == "" {
+			return errors.New("'emf.metrics' must not have an empty name")
+		}
+		if names[metric.Name] {
+			return fmt.Errorf("'emf.metrics' has duplicate metric %q", metric.Name)
+		}
+		names[metric.Name] = true
+		if len(metric.Dimensions) > maxEMFDimensions {
+			return fmt.Errorf("'emf.metrics' has %d dimensions for %q, at most %d are allowed", len(metric.Dimensions), metric.Name, maxEMFDimensions)
+		}
+		for _, dimension := range metric.Dimensions {
+			if dimension == "" {
+				return fmt.Errorf("'emf.metrics' has an empty dimension for %q", metric.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// addEMFMetrics adds to fields the configured metrics of the record and their metadata, so that CloudWatch extracts
+// them from the event. The value of a metric is read from a record attribute, and its dimensions from the record
+// attributes, then the resource attributes. Metrics whose value is missing or not a number, or with a missing
+// dimension, are left out. The fields named after a fixed field follow the field collision policy, and the metrics
+// whose fields are skipped are left out too. No field is added when the record has none of the metrics.
+func addEMFMetrics(fields map[string]interface{}, config *Config, resourceAttrs map[string]interface{}, attrs pdata.AttributeMap, timestamp time.Time) {
+	settings := config.EMF
+	if len(settings.Metrics) == 0 {
+		return
+	}
+	reserved := config.reservedFieldNames()
+	var directives []emfDirective
+	for _, metric := range settings.Metrics {
+		value, ok := emfMetricValue(attrs, metric.valueAttribute())
+		if !ok {
+			continue
+		}
+		dimensions, ok := emfDimensions(metric.Dimensions, resourceAttrs, attrs)
+		if !ok {
+			continue
+		}
+		metricField, ok := config.promotedFieldName(metric.Name, reserved)
+		if !ok {
+			continue
+		}
+		dimensionFields := make([]string, 0, len(metric.Dimensions))
+		for _, name := range metric.Dimensions {
+			field, ok := config.promotedFieldName(name, reserved)
+			if !ok {
+				break
+			}
+			dimensionFields = append(dimensionFields, field)
+		}
+		if len(dimensionFields) < len(metric.Dimensions) {
+			continue
+		}
+		for i, name := range metric.Dimensions {
+			fields[dimensionFields[i]] = dimensions[name]
+		}
+		fields[metricField] = value
+		directives = append(directives, emfDirective{
+			Namespace:  settings.Namespace,
+			Dimensions: [][]string{dimensionFields},
+			Metrics:    []emfMetricMetadata{{Name: metricField, Unit: metric.Unit}},
+		})
+	}
+	if len(directives) == 0 {
+		return
+	}
+	fields[emfMetadataField] = emfMetadata{
+		Timestamp:         timestamp.UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: directives,
+	}
+}
+
+// isEMFBody tells whether the body is a string holding a JSON object in the embedded metric format, i.e. with an
+// _aws field holding a timestamp and at least one directive with a namespace and metrics. Bodies too large for an
+// event are not, since cutting them would break their JSON.
+func isEMFBody(body pdata.AttributeValue, config *Config) bool {
+	if body.Type() != pdata.AttributeValueTypeString || len(body.StringVal()) > maxBodyBytes(config) {
+		return false
+	}
+	var event struct {
+		Metadata *emfMetadata `json:"_aws"`
+	}
+	if err := json.Unmarshal([]byte(body.StringVal()), &event); err != nil || event.Metadata == nil {
+		return false
+	}
+	if event.Metadata.Timestamp <= 0 || len(event.Metadata.CloudWatchMetrics) == 0 {
+		return false
+	}
+	for _, directive := range event.Metadata.CloudWatchMetrics {
+		if directive.Namespace == "" || len(directive.Metrics) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// emfMetricValue returns the value of a numeric attribute
+func emfMetricValue(attrs pdata.AttributeMap, key string) (interface{}, bool) {
+	value, ok := attrs.Get(key)
+	if !ok {
+		return nil, false
+	}
+	switch value.Type() {
+	case pdata.AttributeValueTypeInt:
+		return value.IntVal(), true
+	case pdata.AttributeValueTypeDouble:
+		return value.DoubleVal(), true
+	}
+	return nil, false
+}
+
+// emfDimensions returns the values of the dimensions as strings, or false when one of them is missing
+func emfDimensions(names []string, resourceAttrs map[string]interface{}, attrs pdata.AttributeMap) (map[string]string, bool) {
+	dimensions := make(map[string]string, len(names))
+	for _, name := range names {
+		if value, ok := attrs.Get(name); ok {
+			dimensions[name] = value.AsString()
+		} else if value, ok := resourceAttrs[name]; ok && value != nil {
+			dimensions[name] = fmt.Sprint(value)
+		} else {
+			return nil, false
+		}
+	}
+	return dimensions, true
+}
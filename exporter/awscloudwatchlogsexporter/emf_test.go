@@ -0,0 +1,263 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+)
+
+func TestLogToCWLogEMF(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.SetTimestamp(pdata.NewTimestampFromTime(time.Unix(1, 500*int64(time.Millisecond))))
+	log.Attributes().InsertInt("latency_ms", 42)
+	log.Attributes().InsertDouble("size", 1.5)
+	log.Attributes().InsertString("route", "/users")
+	config := &Config{
+		EMF: EMFSettings{
+			Namespace: "MyApp",
+			Metrics: []EMFMetric{
+				{Name: "Latency", ValueAttribute: "latency_ms", Unit: "Milliseconds", Dimensions: []string{"service.name", "route"}},
+				{Name: "size"},
+			},
+		},
+	}
+
+	got, _, err := logToCWLog(map[string]interface{}{"service.name": "api"}, log, config)
+	require.NoError(t, err)
+	assert.Equal(t, `{"attributes":{"latency_ms":42,"route":"/users","size":1.5},"resource":{"service.name":"api"},`+
+		`"Latency":42,"_aws":{"Timestamp":1500,"CloudWatchMetrics":[`+
+		`{"Namespace":"MyApp","Dimensions":[["service.name","route"]],"Metrics":[{"Name":"Latency","Unit":"Milliseconds"}]},`+
+		`{"Namespace":"MyApp","Dimensions":[[]],"Metrics":[{"Name":"size"}]}]},`+
+		`"route":"/users","service.name":"api","size":1.5}`, *got.Message)
+}
+
+func TestLogToCWLogEMFSkipped(t *testing.T) {
+	metric := EMFMetric{Name: "Latency", ValueAttribute: "latency_ms", Dimensions: []string{"route"}}
+	tests := []struct {
+		name  string
+		attrs map[string]pdata.AttributeValue
+	}{
+		{name: "missing value", attrs: map[string]pdata.AttributeValue{"route": pdata.NewAttributeValueString("/users")}},
+		{name: "non-numeric value", attrs: map[string]pdata.AttributeValue{
+			"latency_ms": pdata.NewAttributeValueString("42"),
+			"route":      pdata.NewAttributeValueString("/users"),
+		}},
+		{name: "missing dimension", attrs: map[string]pdata.AttributeValue{"latency_ms": pdata.NewAttributeValueInt(42)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := pdata.NewLogRecord()
+			pdata.NewAttributeMapFromMap(tt.attrs).CopyTo(log.Attributes())
+
+			got, _, err := logToCWLog(nil, log, &Config{EMF: EMFSettings{Namespace: "MyApp", Metrics: []EMFMetric{metric}}})
+			require.NoError(t, err)
+			assert.NotContains(t, *got.Message, `"_aws"`)
+			assert.NotContains(t, *got.Message, `"Latency"`)
+		})
+	}
+}
+
+func TestLogToCWLogEMFFieldCollision(t *testing.T) {
+	tests := []struct {
+		name      string
+		collision string
+		want      string
+	}{
+		{
+			name: "default",
+			want: `{"name":"checkout","attributes":{"latency_ms":42,"name":"api"},` +
+				`"Latency":42,"_aws":{"Timestamp":1500,"CloudWatchMetrics":[` +
+				`{"Namespace":"MyApp","Dimensions":[["attr_name"]],"Metrics":[{"Name":"Latency"}]}]},"attr_name":"api"}`,
+		},
+		{
+			name:      "prefix",
+			collision: FieldCollisionPrefix,
+			want: `{"name":"checkout","attributes":{"latency_ms":42,"name":"api"},` +
+				`"Latency":42,"_aws":{"Timestamp":1500,"CloudWatchMetrics":[` +
+				`{"Namespace":"MyApp","Dimensions":[["attr_name"]],"Metrics":[{"Name":"Latency"}]}]},"attr_name":"api"}`,
+		},
+		{
+			name:      "skip",
+			collision: FieldCollisionSkip,
+			want:      `{"name":"checkout","attributes":{"latency_ms":42,"name":"api"}}`,
+		},
+		{
+			name:      "overwrite",
+			collision: FieldCollisionOverwrite,
+			want: `{"attributes":{"latency_ms":42,"name":"api"},` +
+				`"Latency":42,"_aws":{"Timestamp":1500,"CloudWatchMetrics":[` +
+				`{"Namespace":"MyApp","Dimensions":[["name"]],"Metrics":[{"Name":"Latency"}]}]},"name":"api"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := pdata.NewLogRecord()
+			log.SetName("checkout")
+			log.SetTimestamp(pdata.NewTimestampFromTime(time.Unix(1, 500*int64(time.Millisecond))))
+			log.Attributes().InsertInt("latency_ms", 42)
+			log.Attributes().InsertString("name", "api")
+			config := &Config{
+				FieldCollision: tt.collision,
+				EMF: EMFSettings{
+					Namespace: "MyApp",
+					Metrics:   []EMFMetric{{Name: "Latency", ValueAttribute: "latency_ms", Dimensions: []string{"name"}}},
+				},
+			}
+
+			got, _, err := logToCWLog(nil, log, config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Message)
+		})
+	}
+}
+
+func TestLogToCWLogEMFMetricCollision(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.SetTimestamp(pdata.NewTimestampFromTime(time.Unix(1, 500*int64(time.Millisecond))))
+	log.Attributes().InsertInt("flags", 3)
+	config := &Config{EMF: EMFSettings{Namespace: "MyApp", Metrics: []EMFMetric{{Name: "flags"}}}}
+
+	got, _, err := logToCWLog(nil, log, config)
+	require.NoError(t, err)
+	assert.Equal(t, `{"attributes":{"flags":3},"_aws":{"Timestamp":1500,"CloudWatchMetrics":[`+
+		`{"Namespace":"MyApp","Dimensions":[[]],"Metrics":[{"Name":"attr_flags"}]}]},"attr_flags":3}`, *got.Message)
+}
+
+func TestIsEMFBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{
+			name: "emf",
+			body: `{"_aws":{"Timestamp":1500,"CloudWatchMetrics":[{"Namespace":"MyApp","Dimensions":[["route"]],"Metrics":[{"Name":"Latency"}]}]},"route":"/users","Latency":42}`,
+			want: true,
+		},
+		{
+			name: "plain text",
+			body: "hello",
+		},
+		{
+			name: "json without metadata",
+			body: `{"route":"/users","Latency":42}`,
+		},
+		{
+			name: "metadata without directives",
+			body: `{"_aws":{"Timestamp":1500,"CloudWatchMetrics":[]}}`,
+		},
+		{
+			name: "metadata without timestamp",
+			body: `{"_aws":{"CloudWatchMetrics":[{"Namespace":"MyApp","Metrics":[{"Name":"Latency"}]}]},"Latency":42}`,
+		},
+		{
+			name: "directive without namespace",
+			body: `{"_aws":{"Timestamp":1500,"CloudWatchMetrics":[{"Metrics":[{"Name":"Latency"}]}]},"Latency":42}`,
+		},
+		{
+			name: "invalid metadata",
+			body: `{"_aws":"MyApp"}`,
+		},
+		{
+			name: "too large",
+			body: `{"_aws":{"Timestamp":1500,"CloudWatchMetrics":[{"Namespace":"MyApp","Metrics":[{"Name":"Latency"}]}]},"Latency":42,"padding":"` + strings.Repeat("x", maxEventSizeBytes) + `"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isEMFBody(pdata.NewAttributeValueString(tt.body), &Config{}))
+		})
+	}
+	assert.False(t, isEMFBody(pdata.NewAttributeValueInt(1), &Config{}))
+}
+
+func TestLogsToCWLogsEMFPassthrough(t *testing.T) {
+	emfBody := `{"_aws": {"Timestamp": 1500, "CloudWatchMetrics": [{"Namespace": "MyApp", "Dimensions": [["route"]], "Metrics": [{"Name": "Latency", "Unit": "Milliseconds"}]}]}, "route": "/users", "Latency": 42}`
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	logs.AppendEmpty().Body().SetStringVal(emfBody)
+	logs.AppendEmpty().Body().SetStringVal("hello")
+
+	events, _, _ := logsToCWLogs(zap.NewNop(), ld, &Config{EMFPassthrough: true}, logNames{}, 0)
+	require.Len(t, events, 2)
+	assert.Equal(t, emfBody, *events[0].Message)
+	assert.Equal(t, `{"body":"hello"}`, *events[1].Message)
+
+	// without passthrough, the EMF body is wrapped like any other
+	events, _, _ = logsToCWLogs(zap.NewNop(), ld, &Config{}, logNames{}, 0)
+	require.Len(t, events, 2)
+	assert.NotEqual(t, emfBody, *events[0].Message)
+}
+
+func TestEMFSettingsValidate(t *testing.T) {
+	tooManyDimensions := make([]string, maxEMFDimensions+1)
+	for i := range tooManyDimensions {
+		tooManyDimensions[i] = "dimension" + strconv.Itoa(i)
+	}
+	tests := []struct {
+		name     string
+		settings EMFSettings
+		err      string
+	}{
+		{name: "no metrics", settings: EMFSettings{}},
+		{
+			name:     "valid",
+			settings: EMFSettings{Namespace: "MyApp", Metrics: []EMFMetric{{Name: "Latency", Dimensions: tooManyDimensions[:maxEMFDimensions]}}},
+		},
+		{
+			name:     "missing namespace",
+			settings: EMFSettings{Metrics: []EMFMetric{{Name: "Latency"}}},
+			err:      "'emf.namespace' must be set when 'emf.metrics' are",
+		},
+		{
+			name:     "empty name",
+			settings: EMFSettings{Namespace: "MyApp", Metrics: []EMFMetric{{ValueAttribute: "latency_ms"}}},
+			err:      "'emf.metrics' must not have an empty name",
+		},
+		{
+			name:     "duplicate name",
+			settings: EMFSettings{Namespace: "MyApp", Metrics: []EMFMetric{{Name: "Latency"}, {Name: "Latency"}}},
+			err:      `'emf.metrics' has duplicate metric "Latency"`,
+		},
+		{
+			name:     "too many dimensions",
+			settings: EMFSettings{Namespace: "MyApp", Metrics: []EMFMetric{{Name: "Latency", Dimensions: tooManyDimensions}}},
+			err:      `'emf.metrics' has 11 dimensions for "Latency", at most 10 are allowed`,
+		},
+		{
+			name:     "empty dimension",
+			settings: EMFSettings{Namespace: "MyApp", Metrics: []EMFMetric{{Name: "Latency", Dimensions: []string{""}}}},
+			err:      `'emf.metrics' has an empty dimension for "Latency"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -15,32 +15,114 @@
 package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/google/uuid"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
 	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/awsutil"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
 )
 
+// maxEventSizeBytes is the largest message CloudWatch Logs accepts for a single event: 256 KB minus
+// the 26 bytes of per event overhead. See https://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/cloudwatch_limits_cwl.html
+const maxEventSizeBytes = 256*1024 - 26
+
+// errCodeThrottlingException is the error code CloudWatch Logs returns when a log stream is throttled
+const errCodeThrottlingException = "ThrottlingException"
+
+// pusherKey identifies the pusher of a log stream, by the resolved names before rotation
+type pusherKey struct {
+	logGroupName  string
+	logStreamName string
+	// region is the region resolved from the resource attributes, empty for the region of the exporter
+	region string
+}
+
 type exporter struct {
 	Config           *Config
 	logger           *zap.Logger
 	retryCount       int
 	collectorID      string
 	svcStructuredLog *cwlogs.Client
-	pusher           cwlogs.Pusher
+	// names resolves the log group and log stream of the records
+	names logNames
+	// pusher pushes the events of the default log group and log stream
+	pusher cwlogs.Pusher
+	// pushers push the events of the other log groups and log streams resolved from the attributes
+	pushers map[pusherKey]cwlogs.Pusher
+	// newPusher creates a pusher for the given log stream of a log group in a region, empty for the region of the
+	// exporter, used for the resolved log streams and when rotating streams
+	newPusher func(region, logGroupName, streamName string) cwlogs.Pusher
+	// region is the region of the session of the exporter
+	region string
+	// regionClients are the clients of the other regions resolved from the resource attributes, created on first use
+	regionClients map[string]*sharedClient
+	// newRegionClient returns the client of a region resolved from the resource attributes
+	newRegionClient func(region string) (*sharedClient, error)
+	// shards push the events of the additional log streams of the log streams throttled for their quota
+	shards map[pusherKey][]cwlogs.Pusher
+	// nextShard is the shard receiving the next event of each sharded log stream
+	nextShard map[pusherKey]int
+	// periodEnds are the ends of the periods of the log streams rotated by LogStreamRotation, after which their
+	// pushers are retired
+	periodEnds map[pusherKey]time.Time
+	// streamSuffix is the suffix added to the log streams, 0 while the resolved log streams are used
+	streamSuffix int
+	// breaker short-circuits the exports while CloudWatch Logs is failing, nil when it is disabled
+	breaker *circuitBreaker
+	// pusherLock guards the pushers and the pending events, shared with the coalescing flushes. It is not held
+	// while adding the events or pushing, every pusher synchronizes the requests to its own log stream.
+	pusherLock sync.Mutex
+	// exportLocks are the export locks of the pushers being flushed, guarded by the pusher lock
+	exportLocks map[cwlogs.Pusher]*exportLock
+	// pending is the number of events added to the pusher since the last flush
+	pending int
+	// retired are the pushers replaced by the last rotation, which may still hold events
+	retired []keyedPusher
+	// lastUsed is the time the pushers of the resolved log streams last received an event, used to drop the idle
+	// ones when PusherIdleTimeout is set
+	lastUsed map[pusherKey]time.Time
+	// dryRunFile receives the events in dry run when DryRunPath is set
+	dryRunFile *os.File
+	dryRunLock sync.Mutex
+	// done stops the coalescing flushes and the reaping of the idle pushers
+	done       chan struct{}
+	background sync.WaitGroup
+}
+
+// keyedPusher is a pusher with the log stream it pushes the events of
+type keyedPusher struct {
+	key    pusherKey
+	pusher cwlogs.Pusher
+}
+
+// exportLock serializes the flushes of a pusher, each adding the events of its export before flushing them
+type exportLock struct {
+	sync.Mutex
+	// users is the number of flushes holding or waiting for the lock, guarded by the pusher lock
+	users int
 }
 
 func newCwLogsPusher(expConfig *Config, params component.ExporterCreateSettings) (component.LogsExporter, error) {
@@ -49,107 +131,755 @@ func newCwLogsPusher(expConfig *Config, params component.ExporterCreateSettings)
 	}
 
 	expConfig.logger = params.Logger
-
-	// create AWS session
-	awsConfig, session, err := awsutil.GetAWSConfigSession(params.Logger, &awsutil.Conn{}, &expConfig.AWSSessionSettings)
-	if err != nil {
-		return nil, err
+	collectorID := expConfig.CollectorID
+	if collectorID == "" {
+		var err error
+		if collectorID, err = generatedCollectorID(); err != nil {
+			return nil, err
+		}
 	}
+	expConfig.collectorID = collectorID
 
-	// create CWLogs client with aws session config
-	svcStructuredLog := cwlogs.NewClient(params.Logger, awsConfig, params.BuildInfo, expConfig.LogGroupName, session)
-	collectorIdentifier, err := uuid.NewRandom()
+	if expConfig.DryRun {
+		// the events are not sent, AWS is not configured
+		names, err := newLogNames(expConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &exporter{Config: expConfig, logger: params.Logger, collectorID: collectorID, names: names}, nil
+	}
 
+	sessionConfig := expConfig
+	if isTemplated(expConfig.Region) {
+		sessionConfig = expConfig.withRegion(expConfig.RegionFallback)
+	}
+	shared, err := getClient(sessionConfig, params)
 	if err != nil {
 		return nil, err
 	}
+	awsConfig, svcStructuredLog := shared.awsConfig, shared.client
 
 	expConfig.Validate()
-
-	pusher := cwlogs.NewPusher(aws.String(expConfig.LogGroupName), aws.String(expConfig.LogStreamName), *awsConfig.MaxRetries, *svcStructuredLog, params.Logger)
+	names, err := newLogNames(expConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	logsExporter := &exporter{
 		svcStructuredLog: svcStructuredLog,
 		Config:           expConfig,
 		logger:           params.Logger,
 		retryCount:       *awsConfig.MaxRetries,
-		collectorID:      collectorIdentifier.String(),
-		pusher:           pusher,
+		collectorID:      collectorID,
+		names:            names,
+		region:           aws.StringValue(awsConfig.Region),
+		newRegionClient: func(region string) (*sharedClient, error) {
+			return getClient(expConfig.withRegion(region), params)
+		},
+	}
+	logsExporter.newPusher = func(region, logGroupName, streamName string) cwlogs.Pusher {
+		client := shared
+		if region != "" {
+			client = logsExporter.regionClients[region]
+		}
+		return cwlogs.NewPusher(aws.String(logGroupName), aws.String(streamName), *client.awsConfig.MaxRetries, *client.client, params.Logger,
+			cwlogs.WithSortByTimestamp(expConfig.SortByTimestamp),
+			cwlogs.WithDeduplication(expConfig.Deduplicate),
+			cwlogs.WithMetricTags(expConfig.metricTags()...),
+			// the coalesced events of a failed push are pushed again with the next window, as their exports completed
+			cwlogs.WithRetainFailedBatches(expConfig.Coalescing.Window > 0))
 	}
+	logsExporter.pusher = logsExporter.newPusher("", expConfig.defaultLogGroupName(), expConfig.defaultLogStreamName())
+	logsExporter.breaker = newCircuitBreaker(expConfig.CircuitBreaker, logsExporter.onBreakerStateChange)
 	return logsExporter, nil
 }
 
+var (
+	collectorIDOnce sync.Once
+	collectorIDErr  error
+	// collectorIDValue identifies the collector process when CollectorID is not set, shared by its exporters
+	collectorIDValue string
+)
+
+// generatedCollectorID returns the random identifier of the collector process, generated on first use, so that it
+// is stable over the lifetime of the process
+func generatedCollectorID() (string, error) {
+	collectorIDOnce.Do(func() {
+		var id uuid.UUID
+		id, collectorIDErr = uuid.NewRandom()
+		collectorIDValue = id.String()
+	})
+	return collectorIDValue, collectorIDErr
+}
+
+// clientKey identifies the CloudWatch Logs clients that exporters can share
+type clientKey struct {
+	settings awsutil.AWSSessionSettings
+	// the user agent of the client depends on whether the log group holds Container Insights data
+	containerInsights bool
+	// the exporters bounding creations differently do not share the bound
+	maxConcurrentCreations int
+	// the compression is set on the client
+	requestCompression          bool
+	requestCompressionThreshold int
+	// the retention, the key and the tags are set by the client when it creates the log groups
+	logRetention   int
+	forceRetention bool
+	kmsKeyARN      string
+	// tags are encoded as JSON, with sorted keys, since maps are not comparable
+	tags string
+}
+
+// sharedClient is a CloudWatch Logs client with the configuration of its session
+type sharedClient struct {
+	awsConfig *aws.Config
+	client    *cwlogs.Client
+}
+
+var (
+	clientsLock sync.Mutex
+	clients     = map[clientKey]*sharedClient{}
+)
+
+// getClient returns the CloudWatch Logs client of the exporters with the same AWS session settings, e.g. the same
+// region and role, creating it on first use. Exporters writing to different log groups share the session, the
+// resolved credentials and endpoint, and the connections of the client.
+func getClient(expConfig *Config, params component.ExporterCreateSettings) (*sharedClient, error) {
+	key := clientKey{
+		settings:                    expConfig.AWSSessionSettings,
+		containerInsights:           cwlogs.IsContainerInsightsLogGroup(expConfig.LogGroupName),
+		maxConcurrentCreations:      expConfig.MaxConcurrentCreations,
+		requestCompression:          expConfig.RequestCompression,
+		requestCompressionThreshold: expConfig.RequestCompressionThreshold,
+		logRetention:                expConfig.LogRetention,
+		forceRetention:              expConfig.ForceRetention,
+		kmsKeyARN:                   expConfig.KMSKeyARN,
+	}
+	if len(expConfig.Tags) > 0 {
+		tags, err := json.Marshal(expConfig.Tags)
+		if err != nil {
+			return nil, err
+		}
+		key.tags = string(tags)
+	}
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	if shared, ok := clients[key]; ok {
+		return shared, nil
+	}
+
+	// create AWS session
+	awsConfig, session, err := awsutil.GetAWSConfigSession(params.Logger, &awsutil.Conn{}, &expConfig.AWSSessionSettings)
+	if err != nil {
+		return nil, err
+	}
+	if expConfig.UseFIPSEndpoint && expConfig.Endpoint == "" {
+		if err = awsutil.CheckFIPSEndpoint(cloudwatchlogs.EndpointsID, aws.StringValue(awsConfig.Region)); err != nil {
+			return nil, err
+		}
+	}
+	// create CWLogs client with aws session config
+	shared := &sharedClient{
+		awsConfig: awsConfig,
+		client: cwlogs.NewClient(params.Logger, awsConfig, params.BuildInfo, expConfig.LogGroupName, session,
+			cwlogs.WithMaxConcurrentCreations(expConfig.MaxConcurrentCreations),
+			cwlogs.WithLogRetention(int64(expConfig.LogRetention), expConfig.ForceRetention),
+			cwlogs.WithKMSKey(expConfig.KMSKeyARN),
+			cwlogs.WithLogGroupTags(expConfig.Tags),
+			cwlogs.WithRequestCompression(expConfig.RequestCompression, expConfig.RequestCompressionThreshold)),
+	}
+	clients[key] = shared
+	return shared, nil
+}
+
 func newCwLogsExporter(config config.Exporter, params component.ExporterCreateSettings) (component.LogsExporter, error) {
 	expConfig := config.(*Config)
 	logsExporter, err := newCwLogsPusher(expConfig, params)
 	if err != nil {
 		return nil, err
 	}
+	if expConfig.IsolateLogGroups {
+		return newGroupQueues(logsExporter.(*exporter), func() (component.LogsExporter, error) {
+			return exporterhelper.NewLogsExporter(
+				config,
+				params,
+				logsExporter.ConsumeLogs,
+				exporterhelper.WithQueue(expConfig.enforcedQueueSettings()),
+				exporterhelper.WithRetry(expConfig.RetrySettings),
+			)
+		}), nil
+	}
 	return exporterhelper.NewLogsExporter(
 		config,
 		params,
 		logsExporter.ConsumeLogs,
 		exporterhelper.WithQueue(expConfig.enforcedQueueSettings()),
 		exporterhelper.WithRetry(expConfig.RetrySettings),
+		exporterhelper.WithStart(logsExporter.Start),
+		exporterhelper.WithShutdown(logsExporter.Shutdown),
 	)
 
 }
 
 func (e *exporter) ConsumeLogs(ctx context.Context, ld pdata.Logs) error {
-	cwLogsPusher := e.pusher
-	logEvents, _ := logsToCWLogs(e.logger, ld)
+	logEvents, dropped, sampledOut := logsToCWLogs(e.logger, ld, e.Config, e.names, e.clockSkew())
+	if dropped > 0 {
+		e.recordPerLogGroup(ctx, mDroppedLogRecords, dropped)
+	}
+	if truncated := countTruncated(logEvents); truncated > 0 {
+		e.recordPerLogGroup(ctx, mTruncatedLogRecords, truncated)
+	}
+	if fallbacks := countFallbacks(logEvents); fallbacks > 0 {
+		e.recordPerLogGroup(ctx, mFallbackLogRecords, fallbacks)
+	}
+	if sampledOut > 0 {
+		stats.Record(ctx, mSampledOutLogRecords.M(int64(sampledOut)))
+	}
 	if len(logEvents) == 0 {
 		return nil
 	}
+	if e.Config.DryRun {
+		return e.dryRun(logEvents)
+	}
 
+	e.pusherLock.Lock()
+	if !e.breaker.allow() {
+		e.pusherLock.Unlock()
+		return errCircuitOpen
+	}
+	if err := ctx.Err(); err != nil {
+		// no event was added yet, the retry of the export pushes them once
+		e.breaker.cancel()
+		e.pusherLock.Unlock()
+		return err
+	}
+	// pushers are the pushers of the log streams of the events, and events the events of each, in the same order.
+	// The events are added outside the pusher lock, as adding to a full batch pushes it.
+	var pushers []keyedPusher
+	var events [][]*cwlogs.Event
+	index := map[cwlogs.Pusher]int{}
+	// rejected is the number of events of the log streams left without a pusher by MaxPushers
+	rejected := 0
+	defer func() {
+		if rejected > 0 {
+			e.recordPerLogGroup(ctx, mRejectedLogRecords, rejected)
+		}
+	}()
 	for _, logEvent := range logEvents {
-		logEvent := &cwlogs.Event{
-			InputLogEvent: logEvent,
-			GeneratedTime: time.Now(),
+		key := pusherKey{logGroupName: logEvent.logGroupName, logStreamName: logEvent.logStreamName}
+		if logEvent.region != e.region {
+			key.region = logEvent.region
 		}
-		e.logger.Debug("Adding log event", zap.Any("event", logEvent))
-		err := cwLogsPusher.AddLogEntry(logEvent)
-		if err != nil {
-			e.logger.Error("Failed ", zap.Int("num_of_events", len(logEvents)))
+		if err := e.addRegionClient(key.region); err != nil {
+			e.pusherLock.Unlock()
+			return err
+		}
+		pusher, ok := e.pusherFor(key)
+		if !ok {
+			rejected++
+			continue
 		}
+		e.pusherPeriod(key, logEvent.periodEnd)
+		i, ok := index[pusher]
+		if !ok {
+			i = len(pushers)
+			index[pusher] = i
+			pushers = append(pushers, keyedPusher{key, pusher})
+			events = append(events, nil)
+		}
+		events[i] = append(events[i], &cwlogs.Event{
+			InputLogEvent: logEvent.InputLogEvent,
+			GeneratedTime: time.Now(),
+		})
+	}
+	if rejected > 0 {
+		e.logger.Warn("Dropping log records of new log streams, the maximum number of pushers is reached",
+			zap.Int("num_of_events", rejected), zap.Int("max_pushers", e.Config.MaxPushers))
+	}
+	if e.Config.Coalescing.Window > 0 {
+		e.pusherLock.Unlock()
+		e.coalesce(ctx, pushers, events)
+		return nil
+	}
+	for _, retired := range e.retired {
+		// the pushers evicted by this export are flushed once
+		if _, ok := index[retired.pusher]; !ok {
+			pushers = append(pushers, retired)
+			events = append(events, nil)
+		}
+	}
+	e.retired = nil
+	e.pending = 0
+	e.pusherLock.Unlock()
+	if err := e.push(ctx, pushers, events); err != nil {
+		return err
 	}
 	e.logger.Debug("Log events are successfully put")
-	flushErr := cwLogsPusher.ForceFlush()
-	if flushErr != nil {
-		e.logger.Error("Error force flushing logs. Skipping to next logPusher.", zap.Error(flushErr))
-		return flushErr
+	return nil
+}
+
+// coalesce adds the events to their pushers, to push them when the coalescing window elapses, or at once when
+// MaxEvents events are pending. The pushers keep the events of a failed push to push them again, the export is not
+// retried.
+func (e *exporter) coalesce(ctx context.Context, pushers []keyedPusher, events [][]*cwlogs.Event) {
+	added := 0
+	for i, p := range pushers {
+		e.addEvents(p.pusher, events[i])
+		added += len(events[i])
+	}
+	e.pusherLock.Lock()
+	for _, p := range pushers {
+		// the pushers retired or dropped while the events were added are flushed with the retired ones
+		if !e.flushedLater(p) {
+			e.retired = append(e.retired, p)
+		}
+	}
+	e.pending += added
+	if maxEvents := e.Config.Coalescing.MaxEvents; maxEvents == 0 || e.pending < maxEvents {
+		e.pusherLock.Unlock()
+		return
+	}
+	taken := e.takePushers()
+	e.pusherLock.Unlock()
+	// the error is logged by flush
+	_ = e.flush(ctx, taken)
+}
+
+// flushedLater returns whether the pusher is flushed by the next coalescing flush, being a pusher of the exporter or
+// a retired one. It must be called with the pusher lock held.
+func (e *exporter) flushedLater(p keyedPusher) bool {
+	if p.pusher == e.pusher || e.pushers[p.key] == p.pusher {
+		return true
+	}
+	for _, shard := range e.shards[p.key] {
+		if shard == p.pusher {
+			return true
+		}
+	}
+	for _, retired := range e.retired {
+		if retired.pusher == p.pusher {
+			return true
+		}
+	}
+	return false
+}
+
+// addEvents adds the events to the pusher, which pushes its batch when it is full
+func (e *exporter) addEvents(pusher cwlogs.Pusher, events []*cwlogs.Event) {
+	for _, event := range events {
+		e.logger.Debug("Adding log event", zap.Any("event", event))
+		if err := pusher.AddLogEntry(event); err != nil {
+			e.logger.Error("Failed ", zap.Int("num_of_events", len(events)))
+		}
+	}
+}
+
+// defaultPusherKey identifies the pusher of the configured log group and log stream, or of their fallbacks
+func (e *exporter) defaultPusherKey() pusherKey {
+	return pusherKey{logGroupName: e.Config.defaultLogGroupName(), logStreamName: e.Config.defaultLogStreamName()}
+}
+
+// pusherFor returns the pusher of the log stream, creating it on first use, or false when it cannot be created
+// under MaxPushers. It must be called with the pusher lock held.
+func (e *exporter) pusherFor(key pusherKey) (cwlogs.Pusher, bool) {
+	if key == e.defaultPusherKey() {
+		return e.shardFor(key, e.pusher), true
+	}
+	pusher, ok := e.pushers[key]
+	if !ok {
+		if !e.makeRoomForPusher() {
+			return nil, false
+		}
+		if e.pushers == nil {
+			e.pushers = map[pusherKey]cwlogs.Pusher{}
+		}
+		pusher = e.newPusher(key.region, key.logGroupName, e.rotatedStreamName(key.logStreamName))
+		e.pushers[key] = pusher
+	}
+	if e.Config.PusherIdleTimeout > 0 || e.Config.MaxPushers > 0 {
+		if e.lastUsed == nil {
+			e.lastUsed = map[pusherKey]time.Time{}
+		}
+		e.lastUsed[key] = now()
+	}
+	return e.shardFor(key, pusher), true
+}
+
+// addRegionClient creates the client of a region resolved from the resource attributes on first use, sharing the
+// client of the exporters with the same AWS session settings in the region. It must be called with the pusher lock
+// held.
+func (e *exporter) addRegionClient(region string) error {
+	if region == "" {
+		return nil
 	}
+	if _, ok := e.regionClients[region]; ok {
+		return nil
+	}
+	client, err := e.newRegionClient(region)
+	if err != nil {
+		return fmt.Errorf("failed to create the CloudWatch Logs client of region %q: %w", region, err)
+	}
+	if e.regionClients == nil {
+		e.regionClients = map[string]*sharedClient{}
+	}
+	e.regionClients[region] = client
 	return nil
 }
 
+// takePushers returns every pusher to flush them, including the pushers retired by the last rotation, and resets
+// the pending events. It must be called with the pusher lock held.
+func (e *exporter) takePushers() []keyedPusher {
+	e.pending = 0
+	pushers := e.retired
+	e.retired = nil
+	e.forEachPusher(func(key pusherKey, pusher cwlogs.Pusher) {
+		pushers = append(pushers, keyedPusher{key, pusher})
+	})
+	return pushers
+}
+
+// flush pushes the pending events of the pushers concurrently, as every pusher synchronizes the requests to its
+// log stream. It must be called without the pusher lock held, so that the exports to other log streams are not
+// held up by the pushes. The first error is returned, once all the pushers were flushed.
+func (e *exporter) flush(ctx context.Context, pushers []keyedPusher) error {
+	return e.push(ctx, pushers, nil)
+}
+
+// push adds the events of an export to the pushers and flushes them, like flush. The events are in the order of
+// the pushers, nil when there are none to add.
+func (e *exporter) push(ctx context.Context, pushers []keyedPusher, events [][]*cwlogs.Event) error {
+	errs := e.forceFlush(ctx, pushers, events)
+	// pushErr is the first error that is not the cancellation of the context, which tells nothing of the log streams
+	var flushErr, pushErr error
+	throttled := false
+	// the log streams throttled for exceeding their quota
+	overQuota := map[pusherKey]bool{}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		e.logger.Error("Error force flushing logs. Skipping to next logPusher.",
+			zap.String("LogGroupName", pushers[i].key.logGroupName),
+			zap.String("LogStreamName", pushers[i].key.logStreamName),
+			zap.Error(err))
+		if flushErr == nil {
+			flushErr = err
+		}
+		if pushErr == nil && (ctx.Err() == nil || !errors.Is(err, ctx.Err())) {
+			pushErr = err
+		}
+		throttled = throttled || isThrottlingError(err)
+		if isStreamQuotaError(err) {
+			overQuota[pushers[i].key] = true
+		}
+	}
+
+	e.pusherLock.Lock()
+	defer e.pusherLock.Unlock()
+	if flushErr != nil && pushErr == nil {
+		e.breaker.cancel()
+	} else {
+		e.breaker.record(pushErr)
+	}
+	// sharding the log streams over their quota spares rotating every log stream
+	sharded := false
+	for key := range overQuota {
+		sharded = e.addShard(key) || sharded
+	}
+	if flushErr != nil && e.Config.RotateStreamOnThrottling && throttled && !sharded {
+		e.rotateStream()
+	}
+	e.retireEndedPeriods(pushers, errs)
+	return flushErr
+}
+
+// forceFlush adds the events to the pushers and flushes them concurrently, and returns their errors in the same
+// order. Every pusher is flushed under its export lock, so that an export never pushes the events of a concurrent
+// one, whose failure it could not report. The events are not added to a pusher once the context is cancelled, e.g.
+// while waiting for the lock, so that the retry of the export pushes them once.
+func (e *exporter) forceFlush(ctx context.Context, pushers []keyedPusher, events [][]*cwlogs.Event) []error {
+	e.pusherLock.Lock()
+	locks := e.acquireExportLocks(pushers)
+	e.pusherLock.Unlock()
+	defer func() {
+		e.pusherLock.Lock()
+		e.releaseExportLocks(pushers)
+		e.pusherLock.Unlock()
+	}()
+	errs := make([]error, len(pushers))
+	var wg sync.WaitGroup
+	for i, p := range pushers {
+		wg.Add(1)
+		go func(i int, p keyedPusher) {
+			defer wg.Done()
+			locks[i].Lock()
+			defer locks[i].Unlock()
+			if events != nil && len(events[i]) > 0 {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					return
+				}
+				e.addEvents(p.pusher, events[i])
+			}
+			errs[i] = p.pusher.ForceFlush(ctx)
+		}(i, p)
+	}
+	wg.Wait()
+	return errs
+}
+
+// acquireExportLocks returns the export locks of the pushers, in the same order, created on first use. It must be
+// called with the pusher lock held, and the locks released with releaseExportLocks.
+func (e *exporter) acquireExportLocks(pushers []keyedPusher) []*exportLock {
+	if e.exportLocks == nil {
+		e.exportLocks = map[cwlogs.Pusher]*exportLock{}
+	}
+	locks := make([]*exportLock, len(pushers))
+	for i, p := range pushers {
+		lock, ok := e.exportLocks[p.pusher]
+		if !ok {
+			lock = &exportLock{}
+			e.exportLocks[p.pusher] = lock
+		}
+		lock.users++
+		locks[i] = lock
+	}
+	return locks
+}
+
+// releaseExportLocks drops the export locks of the pushers no other flush uses. It must be called with the pusher
+// lock held.
+func (e *exporter) releaseExportLocks(pushers []keyedPusher) {
+	for _, p := range pushers {
+		lock := e.exportLocks[p.pusher]
+		if lock.users--; lock.users == 0 {
+			delete(e.exportLocks, p.pusher)
+		}
+	}
+}
+
+// forEachPusher calls fn with the default pusher, then with the pushers of the resolved log streams and of the
+// additional shards
+func (e *exporter) forEachPusher(fn func(key pusherKey, pusher cwlogs.Pusher)) {
+	if e.pusher != nil {
+		fn(e.defaultPusherKey(), e.pusher)
+	}
+	for key, pusher := range e.pushers {
+		fn(key, pusher)
+	}
+	for key, shards := range e.shards {
+		for _, pusher := range shards {
+			fn(key, pusher)
+		}
+	}
+}
+
+// flushPeriodically pushes the events coalesced over each window until the exporter shuts down.
+// The events of a failed push are kept by the pushers and pushed again with the next window, as the exports they come
+// from have completed already.
+func (e *exporter) flushPeriodically(window time.Duration) {
+	defer e.background.Done()
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			e.pusherLock.Lock()
+			// the pending events wait while the circuit breaker is open
+			if e.pending == 0 || e.breaker.blocked() {
+				e.pusherLock.Unlock()
+				continue
+			}
+			pushers := e.takePushers()
+			e.pusherLock.Unlock()
+			// the error is logged by flush
+			_ = e.flush(context.Background(), pushers)
+		}
+	}
+}
+
+// rotatedStreamName is the name of the active log stream for the resolved log stream name
+func (e *exporter) rotatedStreamName(streamName string) string {
+	if e.streamSuffix == 0 {
+		return streamName
+	}
+	return streamName + "-" + strconv.Itoa(e.streamSuffix)
+}
+
+// rotateStream moves every log stream of the exporter to the stream with the next suffix. The events of the
+// failed flush are dropped by the pusher, the batch is retried on the new stream by the retry settings. The replaced
+// pushers are retired, so that the events added to them since the flush are pushed by the next one.
+func (e *exporter) rotateStream() {
+	e.forEachPusher(func(key pusherKey, pusher cwlogs.Pusher) {
+		e.retired = append(e.retired, keyedPusher{key, pusher})
+	})
+	e.streamSuffix++
+	defaultKey := e.defaultPusherKey()
+	e.logger.Info("Log stream is throttled, rotating to a new log stream",
+		zap.String("LogGroupName", defaultKey.logGroupName),
+		zap.String("LogStreamName", e.rotatedStreamName(defaultKey.logStreamName)))
+	e.pusher = e.newPusher(defaultKey.region, defaultKey.logGroupName, e.rotatedStreamName(defaultKey.logStreamName))
+	for key := range e.pushers {
+		e.pushers[key] = e.newPusher(key.region, key.logGroupName, e.rotatedStreamName(key.logStreamName))
+	}
+	for key, shards := range e.shards {
+		for i := range shards {
+			shards[i] = e.newPusher(key.region, key.logGroupName, shardStreamName(e.rotatedStreamName(key.logStreamName), i+1))
+		}
+	}
+}
+
+// recordPerLogGroup counts log records, such as the ones dropped because they could not be converted to valid
+// events, per exporter, and per log group when all the records of the exporter go to the same one
+func (e *exporter) recordPerLogGroup(ctx context.Context, measure *stats.Int64Measure, n int) {
+	_ = stats.RecordWithTags(ctx, e.Config.metricTags(), measure.M(int64(n)))
+}
+
+// metricTags are the tags of the metrics recorded per log group: the exporter, and the log group unless it is
+// templated, to bound the cardinality
+func (config *Config) metricTags() []tag.Mutator {
+	mutators := []tag.Mutator{tag.Upsert(exporterTagKey, config.ID().String())}
+	if !isTemplated(config.LogGroupName) {
+		mutators = append(mutators, tag.Upsert(logGroupTagKey, config.LogGroupName))
+	}
+	return mutators
+}
+
+// countTruncated returns the number of events whose body was cut to fit the event size limit
+func countTruncated(events []cwLogEvent) int {
+	n := 0
+	for _, event := range events {
+		if event.truncated {
+			n++
+		}
+	}
+	return n
+}
+
+// countFallbacks returns the number of events sent to the fallback log group or log stream because their template
+// could not be resolved
+func countFallbacks(events []cwLogEvent) int {
+	n := 0
+	for _, event := range events {
+		if event.fallback {
+			n++
+		}
+	}
+	return n
+}
+
+// onBreakerStateChange reports the state of the circuit breaker in the logs and the breaker state metric
+func (e *exporter) onBreakerStateChange(state breakerState) {
+	if state == breakerOpen {
+		e.logger.Warn("CloudWatch Logs keeps failing, pausing the exports",
+			zap.Duration("cool_down", e.Config.CircuitBreaker.CoolDown))
+	} else {
+		e.logger.Info("Circuit breaker of the exports changed state", zap.Stringer("state", state))
+	}
+	_ = stats.RecordWithTags(context.Background(),
+		[]tag.Mutator{tag.Upsert(exporterTagKey, e.Config.ID().String())},
+		mCircuitBreakerState.M(int64(state)))
+}
+
+func isThrottlingError(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == errCodeThrottlingException
+}
+
 func (e *exporter) Capabilities() consumer.Capabilities {
 	return consumer.Capabilities{MutatesData: false}
 }
 
 func (e *exporter) Shutdown(ctx context.Context) error {
-	if e.pusher != nil {
-		e.pusher.ForceFlush()
+	if e.done != nil {
+		close(e.done)
+		e.background.Wait()
 	}
-	return nil
+	e.pusherLock.Lock()
+	pushers := e.takePushers()
+	e.pusherLock.Unlock()
+	var errs error
+	for i, err := range e.forceFlush(ctx, pushers, nil) {
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("failed to flush log stream %q of log group %q: %w",
+				pushers[i].key.logStreamName, pushers[i].key.logGroupName, err))
+		}
+	}
+	if e.dryRunFile != nil {
+		errs = multierr.Append(errs, e.dryRunFile.Close())
+	}
+	return errs
 }
 
 func (e *exporter) Start(ctx context.Context, host component.Host) error {
+	if err := e.openDryRunFile(); err != nil {
+		return err
+	}
+	if e.Config.Coalescing.Window > 0 || e.Config.PusherIdleTimeout > 0 {
+		e.done = make(chan struct{})
+	}
+	if window := e.Config.Coalescing.Window; window > 0 {
+		e.background.Add(1)
+		go e.flushPeriodically(window)
+	}
+	if timeout := e.Config.PusherIdleTimeout; timeout > 0 {
+		e.background.Add(1)
+		go e.reapIdlePushers(timeout)
+	}
 	return nil
 }
 
-func logsToCWLogs(logger *zap.Logger, ld pdata.Logs) ([]*cloudwatchlogs.InputLogEvent, int) {
+// cwLogEvent is a CloudWatch event with the log group and log stream it is pushed to
+type cwLogEvent struct {
+	*cloudwatchlogs.InputLogEvent
+	logGroupName  string
+	logStreamName string
+	// region is the region resolved from the resource attributes, empty for the region of the exporter
+	region string
+	// truncated tells the body of the record was cut to fit the event size limit
+	truncated bool
+	// fallback tells the log group or log stream is the fallback of a template that could not be resolved
+	fallback bool
+	// periodEnd is the end of the period of the log stream rotated by LogStreamRotation, zero without rotation
+	periodEnd time.Time
+}
+
+// clockSkew returns how far the clock of the CloudWatch Logs servers is ahead of the local one, when it is corrected
+func (e *exporter) clockSkew() time.Duration {
+	if !e.Config.ClockSkewCorrection || e.svcStructuredLog == nil {
+		return 0
+	}
+	return e.svcStructuredLog.ClockSkew()
+}
+
+// logsToCWLogs converts the log records to CloudWatch events, and returns them with the number of records dropped
+// because they could not be converted, and the number of records left out by sampling. The log group of the events
+// is resolved from the attributes of their resource, and their log stream from the attributes of the record too.
+func logsToCWLogs(logger *zap.Logger, ld pdata.Logs, config *Config, names logNames, skew time.Duration) ([]cwLogEvent, int, int) {
 	n := ld.ResourceLogs().Len()
 	if n == 0 {
-		return []*cloudwatchlogs.InputLogEvent{}, 0
+		return []cwLogEvent{}, 0, 0
 	}
 
-	var dropped int
-	out := make([]*cloudwatchlogs.InputLogEvent, 0) // TODO(jbd): set a better capacity
+	var dropped, sampledOut int
+	out := make([]cwLogEvent, 0) // TODO(jbd): set a better capacity
 
 	rls := ld.ResourceLogs()
 	for i := 0; i < rls.Len(); i++ {
 		rl := rls.At(i)
-		resourceAttrs := attrsValue(rl.Resource().Attributes())
+		logGroupName, groupFallback := names.logGroupName(config, rl.Resource())
+		region, regionErr := names.regionName(rl.Resource())
+		resourceAttrs := attrsValue(rl.Resource().Attributes(), config.DropNilAttributes)
+		resourceAttrs = filterAttributes(resourceAttrs, config.ResourceAttributeInclude, config.ResourceAttributeExclude)
+		for _, key := range config.DropResourceAttributes {
+			delete(resourceAttrs, key)
+		}
+		if config.FlattenAttributes {
+			resourceAttrs = flattenAttributes(resourceAttrs, config.maxFlattenDepth())
+		}
+		formatAttributes(resourceAttrs, config.AttributeFormatters)
 
 		ills := rl.InstrumentationLibraryLogs()
 		for j := 0; j < ills.Len(); j++ {
@@ -157,33 +887,257 @@ func logsToCWLogs(logger *zap.Logger, ld pdata.Logs) ([]*cloudwatchlogs.InputLog
 			logs := ils.Logs()
 			for k := 0; k < logs.Len(); k++ {
 				log := logs.At(k)
-				event, err := logToCWLog(resourceAttrs, log)
+				if config.Sampling.Enabled && !sampled(config.Sampling, rl.Resource(), log) {
+					sampledOut++
+					continue
+				}
+				if regionErr != nil {
+					logger.Warn("Dropping a log record without a valid region", zap.Error(regionErr))
+					dropped++
+					continue
+				}
+				logStreamName, streamFallback, err := names.logStreamName(config, rl.Resource(), log)
+				if err != nil {
+					logger.Warn("Dropping a log record without a valid log stream", zap.Error(err))
+					dropped++
+					continue
+				}
+				event, truncated, err := logToCWLog(resourceAttrs, log, config)
 				if err != nil {
 					logger.Debug("Failed to convert to CloudWatch Log", zap.Error(err))
 					dropped++
-				} else {
-					out = append(out, event)
+					continue
 				}
+				if !applyTimestampRange(event, config.TimestampOutOfRange, skew) {
+					logger.Debug("Dropping a log record with a timestamp out of the range accepted by CloudWatch Logs",
+						zap.Time("timestamp", epochToTime(*event.Timestamp)),
+						zap.String("log_group_name", logGroupName),
+						zap.String("log_stream_name", logStreamName),
+						zap.String("message", *event.Message))
+					dropped++
+					continue
+				}
+				logStreamName, periodEnd := streamPeriod(logStreamName, config.LogStreamRotation, *event.Timestamp)
+				out = append(out, cwLogEvent{InputLogEvent: event, logGroupName: logGroupName, logStreamName: logStreamName, region: region, truncated: truncated, fallback: groupFallback || streamFallback, periodEnd: periodEnd})
+			}
+		}
+	}
+	return out, dropped, sampledOut
+}
+
+// sampled tells whether the record is part of the exported fraction, based on the hash of its sampling key
+func sampled(settings SamplingSettings, resource pdata.Resource, log pdata.LogRecord) bool {
+	var key []byte
+	if settings.Key == "" {
+		if log.TraceID().IsEmpty() {
+			return true
+		}
+		traceID := log.TraceID().Bytes()
+		key = traceID[:]
+	} else {
+		value, ok := log.Attributes().Get(settings.Key)
+		if !ok {
+			if value, ok = resource.Attributes().Get(settings.Key); !ok {
+				return true
 			}
 		}
+		key = []byte(value.AsString())
 	}
-	return out, dropped
+	hasher := fnv.New32a()
+	hasher.Write(key)
+	return float64(hasher.Sum32()) < settings.Ratio*(1<<32)
 }
 
+// cwLogBody is the JSON event of a record. The names of its fixed fields depend on the field naming, see
+// fixedFields.
 type cwLogBody struct {
-	Name                   string                 `json:"name,omitempty"`
-	Body                   interface{}            `json:"body,omitempty"`
-	SeverityNumber         int32                  `json:"severity_number,omitempty"`
-	SeverityText           string                 `json:"severity_text,omitempty"`
-	DroppedAttributesCount uint32                 `json:"dropped_attributes_count,omitempty"`
-	Flags                  uint32                 `json:"flags,omitempty"`
-	TraceID                string                 `json:"trace_id,omitempty"`
-	SpanID                 string                 `json:"span_id,omitempty"`
-	Attributes             map[string]interface{} `json:"attributes,omitempty"`
-	Resource               map[string]interface{} `json:"resource,omitempty"`
-}
-
-func logToCWLog(resourceAttrs map[string]interface{}, log pdata.LogRecord) (*cloudwatchlogs.InputLogEvent, error) {
+	Name                   string
+	Body                   interface{}
+	SeverityNumber         int32
+	SeverityText           string
+	DroppedAttributesCount uint32
+	Flags                  uint32
+	TraceID                string
+	SpanID                 string
+	Attributes             map[string]interface{}
+	Resource               map[string]interface{}
+	// Truncated and OriginalBytes flag events whose body was cut to fit the CloudWatch event size limit.
+	Truncated     bool
+	OriginalBytes int
+	// fields are the top-level fields whose name is configurable, written after the fixed ones.
+	fields map[string]interface{}
+	// compact writes <, > and & as is instead of escaping them
+	compact bool
+	// naming is the naming convention of the fixed fields, snake_case when it is empty
+	naming string
+}
+
+// MarshalJSON writes the fixed fields of the body which are set, named after the field naming, followed by the ones
+// with a configurable name. The latter take the place of the fixed fields of the same name.
+func (b cwLogBody) MarshalJSON() ([]byte, error) {
+	out := []byte{'{'}
+	for _, field := range b.fixedFields() {
+		name := fieldName(field.name, b.naming)
+		if _, ok := b.fields[name]; ok {
+			continue
+		}
+		value, err := marshalJSON(field.value, b.compact)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > 1 {
+			out = append(out, ',')
+		}
+		out = strconv.AppendQuote(out, name)
+		out = append(out, ':')
+		out = append(out, value...)
+	}
+	out = append(out, '}')
+	if len(b.fields) == 0 {
+		return out, nil
+	}
+	fields, err := marshalJSON(b.fields, b.compact)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == len("{}") {
+		return fields, nil
+	}
+	out = append(out[:len(out)-1], ',')
+	return append(out, fields[1:]...), nil
+}
+
+// marshal returns the JSON representation of the body
+func (b cwLogBody) marshal() ([]byte, error) {
+	return marshalJSON(b, b.compact)
+}
+
+// marshalJSON returns the JSON representation of v. Unless compact is set, <, > and & are escaped as \u003c,
+// \u003e and \u0026 like json.Marshal does, which takes 6 bytes instead of 1 for each of them.
+func marshalJSON(v interface{}, compact bool) ([]byte, error) {
+	if !compact {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	// the encoder terminates the value with a newline
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// traceFlagsSampled is the sampled bit of the W3C trace flags
+const traceFlagsSampled = 1
+
+const (
+	pipelineLatencyField = "pipeline_latency_ms"
+	exportedAtField      = "exported_at"
+	// defaultCollectorIDField is the name of the field added by IncludeCollectorID
+	defaultCollectorIDField = "collector_id"
+	// defaultTraceIDField and defaultSpanIDField are the names of the fixed fields of the IDs in cwLogBody
+	defaultTraceIDField = "trace_id"
+	defaultSpanIDField  = "span_id"
+)
+
+// now returns the current time, replaced in tests
+var now = time.Now
+
+// cwAgentLogBody mimics the layout of the events sent by the CloudWatch agent,
+// so that Logs Insights queries written for the agent keep working.
+type cwAgentLogBody struct {
+	Timestamp string `json:"@timestamp"`
+	Message   string `json:"@message"`
+}
+
+// cwAgentTimestampFormat is RFC3339 with millisecond precision, the precision of CloudWatch event timestamps
+const cwAgentTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// logToCWLog converts the log record to a CloudWatch event, and tells whether its body was cut to fit the
+// event size limit.
+func logToCWLog(resourceAttrs map[string]interface{}, log pdata.LogRecord, config *Config) (*cloudwatchlogs.InputLogEvent, bool, error) {
+	timestamp := eventTimestamp(log, config)
+	var body []byte
+	var truncated bool
+	var err error
+	switch {
+	case config.EMFPassthrough && isEMFBody(log.Body(), config):
+		body = []byte(log.Body().StringVal())
+	case config.RawLog:
+		body, truncated, err = rawLogMessage(log, config)
+	case config.Format == FormatCWAgent:
+		body, err = cwAgentLogToJSON(log, timestamp)
+	default:
+		body, truncated, err = cwLogToJSON(resourceAttrs, log, config, timestamp)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	message := string(body)
+	if config.AppendNewline {
+		message += "\n"
+	}
+	return &cloudwatchlogs.InputLogEvent{
+		Timestamp: aws.Int64(timestamp.UnixNano() / int64(time.Millisecond)), // in milliseconds
+		Message:   aws.String(message),
+	}, truncated, nil
+}
+
+// maxBodyBytes is the room left for the JSON body in the message of an event
+func maxBodyBytes(config *Config) int {
+	if config.AppendNewline {
+		return config.eventSizeLimit() - len("\n")
+	}
+	return config.eventSizeLimit()
+}
+
+// rawLogMessage is the body of the record as is when it is a string, and its JSON representation otherwise,
+// without the fields of the record around it. Bodies too large for an event are cut and end with the truncated
+// suffix.
+func rawLogMessage(log pdata.LogRecord, config *Config) ([]byte, bool, error) {
+	var message []byte
+	if log.Body().Type() == pdata.AttributeValueTypeString {
+		message = []byte(log.Body().StringVal())
+	} else {
+		var err error
+		if message, err = json.Marshal(attrValue(log.Body())); err != nil {
+			return nil, false, err
+		}
+	}
+	maxBytes := maxBodyBytes(config)
+	if len(message) <= maxBytes {
+		return message, false, nil
+	}
+	cut := maxBytes - len(config.TruncatedSuffix)
+	if cut < 0 {
+		cut = 0
+	}
+	// Do not split a multibyte character
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return append(message[:cut:cut], config.TruncatedSuffix...), true, nil
+}
+
+func cwAgentLogToJSON(log pdata.LogRecord, timestamp time.Time) ([]byte, error) {
+	body := cwAgentLogBody{
+		Timestamp: timestamp.UTC().Format(cwAgentTimestampFormat),
+	}
+	// The agent ships log lines, other kinds of bodies are sent as their JSON representation
+	if log.Body().Type() == pdata.AttributeValueTypeString {
+		body.Message = log.Body().StringVal()
+	} else {
+		message, err := json.Marshal(attrValue(log.Body()))
+		if err != nil {
+			return nil, err
+		}
+		body.Message = string(message)
+	}
+	return json.Marshal(body)
+}
+
+func cwLogToJSON(resourceAttrs map[string]interface{}, log pdata.LogRecord, config *Config, timestamp time.Time) ([]byte, bool, error) {
 	// TODO(jbd): Benchmark and improve the allocations.
 	// Evaluate go.elastic.co/fastjson as a replacement for encoding/json.
 	body := cwLogBody{
@@ -193,38 +1147,259 @@ func logToCWLog(resourceAttrs map[string]interface{}, log pdata.LogRecord) (*clo
 		SeverityText:           log.SeverityText(),
 		DroppedAttributesCount: log.DroppedAttributesCount(),
 		Flags:                  log.Flags(),
+		naming:                 config.FieldNaming,
 	}
 	if traceID := log.TraceID(); !traceID.IsEmpty() {
 		body.TraceID = traceID.HexString()
+		if config.XRayTraceFormat {
+			body.TraceID = xrayTraceID(traceID)
+		}
 	}
 	if spanID := log.SpanID(); !spanID.IsEmpty() {
 		body.SpanID = spanID.HexString()
 	}
-	body.Attributes = attrsValue(log.Attributes())
+	if config.CompactJSON {
+		body.compact = true
+		// the severity text names the severity level already
+		if body.SeverityText != "" {
+			body.SeverityNumber = 0
+		}
+	}
+	if config.OmitRawSeverity {
+		body.SeverityNumber = 0
+		body.SeverityText = ""
+	}
+	body.Attributes = attrsValue(log.Attributes(), config.DropNilAttributes)
+	if config.FlattenAttributes {
+		body.Attributes = flattenAttributes(body.Attributes, config.maxFlattenDepth())
+	}
+	formatAttributes(body.Attributes, config.AttributeFormatters)
 	body.Resource = resourceAttrs
+	body.fields = map[string]interface{}{}
+	// the IDs under other names are written with the configurable fields
+	if field := config.traceIDField(); field != defaultTraceIDField && body.TraceID != "" {
+		body.fields[field] = body.TraceID
+		body.TraceID = ""
+	}
+	if field := config.spanIDField(); field != defaultSpanIDField && body.SpanID != "" {
+		body.fields[field] = body.SpanID
+		body.SpanID = ""
+	}
+	extractFields(body.fields, body.Body, config.FieldExtractors)
+	if config.SampledField != "" {
+		body.fields[config.SampledField] = log.Flags()&traceFlagsSampled != 0
+	}
+	if config.IncludeCollectorID {
+		body.fields[config.collectorIDField()] = config.collectorID
+	}
+	if config.SeverityAsLevel {
+		if level := severityLevel(log.SeverityNumber()); level != "" {
+			body.fields[levelField] = level
+		}
+	}
+	// The time the record was observed is not available, so the latency is measured until the export
+	if config.PipelineLatency && timestamp.UnixNano() != 0 {
+		latency := now().Sub(timestamp).Milliseconds()
+		if latency < 0 {
+			latency = 0
+		}
+		body.fields[pipelineLatencyField] = latency
+	}
+	if config.ExportedAt {
+		body.fields[exportedAtField] = now().UnixNano() / int64(time.Millisecond)
+	}
+	if config.PropagatedContext {
+		addPropagatedContext(body.fields, log.Attributes())
+	}
+	addEMFMetrics(body.fields, config, resourceAttrs, log.Attributes(), timestamp)
+	if len(config.RedactAttributes) > 0 {
+		redactAttributes(&body, config.RedactAttributes, config.redactionMask())
+	}
+	if config.bodyTransform != nil {
+		config.bodyTransform(&body)
+	}
 
-	bodyJSON, err := json.Marshal(body)
+	bodyJSON, err := body.marshal()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return &cloudwatchlogs.InputLogEvent{
-		Timestamp: aws.Int64(int64(log.Timestamp()) / int64(time.Millisecond)), // in milliseconds
-		Message:   aws.String(string(bodyJSON)),
-	}, nil
+	if maxBytes := maxBodyBytes(config); len(bodyJSON) > maxBytes {
+		return truncateBody(&body, bodyJSON, maxBytes, config.TruncatedSuffix)
+	}
+	return bodyJSON, false, nil
 }
 
-func attrsValue(attrs pdata.AttributeMap) map[string]interface{} {
+// epochNanosThreshold separates epoch timestamps in milliseconds from the ones in nanoseconds:
+// 1e15 milliseconds is in year 33658 while 1e15 nanoseconds is in January 1970.
+const epochNanosThreshold = 1e15
+
+// eventTimestamp returns the time of the event, read from the configured timestamp attribute when it is set
+// and parseable, and from the configured timestamp source otherwise.
+func eventTimestamp(log pdata.LogRecord, config *Config) time.Time {
+	if config.TimestampAttribute != "" {
+		if value, ok := log.Attributes().Get(config.TimestampAttribute); ok {
+			if timestamp, ok := parseTimestamp(value); ok {
+				return timestamp
+			}
+		}
+	}
+	if config.TimestampSource == TimestampSourceNow || (config.TimestampSource == TimestampSourceRecord && log.Timestamp() == 0) {
+		return now()
+	}
+	return log.Timestamp().AsTime()
+}
+
+// parseTimestamp parses an epoch in milliseconds or nanoseconds, or an RFC3339 string.
+func parseTimestamp(value pdata.AttributeValue) (time.Time, bool) {
+	switch value.Type() {
+	case pdata.AttributeValueTypeInt:
+		return epochToTime(value.IntVal()), true
+	case pdata.AttributeValueTypeDouble:
+		return epochToTime(int64(value.DoubleVal())), true
+	case pdata.AttributeValueTypeString:
+		if epoch, err := strconv.ParseInt(value.StringVal(), 10, 64); err == nil {
+			return epochToTime(epoch), true
+		}
+		if timestamp, err := time.Parse(time.RFC3339Nano, value.StringVal()); err == nil {
+			return timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func epochToTime(epoch int64) time.Time {
+	if epoch >= epochNanosThreshold || epoch <= -epochNanosThreshold {
+		return time.Unix(0, epoch)
+	}
+	return time.Unix(0, epoch*int64(time.Millisecond))
+}
+
+const (
+	// maxEventAge and maxEventLead bound the timestamps of the events accepted by PutLogEvents
+	maxEventAge  = 14 * 24 * time.Hour
+	maxEventLead = 2 * time.Hour
+
+	// timestampRangeMargin keeps clamped events within the accepted range while they wait in the sending queue
+	timestampRangeMargin = 5 * time.Minute
+)
+
+// applyTimestampRange applies the policy to an event whose timestamp is out of the range accepted by PutLogEvents,
+// and returns false when the event must be dropped. The range is shifted by the skew of the clock of the servers.
+func applyTimestampRange(event *cloudwatchlogs.InputLogEvent, policy string, skew time.Duration) bool {
+	if policy == "" || policy == TimestampOutOfRangeKeep {
+		return true
+	}
+	current := now().Add(skew)
+	oldest := current.Add(-maxEventAge).UnixNano() / int64(time.Millisecond)
+	newest := current.Add(maxEventLead).UnixNano() / int64(time.Millisecond)
+	timestamp := *event.Timestamp
+	if timestamp >= oldest && timestamp <= newest {
+		return true
+	}
+	if policy == TimestampOutOfRangeDrop {
+		return false
+	}
+	margin := int64(timestampRangeMargin / time.Millisecond)
+	if timestamp < oldest {
+		event.Timestamp = aws.Int64(oldest + margin)
+	} else {
+		event.Timestamp = aws.Int64(newest - margin)
+	}
+	return true
+}
+
+// truncateBody shortens the body of an event whose marshalled form exceeds maxBytes, ends it with suffix and marks
+// it as truncated. Non-string bodies are truncated on their JSON representation. When the rest of the event is too
+// large on its own the original message is returned, leaving the truncation to the pusher.
+func truncateBody(body *cwLogBody, bodyJSON []byte, maxBytes int, suffix string) ([]byte, bool, error) {
+	original := bodyJSON
+	text, ok := body.Body.(string)
+	if !ok {
+		raw, err := marshalJSON(body.Body, body.compact)
+		if err != nil {
+			return nil, false, err
+		}
+		text = string(raw)
+	}
+	body.Body = text + suffix
+	body.Truncated = true
+	body.OriginalBytes = len(original)
+
+	var err error
+	if bodyJSON, err = body.marshal(); err != nil {
+		return nil, false, err
+	}
+	for len(bodyJSON) > maxBytes {
+		quoted, err := marshalJSON(text, body.compact)
+		if err != nil {
+			return nil, false, err
+		}
+		// Escaping makes the text take more room in the message than in the body,
+		// so cut it proportionally to the room left once the rest of the event, suffix included, is accounted for.
+		room := maxBytes - (len(bodyJSON) - len(quoted))
+		if room <= len(`""`) || len(text) == 0 {
+			return original, false, nil
+		}
+		cut := len(text) * room / len(quoted)
+		if cut >= len(text) {
+			cut = len(text) - 1
+		}
+		// Do not split a multibyte character
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+		body.Body = text + suffix
+
+		if bodyJSON, err = body.marshal(); err != nil {
+			return nil, false, err
+		}
+	}
+	return bodyJSON, true, nil
+}
+
+// attrsValue converts the attributes to their JSON representation. When dropNil is set, the attributes
+// without a value, e.g. of the empty type, are left out instead of being written as null.
+func attrsValue(attrs pdata.AttributeMap, dropNil bool) map[string]interface{} {
 	if attrs.Len() == 0 {
 		return nil
 	}
 	out := make(map[string]interface{}, attrs.Len())
 	attrs.Range(func(k string, v pdata.AttributeValue) bool {
-		out[k] = attrValue(v)
+		value := attrValue(v)
+		if value == nil && dropNil {
+			return true
+		}
+		out[k] = value
 		return true
 	})
+	if len(out) == 0 {
+		return nil
+	}
 	return out
 }
 
+// filterAttributes keeps the attributes whose key is in include, or all of them when include is empty, then
+// leaves out the attributes whose key is in exclude.
+func filterAttributes(attrs map[string]interface{}, include, exclude []string) map[string]interface{} {
+	if len(include) > 0 && attrs != nil {
+		included := make(map[string]interface{}, len(include))
+		for _, key := range include {
+			if value, ok := attrs[key]; ok {
+				included[key] = value
+			}
+		}
+		attrs = included
+	}
+	for _, key := range exclude {
+		delete(attrs, key)
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
 func attrValue(value pdata.AttributeValue) interface{} {
 	switch value.Type() {
 	case pdata.AttributeValueTypeInt:
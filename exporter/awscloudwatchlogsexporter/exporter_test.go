@@ -16,7 +16,21 @@ package awscloudwatchlogsexporter
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -24,8 +38,12 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
 
 	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
 )
@@ -43,7 +61,7 @@ func (p *mockPusher) AddLogEntry(logEvent *cwlogs.Event) error {
 	return nil
 }
 
-func (p *mockPusher) ForceFlush() error {
+func (p *mockPusher) ForceFlush(_ context.Context) error {
 	args := p.Called(nil)
 	errorStr := args.String(0)
 	if errorStr != "" {
@@ -91,8 +109,8 @@ func TestLogToCWLog(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resourceAttrs := attrsValue(tt.resource.Attributes())
-			got, err := logToCWLog(resourceAttrs, tt.log)
+			resourceAttrs := attrsValue(tt.resource.Attributes(), false)
+			got, _, err := logToCWLog(resourceAttrs, tt.log, &Config{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("logToCWLog() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -102,14 +120,694 @@ func TestLogToCWLog(t *testing.T) {
 	}
 }
 
+func TestLogToCWLogTruncation(t *testing.T) {
+	tests := []struct {
+		name string
+		body func(pdata.AttributeValue)
+	}{
+		{
+			name: "string body",
+			body: func(v pdata.AttributeValue) { v.SetStringVal(strings.Repeat("a", 2*maxEventSizeBytes)) },
+		},
+		{
+			name: "multibyte string body",
+			body: func(v pdata.AttributeValue) { v.SetStringVal(strings.Repeat("é", maxEventSizeBytes)) },
+		},
+		{
+			name: "escaped string body",
+			body: func(v pdata.AttributeValue) { v.SetStringVal(strings.Repeat("<\"", maxEventSizeBytes)) },
+		},
+		{
+			name: "map body",
+			body: func(v pdata.AttributeValue) {
+				pdata.NewAttributeValueMap().CopyTo(v)
+				v.MapVal().InsertString("message", strings.Repeat("b", 2*maxEventSizeBytes))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := testLogRecord()
+			tt.body(log.Body())
+
+			got, _, err := logToCWLog(nil, log, &Config{})
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(*got.Message), maxEventSizeBytes)
+
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(*got.Message), &body))
+			assert.Equal(t, true, body["truncated"])
+			assert.Greater(t, body["original_bytes"], float64(maxEventSizeBytes))
+			assert.True(t, utf8.ValidString(body["body"].(string)))
+			assert.Equal(t, "test", body["name"])
+		})
+	}
+
+	got, _, err := logToCWLog(nil, testLogRecord(), &Config{})
+	require.NoError(t, err)
+	assert.NotContains(t, *got.Message, "truncated")
+	assert.NotContains(t, *got.Message, "original_bytes")
+}
+
+func TestLogToCWLogAppendNewline(t *testing.T) {
+	got, _, err := logToCWLog(nil, testLogRecordWithoutTrace(), &Config{AppendNewline: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"test","body":"hello world","severity_number":5,"severity_text":"debug","dropped_attributes_count":4,"attributes":{"key1":1,"key2":"attr2"}}`+"\n", *got.Message)
+
+	// A body filling the event exactly has to be truncated to make room for the newline
+	log := pdata.NewLogRecord()
+	log.SetName("test")
+	log.Body().SetStringVal(strings.Repeat("a", maxEventSizeBytes-len(`{"name":"test","body":""}`)))
+
+	got, _, err = logToCWLog(nil, log, &Config{})
+	require.NoError(t, err)
+	assert.Len(t, *got.Message, maxEventSizeBytes)
+	assert.NotContains(t, *got.Message, "truncated")
+
+	got, _, err = logToCWLog(nil, log, &Config{AppendNewline: true})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(*got.Message), maxEventSizeBytes)
+	assert.True(t, strings.HasSuffix(*got.Message, "}\n"))
+	assert.Contains(t, *got.Message, `"truncated":true`)
+}
+
+func TestLogToCWLogTimestampAttribute(t *testing.T) {
+	eventTime := time.Date(2021, 1, 4, 12, 30, 15, 123456789, time.UTC)
+	eventTimeMs := eventTime.UnixNano() / int64(time.Millisecond)
+	recordTimeMs := int64(1609719139)
+
+	tests := []struct {
+		name      string
+		attribute func(pdata.AttributeMap)
+		want      int64
+	}{
+		{
+			name:      "epoch milliseconds",
+			attribute: func(m pdata.AttributeMap) { m.InsertInt("event.time", eventTimeMs) },
+			want:      eventTimeMs,
+		},
+		{
+			name:      "epoch nanoseconds",
+			attribute: func(m pdata.AttributeMap) { m.InsertInt("event.time", eventTime.UnixNano()) },
+			want:      eventTimeMs,
+		},
+		{
+			name:      "epoch milliseconds string",
+			attribute: func(m pdata.AttributeMap) { m.InsertString("event.time", strconv.FormatInt(eventTimeMs, 10)) },
+			want:      eventTimeMs,
+		},
+		{
+			name:      "epoch milliseconds double",
+			attribute: func(m pdata.AttributeMap) { m.InsertDouble("event.time", float64(eventTimeMs)) },
+			want:      eventTimeMs,
+		},
+		{
+			name:      "RFC3339",
+			attribute: func(m pdata.AttributeMap) { m.InsertString("event.time", eventTime.Format(time.RFC3339Nano)) },
+			want:      eventTimeMs,
+		},
+		{
+			name:      "RFC3339 with offset",
+			attribute: func(m pdata.AttributeMap) { m.InsertString("event.time", "2021-01-04T14:30:15.123+02:00") },
+			want:      eventTimeMs,
+		},
+		{
+			name:      "unparseable",
+			attribute: func(m pdata.AttributeMap) { m.InsertString("event.time", "yesterday") },
+			want:      recordTimeMs,
+		},
+		{
+			name:      "unsupported type",
+			attribute: func(m pdata.AttributeMap) { m.InsertBool("event.time", true) },
+			want:      recordTimeMs,
+		},
+		{
+			name:      "missing",
+			attribute: func(m pdata.AttributeMap) {},
+			want:      recordTimeMs,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := testLogRecord()
+			tt.attribute(log.Attributes())
+			got, _, err := logToCWLog(nil, log, &Config{TimestampAttribute: "event.time"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Timestamp)
+		})
+	}
+}
+
+func TestLogToCWLogTimestampSource(t *testing.T) {
+	exportTime := time.Date(2021, 1, 4, 12, 30, 15, 123456789, time.UTC)
+	exportTimeMs := exportTime.UnixNano() / int64(time.Millisecond)
+	recordTimeMs := int64(1609719139)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return exportTime }
+
+	tests := []struct {
+		name   string
+		source string
+		record pdata.Timestamp
+		want   int64
+	}{
+		{name: "default", record: pdata.Timestamp(recordTimeMs * int64(time.Millisecond)), want: recordTimeMs},
+		{name: "default without timestamp", want: 0},
+		{name: "record", source: TimestampSourceRecord, record: pdata.Timestamp(recordTimeMs * int64(time.Millisecond)), want: recordTimeMs},
+		{name: "record without timestamp", source: TimestampSourceRecord, want: exportTimeMs},
+		{name: "now", source: TimestampSourceNow, record: pdata.Timestamp(recordTimeMs * int64(time.Millisecond)), want: exportTimeMs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := pdata.NewLogRecord()
+			log.SetTimestamp(tt.record)
+			got, _, err := logToCWLog(nil, log, &Config{TimestampSource: tt.source})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Timestamp)
+		})
+	}
+
+	// the timestamp attribute takes precedence over the source
+	log := pdata.NewLogRecord()
+	log.Attributes().InsertInt("event.time", recordTimeMs)
+	got, _, err := logToCWLog(nil, log, &Config{TimestampAttribute: "event.time", TimestampSource: TimestampSourceNow})
+	require.NoError(t, err)
+	assert.Equal(t, recordTimeMs, *got.Timestamp)
+}
+
+func TestLogToCWLogTraceIDFields(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.Body().SetStringVal("hello")
+	log.SetTraceID(pdata.NewTraceID([16]byte{0x57, 0x59, 0xe9, 0x88, 0xbd, 0x86, 0x2e, 0x3f, 0xe1, 0xbe, 0x46, 0xa9, 0x94, 0x27, 0x27, 0x93}))
+	log.SetSpanID(pdata.NewSpanID([8]byte{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8}))
+
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{
+			name: "default",
+			want: `{"body":"hello","trace_id":"5759e988bd862e3fe1be46a994272793","span_id":"53995c3f42cd8ad8"}`,
+		},
+		{
+			name:   "xray format",
+			config: Config{XRayTraceFormat: true},
+			want:   `{"body":"hello","trace_id":"1-5759e988-bd862e3fe1be46a994272793","span_id":"53995c3f42cd8ad8"}`,
+		},
+		{
+			name:   "renamed",
+			config: Config{TraceIDField: "aws.xray.trace_id", SpanIDField: "aws.xray.span_id", XRayTraceFormat: true},
+			want:   `{"body":"hello","aws.xray.span_id":"53995c3f42cd8ad8","aws.xray.trace_id":"1-5759e988-bd862e3fe1be46a994272793"}`,
+		},
+		{
+			name:   "default names",
+			config: Config{TraceIDField: "trace_id", SpanIDField: "span_id"},
+			want:   `{"body":"hello","trace_id":"5759e988bd862e3fe1be46a994272793","span_id":"53995c3f42cd8ad8"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := logToCWLog(nil, log, &tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Message)
+		})
+	}
+
+	// the fields are left out without IDs
+	got, _, err := logToCWLog(nil, pdata.NewLogRecord(), &Config{TraceIDField: "aws.xray.trace_id"})
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, *got.Message)
+}
+
+func TestLogToCWLogSampledField(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  uint32
+		config *Config
+		want   string
+	}{
+		{
+			name:   "sampled",
+			flags:  1,
+			config: &Config{SampledField: "sampled"},
+			want:   `{"name":"test","body":"hello world","flags":1,"sampled":true}`,
+		},
+		{
+			name:   "sampled with other flags",
+			flags:  0x81,
+			config: &Config{SampledField: "sampled"},
+			want:   `{"name":"test","body":"hello world","flags":129,"sampled":true}`,
+		},
+		{
+			name:   "unsampled",
+			flags:  0x80,
+			config: &Config{SampledField: "sampled"},
+			want:   `{"name":"test","body":"hello world","flags":128,"sampled":false}`,
+		},
+		{
+			name:   "no flags",
+			config: &Config{SampledField: "sampled"},
+			want:   `{"name":"test","body":"hello world","sampled":false}`,
+		},
+		{
+			name:   "custom name",
+			flags:  1,
+			config: &Config{SampledField: "trace_sampled"},
+			want:   `{"name":"test","body":"hello world","flags":1,"trace_sampled":true}`,
+		},
+		{
+			name:   "disabled",
+			flags:  1,
+			config: &Config{},
+			want:   `{"name":"test","body":"hello world","flags":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := pdata.NewLogRecord()
+			log.SetName("test")
+			log.Body().SetStringVal("hello world")
+			log.SetFlags(tt.flags)
+			got, _, err := logToCWLog(nil, log, tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Message)
+		})
+	}
+}
+
+func TestLogToCWLogPipelineLatency(t *testing.T) {
+	exportTime := time.Date(2021, 1, 4, 12, 30, 15, 0, time.UTC)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return exportTime }
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		config    *Config
+		want      string
+	}{
+		{
+			name:      "latency",
+			timestamp: exportTime.Add(-1500 * time.Millisecond),
+			config:    &Config{PipelineLatency: true},
+			want:      `{"name":"test","pipeline_latency_ms":1500}`,
+		},
+		{
+			name:      "record from the future",
+			timestamp: exportTime.Add(time.Minute),
+			config:    &Config{PipelineLatency: true},
+			want:      `{"name":"test","pipeline_latency_ms":0}`,
+		},
+		{
+			name:   "no timestamp",
+			config: &Config{PipelineLatency: true},
+			want:   `{"name":"test"}`,
+		},
+		{
+			name:      "with sampled field",
+			timestamp: exportTime.Add(-time.Second),
+			config:    &Config{PipelineLatency: true, SampledField: "sampled"},
+			want:      `{"name":"test","pipeline_latency_ms":1000,"sampled":false}`,
+		},
+		{
+			name:      "disabled",
+			timestamp: exportTime.Add(-time.Second),
+			config:    &Config{},
+			want:      `{"name":"test"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := pdata.NewLogRecord()
+			log.SetName("test")
+			if !tt.timestamp.IsZero() {
+				log.SetTimestamp(pdata.NewTimestampFromTime(tt.timestamp))
+			}
+			got, _, err := logToCWLog(nil, log, tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Message)
+		})
+	}
+}
+
+func TestLogToCWLogExportedAt(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.SetName("test")
+	log.SetTimestamp(pdata.NewTimestampFromTime(time.Now().Add(-time.Hour)))
+	before := time.Now()
+	got, _, err := logToCWLog(nil, log, &Config{ExportedAt: true})
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(*got.Message), &fields))
+	require.Contains(t, fields, exportedAtField)
+	exportedAt := time.Unix(0, int64(fields[exportedAtField].(float64))*int64(time.Millisecond))
+	// the field is stamped at export time, not with the time of the record
+	assert.False(t, exportedAt.Before(before.Truncate(time.Millisecond)))
+	assert.WithinDuration(t, time.Now(), exportedAt, 5*time.Second)
+
+	got, _, err = logToCWLog(nil, log, &Config{})
+	require.NoError(t, err)
+	assert.NotContains(t, *got.Message, exportedAtField)
+}
+
+func TestLogToCWLogCWAgentFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   func(pdata.AttributeValue)
+		golden string
+	}{
+		{
+			name:   "string body",
+			body:   func(v pdata.AttributeValue) {},
+			golden: "string_body.json",
+		},
+		{
+			name: "map body",
+			body: func(v pdata.AttributeValue) {
+				pdata.NewAttributeValueMap().CopyTo(v)
+				v.MapVal().InsertString("level", "info")
+				v.MapVal().InsertString("msg", "hello world")
+			},
+			golden: "map_body.json",
+		},
+		{
+			name:   "empty body",
+			body:   func(v pdata.AttributeValue) { v.SetStringVal("") },
+			golden: "empty_body.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := testLogRecord()
+			log.SetTimestamp(pdata.NewTimestampFromTime(time.Unix(1609719139, 139000000)))
+			tt.body(log.Body())
+
+			got, _, err := logToCWLog(attrsValue(testResource().Attributes(), false), log, &Config{Format: FormatCWAgent})
+			require.NoError(t, err)
+			want, err := ioutil.ReadFile(filepath.Join("testdata", "cwagent", tt.golden))
+			require.NoError(t, err)
+			assert.Equal(t, strings.TrimSpace(string(want)), *got.Message)
+			assert.Equal(t, int64(1609719139139), *got.Timestamp)
+		})
+	}
+}
+
+func TestLogToCWLogRawLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		body   func(pdata.AttributeValue)
+		want   string
+	}{
+		{
+			name:   "string body",
+			config: &Config{RawLog: true},
+			body:   func(v pdata.AttributeValue) { v.SetStringVal(`level=info msg="hello world"`) },
+			want:   `level=info msg="hello world"`,
+		},
+		{
+			name:   "map body",
+			config: &Config{RawLog: true},
+			body: func(v pdata.AttributeValue) {
+				pdata.NewAttributeValueMap().CopyTo(v)
+				v.MapVal().InsertString("level", "info")
+				v.MapVal().InsertString("msg", "hello world")
+			},
+			want: `{"level":"info","msg":"hello world"}`,
+		},
+		{
+			name:   "int body",
+			config: &Config{RawLog: true},
+			body:   func(v pdata.AttributeValue) { pdata.NewAttributeValueInt(42).CopyTo(v) },
+			want:   `42`,
+		},
+		{
+			name:   "newline",
+			config: &Config{RawLog: true, AppendNewline: true},
+			body:   func(v pdata.AttributeValue) {},
+			want:   "hello world\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := testLogRecord()
+			tt.body(log.Body())
+
+			got, _, err := logToCWLog(attrsValue(testResource().Attributes(), false), log, tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Message)
+			assert.Equal(t, int64(1609719139), *got.Timestamp)
+		})
+	}
+}
+
+func TestLogToCWLogRawLogTruncation(t *testing.T) {
+	log := testLogRecord()
+	// the multibyte character straddles the size limit
+	log.Body().SetStringVal(strings.Repeat("a", maxEventSizeBytes-1) + "é" + "tail")
+
+	got, _, err := logToCWLog(nil, log, &Config{RawLog: true})
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", maxEventSizeBytes-1), *got.Message)
+}
+
+func TestLogToCWLogMaxEventSizeBytes(t *testing.T) {
+	log := testLogRecord()
+	// the multibyte character straddles the size limit
+	log.Body().SetStringVal(strings.Repeat("a", 1000) + "é" + strings.Repeat("z", 1000))
+
+	got, truncated, err := logToCWLog(nil, log, &Config{RawLog: true, MaxEventSizeBytes: 1024, TruncatedSuffix: "[Truncated...]"})
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.LessOrEqual(t, len(*got.Message), 1024)
+	assert.True(t, utf8.ValidString(*got.Message))
+	assert.Equal(t, strings.Repeat("a", 1000)+"é"+strings.Repeat("z", 1024-1000-len("é")-len("[Truncated...]"))+"[Truncated...]", *got.Message)
+
+	log.Body().SetStringVal(strings.Repeat("é", 1024))
+	got, truncated, err = logToCWLog(nil, log, &Config{MaxEventSizeBytes: 1024, TruncatedSuffix: "[Truncated...]"})
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.LessOrEqual(t, len(*got.Message), 1024)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(*got.Message), &body))
+	assert.Equal(t, true, body["truncated"])
+	assert.True(t, utf8.ValidString(body["body"].(string)))
+	assert.True(t, strings.HasSuffix(body["body"].(string), "é[Truncated...]"))
+
+	log.Body().SetStringVal("hello")
+	got, truncated, err = logToCWLog(nil, log, &Config{MaxEventSizeBytes: 1024, TruncatedSuffix: "[Truncated...]"})
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	assert.NotContains(t, *got.Message, "Truncated")
+}
+
 func BenchmarkLogToCWLog(b *testing.B) {
 	b.ReportAllocs()
 
 	resource := testResource()
 	log := testLogRecord()
 	for i := 0; i < b.N; i++ {
-		logToCWLog(attrsValue(resource.Attributes()), log)
+		logToCWLog(attrsValue(resource.Attributes(), false), log, &Config{})
+	}
+}
+
+// BenchmarkLogToCWLogSize reports the size of the events of a record with a bulky resource, as bytes/event
+func BenchmarkLogToCWLogSize(b *testing.B) {
+	resource := testResource()
+	resource.Attributes().InsertString("k8s.pod.spec", strings.Repeat(`{"containers":[{"image":"app:1.0","args":["--level>=info"]}]}`, 20))
+	log := testLogRecord()
+	log.Body().SetStringVal("GET /users?id=1&name=<admin> 200")
+
+	tests := []struct {
+		name   string
+		config *Config
+	}{
+		{name: "default", config: &Config{}},
+		{name: "compact_json", config: &Config{CompactJSON: true}},
+		{name: "drop_resource_attributes", config: &Config{CompactJSON: true, DropResourceAttributes: []string{"k8s.pod.spec"}}},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			ld := pdata.NewLogs()
+			rl := ld.ResourceLogs().AppendEmpty()
+			resource.CopyTo(rl.Resource())
+			log.CopyTo(rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty())
+			var size int
+			for i := 0; i < b.N; i++ {
+				events, _, _ := logsToCWLogs(zap.NewNop(), ld, tt.config, logNames{}, 0)
+				size = len(*events[0].Message)
+			}
+			b.ReportMetric(float64(size), "bytes/event")
+		})
+	}
+}
+
+func TestLogToCWLogCompactJSON(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.SetSeverityNumber(pdata.SeverityNumberINFO)
+	log.SetSeverityText("INFO")
+	log.Body().SetStringVal("a < b && b > c")
+
+	got, _, err := logToCWLog(nil, log, &Config{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"a \u003c b \u0026\u0026 b \u003e c","severity_number":9,"severity_text":"INFO"}`, *got.Message)
+
+	got, _, err = logToCWLog(nil, log, &Config{CompactJSON: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"a < b && b > c","severity_text":"INFO"}`, *got.Message)
+
+	// the severity number is kept without a text
+	log.SetSeverityText("")
+	got, _, err = logToCWLog(nil, log, &Config{CompactJSON: true, SampledField: "sampled"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"a < b && b > c","severity_number":9,"sampled":false}`, *got.Message)
+}
+
+func TestLogToCWLogSeverityAsLevel(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.SetSeverityNumber(pdata.SeverityNumberWARN2)
+	log.SetSeverityText("Warning")
+	log.Body().SetStringVal("disk almost full")
+
+	got, _, err := logToCWLog(nil, log, &Config{SeverityAsLevel: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"disk almost full","severity_number":14,"severity_text":"Warning","level":"WARN"}`, *got.Message)
+
+	got, _, err = logToCWLog(nil, log, &Config{SeverityAsLevel: true, OmitRawSeverity: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"disk almost full","level":"WARN"}`, *got.Message)
+
+	// the level is left out when the severity number is unspecified
+	log.SetSeverityNumber(pdata.SeverityNumberUNDEFINED)
+	got, _, err = logToCWLog(nil, log, &Config{SeverityAsLevel: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"disk almost full","severity_text":"Warning"}`, *got.Message)
+}
+
+func TestLogsToCWLogsFlattenAttributes(t *testing.T) {
+	ld := pdata.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	k8s := pdata.NewAttributeValueMap()
+	k8s.MapVal().InsertString("namespace", "prod")
+	rl.Resource().Attributes().Insert("k8s", k8s)
+	log := rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty()
+	log.Body().SetStringVal("hello")
+	request := pdata.NewAttributeValueMap()
+	request.MapVal().InsertString("method", "GET")
+	http := pdata.NewAttributeValueMap()
+	http.MapVal().Insert("request", request)
+	log.Attributes().Insert("http", http)
+	ids := pdata.NewAttributeValueArray()
+	ids.SliceVal().AppendEmpty().SetIntVal(1)
+	ids.SliceVal().AppendEmpty().SetIntVal(2)
+	log.Attributes().Insert("ids", ids)
+
+	events, _, _ := logsToCWLogs(zap.NewNop(), ld, &Config{}, logNames{}, 0)
+	require.Len(t, events, 1)
+	assert.Equal(t, `{"body":"hello","attributes":{"http":{"request":{"method":"GET"}},"ids":[1,2]},"resource":{"k8s":{"namespace":"prod"}}}`, *events[0].Message)
+
+	events, _, _ = logsToCWLogs(zap.NewNop(), ld, &Config{FlattenAttributes: true}, logNames{}, 0)
+	require.Len(t, events, 1)
+	assert.Equal(t, `{"body":"hello","attributes":{"http.request.method":"GET","ids.0":1,"ids.1":2},"resource":{"k8s.namespace":"prod"}}`, *events[0].Message)
+
+	events, _, _ = logsToCWLogs(zap.NewNop(), ld, &Config{FlattenAttributes: true, MaxFlattenDepth: 1}, logNames{}, 0)
+	require.Len(t, events, 1)
+	assert.Equal(t, `{"body":"hello","attributes":{"http.request":{"method":"GET"},"ids.0":1,"ids.1":2},"resource":{"k8s.namespace":"prod"}}`, *events[0].Message)
+}
+
+func TestLogToCWLogCompactJSONTruncation(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.Body().SetStringVal(strings.Repeat("<", maxEventSizeBytes))
+
+	got, _, err := logToCWLog(nil, log, &Config{CompactJSON: true})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(*got.Message), maxEventSizeBytes)
+	// unescaped, the body fills most of the event
+	assert.Greater(t, len(*got.Message), maxEventSizeBytes-100)
+	assert.NotContains(t, *got.Message, `\u003c`)
+	assert.Contains(t, *got.Message, `"truncated":true`)
+}
+
+func TestLogsToCWLogsDropResourceAttributes(t *testing.T) {
+	ld := pdata.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("service.name", "api")
+	rl.Resource().Attributes().InsertString("k8s.pod.spec", "{...}")
+	rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().Body().SetStringVal("hello")
+
+	config := &Config{DropResourceAttributes: []string{"k8s.pod.spec", "missing"}}
+	events, _, _ := logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 0)
+	require.Len(t, events, 1)
+	assert.Equal(t, `{"body":"hello","resource":{"service.name":"api"}}`, *events[0].Message)
+}
+
+func TestFilterAttributes(t *testing.T) {
+	attrs := func() map[string]interface{} {
+		return map[string]interface{}{"service.name": "api", "k8s.pod.name": "api-0", "k8s.pod.uid": "abc"}
+	}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    map[string]interface{}
+	}{
+		{
+			name: "no filters",
+			want: attrs(),
+		},
+		{
+			name:    "include",
+			include: []string{"service.name", "k8s.pod.name", "missing"},
+			want:    map[string]interface{}{"service.name": "api", "k8s.pod.name": "api-0"},
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"k8s.pod.uid"},
+			want:    map[string]interface{}{"service.name": "api", "k8s.pod.name": "api-0"},
+		},
+		{
+			name:    "exclude after include",
+			include: []string{"service.name", "k8s.pod.name"},
+			exclude: []string{"k8s.pod.name"},
+			want:    map[string]interface{}{"service.name": "api"},
+		},
+		{
+			name:    "include matching nothing",
+			include: []string{"missing"},
+			want:    nil,
+		},
+		{
+			name:    "exclude everything",
+			exclude: []string{"service.name", "k8s.pod.name", "k8s.pod.uid"},
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, filterAttributes(attrs(), tt.include, tt.exclude))
+		})
+	}
+	assert.Nil(t, filterAttributes(nil, []string{"service.name"}, []string{"k8s.pod.uid"}))
+}
+
+func TestLogsToCWLogsResourceAttributeFilters(t *testing.T) {
+	ld := pdata.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("service.name", "api")
+	rl.Resource().Attributes().InsertString("k8s.pod.name", "api-0")
+	rl.Resource().Attributes().InsertString("k8s.pod.uid", "abc")
+	rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().Body().SetStringVal("hello")
+
+	config := &Config{
+		ResourceAttributeInclude: []string{"service.name", "k8s.pod.name"},
+		ResourceAttributeExclude: []string{"k8s.pod.name"},
 	}
+	events, _, _ := logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 0)
+	require.Len(t, events, 1)
+	assert.Equal(t, `{"body":"hello","resource":{"service.name":"api"}}`, *events[0].Message)
 }
 
 func testResource() pdata.Resource {
@@ -230,6 +928,43 @@ func TestAttrValue(t *testing.T) {
 	}
 }
 
+func TestAttrsValueNil(t *testing.T) {
+	attrs := pdata.NewAttributeMap()
+	attrs.InsertString("key", "value")
+	attrs.Insert("empty", pdata.NewAttributeValueEmpty())
+
+	assert.Equal(t, map[string]interface{}{"key": "value", "empty": nil}, attrsValue(attrs, false))
+	assert.Equal(t, map[string]interface{}{"key": "value"}, attrsValue(attrs, true))
+
+	onlyNil := pdata.NewAttributeMap()
+	onlyNil.Insert("empty", pdata.NewAttributeValueEmpty())
+	assert.Nil(t, attrsValue(onlyNil, true))
+}
+
+func TestLogToCWLogDropNilAttributes(t *testing.T) {
+	resource := pdata.NewResource()
+	resource.Attributes().InsertString("host", "abc123")
+	resource.Attributes().Insert("empty", pdata.NewAttributeValueEmpty())
+	log := pdata.NewLogRecord()
+	log.SetName("test")
+	log.Attributes().Insert("empty", pdata.NewAttributeValueEmpty())
+
+	ld := pdata.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	resource.CopyTo(rl.Resource())
+	log.CopyTo(rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty())
+
+	events, dropped, _ := logsToCWLogs(zap.NewNop(), ld, &Config{}, logNames{}, 0)
+	require.Len(t, events, 1)
+	assert.Zero(t, dropped)
+	assert.Equal(t, `{"name":"test","attributes":{"empty":null},"resource":{"empty":null,"host":"abc123"}}`, *events[0].Message)
+
+	events, dropped, _ = logsToCWLogs(zap.NewNop(), ld, &Config{DropNilAttributes: true}, logNames{}, 0)
+	require.Len(t, events, 1)
+	assert.Zero(t, dropped)
+	assert.Equal(t, `{"name":"test","resource":{"host":"abc123"}}`, *events[0].Message)
+}
+
 func TestConsumeLogs(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -258,11 +993,1119 @@ func TestConsumeLogs(t *testing.T) {
 	require.NoError(t, exp.Shutdown(ctx))
 }
 
-func TestNewExporterWithoutRegionErr(t *testing.T) {
-	factory := NewFactory()
-	expCfg := factory.CreateDefaultConfig().(*Config)
-	expCfg.MaxRetries = 0
-	exp, err := newCwLogsExporter(expCfg, componenttest.NewNopExporterCreateSettings())
+func TestSharedClient(t *testing.T) {
+	newExporter := func(region, group string, logRetention int, tags map[string]string) *exporter {
+		expCfg := NewFactory().CreateDefaultConfig().(*Config)
+		expCfg.Region = region
+		expCfg.LogGroupName = group
+		expCfg.LogStreamName = "testStream"
+		expCfg.LogRetention = logRetention
+		expCfg.Tags = tags
+		exp, err := newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+		require.NoError(t, err)
+		return exp.(*exporter)
+	}
+
+	first := newExporter("eu-west-3", "first-group", 0, nil)
+	second := newExporter("eu-west-3", "second-group", 0, nil)
+	otherRegion := newExporter("eu-north-1", "first-group", 0, nil)
+	containerInsights := newExporter("eu-west-3", "/aws/containerinsights/cluster/performance", 0, nil)
+	otherRetention := newExporter("eu-west-3", "second-group", 30, nil)
+	tagged := newExporter("eu-west-3", "first-group", 0, map[string]string{"team": "payments", "cost-center": "1234"})
+	sameTags := newExporter("eu-west-3", "second-group", 0, map[string]string{"cost-center": "1234", "team": "payments"})
+	compressedCfg := NewFactory().CreateDefaultConfig().(*Config)
+	compressedCfg.Region = "eu-west-3"
+	compressedCfg.LogGroupName = "first-group"
+	compressedCfg.LogStreamName = "testStream"
+	compressedCfg.RequestCompression = true
+	compressed, err := newCwLogsPusher(compressedCfg, componenttest.NewNopExporterCreateSettings())
+	require.NoError(t, err)
+
+	// the groups of the same region share a client
+	assert.Same(t, first.svcStructuredLog, second.svcStructuredLog)
+	assert.NotSame(t, first.svcStructuredLog, otherRegion.svcStructuredLog)
+	// Container Insights requests have their own user agent
+	assert.NotSame(t, first.svcStructuredLog, containerInsights.svcStructuredLog)
+	// the retention is set by the client creating the log groups
+	assert.NotSame(t, first.svcStructuredLog, otherRetention.svcStructuredLog)
+	assert.NotSame(t, first.svcStructuredLog, tagged.svcStructuredLog)
+	assert.Same(t, tagged.svcStructuredLog, sameTags.svcStructuredLog)
+	// the requests are compressed by the client
+	assert.NotSame(t, first.svcStructuredLog, compressed.(*exporter).svcStructuredLog)
+}
+
+func TestNewCwLogsPusherTemplatedRegion(t *testing.T) {
+	expCfg := NewFactory().CreateDefaultConfig().(*Config)
+	expCfg.Region = "{resource.cloud.region}"
+	expCfg.RegionFallback = "us-west-2"
+	expCfg.LogGroupName = "group"
+	expCfg.LogStreamName = "stream"
+	logsExporter, err := newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+	require.NoError(t, err)
+	exp := logsExporter.(*exporter)
+	// the session of the exporter is in the fallback region
+	assert.Equal(t, "us-west-2", exp.region)
+
+	client, err := exp.newRegionClient("eu-west-1")
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1", aws.StringValue(client.awsConfig.Region))
+	// the sessions are not created again
+	again, err := exp.newRegionClient("eu-west-1")
+	require.NoError(t, err)
+	assert.Same(t, client, again)
+}
+
+func TestFIPSEndpoint(t *testing.T) {
+	newExporter := func(region string) (*exporter, error) {
+		expCfg := NewFactory().CreateDefaultConfig().(*Config)
+		expCfg.Region = region
+		expCfg.UseFIPSEndpoint = true
+		expCfg.LogGroupName = "group"
+		expCfg.LogStreamName = "stream"
+		exp, err := newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+		if err != nil {
+			return nil, err
+		}
+		return exp.(*exporter), nil
+	}
+
+	exp, err := newExporter("us-west-2")
+	require.NoError(t, err)
+	assert.NotNil(t, exp.svcStructuredLog)
+
+	_, err = newExporter("ap-southeast-2")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no FIPS endpoint of logs in region "ap-southeast-2"`)
+}
+
+func TestCollectorID(t *testing.T) {
+	factory := NewFactory()
+	expCfg := factory.CreateDefaultConfig().(*Config)
+	expCfg.Region = "us-west-2"
+	expCfg.LogGroupName = "testGroup"
+	expCfg.LogStreamName = "testStream"
+	expCfg.MaxRetries = 0
+
+	exp, err := newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+	require.NoError(t, err)
+	generated := exp.(*exporter).collectorID
+	assert.NotEmpty(t, generated)
+
+	// the generated identifier is shared by the exporters of the process
+	exp, err = newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+	require.NoError(t, err)
+	assert.Equal(t, generated, exp.(*exporter).collectorID)
+
+	expCfg.CollectorID = "collector-pod-0"
+	exp, err = newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+	require.NoError(t, err)
+	assert.Equal(t, "collector-pod-0", exp.(*exporter).collectorID)
+}
+
+func TestConsumeLogsCollectorIDField(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		config func(cfg *Config)
+	}{
+		{name: "default", field: "collector_id", config: func(cfg *Config) { cfg.IncludeCollectorID = true }},
+		{name: "custom name", field: "otel.collector", config: func(cfg *Config) {
+			cfg.IncludeCollectorID = true
+			cfg.CollectorIDField = "otel.collector"
+		}},
+		{name: "disabled", config: func(cfg *Config) {}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expCfg := createDefaultConfig().(*Config)
+			expCfg.LogGroupName = "group"
+			expCfg.LogStreamName = "stream"
+			expCfg.DryRun = true
+			tt.config(expCfg)
+			require.NoError(t, expCfg.Validate())
+			exp, err := newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+			require.NoError(t, err)
+
+			ld := pdata.NewLogs()
+			ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+			events, _, _ := logsToCWLogs(zap.NewNop(), ld, expCfg, exp.(*exporter).names, 0)
+			require.Len(t, events, 1)
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(*events[0].Message), &fields))
+			if tt.field == "" {
+				assert.NotContains(t, fields, "collector_id")
+				return
+			}
+			assert.Equal(t, exp.(*exporter).collectorID, fields[tt.field])
+			assert.NotEmpty(t, fields[tt.field])
+		})
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "access-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret-key")
+	var targets []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		assert.Equal(t, "/", r.URL.Path)
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/logs/aws4_request")
+		_, _ = w.Write([]byte(`{"nextSequenceToken": "1"}`))
+	}))
+	defer server.Close()
+
+	newExporter := func(noVerifySSL bool) *exporter {
+		expCfg := NewFactory().CreateDefaultConfig().(*Config)
+		expCfg.Region = "eu-west-1"
+		expCfg.Endpoint = server.URL
+		expCfg.NoVerifySSL = noVerifySSL
+		expCfg.LogGroupName = "testGroup"
+		expCfg.LogStreamName = "testStream"
+		expCfg.MaxRetries = 0
+		exp, err := newCwLogsPusher(expCfg, componenttest.NewNopExporterCreateSettings())
+		require.NoError(t, err)
+		return exp.(*exporter)
+	}
+
+	// the certificate of the server is self-signed
+	err := newExporter(false).ConsumeLogs(context.Background(), newSingleRecordLogs())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+	assert.Empty(t, targets)
+
+	require.NoError(t, newExporter(true).ConsumeLogs(context.Background(), newSingleRecordLogs()))
+	assert.Equal(t, []string{"Logs_20140328.CreateLogStream", "Logs_20140328.PutLogEvents"}, targets)
+}
+
+func TestNewExporterWithoutRegionErr(t *testing.T) {
+	factory := NewFactory()
+	expCfg := factory.CreateDefaultConfig().(*Config)
+	expCfg.MaxRetries = 0
+	exp, err := newCwLogsExporter(expCfg, componenttest.NewNopExporterCreateSettings())
 	assert.Nil(t, exp)
 	assert.NotNil(t, err)
 }
+
+func TestLogsToCWLogsSampling(t *testing.T) {
+	const numRecords = 10000
+	ld := pdata.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("service.name", "checkout")
+	logs := rl.InstrumentationLibraryLogs().AppendEmpty().Logs()
+	for i := 0; i < numRecords; i++ {
+		log := logs.AppendEmpty()
+		var traceID [16]byte
+		binary.BigEndian.PutUint64(traceID[8:], uint64(i))
+		log.SetTraceID(pdata.NewTraceID(traceID))
+		log.Attributes().InsertInt("request", int64(i))
+		log.Body().SetStringVal("hello world")
+	}
+
+	tests := []struct {
+		name   string
+		ratio  float64
+		key    string
+		wantIn float64
+	}{
+		{name: "trace id quarter", ratio: 0.25, wantIn: 0.25},
+		{name: "trace id half", ratio: 0.5, wantIn: 0.5},
+		{name: "attribute tenth", ratio: 0.1, key: "request", wantIn: 0.1},
+		{name: "all", ratio: 1, wantIn: 1},
+		{name: "none", ratio: 0, wantIn: 0},
+		{name: "missing attribute", ratio: 0, key: "missing", wantIn: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Sampling: SamplingSettings{Enabled: true, Ratio: tt.ratio, Key: tt.key}}
+			events, dropped, sampledOut := logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 0)
+			assert.Zero(t, dropped)
+			assert.Equal(t, numRecords, len(events)+sampledOut)
+			assert.InDelta(t, tt.wantIn, float64(len(events))/numRecords, 0.02)
+
+			// The decision only depends on the key
+			again, _, _ := logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 0)
+			assert.Equal(t, events, again)
+		})
+	}
+
+	// A resource attribute samples all the records of the resource together
+	config := &Config{Sampling: SamplingSettings{Enabled: true, Ratio: 0.5, Key: "service.name"}}
+	events, _, sampledOut := logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 0)
+	assert.True(t, len(events) == numRecords || sampledOut == numRecords)
+}
+
+func TestConsumeLogsSampledOutMetric(t *testing.T) {
+	require.NoError(t, view.Register(MetricViews()...))
+	before := sampledOutSum(t)
+
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	for i := 0; i < 10; i++ {
+		log := logs.AppendEmpty()
+		log.SetTraceID(pdata.NewTraceID([16]byte{byte(i + 1)}))
+	}
+
+	exp := &exporter{
+		Config: &Config{Sampling: SamplingSettings{Enabled: true, Ratio: 0}},
+		logger: zap.NewNop(),
+		pusher: new(mockPusher),
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, before+10, sampledOutSum(t))
+}
+
+func TestApplyTimestampRange(t *testing.T) {
+	current := time.Unix(1640995200, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return current }
+	ms := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+
+	tests := []struct {
+		name      string
+		policy    string
+		timestamp time.Time
+		keep      bool
+		want      time.Time
+	}{
+		{name: "in range", policy: TimestampOutOfRangeDrop, timestamp: current.Add(-time.Hour), keep: true, want: current.Add(-time.Hour)},
+		{name: "oldest", policy: TimestampOutOfRangeDrop, timestamp: current.Add(-maxEventAge), keep: true, want: current.Add(-maxEventAge)},
+		{name: "keep", policy: TimestampOutOfRangeKeep, timestamp: current.Add(-maxEventAge - time.Hour), keep: true, want: current.Add(-maxEventAge - time.Hour)},
+		{name: "default", policy: "", timestamp: current.Add(3 * time.Hour), keep: true, want: current.Add(3 * time.Hour)},
+		{name: "drop past", policy: TimestampOutOfRangeDrop, timestamp: current.Add(-maxEventAge - time.Millisecond)},
+		{name: "drop future", policy: TimestampOutOfRangeDrop, timestamp: current.Add(maxEventLead + time.Millisecond)},
+		{
+			name:      "clamp past",
+			policy:    TimestampOutOfRangeClamp,
+			timestamp: time.Unix(0, 0),
+			keep:      true,
+			want:      current.Add(-maxEventAge + timestampRangeMargin),
+		},
+		{
+			name:      "clamp future",
+			policy:    TimestampOutOfRangeClamp,
+			timestamp: current.Add(24 * time.Hour),
+			keep:      true,
+			want:      current.Add(maxEventLead - timestampRangeMargin),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &cloudwatchlogs.InputLogEvent{Timestamp: aws.Int64(ms(tt.timestamp)), Message: aws.String("{}")}
+			assert.Equal(t, tt.keep, applyTimestampRange(event, tt.policy, 0))
+			if tt.keep {
+				assert.Equal(t, ms(tt.want), *event.Timestamp)
+			}
+		})
+	}
+}
+
+func TestLogsToCWLogsClockSkew(t *testing.T) {
+	// the local clock is 3 hours behind the clock of the servers and of the sources
+	server := time.Now()
+	defer func() { now = time.Now }()
+	now = func() time.Time { return server.Add(-3 * time.Hour) }
+
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	logs.AppendEmpty().SetTimestamp(pdata.NewTimestampFromTime(server))
+	logs.AppendEmpty().SetTimestamp(pdata.NewTimestampFromTime(server.Add(-time.Hour)))
+	config := &Config{TimestampOutOfRange: TimestampOutOfRangeDrop}
+
+	// without correction, the current events look more than 2 hours in the future
+	events, dropped, _ := logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 0)
+	assert.Len(t, events, 1)
+	assert.Equal(t, 1, dropped)
+
+	events, dropped, _ = logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 3*time.Hour)
+	assert.Len(t, events, 2)
+	assert.Equal(t, 0, dropped)
+
+	// the events are clamped to the range of the servers
+	config.TimestampOutOfRange = TimestampOutOfRangeClamp
+	logs.At(0).SetTimestamp(pdata.NewTimestampFromTime(server.Add(24 * time.Hour)))
+	events, _, _ = logsToCWLogs(zap.NewNop(), ld, config, logNames{}, 3*time.Hour)
+	require.Len(t, events, 2)
+	assert.Equal(t, server.Add(maxEventLead-timestampRangeMargin).UnixNano()/int64(time.Millisecond), *events[0].Timestamp)
+}
+
+func TestConsumeLogsTruncatedMetric(t *testing.T) {
+	require.NoError(t, view.Register(MetricViews()...))
+	tags := []tag.Tag{{Key: exporterTagKey, Value: "awscloudwatchlogs/truncated"}, {Key: logGroupTagKey, Value: "group"}}
+	before := recordedSum(t, mTruncatedLogRecords.Name(), tags)
+
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	logs.AppendEmpty().Body().SetStringVal(strings.Repeat("a", 2048))
+	logs.AppendEmpty().Body().SetStringVal("hello")
+	logs.AppendEmpty().Body().SetStringVal(strings.Repeat("b", 2048))
+
+	pusher := &countingPusher{}
+	exp := &exporter{
+		Config: &Config{
+			ExporterSettings:  config.NewExporterSettings(config.NewComponentIDWithName(typeStr, "truncated")),
+			LogGroupName:      "group",
+			MaxEventSizeBytes: 1024,
+		},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, 3, pusher.pushed)
+	assert.Equal(t, before+2, recordedSum(t, mTruncatedLogRecords.Name(), tags))
+}
+
+func TestConsumeLogsFallbackMetric(t *testing.T) {
+	require.NoError(t, view.Register(MetricViews()...))
+	tags := []tag.Tag{{Key: exporterTagKey, Value: "awscloudwatchlogs/fallback"}, {Key: logGroupTagKey, Value: "group"}}
+	before := recordedSum(t, mFallbackLogRecords.Name(), tags)
+
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	logs.AppendEmpty().Attributes().InsertString("stream", "checkout")
+	logs.AppendEmpty()
+	logs.AppendEmpty().Attributes().InsertString("stream", "")
+
+	cfg := &Config{
+		ExporterSettings:      config.NewExporterSettings(config.NewComponentIDWithName(typeStr, "fallback")),
+		LogGroupName:          "group",
+		LogStreamName:         "{attributes.stream}",
+		LogStreamNameFallback: "default",
+	}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	fallback, other := &countingPusher{}, &countingPusher{}
+	exp := &exporter{
+		Config:    cfg,
+		logger:    zap.NewNop(),
+		names:     names,
+		pusher:    fallback,
+		newPusher: func(string, string, string) cwlogs.Pusher { return other },
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, 1, other.pushed)
+	assert.Equal(t, 2, fallback.pushed)
+	assert.Equal(t, before+2, recordedSum(t, mFallbackLogRecords.Name(), tags))
+
+	// the fallback log group is counted too, without the log group tag of the templated names
+	ld = pdata.NewLogs()
+	ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().Attributes().InsertString("stream", "checkout")
+	cfg.LogGroupName = "/aws/{resource.service.name}"
+	cfg.LogGroupNameFallback = "/aws/default"
+	exp.names, err = newLogNames(cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, float64(1), recordedSum(t, mFallbackLogRecords.Name(), tags[:1]))
+}
+
+func TestConsumeLogsTimestampOutOfRange(t *testing.T) {
+	require.NoError(t, view.Register(MetricViews()...))
+	tags := []tag.Tag{{Key: exporterTagKey, Value: "awscloudwatchlogs/out_of_range"}, {Key: logGroupTagKey, Value: "group"}}
+	before := recordedSum(t, mDroppedLogRecords.Name(), tags)
+
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	logs.AppendEmpty().SetTimestamp(pdata.NewTimestampFromTime(time.Now()))
+	logs.AppendEmpty().SetTimestamp(pdata.NewTimestampFromTime(time.Now().Add(-30 * 24 * time.Hour)))
+
+	pusher := &countingPusher{}
+	exp := &exporter{
+		Config: &Config{
+			ExporterSettings:    config.NewExporterSettings(config.NewComponentIDWithName(typeStr, "out_of_range")),
+			LogGroupName:        "group",
+			TimestampOutOfRange: TimestampOutOfRangeDrop,
+		},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, 1, pusher.pushed)
+	assert.Equal(t, before+1, recordedSum(t, mDroppedLogRecords.Name(), tags))
+
+	// templated log group names are left out of the tags
+	exp.Config.LogGroupName = "/aws/{resource.service.name}"
+	exp.newPusher = func(string, string, string) cwlogs.Pusher { return pusher }
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, float64(1), recordedSum(t, mDroppedLogRecords.Name(), tags[:1]))
+}
+
+// recordedSum returns the sum of the view recorded with exactly the given tags
+func TestMetricViewsPusherHistograms(t *testing.T) {
+	// the views are registered again by every test
+	require.NoError(t, view.Register(MetricViews()...))
+	require.NoError(t, view.Register(MetricViews()...))
+	for _, name := range []string{"awscloudwatchlogs_batch_bytes", "awscloudwatchlogs_batch_events", "awscloudwatchlogs_put_log_events_latency"} {
+		v := view.Find(name)
+		require.NotNil(t, v, name)
+		assert.Equal(t, view.AggTypeDistribution, v.Aggregation.Type)
+		// the log stream is left out to bound the cardinality
+		assert.Equal(t, []tag.Key{exporterTagKey, logGroupTagKey}, v.TagKeys)
+	}
+}
+
+func TestConfigMetricTags(t *testing.T) {
+	tags := func(logGroupName string) map[tag.Key]string {
+		cfg := &Config{ExporterSettings: config.NewExporterSettings(config.NewComponentIDWithName(typeStr, "tags")), LogGroupName: logGroupName}
+		ctx, err := tag.New(context.Background(), cfg.metricTags()...)
+		require.NoError(t, err)
+		values := map[tag.Key]string{}
+		for _, key := range []tag.Key{exporterTagKey, logGroupTagKey} {
+			if value, ok := tag.FromContext(ctx).Value(key); ok {
+				values[key] = value
+			}
+		}
+		return values
+	}
+	assert.Equal(t, map[tag.Key]string{exporterTagKey: "awscloudwatchlogs/tags", logGroupTagKey: "group"}, tags("group"))
+	assert.Equal(t, map[tag.Key]string{exporterTagKey: "awscloudwatchlogs/tags"}, tags("/otel/{resource.service.name}"))
+}
+
+func recordedSum(t *testing.T, name string, tags []tag.Tag) float64 {
+	rows, err := view.RetrieveData(name)
+	require.NoError(t, err)
+	for _, row := range rows {
+		if assert.ObjectsAreEqual(tags, row.Tags) {
+			return row.Data.(*view.SumData).Value
+		}
+	}
+	return 0
+}
+
+func sampledOutSum(t *testing.T) float64 {
+	rows, err := view.RetrieveData(mSampledOutLogRecords.Name())
+	require.NoError(t, err)
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[0].Data.(*view.SumData).Value
+}
+
+// throttledPusher fails its flushes with a throttling error the given number of times
+type throttledPusher struct {
+	throttles int
+	flushes   int
+}
+
+func (p *throttledPusher) AddLogEntry(*cwlogs.Event) error {
+	return nil
+}
+
+func (p *throttledPusher) ForceFlush(_ context.Context) error {
+	p.flushes++
+	if p.flushes <= p.throttles {
+		return awserr.New(errCodeThrottlingException, "Rate exceeded for logStreamName", nil)
+	}
+	return nil
+}
+
+func TestConsumeLogsRotateStreamOnThrottling(t *testing.T) {
+	ld := pdata.NewLogs()
+	ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+
+	pushers := map[string]*throttledPusher{
+		"pod-a-1": {throttles: 1},
+		"pod-a-2": {},
+	}
+	var streams []string
+	exp := &exporter{
+		Config: &Config{LogStreamName: "pod-a", RotateStreamOnThrottling: true},
+		logger: zap.NewNop(),
+		pusher: &throttledPusher{throttles: 1},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			streams = append(streams, streamName)
+			return pushers[streamName]
+		},
+	}
+
+	// Every throttled flush fails the batch and moves to the next stream
+	err := exp.ConsumeLogs(context.Background(), ld)
+	assert.True(t, isThrottlingError(err))
+	err = exp.ConsumeLogs(context.Background(), ld)
+	assert.True(t, isThrottlingError(err))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+
+	assert.Equal(t, []string{"pod-a-1", "pod-a-2"}, streams)
+	assert.Equal(t, 2, exp.streamSuffix)
+	assert.Equal(t, 2, pushers["pod-a-2"].flushes)
+}
+
+func TestConsumeLogsThrottlingWithoutRotation(t *testing.T) {
+	ld := pdata.NewLogs()
+	ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+
+	pusher := &throttledPusher{throttles: 1}
+	exp := &exporter{
+		Config: &Config{LogStreamName: "pod-a"},
+		logger: zap.NewNop(),
+		pusher: pusher,
+		newPusher: func(string, string, string) cwlogs.Pusher {
+			t.Fatal("the log stream must not be rotated")
+			return nil
+		},
+	}
+	assert.Error(t, exp.ConsumeLogs(context.Background(), ld))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, 0, exp.streamSuffix)
+	assert.Equal(t, 2, pusher.flushes)
+}
+
+// countingPusher counts the PutLogEvents requests a pusher would make, one per flush of pending events
+type countingPusher struct {
+	sync.Mutex
+	pending  int
+	pushed   int
+	requests int
+}
+
+func (p *countingPusher) AddLogEntry(*cwlogs.Event) error {
+	p.Lock()
+	defer p.Unlock()
+	p.pending++
+	return nil
+}
+
+func (p *countingPusher) ForceFlush(_ context.Context) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.pending > 0 {
+		p.requests++
+		p.pushed += p.pending
+		p.pending = 0
+	}
+	return nil
+}
+
+// cancellingPusher cancels the export after the given number of events were added
+type cancellingPusher struct {
+	countingPusher
+	cancel func()
+	after  int
+}
+
+func (p *cancellingPusher) AddLogEntry(e *cwlogs.Event) error {
+	if p.after--; p.after == 0 {
+		p.cancel()
+	}
+	return p.countingPusher.AddLogEntry(e)
+}
+
+// cancelledPushPusher drops its pending events when its push is cancelled, like a pusher that does not retain its
+// failed batches
+type cancelledPushPusher struct {
+	cancellingPusher
+}
+
+func (p *cancelledPushPusher) ForceFlush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		p.Lock()
+		defer p.Unlock()
+		p.pending = 0
+		return err
+	}
+	return p.countingPusher.ForceFlush(ctx)
+}
+
+// takePushers returns the pushers of the exporter to flush them
+func takePushers(exp *exporter) []keyedPusher {
+	exp.pusherLock.Lock()
+	defer exp.pusherLock.Unlock()
+	return exp.takePushers()
+}
+
+func newSingleRecordLogs() pdata.Logs {
+	ld := pdata.NewLogs()
+	ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+	return ld
+}
+
+func TestConsumeLogsCoalescingMaxEvents(t *testing.T) {
+	ctx := context.Background()
+	pusher := &countingPusher{}
+	exp := &exporter{
+		Config: &Config{Coalescing: CoalescingSettings{Window: time.Hour, MaxEvents: 10}},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+	for i := 0; i < 105; i++ {
+		require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	}
+	assert.Equal(t, 10, pusher.requests)
+	assert.Equal(t, 100, pusher.pushed)
+
+	// the remaining events are pushed on shutdown
+	require.NoError(t, exp.Shutdown(ctx))
+	assert.Equal(t, 11, pusher.requests)
+	assert.Equal(t, 105, pusher.pushed)
+}
+
+func TestConsumeLogsCoalescingFailedPush(t *testing.T) {
+	ctx := context.Background()
+	pusher := &failingPusher{err: errPutLogEvents}
+	exp := &exporter{
+		Config: &Config{Coalescing: CoalescingSettings{Window: time.Hour, MaxEvents: 1}},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+	defer exp.Shutdown(ctx)
+
+	// the pusher keeps the events of the failed push, the export is not retried
+	require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Equal(t, 1, pusher.flushes)
+}
+
+func TestConsumeLogsCoalescingWindow(t *testing.T) {
+	ctx := context.Background()
+	pusher := &countingPusher{}
+	exp := &exporter{
+		Config: &Config{Coalescing: CoalescingSettings{Window: 10 * time.Millisecond}},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+	defer exp.Shutdown(ctx)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	}
+
+	assert.Eventually(t, func() bool {
+		pusher.Lock()
+		defer pusher.Unlock()
+		return pusher.pushed == 50
+	}, time.Second, 5*time.Millisecond)
+	pusher.Lock()
+	defer pusher.Unlock()
+	assert.Less(t, pusher.requests, 50)
+}
+
+func TestConsumeLogsCoalescingShutdown(t *testing.T) {
+	ctx := context.Background()
+	pusher := &countingPusher{}
+	exp := &exporter{
+		Config: &Config{Coalescing: CoalescingSettings{Window: time.Hour}},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	}
+	assert.Equal(t, 0, pusher.requests)
+
+	// the events are pushed on shutdown, before the window elapses
+	require.NoError(t, exp.Shutdown(ctx))
+	assert.Equal(t, 1, pusher.requests)
+	assert.Equal(t, 3, pusher.pushed)
+}
+
+func TestShutdownFlushesEveryPusher(t *testing.T) {
+	defaultPusher := &failingPusher{}
+	resolved := &failingPusher{err: errors.New("first")}
+	other := &failingPusher{}
+	shard := &failingPusher{err: errors.New("second")}
+	exp := &exporter{
+		Config: &Config{LogGroupName: "group", LogStreamName: "stream"},
+		logger: zap.NewNop(),
+		pusher: defaultPusher,
+		pushers: map[pusherKey]cwlogs.Pusher{
+			{logGroupName: "group", logStreamName: "resolved"}:     resolved,
+			{logGroupName: "other-group", logStreamName: "stream"}: other,
+		},
+		shards: map[pusherKey][]cwlogs.Pusher{{logGroupName: "group", logStreamName: "resolved"}: {shard}},
+	}
+
+	err := exp.Shutdown(context.Background())
+	for _, pusher := range []*failingPusher{defaultPusher, resolved, other, shard} {
+		assert.Equal(t, 1, pusher.flushes)
+	}
+	// the errors of every pusher are returned
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `failed to flush log stream "resolved" of log group "group": first`)
+	assert.Contains(t, err.Error(), `failed to flush log stream "resolved" of log group "group": second`)
+}
+
+func TestConsumeLogsWithoutCoalescing(t *testing.T) {
+	ctx := context.Background()
+	pusher := &countingPusher{}
+	exp := &exporter{
+		Config: &Config{},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	for i := 0; i < 50; i++ {
+		require.NoError(t, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	}
+	assert.Equal(t, 50, pusher.requests)
+}
+
+func BenchmarkConsumeLogsCoalescing(b *testing.B) {
+	ctx := context.Background()
+	for _, bm := range []struct {
+		name       string
+		coalescing CoalescingSettings
+	}{
+		{"uncoalesced", CoalescingSettings{}},
+		{"coalesced", CoalescingSettings{Window: time.Hour, MaxEvents: 100}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			pusher := &countingPusher{}
+			exp := &exporter{
+				Config: &Config{Coalescing: bm.coalescing},
+				logger: zap.NewNop(),
+				pusher: pusher,
+			}
+			ld := newSingleRecordLogs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, exp.ConsumeLogs(ctx, ld))
+			}
+			b.ReportMetric(float64(pusher.requests)/float64(b.N), "requests/op")
+		})
+	}
+}
+
+// blockingPusher blocks its flushes until it is released
+type blockingPusher struct {
+	countingPusher
+	flushing chan struct{}
+	release  chan struct{}
+}
+
+func (p *blockingPusher) ForceFlush(ctx context.Context) error {
+	p.flushing <- struct{}{}
+	<-p.release
+	return p.countingPusher.ForceFlush(ctx)
+}
+
+func TestConsumeLogsConcurrentStreams(t *testing.T) {
+	cfg := &Config{LogGroupName: "group", LogStreamName: "{attributes.stream}", LogStreamNameFallback: "default"}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	blocked := &blockingPusher{flushing: make(chan struct{}), release: make(chan struct{})}
+	other := &countingPusher{}
+	exp := &exporter{
+		Config: cfg,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			if streamName == "blocked" {
+				return blocked
+			}
+			return other
+		},
+	}
+	newLogs := func(stream string) pdata.Logs {
+		ld := pdata.NewLogs()
+		record := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty()
+		record.SetName("test")
+		record.Attributes().UpsertString("stream", stream)
+		return ld
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- exp.ConsumeLogs(context.Background(), newLogs("blocked"))
+	}()
+	<-blocked.flushing
+	// the push to the blocked log stream does not hold up the exports to the other log streams
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newLogs("other")))
+	assert.Equal(t, 1, other.pushed)
+	close(blocked.release)
+	require.NoError(t, <-done)
+	assert.Equal(t, 1, blocked.pushed)
+}
+
+// fullBatchPusher blocks adding its first event until it is released, like a pusher pushing its full batch
+type fullBatchPusher struct {
+	countingPusher
+	adding  chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (p *fullBatchPusher) AddLogEntry(e *cwlogs.Event) error {
+	p.once.Do(func() {
+		p.adding <- struct{}{}
+		<-p.release
+	})
+	return p.countingPusher.AddLogEntry(e)
+}
+
+func TestConsumeLogsConcurrentFullBatch(t *testing.T) {
+	cfg := &Config{LogGroupName: "group", LogStreamName: "{attributes.stream}", LogStreamNameFallback: "default"}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	full := &fullBatchPusher{adding: make(chan struct{}), release: make(chan struct{})}
+	other := &countingPusher{}
+	exp := &exporter{
+		Config: cfg,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			if streamName == "full" {
+				return full
+			}
+			return other
+		},
+	}
+	newLogs := func(stream string) pdata.Logs {
+		ld := pdata.NewLogs()
+		record := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty()
+		record.SetName("test")
+		record.Attributes().UpsertString("stream", stream)
+		return ld
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- exp.ConsumeLogs(context.Background(), newLogs("full"))
+	}()
+	<-full.adding
+	// the push of the full batch does not hold the pusher lock, the exports to the other log streams go on
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newLogs("other")))
+	assert.Equal(t, 1, other.pushed)
+	close(full.release)
+	require.NoError(t, <-done)
+	assert.Equal(t, 1, full.pushed)
+}
+
+// failingFirstPusher fails its first flush, which blocks until it is released, dropping the events it takes like a
+// pusher that does not retain its failed batches. The flushes are serialized like the pushes of a pusher.
+type failingFirstPusher struct {
+	countingPusher
+	pushLock sync.Mutex
+	flushes  int
+	flushing chan struct{}
+	release  chan struct{}
+}
+
+func (p *failingFirstPusher) ForceFlush(ctx context.Context) error {
+	p.pushLock.Lock()
+	defer p.pushLock.Unlock()
+	if p.flushes++; p.flushes > 1 {
+		return p.countingPusher.ForceFlush(ctx)
+	}
+	p.flushing <- struct{}{}
+	<-p.release
+	p.Lock()
+	defer p.Unlock()
+	p.pending = 0
+	return errors.New("push failed")
+}
+
+func TestConsumeLogsConcurrentSharedPusher(t *testing.T) {
+	pusher := &failingFirstPusher{flushing: make(chan struct{}), release: make(chan struct{})}
+	exp := &exporter{
+		Config: &Config{},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	newLogs := func(n int) pdata.Logs {
+		ld := pdata.NewLogs()
+		logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+		for i := 0; i < n; i++ {
+			logs.AppendEmpty().SetName("test")
+		}
+		return ld
+	}
+
+	failed := make(chan error)
+	go func() {
+		failed <- exp.ConsumeLogs(context.Background(), newLogs(2))
+	}()
+	<-pusher.flushing
+	succeeded := make(chan error)
+	go func() {
+		succeeded <- exp.ConsumeLogs(context.Background(), newLogs(3))
+	}()
+	// the second export waits for the flush of the first to add its events, they are not dropped with its batch
+	time.Sleep(10 * time.Millisecond)
+	close(pusher.release)
+	assert.Error(t, <-failed)
+	require.NoError(t, <-succeeded)
+	assert.Equal(t, 3, pusher.pushed)
+	assert.Empty(t, exp.exportLocks)
+}
+
+func TestConsumeLogsCancelledRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pusher := &cancelledPushPusher{cancellingPusher{cancel: cancel, after: 3}}
+	exp := &exporter{
+		Config: &Config{},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	for i := 0; i < 10; i++ {
+		logs.AppendEmpty().SetName("test")
+	}
+
+	assert.Equal(t, context.Canceled, exp.ConsumeLogs(ctx, ld))
+	assert.Equal(t, 0, pusher.pending)
+	assert.Equal(t, 0, pusher.pushed)
+
+	// the retry of the export delivers every event once
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, 10, pusher.pushed)
+}
+
+func TestConsumeLogsCancelledWaitingForPusher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pusher := &blockingPusher{flushing: make(chan struct{}), release: make(chan struct{})}
+	exp := &exporter{
+		Config: &Config{},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- exp.ConsumeLogs(context.Background(), newSingleRecordLogs())
+	}()
+	<-pusher.flushing
+	cancelled := make(chan error)
+	go func() {
+		cancelled <- exp.ConsumeLogs(ctx, newSingleRecordLogs())
+	}()
+	// the export cancelled while waiting for the flush of the other adds no event
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	close(pusher.release)
+	require.NoError(t, <-done)
+	assert.Equal(t, context.Canceled, <-cancelled)
+	assert.Equal(t, 0, pusher.pending)
+	assert.Equal(t, 1, pusher.pushed)
+}
+
+func TestRotateStreamRetiresPushers(t *testing.T) {
+	retired := &countingPusher{}
+	exp := &exporter{
+		Config:    &Config{LogGroupName: "group", LogStreamName: "stream"},
+		logger:    zap.NewNop(),
+		pusher:    retired,
+		newPusher: func(string, string, string) cwlogs.Pusher { return &countingPusher{} },
+	}
+	// an event added by a concurrent export before the rotation
+	require.NoError(t, retired.AddLogEntry(&cwlogs.Event{}))
+	exp.pusherLock.Lock()
+	exp.rotateStream()
+	exp.pusherLock.Unlock()
+	assert.NotSame(t, retired, exp.pusher)
+
+	// the retired pusher is flushed once by the next flush
+	require.NoError(t, exp.flush(context.Background(), takePushers(exp)))
+	assert.Equal(t, 1, retired.pushed)
+	assert.Equal(t, []keyedPusher{{pusherKey{logGroupName: "group", logStreamName: "stream"}, exp.pusher}}, takePushers(exp))
+}
+
+// slowPusher takes latency to push its pending events, like the minimum interval between the requests of a pusher
+type slowPusher struct {
+	latency time.Duration
+	lock    sync.Mutex
+	pending int
+}
+
+func (p *slowPusher) AddLogEntry(*cwlogs.Event) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pending++
+	return nil
+}
+
+func (p *slowPusher) ForceFlush(_ context.Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.pending > 0 {
+		time.Sleep(p.latency)
+		p.pending = 0
+	}
+	return nil
+}
+
+// BenchmarkConsumeLogsStreams exports to 50 log streams, whose pushers take 10ms per push, in a single export
+// holding the events of every stream, and in concurrent exports to one stream each
+func BenchmarkConsumeLogsStreams(b *testing.B) {
+	const streams = 50
+	newExporter := func() *exporter {
+		cfg := &Config{LogGroupName: "group", LogStreamName: "{attributes.stream}", LogStreamNameFallback: "default"}
+		names, err := newLogNames(cfg)
+		require.NoError(b, err)
+		return &exporter{
+			Config:    cfg,
+			logger:    zap.NewNop(),
+			names:     names,
+			pusher:    &slowPusher{latency: 10 * time.Millisecond},
+			newPusher: func(string, string, string) cwlogs.Pusher { return &slowPusher{latency: 10 * time.Millisecond} },
+		}
+	}
+	newLogs := func(streamIDs ...int) pdata.Logs {
+		ld := pdata.NewLogs()
+		logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+		for _, id := range streamIDs {
+			record := logs.AppendEmpty()
+			record.SetName("test")
+			record.Attributes().UpsertString("stream", fmt.Sprintf("stream-%d", id))
+		}
+		return ld
+	}
+
+	b.Run("single export", func(b *testing.B) {
+		exp := newExporter()
+		ids := make([]int, streams)
+		for i := range ids {
+			ids[i] = i
+		}
+		ld := newLogs(ids...)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, exp.ConsumeLogs(context.Background(), ld))
+		}
+	})
+
+	b.Run("concurrent exports", func(b *testing.B) {
+		exp := newExporter()
+		var next int32
+		b.SetParallelism(streams)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			ld := newLogs(int(atomic.AddInt32(&next, 1)) % streams)
+			for pb.Next() {
+				if err := exp.ConsumeLogs(context.Background(), ld); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
+
+func TestConsumeLogsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pusher := &cancellingPusher{cancel: cancel, after: 3}
+	exp := &exporter{
+		Config: &Config{},
+		logger: zap.NewNop(),
+		pusher: pusher,
+	}
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	for i := 0; i < 10; i++ {
+		logs.AppendEmpty().SetName("test")
+	}
+
+	// the export adding its events completes, so that none are left in the pusher for its retry
+	require.NoError(t, exp.ConsumeLogs(ctx, ld))
+	assert.Equal(t, 0, pusher.pending)
+	assert.Equal(t, 10, pusher.pushed)
+
+	// the export cancelled before adding its events adds none
+	assert.Equal(t, context.Canceled, exp.ConsumeLogs(ctx, newSingleRecordLogs()))
+	assert.Equal(t, 0, pusher.pending)
+	assert.Equal(t, 10, pusher.pushed)
+}
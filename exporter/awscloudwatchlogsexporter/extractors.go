@@ -0,0 +1,144 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed JSONPath expression: a sequence of object member names (strings) and array indexes (ints).
+// Only the expressions selecting a single value are supported, e.g. $.request.headers['user-agent'] or $.items[0].
+type jsonPath []interface{}
+
+// parseJSONPath parses an expression starting at the root $, followed by .name, ['name'] or [index] steps.
+// Negative indexes count from the end of the array.
+func parseJSONPath(expr string) (jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, errors.New("must start with $")
+	}
+	var path jsonPath
+	for rest := expr[1:]; rest != ""; {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" || name == "*" {
+				return nil, fmt.Errorf("invalid member name at %q", rest)
+			}
+			path = append(path, name)
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket at %q", rest)
+			}
+			step := rest[1:end]
+			if len(step) >= 2 && (step[0] == '\'' || step[0] == '"') && step[len(step)-1] == step[0] {
+				path = append(path, step[1:len(step)-1])
+			} else if index, err := strconv.Atoi(step); err == nil {
+				path = append(path, index)
+			} else {
+				return nil, fmt.Errorf("invalid step %q", rest[:end+1])
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+	}
+	return path, nil
+}
+
+// lookup returns the value the path selects in a document, and whether it exists.
+func (p jsonPath) lookup(doc interface{}) (interface{}, bool) {
+	value := doc
+	for _, step := range p {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[step]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			if step < 0 {
+				step += len(array)
+			}
+			if step < 0 || step >= len(array) {
+				return nil, false
+			}
+			value = array[step]
+		}
+	}
+	return value, true
+}
+
+// parseBody returns the document the field extractors are evaluated against: the body itself when it is a map or
+// an array, or the JSON object or array it holds when it is a string. Other bodies have no document.
+func parseBody(body interface{}) (interface{}, bool) {
+	switch body := body.(type) {
+	case map[string]interface{}, []interface{}:
+		return body, true
+	case string:
+		trimmed := strings.TrimSpace(body)
+		if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+			return nil, false
+		}
+		decoder := json.NewDecoder(bytes.NewReader([]byte(trimmed)))
+		// keep the numbers as they are written, large integers would lose precision as doubles
+		decoder.UseNumber()
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, false
+		}
+		return doc, true
+	}
+	return nil, false
+}
+
+// extractFields adds to fields the values that the extractors select in the body. The extractors whose path does
+// not exist in the body are skipped.
+func extractFields(fields map[string]interface{}, body interface{}, fieldExtractors map[string]string) {
+	if len(fieldExtractors) == 0 {
+		return
+	}
+	doc, ok := parseBody(body)
+	if !ok {
+		return
+	}
+	for field, expr := range fieldExtractors {
+		// the expressions are checked when the configuration is validated
+		path, err := parseJSONPath(expr)
+		if err != nil {
+			continue
+		}
+		if value, ok := path.lookup(doc); ok {
+			fields[field] = value
+		}
+	}
+}
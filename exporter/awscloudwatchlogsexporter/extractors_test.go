@@ -0,0 +1,146 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    jsonPath
+		wantErr string
+	}{
+		{expr: "$", want: nil},
+		{expr: "$.request.status", want: jsonPath{"request", "status"}},
+		{expr: "$.items[0].id", want: jsonPath{"items", 0, "id"}},
+		{expr: "$.items[-1]", want: jsonPath{"items", -1}},
+		{expr: "$['user-agent']", want: jsonPath{"user-agent"}},
+		{expr: `$.headers["x.forwarded"].value`, want: jsonPath{"headers", "x.forwarded", "value"}},
+		{expr: "request.status", wantErr: "must start with $"},
+		{expr: "$..status", wantErr: `invalid member name at "..status"`},
+		{expr: "$.items[*]", wantErr: `invalid step "[*]"`},
+		{expr: "$.items[0", wantErr: `unclosed bracket at "[0"`},
+		{expr: "$items", wantErr: `unexpected "items"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseJSONPath(tt.expr)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExtractFields(t *testing.T) {
+	body := `{
+		"request": {"method": "GET", "headers": {"user-agent": "curl/7.79.1"}},
+		"response": {"status": 503, "bytes": 9007199254740993},
+		"retries": [{"delay": 0.5}, {"delay": 1.5}],
+		"ok": false,
+		"error": null
+	}`
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{name: "string", expr: "$.request.method", want: "GET"},
+		{name: "quoted name", expr: "$.request.headers['user-agent']", want: "curl/7.79.1"},
+		{name: "number", expr: "$.response.status", want: json.Number("503")},
+		{name: "large integer", expr: "$.response.bytes", want: json.Number("9007199254740993")},
+		{name: "index", expr: "$.retries[0].delay", want: json.Number("0.5")},
+		{name: "negative index", expr: "$.retries[-1].delay", want: json.Number("1.5")},
+		{name: "bool", expr: "$.ok", want: false},
+		{name: "null", expr: "$.error", want: nil},
+		{name: "object", expr: "$.request.headers", want: map[string]interface{}{"user-agent": "curl/7.79.1"}},
+		{name: "root", expr: "$.response", want: map[string]interface{}{"status": json.Number("503"), "bytes": json.Number("9007199254740993")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]interface{}{}
+			extractFields(fields, body, map[string]string{"field": tt.expr})
+			assert.Equal(t, map[string]interface{}{"field": tt.want}, fields)
+		})
+	}
+}
+
+func TestExtractFieldsSkipped(t *testing.T) {
+	extractors := map[string]string{
+		"missing":      "$.request.path",
+		"out_of_range": "$.retries[2]",
+		"not_an_array": "$.request[0]",
+		"not_object":   "$.request.method.name",
+	}
+	for _, body := range []interface{}{
+		`{"request": {"method": "GET"}, "retries": [1, 2]}`,
+		"GET /index.html",
+		`{"truncated": `,
+		int64(42),
+		nil,
+	} {
+		fields := map[string]interface{}{}
+		extractFields(fields, body, extractors)
+		assert.Empty(t, fields, "body %v", body)
+	}
+}
+
+func TestLogToCWLogFieldExtractors(t *testing.T) {
+	config := &Config{FieldExtractors: map[string]string{
+		"status":  "$.response.status",
+		"method":  "$.request.method",
+		"missing": "$.request.path",
+	}}
+
+	log := pdata.NewLogRecord()
+	log.Body().SetStringVal(`{"request":{"method":"POST"},"response":{"status":201}}`)
+	got, _, err := logToCWLog(nil, log, config)
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"{\"request\":{\"method\":\"POST\"},\"response\":{\"status\":201}}","method":"POST","status":201}`, *got.Message)
+
+	// structured bodies are queried as they are
+	log = pdata.NewLogRecord()
+	request := pdata.NewAttributeValueMap()
+	request.MapVal().InsertString("method", "GET")
+	pdata.NewAttributeValueMap().CopyTo(log.Body())
+	log.Body().MapVal().Insert("request", request)
+	got, _, err = logToCWLog(nil, log, config)
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":{"request":{"method":"GET"}},"method":"GET"}`, *got.Message)
+}
+
+func TestValidateFieldExtractors(t *testing.T) {
+	config := &Config{
+		LogGroupName:    "group",
+		LogStreamName:   "stream",
+		QueueSettings:   QueueSettings{QueueSize: 1},
+		FieldExtractors: map[string]string{"status": "$.status", "user": "$.users[*].name"},
+	}
+	assert.EqualError(t, config.Validate(), `'field_extractors' has invalid path "$.users[*].name" for "user": invalid step "[*]"`)
+	config.FieldExtractors["user"] = "$.users[0].name"
+	assert.NoError(t, config.Validate())
+	config.FieldExtractors[""] = "$.users"
+	assert.EqualError(t, config.Validate(), "'field_extractors' must not have an empty field name")
+}
@@ -19,7 +19,9 @@ package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelem
 import (
 	"context"
 	"errors"
+	"time"
 
+	"go.opencensus.io/stats/view"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
@@ -27,9 +29,15 @@ import (
 	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/awsutil"
 )
 
-const typeStr = "awscloudwatchlogs"
+const (
+	typeStr = "awscloudwatchlogs"
+
+	defaultCoolDown = 30 * time.Second
+)
 
 func NewFactory() component.ExporterFactory {
+	_ = view.Register(MetricViews()...)
+
 	return exporterhelper.NewFactory(
 		typeStr,
 		createDefaultConfig,
@@ -44,6 +52,10 @@ func createDefaultConfig() config.Exporter {
 		QueueSettings: QueueSettings{
 			QueueSize: exporterhelper.DefaultQueueSettings().QueueSize,
 		},
+		SortByTimestamp: true,
+		CircuitBreaker: CircuitBreakerSettings{
+			CoolDown: defaultCoolDown,
+		},
 	}
 }
 
@@ -32,6 +32,10 @@ func TestDefaultConfig_exporterSettings(t *testing.T) {
 		QueueSettings: QueueSettings{
 			QueueSize: exporterhelper.DefaultQueueSettings().QueueSize,
 		},
+		SortByTimestamp: true,
+		CircuitBreaker: CircuitBreakerSettings{
+			CoolDown: defaultCoolDown,
+		},
 	}
 	assert.Equal(t, want, createDefaultConfig())
 }
@@ -0,0 +1,70 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"sort"
+	"strconv"
+)
+
+// defaultMaxFlattenDepth is the number of levels of nested attributes flattened when the maximum depth is not set
+const defaultMaxFlattenDepth = 5
+
+// flattenAttributes returns the attributes with their nested maps and arrays flattened into dotted keys, e.g.
+// {"http": {"method": "GET"}} into {"http.method": "GET"}, and {"ids": [1, 2]} into {"ids.0": 1, "ids.1": 2}.
+// The values nested deeper than maxDepth levels are kept as is under the key of their parent, and so are the
+// empty maps and arrays. The keys are visited in order, so that a key produced by several attributes, e.g. by
+// "http.method" and {"http": {"method"}}, always holds the same value.
+func flattenAttributes(attrs map[string]interface{}, maxDepth int) map[string]interface{} {
+	if len(attrs) == 0 {
+		return attrs
+	}
+	out := make(map[string]interface{}, len(attrs))
+	for _, key := range sortedKeys(attrs) {
+		flattenValue(out, key, attrs[key], maxDepth)
+	}
+	return out
+}
+
+func flattenValue(out map[string]interface{}, key string, value interface{}, depth int) {
+	if depth > 0 {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if len(value) > 0 {
+				for _, k := range sortedKeys(value) {
+					flattenValue(out, key+"."+k, value[k], depth-1)
+				}
+				return
+			}
+		case []interface{}:
+			if len(value) > 0 {
+				for i, v := range value {
+					flattenValue(out, key+"."+strconv.Itoa(i), v, depth-1)
+				}
+				return
+			}
+		}
+	}
+	out[key] = value
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
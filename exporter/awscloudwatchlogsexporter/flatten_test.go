@@ -0,0 +1,88 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlattenAttributes(t *testing.T) {
+	deep := map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": map[string]interface{}{"d": "e"}}}}
+
+	tests := []struct {
+		name     string
+		attrs    map[string]interface{}
+		maxDepth int
+		want     map[string]interface{}
+	}{
+		{
+			name:     "flat",
+			attrs:    map[string]interface{}{"service": "api", "status": int64(200)},
+			maxDepth: defaultMaxFlattenDepth,
+			want:     map[string]interface{}{"service": "api", "status": int64(200)},
+		},
+		{
+			name: "nested maps",
+			attrs: map[string]interface{}{
+				"http": map[string]interface{}{
+					"method":  "GET",
+					"request": map[string]interface{}{"size": int64(12)},
+				},
+			},
+			maxDepth: defaultMaxFlattenDepth,
+			want:     map[string]interface{}{"http.method": "GET", "http.request.size": int64(12)},
+		},
+		{
+			name: "arrays",
+			attrs: map[string]interface{}{
+				"ids":   []interface{}{int64(1), int64(2)},
+				"users": []interface{}{map[string]interface{}{"name": "ann"}, []interface{}{true}},
+			},
+			maxDepth: defaultMaxFlattenDepth,
+			want:     map[string]interface{}{"ids.0": int64(1), "ids.1": int64(2), "users.0.name": "ann", "users.1.0": true},
+		},
+		{
+			name:     "empty containers",
+			attrs:    map[string]interface{}{"labels": map[string]interface{}{}, "ids": []interface{}{}, "none": nil},
+			maxDepth: defaultMaxFlattenDepth,
+			want:     map[string]interface{}{"labels": map[string]interface{}{}, "ids": []interface{}{}, "none": nil},
+		},
+		{
+			name:     "depth limit",
+			attrs:    deep,
+			maxDepth: 2,
+			want:     map[string]interface{}{"a.b.c": map[string]interface{}{"d": "e"}},
+		},
+		{
+			name:     "deep nesting",
+			attrs:    deep,
+			maxDepth: defaultMaxFlattenDepth,
+			want:     map[string]interface{}{"a.b.c.d": "e"},
+		},
+		{
+			name:     "colliding keys",
+			attrs:    map[string]interface{}{"http.method": "POST", "http": map[string]interface{}{"method": "GET"}},
+			maxDepth: defaultMaxFlattenDepth,
+			want:     map[string]interface{}{"http.method": "POST"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, flattenAttributes(tt.attrs, tt.maxDepth))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// FormatterDuration renders a number of nanoseconds as a duration, e.g. 1.5s
+	FormatterDuration = "duration"
+	// FormatterRFC3339 renders a number of nanoseconds since the epoch as an RFC3339 UTC time
+	FormatterRFC3339 = "rfc3339"
+	// FormatterBytes renders a number of bytes with a binary unit, e.g. 1.5 KiB
+	FormatterBytes = "bytes"
+)
+
+var formatters = map[string]func(int64) string{
+	FormatterDuration: func(v int64) string {
+		return time.Duration(v).String()
+	},
+	FormatterRFC3339: func(v int64) string {
+		return time.Unix(0, v).UTC().Format(time.RFC3339Nano)
+	},
+	FormatterBytes: formatBytes,
+}
+
+// formatAttributes replaces in place the numeric values of the attributes with a formatter by their rendering.
+// Doubles are truncated to integers, other values are left as is.
+func formatAttributes(attrs map[string]interface{}, attributeFormatters map[string]string) {
+	for key, formatter := range attributeFormatters {
+		var number int64
+		switch value := attrs[key].(type) {
+		case int64:
+			number = value
+		case float64:
+			number = int64(value)
+		default:
+			continue
+		}
+		attrs[key] = formatters[formatter](number)
+	}
+}
+
+func formatBytes(v int64) string {
+	const unit = 1024
+	if v > -unit && v < unit {
+		return fmt.Sprintf("%d B", v)
+	}
+	size, exp := float64(v)/unit, 0
+	for size <= -unit || size >= unit {
+		size /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", size, "KMGTPE"[exp])
+}
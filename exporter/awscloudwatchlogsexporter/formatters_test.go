@@ -0,0 +1,82 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestFormatAttributes(t *testing.T) {
+	tests := []struct {
+		name      string
+		formatter string
+		value     interface{}
+		want      interface{}
+	}{
+		{name: "duration", formatter: FormatterDuration, value: int64(1500000000), want: "1.5s"},
+		{name: "duration double", formatter: FormatterDuration, value: float64(2500.7), want: "2.5µs"},
+		{name: "negative duration", formatter: FormatterDuration, value: int64(-90000000000), want: "-1m30s"},
+		{name: "rfc3339", formatter: FormatterRFC3339, value: int64(1609763415123456789), want: "2021-01-04T12:30:15.123456789Z"},
+		{name: "rfc3339 epoch", formatter: FormatterRFC3339, value: int64(0), want: "1970-01-01T00:00:00Z"},
+		{name: "bytes", formatter: FormatterBytes, value: int64(512), want: "512 B"},
+		{name: "kibibytes", formatter: FormatterBytes, value: int64(1536), want: "1.5 KiB"},
+		{name: "mebibytes", formatter: FormatterBytes, value: int64(5 * 1024 * 1024), want: "5.0 MiB"},
+		{name: "exbibytes", formatter: FormatterBytes, value: float64(1 << 62), want: "4.0 EiB"},
+		{name: "negative bytes", formatter: FormatterBytes, value: int64(-2048), want: "-2.0 KiB"},
+		{name: "string left as is", formatter: FormatterDuration, value: "5s", want: "5s"},
+		{name: "bool left as is", formatter: FormatterBytes, value: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := map[string]interface{}{"key": tt.value, "other": int64(1000)}
+			formatAttributes(attrs, map[string]string{"key": tt.formatter, "missing": FormatterBytes})
+			assert.Equal(t, map[string]interface{}{"key": tt.want, "other": int64(1000)}, attrs)
+		})
+	}
+}
+
+func TestLogToCWLogAttributeFormatters(t *testing.T) {
+	resource := pdata.NewResource()
+	resource.Attributes().InsertInt("host.memory", 2048)
+	log := pdata.NewLogRecord()
+	log.Attributes().InsertInt("duration_ns", 1500000)
+	log.Attributes().InsertInt("count", 3)
+	config := &Config{AttributeFormatters: map[string]string{
+		"duration_ns": FormatterDuration,
+		"host.memory": FormatterBytes,
+	}}
+
+	resourceAttrs := attrsValue(resource.Attributes(), false)
+	formatAttributes(resourceAttrs, config.AttributeFormatters)
+	got, _, err := logToCWLog(resourceAttrs, log, config)
+	require.NoError(t, err)
+	assert.Equal(t, `{"attributes":{"count":3,"duration_ns":"1.5ms"},"resource":{"host.memory":"2.0 KiB"}}`, *got.Message)
+}
+
+func TestValidateAttributeFormatters(t *testing.T) {
+	config := &Config{
+		LogGroupName:        "group",
+		LogStreamName:       "stream",
+		QueueSettings:       QueueSettings{QueueSize: 1},
+		AttributeFormatters: map[string]string{"size": "bytes", "elapsed": "minutes"},
+	}
+	assert.EqualError(t, config.Validate(), `'attribute_formatters' has unknown formatter "minutes" for "elapsed"`)
+	config.AttributeFormatters["elapsed"] = FormatterDuration
+	assert.NoError(t, config.Validate())
+}
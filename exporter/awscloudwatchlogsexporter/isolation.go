@@ -0,0 +1,124 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/multierr"
+)
+
+// groupQueues gives every log group its own sending queue when IsolateLogGroups is set, so that the retries of a
+// throttled or failing log group do not hold up the records of the other log groups behind the single consumer of
+// the queue. The records of every export are split by log group, and enqueued on the queue of their log group,
+// created on first use.
+type groupQueues struct {
+	exp *exporter
+	// newQueue creates the sending queue of a log group, pushing its records with the retry settings of the exporter
+	newQueue func() (component.LogsExporter, error)
+	host     component.Host
+	// lock guards the queues
+	lock   sync.Mutex
+	queues map[string]component.LogsExporter
+}
+
+func newGroupQueues(exp *exporter, newQueue func() (component.LogsExporter, error)) *groupQueues {
+	return &groupQueues{exp: exp, newQueue: newQueue, queues: map[string]component.LogsExporter{}}
+}
+
+func (g *groupQueues) Start(ctx context.Context, host component.Host) error {
+	g.host = host
+	return g.exp.Start(ctx, host)
+}
+
+// Shutdown drains the queues of the log groups, then flushes the pushers of the exporter
+func (g *groupQueues) Shutdown(ctx context.Context) error {
+	g.lock.Lock()
+	queues := g.queues
+	g.queues = map[string]component.LogsExporter{}
+	g.lock.Unlock()
+	var errs error
+	for _, queue := range queues {
+		errs = multierr.Append(errs, queue.Shutdown(ctx))
+	}
+	return multierr.Append(errs, g.exp.Shutdown(ctx))
+}
+
+func (g *groupQueues) Capabilities() consumer.Capabilities {
+	return g.exp.Capabilities()
+}
+
+// ConsumeLogs enqueues the records of every log group on its queue. It fails when a queue is full, the records of
+// the other log groups are enqueued still.
+func (g *groupQueues) ConsumeLogs(ctx context.Context, ld pdata.Logs) error {
+	groups := g.exp.splitByLogGroup(ld)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs error
+	for _, name := range names {
+		queue, err := g.queue(ctx, name)
+		if err == nil {
+			err = queue.ConsumeLogs(ctx, groups[name])
+		}
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("failed to enqueue the records of log group %q: %w", name, err))
+		}
+	}
+	return errs
+}
+
+// queue returns the sending queue of the log group, creating and starting it on first use
+func (g *groupQueues) queue(ctx context.Context, logGroupName string) (component.LogsExporter, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if queue, ok := g.queues[logGroupName]; ok {
+		return queue, nil
+	}
+	queue, err := g.newQueue()
+	if err != nil {
+		return nil, err
+	}
+	if err = queue.Start(ctx, g.host); err != nil {
+		return nil, err
+	}
+	g.queues[logGroupName] = queue
+	return queue, nil
+}
+
+// splitByLogGroup returns the records of every log group, resolved from the attributes of their resource
+func (e *exporter) splitByLogGroup(ld pdata.Logs) map[string]pdata.Logs {
+	groups := map[string]pdata.Logs{}
+	rls := ld.ResourceLogs()
+	for i := 0; i < rls.Len(); i++ {
+		rl := rls.At(i)
+		name, _ := e.names.logGroupName(e.Config, rl.Resource())
+		group, ok := groups[name]
+		if !ok {
+			group = pdata.NewLogs()
+			groups[name] = group
+		}
+		rl.CopyTo(group.ResourceLogs().AppendEmpty())
+	}
+	return groups
+}
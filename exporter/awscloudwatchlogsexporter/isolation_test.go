@@ -0,0 +1,136 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+// alwaysThrottledPusher fails every flush for the quota of the log stream
+type alwaysThrottledPusher struct {
+	lock    sync.Mutex
+	flushes int
+}
+
+func (p *alwaysThrottledPusher) AddLogEntry(*cwlogs.Event) error {
+	return nil
+}
+
+func (p *alwaysThrottledPusher) ForceFlush(_ context.Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.flushes++
+	return awserr.New(errCodeThrottlingException, "Rate exceeded for logStreamName", nil)
+}
+
+func (p *alwaysThrottledPusher) flushCount() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.flushes
+}
+
+func TestGroupQueuesIsolateThrottledLogGroup(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.ExporterSettings = config.NewExporterSettings(config.NewComponentIDWithName(typeStr, "isolated"))
+	cfg.LogGroupName = "{resource.group}"
+	cfg.LogGroupNameFallback = "default"
+	cfg.LogStreamName = "stream"
+	cfg.IsolateLogGroups = true
+	cfg.RetrySettings.InitialInterval = 10 * time.Millisecond
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	throttled := &alwaysThrottledPusher{}
+	other := &countingPusher{}
+	exp := &exporter{
+		Config: cfg,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, logGroupName, _ string) cwlogs.Pusher {
+			if logGroupName == "throttled" {
+				return throttled
+			}
+			return other
+		},
+	}
+	queues := newGroupQueues(exp, func() (component.LogsExporter, error) {
+		return exporterhelper.NewLogsExporter(cfg, componenttest.NewNopExporterCreateSettings(), exp.ConsumeLogs,
+			exporterhelper.WithQueue(cfg.enforcedQueueSettings()),
+			exporterhelper.WithRetry(cfg.RetrySettings))
+	})
+	require.NoError(t, queues.Start(context.Background(), componenttest.NewNopHost()))
+	newLogs := func(group string) pdata.Logs {
+		ld := pdata.NewLogs()
+		rl := ld.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().UpsertString("group", group)
+		rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+		return ld
+	}
+
+	require.NoError(t, queues.ConsumeLogs(context.Background(), newLogs("throttled")))
+	assert.Eventually(t, func() bool { return throttled.flushCount() > 1 }, time.Second, time.Millisecond)
+	// the records of the other log group are pushed while the throttled log group is retried
+	for i := 0; i < 3; i++ {
+		require.NoError(t, queues.ConsumeLogs(context.Background(), newLogs("other")))
+	}
+	assert.Eventually(t, func() bool {
+		other.Lock()
+		defer other.Unlock()
+		return other.pushed == 3
+	}, time.Second, time.Millisecond)
+	queues.lock.Lock()
+	assert.Len(t, queues.queues, 2)
+	queues.lock.Unlock()
+	// the events of the throttled log group are still pending
+	err = queues.Shutdown(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `log group "throttled"`)
+}
+
+func TestSplitByLogGroup(t *testing.T) {
+	cfg := &Config{LogGroupName: "{resource.group}", LogGroupNameFallback: "default"}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	exp := &exporter{Config: cfg, names: names}
+	ld := pdata.NewLogs()
+	for _, group := range []string{"a", "b", "a", ""} {
+		rl := ld.ResourceLogs().AppendEmpty()
+		if group != "" {
+			rl.Resource().Attributes().UpsertString("group", group)
+		}
+		rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+	}
+
+	groups := exp.splitByLogGroup(ld)
+	require.Len(t, groups, 3)
+	assert.Equal(t, 2, groups["a"].LogRecordCount())
+	assert.Equal(t, 1, groups["b"].LogRecordCount())
+	assert.Equal(t, 1, groups["default"].LogRecordCount())
+}
@@ -0,0 +1,77 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxPushersEvict flushes and drops the least recently used pusher to make room for the pusher of a new log
+	// stream
+	MaxPushersEvict = "evict"
+	// MaxPushersReject drops the records of the new log streams while the number of pushers is at the maximum
+	MaxPushersReject = "reject"
+)
+
+// makeRoomForPusher tells whether the pusher of a new log stream can be created under MaxPushers, evicting the
+// least recently used pusher with the evict strategy. The evicted pusher is retired, so that its events are pushed
+// by the next flush. It must be called with the pusher lock held.
+func (e *exporter) makeRoomForPusher() bool {
+	if e.Config.MaxPushers == 0 || len(e.pushers) < e.Config.MaxPushers {
+		return true
+	}
+	if e.Config.MaxPushersStrategy == MaxPushersReject {
+		return false
+	}
+	key := e.leastRecentlyUsedPusher()
+	e.logger.Debug("Evicting the least recently used pusher",
+		zap.String("LogGroupName", key.logGroupName),
+		zap.String("LogStreamName", key.logStreamName))
+	e.retired = append(e.retired, e.takePusher(key)...)
+	return true
+}
+
+// leastRecentlyUsedPusher returns the key of the pusher of the resolved log streams that received an event the
+// longest time ago
+func (e *exporter) leastRecentlyUsedPusher() pusherKey {
+	var lru pusherKey
+	first := true
+	for key := range e.pushers {
+		if first || e.lastUsed[key].Before(e.lastUsed[lru]) {
+			lru = key
+			first = false
+		}
+	}
+	return lru
+}
+
+// takePusher removes the pusher of the log stream with its shards, and returns them to flush the events they may
+// still hold. It must be called with the pusher lock held.
+func (e *exporter) takePusher(key pusherKey) []keyedPusher {
+	var taken []keyedPusher
+	if pusher, ok := e.pushers[key]; ok {
+		taken = append(taken, keyedPusher{key, pusher})
+	}
+	for _, shard := range e.shards[key] {
+		taken = append(taken, keyedPusher{key, shard})
+	}
+	delete(e.pushers, key)
+	delete(e.shards, key)
+	delete(e.nextShard, key)
+	delete(e.periodEnds, key)
+	delete(e.lastUsed, key)
+	return taken
+}
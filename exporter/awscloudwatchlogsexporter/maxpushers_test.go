@@ -0,0 +1,154 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+func TestMaxPushersEvict(t *testing.T) {
+	clock := time.Unix(1609763415, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return clock }
+
+	cfg := &Config{
+		LogGroupName:          "group",
+		LogStreamName:         "{attributes.stream}",
+		LogStreamNameFallback: "default",
+		Coalescing:            CoalescingSettings{Window: time.Hour},
+		MaxPushers:            2,
+	}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	pushers := map[string]*countingPusher{}
+	exp := &exporter{
+		Config: cfg,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			pusher := &countingPusher{}
+			pushers[streamName] = pusher
+			return pusher
+		},
+	}
+	for _, stream := range []string{"pod-a", "pod-b", "default", "pod-a"} {
+		clock = clock.Add(time.Second)
+		require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs(stream)))
+	}
+
+	// the least recently used pusher makes room for the new log stream, the default pusher is not counted
+	clock = clock.Add(time.Second)
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("pod-c")))
+	assert.Len(t, exp.pushers, 2)
+	assert.NotContains(t, exp.pushers, pusherKey{logGroupName: "group", logStreamName: "pod-b"})
+	assert.NotContains(t, exp.lastUsed, pusherKey{logGroupName: "group", logStreamName: "pod-b"})
+	require.Len(t, exp.retired, 1)
+	assert.Equal(t, "pod-b", exp.retired[0].key.logStreamName)
+
+	// the events of the evicted pusher are pushed by the next flush
+	exp.pusherLock.Lock()
+	taken := exp.takePushers()
+	exp.pusherLock.Unlock()
+	require.NoError(t, exp.flush(context.Background(), taken))
+	assert.Equal(t, 1, pushers["pod-b"].pushed)
+	assert.Equal(t, 2, pushers["pod-a"].pushed)
+	assert.Equal(t, 1, pushers["pod-c"].pushed)
+}
+
+func TestMaxPushersEvictSameExport(t *testing.T) {
+	cfg := &Config{
+		LogGroupName:          "group",
+		LogStreamName:         "{attributes.stream}",
+		LogStreamNameFallback: "default",
+		MaxPushers:            1,
+	}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	pushers := map[string]*countingPusher{}
+	exp := &exporter{
+		Config: cfg,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			pusher := &countingPusher{}
+			pushers[streamName] = pusher
+			return pusher
+		},
+	}
+	ld := newStreamLogs("pod-a")
+	newStreamLogs("pod-b").ResourceLogs().MoveAndAppendTo(ld.ResourceLogs())
+
+	// the pusher evicted by the export is flushed with the others
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.Equal(t, 1, pushers["pod-a"].pushed)
+	assert.Equal(t, 1, pushers["pod-a"].requests)
+	assert.Equal(t, 1, pushers["pod-b"].pushed)
+	assert.Empty(t, exp.retired)
+}
+
+func TestMaxPushersReject(t *testing.T) {
+	require.NoError(t, view.Register(MetricViews()...))
+	tags := []tag.Tag{{Key: exporterTagKey, Value: "awscloudwatchlogs/max_pushers"}, {Key: logGroupTagKey, Value: "group"}}
+	before := recordedSum(t, mRejectedLogRecords.Name(), tags)
+
+	cfg := &Config{
+		ExporterSettings:      config.NewExporterSettings(config.NewComponentIDWithName(typeStr, "max_pushers")),
+		LogGroupName:          "group",
+		LogStreamName:         "{attributes.stream}",
+		LogStreamNameFallback: "default",
+		MaxPushers:            1,
+		MaxPushersStrategy:    MaxPushersReject,
+	}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	defaultPusher := &countingPusher{}
+	pushers := map[string]*countingPusher{}
+	exp := &exporter{
+		Config: cfg,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: defaultPusher,
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			pusher := &countingPusher{}
+			pushers[streamName] = pusher
+			return pusher
+		},
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("pod-a")))
+
+	// the records of the new log stream are dropped, the known log streams and the default one still receive theirs
+	ld := newStreamLogs("pod-b")
+	newStreamLogs("pod-a").ResourceLogs().MoveAndAppendTo(ld.ResourceLogs())
+	newStreamLogs("default").ResourceLogs().MoveAndAppendTo(ld.ResourceLogs())
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	assert.NotContains(t, pushers, "pod-b")
+	assert.Equal(t, 2, pushers["pod-a"].pushed)
+	assert.Equal(t, 1, defaultPusher.pushed)
+	assert.Equal(t, 0, exp.pending)
+	assert.Equal(t, before+1, recordedSum(t, mRejectedLogRecords.Name(), tags))
+}
@@ -0,0 +1,93 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+var (
+	mSampledOutLogRecords = stats.Int64("awscloudwatchlogs_sampled_out_log_records", "Number of log records not exported because of sampling", stats.UnitDimensionless)
+	mDroppedLogRecords    = stats.Int64("awscloudwatchlogs_dropped_log_records", "Number of log records not exported because they could not be converted to valid events", stats.UnitDimensionless)
+	mTruncatedLogRecords  = stats.Int64("awscloudwatchlogs_truncated_log_records", "Number of log records whose body was cut to fit the event size limit", stats.UnitDimensionless)
+	mFallbackLogRecords   = stats.Int64("awscloudwatchlogs_fallback_log_records", "Number of log records sent to the fallback log group or log stream because their template could not be resolved", stats.UnitDimensionless)
+	mRejectedLogRecords   = stats.Int64("awscloudwatchlogs_rejected_log_records", "Number of log records not exported because the pushers of the exporter are at their maximum", stats.UnitDimensionless)
+	mCircuitBreakerState  = stats.Int64("awscloudwatchlogs_circuit_breaker_state", "State of the circuit breaker around PutLogEvents: 0 closed, 1 open, 2 half-open", stats.UnitDimensionless)
+
+	// exporterTagKey tells apart the exporters a metric is recorded for
+	exporterTagKey = tag.MustNewKey("exporter")
+	// logGroupTagKey is the log group a metric is recorded for, set only when the log group name is not templated
+	// to bound the cardinality
+	logGroupTagKey = tag.MustNewKey("log_group")
+)
+
+// MetricViews return the metrics views according to given telemetry level.
+func MetricViews() []*view.View {
+	return append([]*view.View{
+		{
+			Name:        mSampledOutLogRecords.Name(),
+			Measure:     mSampledOutLogRecords,
+			Description: mSampledOutLogRecords.Description(),
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        mDroppedLogRecords.Name(),
+			Measure:     mDroppedLogRecords,
+			Description: mDroppedLogRecords.Description(),
+			TagKeys:     []tag.Key{exporterTagKey, logGroupTagKey},
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        mTruncatedLogRecords.Name(),
+			Measure:     mTruncatedLogRecords,
+			Description: mTruncatedLogRecords.Description(),
+			TagKeys:     []tag.Key{exporterTagKey, logGroupTagKey},
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        mFallbackLogRecords.Name(),
+			Measure:     mFallbackLogRecords,
+			Description: mFallbackLogRecords.Description(),
+			TagKeys:     []tag.Key{exporterTagKey, logGroupTagKey},
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        mRejectedLogRecords.Name(),
+			Measure:     mRejectedLogRecords,
+			Description: mRejectedLogRecords.Description(),
+			TagKeys:     []tag.Key{exporterTagKey, logGroupTagKey},
+			Aggregation: view.Sum(),
+		},
+		viewCircuitBreakerState,
+	}, pusherViews...)
+}
+
+// pusherViews are the histograms of the PutLogEvents requests by exporter and log group, created once for the same
+// reason as viewCircuitBreakerState.
+var pusherViews = cwlogs.MetricViews(exporterTagKey, logGroupTagKey)
+
+// viewCircuitBreakerState is created once: unlike sums, last value aggregations differ between calls of
+// view.LastValue, and registering the views again would fail.
+var viewCircuitBreakerState = &view.View{
+	Name:        mCircuitBreakerState.Name(),
+	Measure:     mCircuitBreakerState,
+	Description: mCircuitBreakerState.Description(),
+	TagKeys:     []tag.Key{exporterTagKey},
+	Aggregation: view.LastValue(),
+}
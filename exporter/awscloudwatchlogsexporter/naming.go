@@ -0,0 +1,161 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// FieldNamingSnakeCase names the fixed fields of the events in snake_case, e.g. severity_number
+	FieldNamingSnakeCase = "snake_case"
+	// FieldNamingCamelCase names the fixed fields of the events in camelCase, e.g. severityNumber
+	FieldNamingCamelCase = "camelCase"
+)
+
+// camelCaseFieldNames maps the snake_case names of the fixed fields of cwLogBody to their camelCase names. The
+// names made of a single word are the same in both conventions.
+var camelCaseFieldNames = map[string]string{
+	"severity_number":          "severityNumber",
+	"severity_text":            "severityText",
+	"dropped_attributes_count": "droppedAttributesCount",
+	"trace_id":                 "traceId",
+	"span_id":                  "spanId",
+	"original_bytes":           "originalBytes",
+}
+
+// fieldName returns the name of a fixed field of cwLogBody, given in snake_case, in the naming convention.
+func fieldName(name, naming string) string {
+	if naming == FieldNamingCamelCase {
+		if camelCase, ok := camelCaseFieldNames[name]; ok {
+			return camelCase
+		}
+	}
+	return name
+}
+
+// bodyField is a fixed field of cwLogBody, named in snake_case
+type bodyField struct {
+	name  string
+	value interface{}
+}
+
+// fixedFields returns the fixed fields of the body which are set, in the order they are written.
+func (b cwLogBody) fixedFields() []bodyField {
+	var fields []bodyField
+	add := func(name string, value interface{}, set bool) {
+		if set {
+			fields = append(fields, bodyField{name, value})
+		}
+	}
+	add("name", b.Name, b.Name != "")
+	add("body", b.Body, b.Body != nil)
+	add("severity_number", b.SeverityNumber, b.SeverityNumber != 0)
+	add("severity_text", b.SeverityText, b.SeverityText != "")
+	add("dropped_attributes_count", b.DroppedAttributesCount, b.DroppedAttributesCount != 0)
+	add("flags", b.Flags, b.Flags != 0)
+	add(defaultTraceIDField, b.TraceID, b.TraceID != "")
+	add(defaultSpanIDField, b.SpanID, b.SpanID != "")
+	add("attributes", b.Attributes, len(b.Attributes) > 0)
+	add("resource", b.Resource, len(b.Resource) > 0)
+	add("truncated", b.Truncated, b.Truncated)
+	add("original_bytes", b.OriginalBytes, b.OriginalBytes != 0)
+	return fields
+}
+
+// namedField is a top-level field of the events whose name is configured, with the setting naming it
+type namedField struct {
+	setting string
+	name    string
+}
+
+// namedFields returns the top-level fields named by the configuration
+func (config *Config) namedFields() []namedField {
+	var fields []namedField
+	extracted := make([]string, 0, len(config.FieldExtractors))
+	for name := range config.FieldExtractors {
+		extracted = append(extracted, name)
+	}
+	sort.Strings(extracted)
+	for _, name := range extracted {
+		fields = append(fields, namedField{"field_extractors", name})
+	}
+	// the IDs under their default names are fixed fields
+	if name := config.traceIDField(); name != defaultTraceIDField {
+		fields = append(fields, namedField{"trace_id_field", name})
+	}
+	if name := config.spanIDField(); name != defaultSpanIDField {
+		fields = append(fields, namedField{"span_id_field", name})
+	}
+	if config.SampledField != "" {
+		fields = append(fields, namedField{"sampled_field", config.SampledField})
+	}
+	if config.IncludeCollectorID {
+		fields = append(fields, namedField{"collector_id_field", config.collectorIDField()})
+	}
+	if config.SeverityAsLevel {
+		fields = append(fields, namedField{"severity_as_level", levelField})
+	}
+	if config.PipelineLatency {
+		fields = append(fields, namedField{"pipeline_latency", pipelineLatencyField})
+	}
+	if config.ExportedAt {
+		fields = append(fields, namedField{"exported_at", exportedAtField})
+	}
+	if config.PropagatedContext {
+		fields = append(fields, namedField{"propagated_context", traceStateField}, namedField{"propagated_context", baggageField})
+	}
+	return fields
+}
+
+// reservedFieldNames returns the names of the fixed fields of the events, in the field naming of the configuration.
+// The trace and span IDs written under another name leave the name of their fixed field free.
+func (config *Config) reservedFieldNames() map[string]bool {
+	// every fixed field is set, so that fixedFields returns them all
+	body := cwLogBody{
+		Name: "-", Body: "-", SeverityNumber: 1, SeverityText: "-", DroppedAttributesCount: 1, Flags: 1,
+		TraceID: "-", SpanID: "-", Attributes: map[string]interface{}{"-": nil}, Resource: map[string]interface{}{"-": nil},
+		Truncated: true, OriginalBytes: 1,
+	}
+	if config.traceIDField() != defaultTraceIDField {
+		body.TraceID = ""
+	}
+	if config.spanIDField() != defaultSpanIDField {
+		body.SpanID = ""
+	}
+	reserved := map[string]bool{}
+	for _, field := range body.fixedFields() {
+		reserved[fieldName(field.name, config.FieldNaming)] = true
+	}
+	return reserved
+}
+
+// validateFieldNames checks the top-level fields named by the configuration neither take the name of a fixed field
+// nor the name of one another, which would write the key twice in the events.
+func (config *Config) validateFieldNames() error {
+	reserved := config.reservedFieldNames()
+	named := map[string]string{}
+	for _, field := range config.namedFields() {
+		if reserved[field.name] {
+			return fmt.Errorf("'%s' names the fixed field %q of the events", field.setting, field.name)
+		}
+		if setting, ok := named[field.name]; ok {
+			return fmt.Errorf("'%s' and '%s' both name the field %q", setting, field.setting, field.name)
+		}
+		named[field.name] = field.setting
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCWLogBodyFieldNaming(t *testing.T) {
+	body := cwLogBody{
+		Name:                   "name",
+		Body:                   "body",
+		SeverityNumber:         9,
+		SeverityText:           "INFO",
+		DroppedAttributesCount: 1,
+		Flags:                  1,
+		TraceID:                "5759e988bd862e3fe1be46a994272793",
+		SpanID:                 "53995c3f42cd8ad8",
+		Attributes:             map[string]interface{}{"key_name": "value"},
+		Resource:               map[string]interface{}{"service.name": "checkout"},
+		Truncated:              true,
+		OriginalBytes:          300000,
+		fields:                 map[string]interface{}{"pipeline_latency_ms": 5},
+	}
+	tests := []struct {
+		naming string
+		want   []string
+	}{
+		{
+			naming: "",
+			want: []string{"attributes", "body", "dropped_attributes_count", "flags", "name", "original_bytes", "pipeline_latency_ms",
+				"resource", "severity_number", "severity_text", "span_id", "trace_id", "truncated"},
+		},
+		{
+			naming: FieldNamingSnakeCase,
+			want: []string{"attributes", "body", "dropped_attributes_count", "flags", "name", "original_bytes", "pipeline_latency_ms",
+				"resource", "severity_number", "severity_text", "span_id", "trace_id", "truncated"},
+		},
+		{
+			// the fields with a configurable name and the attributes keep their names
+			naming: FieldNamingCamelCase,
+			want: []string{"attributes", "body", "droppedAttributesCount", "flags", "name", "originalBytes", "pipeline_latency_ms",
+				"resource", "severityNumber", "severityText", "spanId", "traceId", "truncated"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.naming, func(t *testing.T) {
+			body.naming = tt.naming
+			out, err := body.marshal()
+			require.NoError(t, err)
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal(out, &fields))
+			var keys []string
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			assert.Equal(t, tt.want, keys)
+			assert.Equal(t, map[string]interface{}{"key_name": "value"}, fields["attributes"])
+		})
+	}
+}
+
+func TestCWLogBodyFieldOrder(t *testing.T) {
+	body := cwLogBody{Body: "hello", SeverityText: "INFO", TraceID: "5759e988bd862e3fe1be46a994272793", naming: FieldNamingCamelCase,
+		fields: map[string]interface{}{"level": "INFO"}}
+	out, err := body.marshal()
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"hello","severityText":"INFO","traceId":"5759e988bd862e3fe1be46a994272793","level":"INFO"}`, string(out))
+
+	// the unset fields are left out
+	out, err = cwLogBody{naming: FieldNamingCamelCase}.marshal()
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(out))
+}
+
+func TestValidateFieldNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(cfg *Config)
+		err    string
+	}{
+		{
+			name:   "extractor",
+			config: func(cfg *Config) { cfg.FieldExtractors = map[string]string{"status": "$.status"} },
+		},
+		{
+			name:   "extractor named after a fixed field",
+			config: func(cfg *Config) { cfg.FieldExtractors = map[string]string{"status": "$.status", "name": "$.a"} },
+			err:    `'field_extractors' names the fixed field "name" of the events`,
+		},
+		{
+			name: "extractor named after a camelCase fixed field",
+			config: func(cfg *Config) {
+				cfg.FieldNaming = FieldNamingCamelCase
+				cfg.FieldExtractors = map[string]string{"severityText": "$.level"}
+			},
+			err: `'field_extractors' names the fixed field "severityText" of the events`,
+		},
+		{
+			name: "extractor named after a fixed field of the other naming",
+			config: func(cfg *Config) {
+				cfg.FieldNaming = FieldNamingCamelCase
+				cfg.FieldExtractors = map[string]string{"severity_text": "$.level"}
+			},
+		},
+		{
+			name:   "trace ID field named after a fixed field",
+			config: func(cfg *Config) { cfg.TraceIDField = "body" },
+			err:    `'trace_id_field' names the fixed field "body" of the events`,
+		},
+		{
+			name:   "span ID field named after a fixed field",
+			config: func(cfg *Config) { cfg.SpanIDField = "flags" },
+			err:    `'span_id_field' names the fixed field "flags" of the events`,
+		},
+		{
+			name:   "trace ID field named after the fixed span ID",
+			config: func(cfg *Config) { cfg.TraceIDField = "span_id" },
+			err:    "'trace_id_field' and 'span_id_field' must be different",
+		},
+		{
+			name: "fields named after the moved IDs",
+			config: func(cfg *Config) {
+				cfg.TraceIDField = "aws.xray.trace_id"
+				cfg.SpanIDField = "aws.xray.span_id"
+				cfg.FieldExtractors = map[string]string{"trace_id": "$.trace"}
+			},
+		},
+		{
+			name: "camelCase trace ID field",
+			config: func(cfg *Config) {
+				cfg.FieldNaming = FieldNamingCamelCase
+				cfg.TraceIDField = "traceId"
+			},
+		},
+		{
+			name: "same trace and span ID fields",
+			config: func(cfg *Config) {
+				cfg.TraceIDField = "id"
+				cfg.SpanIDField = "id"
+			},
+			err: "'trace_id_field' and 'span_id_field' must be different",
+		},
+		{
+			name:   "sampled field named after a fixed field",
+			config: func(cfg *Config) { cfg.SampledField = "flags" },
+			err:    `'sampled_field' names the fixed field "flags" of the events`,
+		},
+		{
+			name:   "sampled field",
+			config: func(cfg *Config) { cfg.SampledField = "sampled" },
+		},
+		{
+			name: "extractor named after the sampled field",
+			config: func(cfg *Config) {
+				cfg.SampledField = "sampled"
+				cfg.FieldExtractors = map[string]string{"sampled": "$.sampled"}
+			},
+			err: `'field_extractors' and 'sampled_field' both name the field "sampled"`,
+		},
+		{
+			name: "sampled field named after the trace ID field",
+			config: func(cfg *Config) {
+				cfg.TraceIDField = "aws.xray.trace_id"
+				cfg.SampledField = "aws.xray.trace_id"
+			},
+			err: `'trace_id_field' and 'sampled_field' both name the field "aws.xray.trace_id"`,
+		},
+		{
+			name: "sampled field named after the level",
+			config: func(cfg *Config) {
+				cfg.SeverityAsLevel = true
+				cfg.SampledField = "level"
+			},
+			err: `'sampled_field' and 'severity_as_level' both name the field "level"`,
+		},
+		{
+			name: "collector ID field named after the sampled field",
+			config: func(cfg *Config) {
+				cfg.SampledField = "sampled"
+				cfg.IncludeCollectorID = true
+				cfg.CollectorIDField = "sampled"
+			},
+			err: `'sampled_field' and 'collector_id_field' both name the field "sampled"`,
+		},
+		{
+			name: "collector ID field named after a fixed field",
+			config: func(cfg *Config) {
+				cfg.IncludeCollectorID = true
+				cfg.CollectorIDField = "resource"
+			},
+			err: `'collector_id_field' names the fixed field "resource" of the events`,
+		},
+		{
+			name: "extractor named after an added field",
+			config: func(cfg *Config) {
+				cfg.PropagatedContext = true
+				cfg.FieldExtractors = map[string]string{"baggage": "$.baggage"}
+			},
+			err: `'field_extractors' and 'propagated_context' both name the field "baggage"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.LogGroupName = "group"
+			cfg.LogStreamName = "stream"
+			tt.config(cfg)
+			err := cfg.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	// traceStateAttribute and baggageAttribute are the record attributes holding the W3C tracestate and baggage
+	// headers propagated with the trace of the record, named after the headers
+	traceStateAttribute = "tracestate"
+	baggageAttribute    = "baggage"
+
+	traceStateField = "trace_state"
+	baggageField    = "baggage"
+)
+
+// addPropagatedContext adds to fields the members of the W3C trace state and baggage held by the attributes of
+// the record, keyed by their names. The fields are left out when the attributes are missing or hold no members.
+func addPropagatedContext(fields map[string]interface{}, attrs pdata.AttributeMap) {
+	if value, ok := attrs.Get(traceStateAttribute); ok && value.Type() == pdata.AttributeValueTypeString {
+		if members := parseTraceState(value.StringVal()); len(members) > 0 {
+			fields[traceStateField] = members
+		}
+	}
+	if value, ok := attrs.Get(baggageAttribute); ok && value.Type() == pdata.AttributeValueTypeString {
+		if members := parseBaggage(value.StringVal()); len(members) > 0 {
+			fields[baggageField] = members
+		}
+	}
+}
+
+// parseTraceState parses the comma-separated key=value list members of a W3C tracestate header, see
+// https://www.w3.org/TR/trace-context/#tracestate-header. Malformed members are skipped.
+func parseTraceState(header string) map[string]string {
+	members := map[string]string{}
+	for _, member := range strings.Split(header, ",") {
+		key, value, ok := cutMember(member)
+		if !ok {
+			continue
+		}
+		// only the leftmost entry of a key is valid
+		if _, seen := members[key]; !seen {
+			members[key] = value
+		}
+	}
+	return members
+}
+
+// parseBaggage parses the comma-separated key=value list members of a W3C baggage header, see
+// https://www.w3.org/TR/baggage/#header-content. The values are percent-decoded, and the properties following
+// them are dropped. Malformed members are skipped.
+func parseBaggage(header string) map[string]string {
+	members := map[string]string{}
+	for _, member := range strings.Split(header, ",") {
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+		key, value, ok := cutMember(member)
+		if !ok {
+			continue
+		}
+		decoded, err := url.PathUnescape(value)
+		if err != nil {
+			continue
+		}
+		members[key] = decoded
+	}
+	return members
+}
+
+// cutMember splits a list member on its first equal sign, trimming the optional whitespace around it
+func cutMember(member string) (string, string, bool) {
+	i := strings.IndexByte(member, '=')
+	if i < 0 {
+		return "", "", false
+	}
+	key, value := strings.TrimSpace(member[:i]), strings.TrimSpace(member[i+1:])
+	if key == "" {
+		return "", "", false
+	}
+	return key, value, true
+}
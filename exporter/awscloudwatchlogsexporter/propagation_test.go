@@ -0,0 +1,113 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestParseTraceState(t *testing.T) {
+	tests := []struct {
+		header string
+		want   map[string]string
+	}{
+		{header: "", want: map[string]string{}},
+		{header: "congo=t61rcWkgMzE", want: map[string]string{"congo": "t61rcWkgMzE"}},
+		{header: "rojo=00f067aa0ba902b7, congo=t61rcWkgMzE", want: map[string]string{"rojo": "00f067aa0ba902b7", "congo": "t61rcWkgMzE"}},
+		{header: "tenant@vendor=a=b,,malformed,=empty", want: map[string]string{"tenant@vendor": "a=b"}},
+		{header: "rojo=first,rojo=second", want: map[string]string{"rojo": "first"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseTraceState(tt.header))
+		})
+	}
+}
+
+func TestParseBaggage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   map[string]string
+	}{
+		{header: "", want: map[string]string{}},
+		{header: "userId=alice", want: map[string]string{"userId": "alice"}},
+		{header: "userId=alice, serverNode = DF%2028 ,isProduction=false", want: map[string]string{"userId": "alice", "serverNode": "DF 28", "isProduction": "false"}},
+		{header: "tenant=acme;ttl=60;secret", want: map[string]string{"tenant": "acme"}},
+		{header: "bad=%zz,noValue,good=1", want: map[string]string{"good": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseBaggage(tt.header))
+		})
+	}
+}
+
+func TestLogToCWLogPropagatedContext(t *testing.T) {
+	log := pdata.NewLogRecord()
+	log.Attributes().InsertString("tracestate", "rojo=00f067aa0ba902b7")
+	log.Attributes().InsertString("baggage", "tenant=acme,plan=gold%20tier")
+
+	got, _, err := logToCWLog(nil, log, &Config{PropagatedContext: true})
+	require.NoError(t, err)
+	assert.Equal(t, `{"attributes":{"baggage":"tenant=acme,plan=gold%20tier","tracestate":"rojo=00f067aa0ba902b7"},`+
+		`"baggage":{"plan":"gold tier","tenant":"acme"},"trace_state":{"rojo":"00f067aa0ba902b7"}}`, *got.Message)
+
+	// the fields are opt-in
+	got, _, err = logToCWLog(nil, log, &Config{})
+	require.NoError(t, err)
+	assert.NotContains(t, *got.Message, `"trace_state"`)
+	assert.NotContains(t, *got.Message, `"baggage":{`)
+}
+
+func TestLogToCWLogPropagatedContextMissing(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]pdata.AttributeValue
+		want  string
+	}{
+		{
+			name: "no attributes",
+			want: `{}`,
+		},
+		{
+			name:  "trace state only",
+			attrs: map[string]pdata.AttributeValue{"tracestate": pdata.NewAttributeValueString("congo=t61rcWkgMzE")},
+			want:  `{"attributes":{"tracestate":"congo=t61rcWkgMzE"},"trace_state":{"congo":"t61rcWkgMzE"}}`,
+		},
+		{
+			name:  "empty baggage",
+			attrs: map[string]pdata.AttributeValue{"baggage": pdata.NewAttributeValueString("")},
+			want:  `{"attributes":{"baggage":""}}`,
+		},
+		{
+			name:  "trace state of another type",
+			attrs: map[string]pdata.AttributeValue{"tracestate": pdata.NewAttributeValueInt(1)},
+			want:  `{"attributes":{"tracestate":1}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := pdata.NewLogRecord()
+			pdata.NewAttributeMapFromMap(tt.attrs).CopyTo(log.Attributes())
+			got, _, err := logToCWLog(nil, log, &Config{PropagatedContext: true})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Message)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"context"
+	"time"
+)
+
+// reapIdlePushers flushes and drops the pushers of the log streams idle for the timeout until the exporter shuts
+// down, so that the pushers of short-lived log streams resolved from the attributes do not accumulate.
+func (e *exporter) reapIdlePushers(timeout time.Duration) {
+	defer e.background.Done()
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			e.pusherLock.Lock()
+			idle := e.takeIdlePushers(timeout)
+			e.pusherLock.Unlock()
+			if len(idle) == 0 {
+				continue
+			}
+			// the error is logged by flush
+			_ = e.flush(context.Background(), idle)
+		}
+	}
+}
+
+// takeIdlePushers removes the pushers of the log streams that received no event for the timeout, with their
+// shards, and returns them to flush the events they may still hold. The default pusher is kept. An export that
+// added events to a pusher before its removal flushes it itself, or with coalescing, the events are flushed with
+// the idle pusher; the next events of the log stream create a new pusher. It must be called with the pusher lock
+// held.
+func (e *exporter) takeIdlePushers(timeout time.Duration) []keyedPusher {
+	var idle []keyedPusher
+	for key, lastUsed := range e.lastUsed {
+		if now().Sub(lastUsed) < timeout {
+			continue
+		}
+		idle = append(idle, e.takePusher(key)...)
+	}
+	return idle
+}
@@ -0,0 +1,125 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+// newStreamLogs returns a record of the log stream resolved from its stream attribute
+func newStreamLogs(stream string) pdata.Logs {
+	ld := pdata.NewLogs()
+	record := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty()
+	record.SetName("test")
+	record.Attributes().UpsertString("stream", stream)
+	return ld
+}
+
+func TestTakeIdlePushers(t *testing.T) {
+	clock := time.Unix(1609763415, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return clock }
+
+	cfg := &Config{
+		LogGroupName:          "group",
+		LogStreamName:         "{attributes.stream}",
+		LogStreamNameFallback: "default",
+		Coalescing:            CoalescingSettings{Window: time.Hour},
+		PusherIdleTimeout:     time.Minute,
+	}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	pushers := map[string]*countingPusher{}
+	exp := &exporter{
+		Config: cfg,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			pusher := &countingPusher{}
+			pushers[streamName] = pusher
+			return pusher
+		},
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("pod-a")))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("pod-b")))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("default")))
+	clock = clock.Add(30 * time.Second)
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("pod-b")))
+
+	// only the log stream without an event for the timeout is idle, the default pusher is kept
+	clock = clock.Add(30 * time.Second)
+	exp.pusherLock.Lock()
+	idle := exp.takeIdlePushers(cfg.PusherIdleTimeout)
+	exp.pusherLock.Unlock()
+	require.Len(t, idle, 1)
+	assert.Equal(t, "pod-a", idle[0].key.logStreamName)
+	assert.Len(t, exp.pushers, 1)
+	require.NoError(t, exp.flush(context.Background(), idle))
+	assert.Equal(t, 1, pushers["pod-a"].pushed)
+
+	// the next event of the log stream creates a new pusher
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("pod-a")))
+	assert.Equal(t, 0, pushers["pod-a"].pushed)
+	assert.Equal(t, 1, pushers["pod-a"].pending)
+	assert.Len(t, exp.pushers, 2)
+}
+
+func TestReapIdlePushers(t *testing.T) {
+	clock := time.Unix(1609763415, 0)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return clock }
+
+	cfg := &Config{
+		LogGroupName:          "group",
+		LogStreamName:         "{attributes.stream}",
+		LogStreamNameFallback: "default",
+		Coalescing:            CoalescingSettings{Window: time.Hour},
+		PusherIdleTimeout:     time.Millisecond,
+	}
+	names, err := newLogNames(cfg)
+	require.NoError(t, err)
+	pusher := &countingPusher{}
+	exp := &exporter{
+		Config:    cfg,
+		logger:    zap.NewNop(),
+		names:     names,
+		pusher:    &countingPusher{},
+		newPusher: func(string, string, string) cwlogs.Pusher { return pusher },
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newStreamLogs("pod-a")))
+
+	// the clock is advanced past the timeout before the reaper starts
+	clock = clock.Add(time.Minute)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	assert.Eventually(t, func() bool {
+		pusher.Lock()
+		defer pusher.Unlock()
+		return pusher.pushed == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, exp.Shutdown(context.Background()))
+	assert.Empty(t, exp.pushers)
+	assert.Empty(t, exp.lastUsed)
+}
@@ -0,0 +1,49 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+// defaultRedactionMask replaces the values of the RedactAttributes when RedactionMask is empty
+const defaultRedactionMask = "[REDACTED]"
+
+// redactionMask is the string replacing the values of the redacted attributes
+func (config *Config) redactionMask() string {
+	if config.RedactionMask == "" {
+		return defaultRedactionMask
+	}
+	return config.RedactionMask
+}
+
+// redactAttributes replaces the values of the resource and record attributes of the body with the keys with the
+// mask. The resource attributes are shared by the records of the resource, they are copied before being redacted.
+func redactAttributes(body *cwLogBody, keys []string, mask string) {
+	copied := false
+	for _, key := range keys {
+		if _, ok := body.Attributes[key]; ok {
+			body.Attributes[key] = mask
+		}
+		if _, ok := body.Resource[key]; !ok {
+			continue
+		}
+		if !copied {
+			resource := make(map[string]interface{}, len(body.Resource))
+			for k, v := range body.Resource {
+				resource[k] = v
+			}
+			body.Resource = resource
+			copied = true
+		}
+		body.Resource[key] = mask
+	}
+}
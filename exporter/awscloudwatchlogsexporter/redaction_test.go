@@ -0,0 +1,85 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestLogToCWLogRedactAttributes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		want   string
+	}{
+		{
+			name:   "default mask",
+			config: &Config{RedactAttributes: []string{"user.email", "host.ip", "missing"}},
+			want:   `{"name":"test","body":"hello world","attributes":{"path":"/login","user.email":"[REDACTED]"},"resource":{"host.ip":"[REDACTED]","service.name":"api"}}`,
+		},
+		{
+			name:   "custom mask",
+			config: &Config{RedactAttributes: []string{"user.email"}, RedactionMask: "***"},
+			want:   `{"name":"test","body":"hello world","attributes":{"path":"/login","user.email":"***"},"resource":{"host.ip":"10.0.0.1","service.name":"api"}}`,
+		},
+		{
+			name:   "disabled",
+			config: &Config{},
+			want:   `{"name":"test","body":"hello world","attributes":{"path":"/login","user.email":"jane@example.com"},"resource":{"host.ip":"10.0.0.1","service.name":"api"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := map[string]interface{}{"service.name": "api", "host.ip": "10.0.0.1"}
+			log := pdata.NewLogRecord()
+			log.SetName("test")
+			log.Body().SetStringVal("hello world")
+			log.Attributes().InsertString("user.email", "jane@example.com")
+			log.Attributes().InsertString("path", "/login")
+			got, _, err := logToCWLog(resource, log, tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got.Message)
+			// the resource attributes shared by the records of the resource are left as is
+			assert.Equal(t, "10.0.0.1", resource["host.ip"])
+		})
+	}
+}
+
+func TestLogToCWLogBodyTransform(t *testing.T) {
+	email := regexp.MustCompile(`[^\s@]+@[^\s@]+`)
+	config := &Config{
+		RedactAttributes: []string{"user.id"},
+		bodyTransform: func(body *cwLogBody) {
+			if message, ok := body.Body.(string); ok {
+				body.Body = email.ReplaceAllString(message, "[email]")
+			}
+			// the transform runs after the redaction
+			assert.Equal(t, defaultRedactionMask, body.Attributes["user.id"])
+			delete(body.Attributes, "user.id")
+		},
+	}
+	log := pdata.NewLogRecord()
+	log.SetName("test")
+	log.Body().SetStringVal("password reset for jane@example.com")
+	log.Attributes().InsertString("user.id", "42")
+	got, _, err := logToCWLog(nil, log, config)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"test","body":"password reset for [email]"}`, *got.Message)
+}
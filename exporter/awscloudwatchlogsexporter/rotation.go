@@ -0,0 +1,78 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import "time"
+
+const (
+	// LogStreamRotationNone sends the events to the log stream named by the configuration
+	LogStreamRotationNone = "none"
+	// LogStreamRotationDaily sends the events to a log stream per UTC day, e.g. app-2024-06-01
+	LogStreamRotationDaily = "daily"
+	// LogStreamRotationHourly sends the events to a log stream per UTC hour, e.g. app-2024-06-01-15
+	LogStreamRotationHourly = "hourly"
+)
+
+// streamPeriod returns the log stream of the period of the rotation the timestamp, in milliseconds, falls in, named
+// after the log stream with the UTC date of the period, and the end of the period. The log stream is returned as is,
+// with a zero end, when the log streams are not rotated.
+func streamPeriod(streamName, rotation string, timestamp int64) (string, time.Time) {
+	var period time.Duration
+	var layout string
+	switch rotation {
+	case LogStreamRotationDaily:
+		period, layout = 24*time.Hour, "2006-01-02"
+	case LogStreamRotationHourly:
+		period, layout = time.Hour, "2006-01-02-15"
+	default:
+		return streamName, time.Time{}
+	}
+	// the periods of UTC are aligned on the Unix epoch
+	start := epochToTime(timestamp).UTC().Truncate(period)
+	return streamName + "-" + start.Format(layout), start.Add(period)
+}
+
+// retireEndedPeriods retires the pushers of the log streams of the periods that ended, once they were flushed
+// successfully, as their periods do not get events anymore but for late records, which create the pusher again. They
+// are flushed once more by the next flush, for the events added since this one. It must be called with the pusher
+// lock held.
+func (e *exporter) retireEndedPeriods(pushers []keyedPusher, errs []error) {
+	for i, p := range pushers {
+		end, ok := e.periodEnds[p.key]
+		if !ok || errs[i] != nil || now().Before(end) || e.pushers[p.key] != p.pusher {
+			continue
+		}
+		e.retired = append(e.retired, p)
+		for _, shard := range e.shards[p.key] {
+			e.retired = append(e.retired, keyedPusher{p.key, shard})
+		}
+		delete(e.pushers, p.key)
+		delete(e.shards, p.key)
+		delete(e.nextShard, p.key)
+		delete(e.periodEnds, p.key)
+	}
+}
+
+// pusherPeriod records the end of the period of the log stream of a pusher, to retire the pusher after it. It must
+// be called with the pusher lock held.
+func (e *exporter) pusherPeriod(key pusherKey, end time.Time) {
+	if end.IsZero() {
+		return
+	}
+	if e.periodEnds == nil {
+		e.periodEnds = map[pusherKey]time.Time{}
+	}
+	e.periodEnds[key] = end
+}
@@ -0,0 +1,101 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+func TestStreamPeriod(t *testing.T) {
+	timestamp := time.Date(2024, 6, 1, 15, 30, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	tests := []struct {
+		rotation string
+		name     string
+		end      time.Time
+	}{
+		{rotation: "", name: "app"},
+		{rotation: LogStreamRotationNone, name: "app"},
+		{rotation: LogStreamRotationDaily, name: "app-2024-06-01", end: time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{rotation: LogStreamRotationHourly, name: "app-2024-06-01-15", end: time.Date(2024, 6, 1, 16, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rotation, func(t *testing.T) {
+			name, end := streamPeriod("app", tt.rotation, timestamp)
+			assert.Equal(t, tt.name, name)
+			assert.True(t, tt.end.Equal(end), "end %v, expected %v", end, tt.end)
+		})
+	}
+}
+
+func TestConsumeLogsLogStreamRotation(t *testing.T) {
+	midnight := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return midnight.Add(time.Minute) }
+
+	config := &Config{LogGroupName: "group", LogStreamName: "app", LogStreamRotation: LogStreamRotationDaily}
+	names, err := newLogNames(config)
+	require.NoError(t, err)
+	pushers := map[string]*countingPusher{}
+	var created []string
+	exp := &exporter{
+		Config: config,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			created = append(created, streamName)
+			pusher := &countingPusher{}
+			pushers[streamName] = pusher
+			return pusher
+		},
+	}
+	newLogs := func(timestamps ...time.Time) pdata.Logs {
+		ld := pdata.NewLogs()
+		logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+		for _, timestamp := range timestamps {
+			log := logs.AppendEmpty()
+			log.SetName("test")
+			log.SetTimestamp(pdata.NewTimestampFromTime(timestamp))
+		}
+		return ld
+	}
+
+	// the batch crossing midnight is split over the log streams of both days
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newLogs(midnight.Add(-time.Second), midnight.Add(time.Second))))
+	assert.Equal(t, []string{"app-2024-06-01", "app-2024-06-02"}, created)
+	assert.Equal(t, 1, pushers["app-2024-06-01"].pushed)
+	assert.Equal(t, 1, pushers["app-2024-06-02"].pushed)
+
+	// the pusher of the day that ended is retired once flushed, and flushed once more by the next export
+	require.Len(t, exp.pushers, 1)
+	require.Len(t, exp.retired, 1)
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newLogs(midnight.Add(time.Second))))
+	assert.Empty(t, exp.retired)
+	assert.Equal(t, 2, pushers["app-2024-06-02"].pushed)
+
+	// a late event creates the pusher of its day again
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newLogs(midnight.Add(-time.Hour))))
+	assert.Equal(t, []string{"app-2024-06-01", "app-2024-06-02", "app-2024-06-01"}, created)
+	assert.Equal(t, 1, pushers["app-2024-06-01"].pushed)
+}
@@ -0,0 +1,42 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import "go.opentelemetry.io/collector/model/pdata"
+
+// levelField is the top-level field holding the severity level of the record
+const levelField = "level"
+
+// severityLevel returns the conventional name of the level of the severity number, following the ranges of the
+// OpenTelemetry log data model, e.g. WARN for WARN to WARN4. It is empty when the severity is unspecified or
+// unknown.
+func severityLevel(severity pdata.SeverityNumber) string {
+	switch {
+	case severity < pdata.SeverityNumberTRACE || severity > pdata.SeverityNumberFATAL4:
+		return ""
+	case severity < pdata.SeverityNumberDEBUG:
+		return "TRACE"
+	case severity < pdata.SeverityNumberINFO:
+		return "DEBUG"
+	case severity < pdata.SeverityNumberWARN:
+		return "INFO"
+	case severity < pdata.SeverityNumberERROR:
+		return "WARN"
+	case severity < pdata.SeverityNumberFATAL:
+		return "ERROR"
+	default:
+		return "FATAL"
+	}
+}
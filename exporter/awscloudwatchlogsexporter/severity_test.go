@@ -0,0 +1,47 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestSeverityLevel(t *testing.T) {
+	tests := []struct {
+		severity pdata.SeverityNumber
+		want     string
+	}{
+		{pdata.SeverityNumberUNDEFINED, ""},
+		{pdata.SeverityNumberTRACE, "TRACE"},
+		{pdata.SeverityNumberTRACE4, "TRACE"},
+		{pdata.SeverityNumberDEBUG, "DEBUG"},
+		{pdata.SeverityNumberDEBUG4, "DEBUG"},
+		{pdata.SeverityNumberINFO, "INFO"},
+		{pdata.SeverityNumberINFO2, "INFO"},
+		{pdata.SeverityNumberWARN, "WARN"},
+		{pdata.SeverityNumberWARN4, "WARN"},
+		{pdata.SeverityNumberERROR, "ERROR"},
+		{pdata.SeverityNumberERROR3, "ERROR"},
+		{pdata.SeverityNumberFATAL, "FATAL"},
+		{pdata.SeverityNumberFATAL4, "FATAL"},
+		{pdata.SeverityNumber(25), ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, severityLevel(tt.severity), "severity %d", tt.severity)
+	}
+}
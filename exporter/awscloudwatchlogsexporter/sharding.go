@@ -0,0 +1,77 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+// streamQuotaMessage is found in the message of the throttling errors returned when a log stream exceeds its
+// ingestion quota, e.g. "Rate exceeded for logStreamName pod-a", unlike the throttling of the account
+const streamQuotaMessage = "for logStreamName"
+
+// isStreamQuotaError tells whether CloudWatch Logs throttled a log stream for exceeding its own ingestion quota
+func isStreamQuotaError(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == errCodeThrottlingException &&
+		strings.Contains(awsErr.Message(), streamQuotaMessage)
+}
+
+// shardStreamName is the name of an additional log stream of a sharded log stream, the first shard keeping the
+// name of the log stream
+func shardStreamName(streamName string, shard int) string {
+	return streamName + "-shard-" + strconv.Itoa(shard)
+}
+
+// shardFor returns the pusher of the next shard of the log stream, so that its events are spread round robin over
+// the shards. The given pusher is the one of the first shard. It must be called with the pusher lock held.
+func (e *exporter) shardFor(key pusherKey, pusher cwlogs.Pusher) cwlogs.Pusher {
+	shards := e.shards[key]
+	if len(shards) == 0 {
+		return pusher
+	}
+	shard := e.nextShard[key] % (len(shards) + 1)
+	e.nextShard[key] = shard + 1
+	if shard == 0 {
+		return pusher
+	}
+	return shards[shard-1]
+}
+
+// addShard adds a log stream to the shards of the log stream, unless it has the maximum number of shards already.
+// It returns whether a shard was added. It must be called with the pusher lock held.
+func (e *exporter) addShard(key pusherKey) bool {
+	shards := e.shards[key]
+	if len(shards)+1 >= e.Config.StreamSharding.MaxShards {
+		return false
+	}
+	if e.shards == nil {
+		e.shards = map[pusherKey][]cwlogs.Pusher{}
+		e.nextShard = map[pusherKey]int{}
+	}
+	streamName := shardStreamName(e.rotatedStreamName(key.logStreamName), len(shards)+1)
+	e.logger.Info("Log stream reached its ingestion quota, adding a log stream",
+		zap.String("LogGroupName", key.logGroupName),
+		zap.String("LogStreamName", streamName))
+	e.shards[key] = append(shards, e.newPusher(key.region, key.logGroupName, streamName))
+	return true
+}
@@ -0,0 +1,130 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+func TestIsStreamQuotaError(t *testing.T) {
+	assert.True(t, isStreamQuotaError(awserr.New(errCodeThrottlingException, "Rate exceeded for logStreamName pod-a", nil)))
+	assert.True(t, isStreamQuotaError(fmt.Errorf("push: %w", awserr.New(errCodeThrottlingException, "Rate exceeded for logStreamName pod-a", nil))))
+	assert.False(t, isStreamQuotaError(awserr.New(errCodeThrottlingException, "Rate exceeded", nil)))
+	assert.False(t, isStreamQuotaError(awserr.New("InvalidParameterException", "for logStreamName pod-a", nil)))
+	assert.False(t, isStreamQuotaError(errors.New("Rate exceeded for logStreamName pod-a")))
+}
+
+// quotaPusher counts the events it pushes, and fails the first flushes with the throttling of its log stream
+type quotaPusher struct {
+	countingPusher
+	throttles int
+	flushes   int
+}
+
+func (p *quotaPusher) ForceFlush(ctx context.Context) error {
+	p.flushes++
+	if p.flushes <= p.throttles {
+		p.pending = 0
+		return awserr.New(errCodeThrottlingException, "Rate exceeded for logStreamName pod-a", nil)
+	}
+	return p.countingPusher.ForceFlush(ctx)
+}
+
+func newRecordsLogs(n int) pdata.Logs {
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	for i := 0; i < n; i++ {
+		logs.AppendEmpty().SetName("test")
+	}
+	return ld
+}
+
+func TestConsumeLogsStreamQuotaSharding(t *testing.T) {
+	ctx := context.Background()
+	pusher := &quotaPusher{throttles: 3}
+	shards := map[string]*quotaPusher{}
+	exp := &exporter{
+		Config: &Config{
+			LogStreamName:            "pod-a",
+			StreamSharding:           StreamShardingSettings{MaxShards: 2},
+			RotateStreamOnThrottling: true,
+		},
+		logger: zap.NewNop(),
+		pusher: pusher,
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			shard := &quotaPusher{}
+			shards[streamName] = shard
+			return shard
+		},
+	}
+
+	// the stream quota throttling adds a log stream instead of rotating
+	err := exp.ConsumeLogs(ctx, newRecordsLogs(4))
+	assert.True(t, isStreamQuotaError(err))
+	require.Len(t, shards, 1)
+	require.Contains(t, shards, "pod-a-shard-1")
+	assert.Equal(t, 0, exp.streamSuffix)
+
+	// the events are spread over both log streams, the throttled one is not sharded past max_shards
+	err = exp.ConsumeLogs(ctx, newRecordsLogs(4))
+	assert.True(t, isStreamQuotaError(err))
+	assert.Equal(t, 2, shards["pod-a-shard-1"].pushed)
+	assert.Len(t, shards, 3, "the log streams are rotated once they cannot be sharded")
+	assert.Equal(t, 1, exp.streamSuffix)
+	assert.Contains(t, shards, "pod-a-1")
+	assert.Contains(t, shards, "pod-a-1-shard-1")
+}
+
+func TestConsumeLogsAccountThrottlingNotSharded(t *testing.T) {
+	pusher := &failingPusher{err: awserr.New(errCodeThrottlingException, "Rate exceeded", nil)}
+	exp := &exporter{
+		Config: &Config{LogStreamName: "pod-a", StreamSharding: StreamShardingSettings{MaxShards: 4}},
+		logger: zap.NewNop(),
+		pusher: pusher,
+		newPusher: func(string, string, string) cwlogs.Pusher {
+			t.Fatal("the log stream must not be sharded when the account is throttled")
+			return nil
+		},
+	}
+	assert.True(t, isThrottlingError(exp.ConsumeLogs(context.Background(), newRecordsLogs(1))))
+	assert.Empty(t, exp.shards)
+}
+
+func TestConsumeLogsStreamQuotaWithoutSharding(t *testing.T) {
+	pusher := &quotaPusher{throttles: 1}
+	exp := &exporter{
+		Config: &Config{LogStreamName: "pod-a"},
+		logger: zap.NewNop(),
+		pusher: pusher,
+		newPusher: func(string, string, string) cwlogs.Pusher {
+			t.Fatal("the log stream must not be sharded")
+			return nil
+		},
+	}
+	assert.Error(t, exp.ConsumeLogs(context.Background(), newRecordsLogs(1)))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), newRecordsLogs(1)))
+	assert.Equal(t, 1, pusher.pushed)
+}
@@ -0,0 +1,219 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	// resourceTokenPrefix starts the tokens replaced by a resource attribute, e.g. {resource.service.name}
+	resourceTokenPrefix = "resource."
+	// attributesTokenPrefix starts the tokens replaced by a log record attribute, e.g. {attributes.stream}
+	attributesTokenPrefix = "attributes."
+)
+
+const (
+	// maxLogGroupNameLength is the longest log group name CloudWatch Logs accepts
+	maxLogGroupNameLength = 512
+	// maxLogStreamNameLength is the longest log stream name CloudWatch Logs accepts
+	maxLogStreamNameLength = 512
+)
+
+// nameTokenPattern matches the tokens of a templated log group or log stream name
+var nameTokenPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// isTemplated tells whether the name has tokens resolved from the attributes of the records
+func isTemplated(name string) bool {
+	return nameTokenPattern.MatchString(name)
+}
+
+// templatePart is a literal part of a name template, or a token replaced by an attribute
+type templatePart struct {
+	literal string
+	// prefix is the prefix of the token, telling where its attribute is found, empty for literals
+	prefix string
+	attr   string
+}
+
+// nameTemplate is a templated log group or log stream name, parsed once so that the names of the records are
+// resolved without matching the tokens again
+type nameTemplate struct {
+	parts    []templatePart
+	fallback string
+}
+
+// parseNameTemplate parses the tokens of the name, which must start with one of the prefixes. The fallback is
+// the name resolved when an attribute is missing. It returns nil when the name has no tokens.
+func parseNameTemplate(name, fallback string, prefixes ...string) (*nameTemplate, error) {
+	matches := nameTokenPattern.FindAllStringSubmatchIndex(name, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	t := &nameTemplate{fallback: fallback}
+	last := 0
+	for _, match := range matches {
+		token, inner := name[match[0]:match[1]], name[match[2]:match[3]]
+		part := templatePart{}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(inner, prefix) && len(inner) > len(prefix) {
+				part = templatePart{prefix: prefix, attr: inner[len(prefix):]}
+				break
+			}
+		}
+		if part.prefix == "" {
+			forms := make([]string, len(prefixes))
+			for i, prefix := range prefixes {
+				forms[i] = "{" + prefix + "<attribute>}"
+			}
+			return nil, fmt.Errorf("unknown token %q, tokens must be %s", token, strings.Join(forms, " or "))
+		}
+		if match[0] > last {
+			t.parts = append(t.parts, templatePart{literal: name[last:match[0]]})
+		}
+		t.parts = append(t.parts, part)
+		last = match[1]
+	}
+	if last < len(name) {
+		t.parts = append(t.parts, templatePart{literal: name[last:]})
+	}
+	return t, nil
+}
+
+// resolve replaces the tokens with the resource or record attributes they refer to. The fallback is returned, with
+// true, when one of the attributes is missing or empty.
+func (t *nameTemplate) resolve(resource, record pdata.AttributeMap) (string, bool) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.prefix == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		attrs := resource
+		if part.prefix == attributesTokenPrefix {
+			attrs = record
+		}
+		value, ok := attrs.Get(part.attr)
+		if !ok || value.AsString() == "" {
+			return t.fallback, true
+		}
+		b.WriteString(value.AsString())
+	}
+	return b.String(), false
+}
+
+// logNames holds the parsed log group and log stream names and region of an exporter, nil for the names without
+// tokens
+type logNames struct {
+	logGroup  *nameTemplate
+	logStream *nameTemplate
+	region    *nameTemplate
+}
+
+func newLogNames(config *Config) (logNames, error) {
+	logGroup, err := parseNameTemplate(config.LogGroupName, config.LogGroupNameFallback, resourceTokenPrefix)
+	if err != nil {
+		return logNames{}, err
+	}
+	logStream, err := parseNameTemplate(config.LogStreamName, config.LogStreamNameFallback, resourceTokenPrefix, attributesTokenPrefix)
+	if err != nil {
+		return logNames{}, err
+	}
+	region, err := parseNameTemplate(config.Region, config.RegionFallback, resourceTokenPrefix)
+	if err != nil {
+		return logNames{}, err
+	}
+	return logNames{logGroup: logGroup, logStream: logStream, region: region}, nil
+}
+
+// logGroupName resolves the log group of the records of the resource, and tells whether it is the fallback
+func (n logNames) logGroupName(config *Config, resource pdata.Resource) (string, bool) {
+	if n.logGroup == nil {
+		return config.LogGroupName, false
+	}
+	return n.logGroup.resolve(resource.Attributes(), pdata.NewAttributeMap())
+}
+
+// logStreamName resolves the log stream of the record, and tells whether it is the fallback. It fails when the
+// resolved name is not a valid log stream name.
+func (n logNames) logStreamName(config *Config, resource pdata.Resource, log pdata.LogRecord) (string, bool, error) {
+	if n.logStream == nil {
+		return config.LogStreamName, false, nil
+	}
+	name, fallback := n.logStream.resolve(resource.Attributes(), log.Attributes())
+	if err := validateLogStreamName(name); err != nil {
+		return "", false, fmt.Errorf("invalid log stream name %q resolved from %q: %w", name, config.LogStreamName, err)
+	}
+	return name, fallback, nil
+}
+
+// regionName resolves the region of the records of the resource, empty for the region of the session of the exporter:
+// the region without tokens, or the region resolved when the fallback is empty. It fails when the resolved name is
+// not a region.
+func (n logNames) regionName(resource pdata.Resource) (string, error) {
+	if n.region == nil {
+		return "", nil
+	}
+	name, _ := n.region.resolve(resource.Attributes(), pdata.NewAttributeMap())
+	if name != "" && !isRegion(name) {
+		return "", fmt.Errorf("invalid region %q", name)
+	}
+	return name, nil
+}
+
+// validateLogStreamName checks the restrictions CloudWatch Logs puts on log stream names
+func validateLogStreamName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("log stream names must not be empty")
+	case len(name) > maxLogStreamNameLength:
+		return fmt.Errorf("log stream names must not be longer than %d characters", maxLogStreamNameLength)
+	}
+	if i := strings.IndexAny(name, ":*"); i >= 0 {
+		return fmt.Errorf("log stream names must not contain %q", name[i])
+	}
+	return nil
+}
+
+// validateLogGroupName checks the restrictions CloudWatch Logs puts on log group names
+func validateLogGroupName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("log group names must not be empty")
+	case len(name) > maxLogGroupNameLength:
+		return fmt.Errorf("log group names must not be longer than %d characters", maxLogGroupNameLength)
+	}
+	for _, r := range name {
+		if !isLogGroupNameRune(r) {
+			return fmt.Errorf("log group names must not contain %q, only letters, digits, '.', '-', '_', '/' and '#'", r)
+		}
+	}
+	return nil
+}
+
+func isLogGroupNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(".-_/#", r)
+}
+
+// literalName is the name without its tokens, which must follow the naming rules on their own, as the attribute
+// values only add characters to them. It is the name itself when it has no tokens.
+func literalName(name string) string {
+	return nameTokenPattern.ReplaceAllString(name, "")
+}
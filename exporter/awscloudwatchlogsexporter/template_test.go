@@ -0,0 +1,376 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/awsutil"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+)
+
+const testLogGroupTemplate = "/otel/{resource.service.namespace}/{resource.service.name}"
+
+func TestParseNameTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		wantErr  string
+	}{
+		{name: testLogGroupTemplate, prefixes: []string{resourceTokenPrefix}},
+		{name: "/otel/{resource.k8s.namespace.name}-logs", prefixes: []string{resourceTokenPrefix}},
+		{name: "{resource.k8s.pod.name}/{attributes.stream}", prefixes: []string{resourceTokenPrefix, attributesTokenPrefix}},
+		{
+			name:     "/otel/{attributes.service.name}",
+			prefixes: []string{resourceTokenPrefix},
+			wantErr:  `unknown token "{attributes.service.name}", tokens must be {resource.<attribute>}`,
+		},
+		{
+			name:     "/otel/{service.name}",
+			prefixes: []string{resourceTokenPrefix, attributesTokenPrefix},
+			wantErr:  `unknown token "{service.name}", tokens must be {resource.<attribute>} or {attributes.<attribute>}`,
+		},
+		{
+			name:     "/otel/{resource.}",
+			prefixes: []string{resourceTokenPrefix},
+			wantErr:  `unknown token "{resource.}", tokens must be {resource.<attribute>}`,
+		},
+		{
+			name:     "/otel/{}",
+			prefixes: []string{resourceTokenPrefix},
+			wantErr:  `unknown token "{}", tokens must be {resource.<attribute>}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := parseNameTemplate(tt.name, "", tt.prefixes...)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.NotNil(t, template)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	template, err := parseNameTemplate("static", "", resourceTokenPrefix)
+	require.NoError(t, err)
+	assert.Nil(t, template)
+}
+
+func TestNameTemplateResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		attrs  map[string]pdata.AttributeValue
+		record map[string]pdata.AttributeValue
+		want   string
+	}{
+		{
+			name: "resolved",
+			attrs: map[string]pdata.AttributeValue{
+				"service.namespace": pdata.NewAttributeValueString("shop"),
+				"service.name":      pdata.NewAttributeValueString("checkout"),
+			},
+			want: "/otel/shop/checkout",
+		},
+		{
+			name:  "missing attribute",
+			attrs: map[string]pdata.AttributeValue{"service.name": pdata.NewAttributeValueString("checkout")},
+			want:  "/otel/fallback",
+		},
+		{
+			name: "empty attribute",
+			attrs: map[string]pdata.AttributeValue{
+				"service.namespace": pdata.NewAttributeValueString(""),
+				"service.name":      pdata.NewAttributeValueString("checkout"),
+			},
+			want: "/otel/fallback",
+		},
+		{
+			name: "non string attribute",
+			attrs: map[string]pdata.AttributeValue{
+				"service.namespace": pdata.NewAttributeValueInt(42),
+				"service.name":      pdata.NewAttributeValueBool(true),
+			},
+			want: "/otel/42/true",
+		},
+		{
+			name: "record attribute",
+			record: map[string]pdata.AttributeValue{
+				"service.namespace": pdata.NewAttributeValueString("shop"),
+				"service.name":      pdata.NewAttributeValueString("checkout"),
+			},
+			want: "/otel/fallback",
+		},
+	}
+	template, err := parseNameTemplate(testLogGroupTemplate, "/otel/fallback", resourceTokenPrefix)
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fallback := template.resolve(pdata.NewAttributeMapFromMap(tt.attrs), pdata.NewAttributeMapFromMap(tt.record))
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want == "/otel/fallback", fallback)
+		})
+	}
+}
+
+func TestValidateLogStreamName(t *testing.T) {
+	assert.NoError(t, validateLogStreamName("pod-a/stdout"))
+	assert.EqualError(t, validateLogStreamName(""), "log stream names must not be empty")
+	assert.EqualError(t, validateLogStreamName(strings.Repeat("a", 513)), "log stream names must not be longer than 512 characters")
+	assert.EqualError(t, validateLogStreamName("pod:a"), "log stream names must not contain ':'")
+	assert.EqualError(t, validateLogStreamName("pod-*"), "log stream names must not contain '*'")
+}
+
+func TestValidateLogGroupName(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{name: "/aws/eks/cluster-1/app_logs.v2#1"},
+		{name: strings.Repeat("a", 512)},
+		{name: "", err: "log group names must not be empty"},
+		{name: strings.Repeat("a", 513), err: "log group names must not be longer than 512 characters"},
+		{name: "/aws/app:1", err: "log group names must not contain ':', only letters, digits, '.', '-', '_', '/' and '#'"},
+		{name: "/aws/my app", err: "log group names must not contain ' ', only letters, digits, '.', '-', '_', '/' and '#'"},
+		{name: "/aws/café", err: "log group names must not contain 'é', only letters, digits, '.', '-', '_', '/' and '#'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLogGroupName(tt.name)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLogsToCWLogsTemplatedLogStream(t *testing.T) {
+	config := &Config{
+		LogGroupName:          "testGroup",
+		LogStreamName:         "{resource.k8s.pod.name}/{attributes.stream}",
+		LogStreamNameFallback: "unknown",
+	}
+	names, err := newLogNames(config)
+	require.NoError(t, err)
+
+	ld := pdata.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("k8s.pod.name", "pod-a")
+	logs := rl.InstrumentationLibraryLogs().AppendEmpty().Logs()
+	for _, stream := range []string{"stdout", "stderr", "", "std*"} {
+		log := logs.AppendEmpty()
+		if stream != "" {
+			log.Attributes().InsertString("stream", stream)
+		}
+	}
+
+	events, dropped, _ := logsToCWLogs(zap.NewNop(), ld, config, names, 0)
+	// the record resolving to an invalid log stream name is dropped
+	assert.Equal(t, 1, dropped)
+	require.Len(t, events, 3)
+	assert.Equal(t, "pod-a/stdout", events[0].logStreamName)
+	assert.Equal(t, "pod-a/stderr", events[1].logStreamName)
+	assert.Equal(t, "unknown", events[2].logStreamName)
+	for _, event := range events {
+		assert.Equal(t, "testGroup", event.logGroupName)
+	}
+}
+
+func TestConsumeLogsTemplatedLogGroup(t *testing.T) {
+	ld := pdata.NewLogs()
+	for _, service := range []string{"checkout", "cart", "checkout", ""} {
+		rl := ld.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().InsertString("service.namespace", "shop")
+		if service != "" {
+			rl.Resource().Attributes().InsertString("service.name", service)
+		}
+		rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+	}
+
+	config := &Config{
+		LogGroupName:         testLogGroupTemplate,
+		LogGroupNameFallback: "/otel/unknown",
+		LogStreamName:        "testStream",
+	}
+	names, err := newLogNames(config)
+	require.NoError(t, err)
+	fallback := &countingPusher{}
+	pushers := map[string]*countingPusher{}
+	exp := &exporter{
+		Config: config,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: fallback,
+		newPusher: func(_, logGroupName, streamName string) cwlogs.Pusher {
+			assert.Equal(t, "testStream", streamName)
+			pusher := &countingPusher{}
+			pushers[logGroupName] = pusher
+			return pusher
+		},
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+
+	// a pusher is created once for every resolved log group, the records missing an attribute use the fallback
+	require.Len(t, pushers, 2)
+	assert.Equal(t, 4, pushers["/otel/shop/checkout"].pushed)
+	assert.Equal(t, 2, pushers["/otel/shop/cart"].pushed)
+	assert.Equal(t, 2, fallback.pushed)
+}
+
+func TestConsumeLogsTemplatedLogStreamRotation(t *testing.T) {
+	ld := pdata.NewLogs()
+	logs := ld.ResourceLogs().AppendEmpty().InstrumentationLibraryLogs().AppendEmpty().Logs()
+	logs.AppendEmpty().Attributes().InsertString("stream", "stdout")
+	logs.AppendEmpty().Attributes().InsertString("stream", "stderr")
+
+	config := &Config{
+		LogGroupName:             "testGroup",
+		LogStreamName:            "pod-a-{attributes.stream}",
+		LogStreamNameFallback:    "pod-a",
+		RotateStreamOnThrottling: true,
+	}
+	names, err := newLogNames(config)
+	require.NoError(t, err)
+	var streams []string
+	exp := &exporter{
+		Config: config,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: &countingPusher{},
+		newPusher: func(_, _, streamName string) cwlogs.Pusher {
+			streams = append(streams, streamName)
+			if streamName == "pod-a-stderr" {
+				return &throttledPusher{throttles: 1}
+			}
+			return &countingPusher{}
+		},
+	}
+
+	// the throttled log stream rotates every log stream of the exporter, the fallback one first
+	err = exp.ConsumeLogs(context.Background(), ld)
+	assert.True(t, isThrottlingError(err))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	sort.Strings(streams[3:])
+	assert.Equal(t, []string{"pod-a-stdout", "pod-a-stderr", "pod-a-1", "pod-a-stderr-1", "pod-a-stdout-1"}, streams)
+	assert.Equal(t, 1, exp.streamSuffix)
+}
+
+func TestLogNamesRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		region   string
+		fallback string
+		attr     string
+		want     string
+		wantErr  string
+	}{
+		{name: "not templated", region: "us-east-1", attr: "eu-west-1", want: ""},
+		{name: "resolved", region: "{resource.cloud.region}", attr: "eu-west-1", want: "eu-west-1"},
+		{name: "gov region", region: "{resource.cloud.region}", attr: "us-gov-west-1", want: "us-gov-west-1"},
+		{name: "missing", region: "{resource.cloud.region}", fallback: "us-east-2", want: "us-east-2"},
+		{name: "missing without fallback", region: "{resource.cloud.region}", want: ""},
+		{name: "invalid", region: "{resource.cloud.region}", attr: "eu-west-1/../", wantErr: `invalid region "eu-west-1/../"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := newLogNames(&Config{
+				AWSSessionSettings: awsutil.AWSSessionSettings{Region: tt.region},
+				RegionFallback:     tt.fallback,
+			})
+			require.NoError(t, err)
+			resource := pdata.NewResource()
+			if tt.attr != "" {
+				resource.Attributes().InsertString("cloud.region", tt.attr)
+			}
+			got, err := names.regionName(resource)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConsumeLogsTemplatedRegion(t *testing.T) {
+	ld := pdata.NewLogs()
+	for _, region := range []string{"eu-west-1", "us-east-1", "", "eu-west-1", "not a region"} {
+		rl := ld.ResourceLogs().AppendEmpty()
+		if region != "" {
+			rl.Resource().Attributes().InsertString("cloud.region", region)
+		}
+		rl.InstrumentationLibraryLogs().AppendEmpty().Logs().AppendEmpty().SetName("test")
+	}
+
+	config := &Config{
+		AWSSessionSettings: awsutil.AWSSessionSettings{Region: "{resource.cloud.region}"},
+		RegionFallback:     "us-east-1",
+		LogGroupName:       "testGroup",
+		LogStreamName:      "testStream",
+	}
+	names, err := newLogNames(config)
+	require.NoError(t, err)
+	fallback := &countingPusher{}
+	pushers := map[string]*countingPusher{}
+	var clients []string
+	exp := &exporter{
+		Config: config,
+		logger: zap.NewNop(),
+		names:  names,
+		pusher: fallback,
+		region: "us-east-1",
+		newPusher: func(region, logGroupName, streamName string) cwlogs.Pusher {
+			assert.Equal(t, "testGroup", logGroupName)
+			assert.Equal(t, "testStream", streamName)
+			pusher := &countingPusher{}
+			pushers[region] = pusher
+			return pusher
+		},
+		newRegionClient: func(region string) (*sharedClient, error) {
+			clients = append(clients, region)
+			return &sharedClient{}, nil
+		},
+	}
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+	require.NoError(t, exp.ConsumeLogs(context.Background(), ld))
+
+	// the client and the pusher of a region are created once, the records of the region of the exporter and the
+	// records missing the attribute use its pusher, and the records with an invalid region are dropped
+	assert.Equal(t, []string{"eu-west-1"}, clients)
+	require.Len(t, pushers, 1)
+	assert.Equal(t, 4, pushers["eu-west-1"].pushed)
+	assert.Equal(t, 4, fallback.pushed)
+
+	// the export fails when the client of a region cannot be created
+	exp.newRegionClient = func(region string) (*sharedClient, error) {
+		return nil, errors.New("no session")
+	}
+	ld.ResourceLogs().At(0).Resource().Attributes().UpsertString("cloud.region", "ap-southeast-2")
+	assert.EqualError(t, exp.ConsumeLogs(context.Background(), ld), `failed to create the CloudWatch Logs client of region "ap-southeast-2": no session`)
+}
@@ -0,0 +1,24 @@
+// Copyright 2020, OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awscloudwatchlogsexporter // import "github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awscloudwatchlogsexporter"
+
+import "go.opentelemetry.io/collector/model/pdata"
+
+// xrayTraceID returns the trace ID in the X-Ray format: the version 1, then the first 8 hex digits of the W3C trace
+// ID, which X-Ray uses for the epoch time in seconds of the start of the trace, then its last 24 hex digits.
+func xrayTraceID(traceID pdata.TraceID) string {
+	id := traceID.HexString()
+	return "1-" + id[:8] + "-" + id[8:]
+}
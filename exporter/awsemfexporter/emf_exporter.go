@@ -117,7 +117,7 @@ func newEmfExporter(
 	return resourcetotelemetry.WrapMetricsExporter(config.(*Config).ResourceToTelemetrySettings, exporter), nil
 }
 
-func (emf *emfExporter) pushMetricsData(_ context.Context, md pdata.Metrics) error {
+func (emf *emfExporter) pushMetricsData(ctx context.Context, md pdata.Metrics) error {
 	rms := md.ResourceMetrics()
 	labels := map[string]string{}
 	for i := 0; i < rms.Len(); i++ {
@@ -170,7 +170,7 @@ func (emf *emfExporter) pushMetricsData(_ context.Context, md pdata.Metrics) err
 
 	if strings.EqualFold(outputDestination, outputDestinationCloudWatch) {
 		for _, emfPusher := range emf.listPushers() {
-			returnError := emfPusher.ForceFlush()
+			returnError := emfPusher.ForceFlush(ctx)
 			if returnError != nil {
 				//TODO now we only have one logPusher, so it's ok to return after first error occurred
 				err := wrapErrorIfBadRequest(&returnError)
@@ -226,7 +226,7 @@ func (emf *emfExporter) ConsumeMetrics(ctx context.Context, md pdata.Metrics) er
 // Shutdown stops the exporter and is invoked during shutdown.
 func (emf *emfExporter) Shutdown(ctx context.Context) error {
 	for _, emfPusher := range emf.listPushers() {
-		returnError := emfPusher.ForceFlush()
+		returnError := emfPusher.ForceFlush(ctx)
 		if returnError != nil {
 			err := wrapErrorIfBadRequest(&returnError)
 			if err != nil {
@@ -60,7 +60,7 @@ func (p *mockPusher) AddLogEntry(logEvent *cwlogs.Event) error {
 	return nil
 }
 
-func (p *mockPusher) ForceFlush() error {
+func (p *mockPusher) ForceFlush(_ context.Context) error {
 	args := p.Called(nil)
 	errorStr := args.String(0)
 	if errorStr != "" {
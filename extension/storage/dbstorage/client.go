@@ -19,6 +19,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
 	// Postgres driver
 	_ "github.com/jackc/pgx/v4/stdlib"
@@ -28,23 +30,133 @@ import (
 )
 
 const (
-	createTable     = "create table if not exists %s (key text primary key, value blob)"
-	getQueryText    = "select value from %s where key=?"
-	setQueryText    = "insert into %s(key, value) values(?,?) on conflict(key) do update set value=?"
-	deleteQueryText = "delete from %s where key=?"
+	createTable                = "create table if not exists %s (key text primary key, value blob)"
+	checkTable                 = "select 1 from %s where 1=0"
+	getQueryText               = "select value from %s where key=?"
+	setQueryText               = "insert into %s(key, value) values(?,?) on conflict(key) do update set value=?"
+	deleteQueryText            = "delete from %s where key=?"
+	findByValuePrefixQueryText = "select key from %s where substr(value, 1, ?) = ? order by key"
+	insertQueryText            = "insert into %s(key, value) values(?,?) on conflict(key) do nothing"
+	updateQueryText            = "update %s set value=? where key=?"
+	bulkSetQueryText           = "insert into %s(key, value) values%s on conflict(key) do update set value=excluded.value"
+
+	// In strict mode, each row records the namespace of the client that wrote it
+	createStrictTable     = "create table if not exists %s (key text primary key, value blob, namespace text)"
+	strictSetQueryText    = "insert into %s(key, value, namespace) values(?,?,?) on conflict(key) do update set value=? where %s.namespace=?"
+	strictDeleteQueryText = "delete from %s where key=? and namespace=?"
+	strictInsertQueryText = "insert into %s(key, value, namespace) values(?,?,?) on conflict(key) do nothing"
+	strictUpdateQueryText = "update %s set value=? where key=? and namespace=?"
 )
 
+// maxBulkSetRows is the number of keys written by a single bulk set statement. It keeps the statement within
+// 999 parameters, the lowest limit across SQLite versions; PostgreSQL allows 65535.
+const maxBulkSetRows = 499
+
+// errNamespaceCollision is returned in strict mode when a client writes a key written by a client of another namespace
+var errNamespaceCollision = errors.New("key belongs to the namespace of another client")
+
+// DBClient is implemented by the clients returned by the extension. On top of storage.Client,
+// it offers capabilities specific to database storage.
+// Components that want to use them type-assert the storage.Client they obtained from the extension.
+type DBClient interface {
+	storage.Client
+
+	// FindByValuePrefix returns the keys of this client whose value starts with prefix
+	FindByValuePrefix(ctx context.Context, prefix []byte) ([]string, error)
+
+	// Begin starts a transaction over the keys of this client
+	Begin(ctx context.Context) (Tx, error)
+
+	// SetWithResult stores data like Set, and reports whether the key was inserted or updated
+	SetWithResult(ctx context.Context, key string, value []byte) (WriteResult, error)
+
+	// DeleteWithResult deletes data like Delete, and reports whether the key was deleted
+	DeleteWithResult(ctx context.Context, key string) (WriteResult, error)
+
+	// Stats describes the entries of this client, for admin tooling. It does not change them
+	Stats(ctx context.Context) (StoreStats, error)
+}
+
+// WriteResult reports the rows affected by a write. A key updated to the value it already had counts as updated.
+type WriteResult struct {
+	Inserted int64
+	Updated  int64
+	Deleted  int64
+}
+
+// Tx is an interactive transaction over the keys of a client, for workflows that need to read,
+// decide and write atomically. It pins one connection of the pool until Commit or Rollback is called,
+// so it should be kept short. Isolation is the default one of the database: SQLite transactions are
+// serializable, PostgreSQL ones are read committed.
+type Tx interface {
+	// Get will retrieve data from storage that corresponds to the specified key
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set will store data. The data can be retrieved using the same key
+	Set(ctx context.Context, key string, value []byte) error
+	// Delete will delete data associated with the specified key
+	Delete(ctx context.Context, key string) error
+	// Commit makes the changes of the transaction visible to other clients
+	Commit() error
+	// Rollback discards the changes of the transaction
+	Rollback() error
+}
+
+// Ensure the database storage client implements the richer interface
+var _ DBClient = (*dbStorageClient)(nil)
+
 type dbStorageClient struct {
-	db          *sql.DB
-	getQuery    *sql.Stmt
-	setQuery    *sql.Stmt
-	deleteQuery *sql.Stmt
+	db         *sql.DB
+	readDB     *sql.DB
+	tableName  string
+	limiter    *connectionLimiter
+	namespace  string
+	maxRetries int
+	keys       keyEncoding
+	sizeGuard  *sizeGuard
+	// batchChunkSize is the number of operations of a batch run in each transaction, 0 to run them on their own
+	batchChunkSize int
+	getQuery       *sql.Stmt
+	readQuery      *sql.Stmt
+	setQuery       *sql.Stmt
+	deleteQuery    *sql.Stmt
+	// reconnector reconnects the database after a connection error, nil when reconnection is disabled
+	reconnector *reconnector
 }
 
-func newClient(ctx context.Context, db *sql.DB, tableName string) (*dbStorageClient, error) {
-	var err error
-	_, err = db.ExecContext(ctx, fmt.Sprintf(createTable, tableName))
-	if err != nil {
+// clientOptions are the settings of the extension that apply to its clients
+type clientOptions struct {
+	autoCreateTable bool
+	// createIndexes creates the secondary indexes of the table along with it
+	createIndexes bool
+	limiter       *connectionLimiter
+	// namespace identifies the client in strict mode, where the keys of other namespaces cannot be written.
+	// Strict mode is disabled when it is empty.
+	namespace string
+	// maxRetries is the number of times a statement failing with a transient error is run again
+	maxRetries int
+	// reconnector reconnects the database after a connection error when it is set
+	reconnector *reconnector
+	// readDB receives the reads made outside of transactions when it is set, so that they do not wait for
+	// the connections writing to the database
+	readDB *sql.DB
+	// keyEncoding is the encoding of the stored keys
+	keyEncoding keyEncoding
+	// sizeGuard bounds the size of the database when it is set
+	sizeGuard *sizeGuard
+	// batchChunkSize runs the operations of batches in transactions of that many operations when it is set
+	batchChunkSize int
+	// schemaSetup serializes or retries the creation of the table along with the other collectors
+	schemaSetup SchemaSetupSettings
+}
+
+func newClient(ctx context.Context, db *sql.DB, tableName string, opts clientOptions) (*dbStorageClient, error) {
+	setText, deleteText := fmt.Sprintf(setQueryText, tableName), deleteQueryText
+	if opts.namespace != "" {
+		setText = fmt.Sprintf(strictSetQueryText, tableName, tableName)
+		deleteText = strictDeleteQueryText
+	}
+
+	if err := setupTable(ctx, db, tableName, opts.namespace != "", opts); err != nil {
 		return nil, err
 	}
 
@@ -52,56 +164,350 @@ func newClient(ctx context.Context, db *sql.DB, tableName string) (*dbStorageCli
 	if err != nil {
 		return nil, err
 	}
-	setQuery, err := db.PrepareContext(ctx, fmt.Sprintf(setQueryText, tableName))
+	setQuery, err := db.PrepareContext(ctx, setText)
 	if err != nil {
 		return nil, err
 	}
-	deleteQuery, err := db.PrepareContext(ctx, fmt.Sprintf(deleteQueryText, tableName))
+	deleteQuery, err := db.PrepareContext(ctx, fmt.Sprintf(deleteText, tableName))
 	if err != nil {
 		return nil, err
 	}
-	return &dbStorageClient{db, selectQuery, setQuery, deleteQuery}, nil
+	readDB, readQuery := db, selectQuery
+	if opts.readDB != nil {
+		readDB = opts.readDB
+		if readQuery, err = readDB.PrepareContext(ctx, fmt.Sprintf(getQueryText, tableName)); err != nil {
+			return nil, err
+		}
+	}
+	return &dbStorageClient{
+		db:             db,
+		readDB:         readDB,
+		tableName:      tableName,
+		limiter:        opts.limiter,
+		namespace:      opts.namespace,
+		maxRetries:     opts.maxRetries,
+		reconnector:    opts.reconnector,
+		keys:           opts.keyEncoding,
+		sizeGuard:      opts.sizeGuard,
+		batchChunkSize: opts.batchChunkSize,
+		getQuery:       selectQuery,
+		readQuery:      readQuery,
+		setQuery:       setQuery,
+		deleteQuery:    deleteQuery,
+	}, nil
+}
+
+// setupTable creates the table of a client along with the columns and indexes of the enabled features, or checks
+// that it has been provisioned when the extension is not allowed to create it. Strict tables record the namespace
+// of the client writing each key.
+func setupTable(ctx context.Context, db *sql.DB, tableName string, strict bool, opts clientOptions) error {
+	createTableText := createTable
+	if strict {
+		createTableText = createStrictTable
+	}
+	return setupSchema(ctx, db, opts.schemaSetup, func(q schemaQueryer) error {
+		var err error
+		if opts.autoCreateTable {
+			_, err = q.ExecContext(ctx, fmt.Sprintf(createTableText, tableName))
+		} else {
+			err = checkTableExists(ctx, q, tableName)
+		}
+		if err != nil || opts.sizeGuard == nil {
+			return err
+		}
+		return opts.sizeGuard.prepareTable(ctx, q, tableName, opts.autoCreateTable, opts.createIndexes)
+	})
+}
+
+// checkTableExists verifies that a table which is not created automatically has been provisioned
+func checkTableExists(ctx context.Context, q schemaQueryer, tableName string) error {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(checkTable, tableName))
+	if err != nil {
+		return fmt.Errorf("table %s is missing and auto_create_table is disabled: %w", tableName, err)
+	}
+	return rows.Close()
+}
+
+// retry runs op until it succeeds, fails with a permanent error, or maxRetries retries have been made. After a
+// connection error, the database is reconnected before op is retried.
+func (c *dbStorageClient) retry(ctx context.Context, op func() error) error {
+	return retry(ctx, c.maxRetries, func() error {
+		err := op()
+		if err != nil && c.reconnector != nil && isConnectionError(err) {
+			// the error of the statement is more telling than the one of the reconnection
+			_ = c.reconnector.reconnect(ctx)
+		}
+		return err
+	})
 }
 
 // Get will retrieve data from storage that corresponds to the specified key
 func (c *dbStorageClient) Get(ctx context.Context, key string) ([]byte, error) {
-	rows, err := c.getQuery.QueryContext(ctx, key)
+	key = c.keys.encode(key)
+	release, err := c.limiter.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+	var value []byte
+	err = c.retry(ctx, func() (err error) {
+		value, err = get(ctx, c.readQuery, key)
+		return err
+	})
+	return value, err
+}
+
+func get(ctx context.Context, getQuery *sql.Stmt, key string) ([]byte, error) {
+	rows, err := getQuery.QueryContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// Closing the rows releases the connection back to the pool; a connection
+	// left holding an open read would pin a stale WAL snapshot.
+	defer rows.Close()
 	if !rows.Next() {
-		return nil, nil
+		return nil, rows.Err()
 	}
 	var result []byte
 	err = rows.Scan(&result)
 	if err != nil {
 		return result, err
 	}
-	err = rows.Close()
-	return result, err
+	return result, rows.Close()
 }
 
 // Set will store data. The data can be retrieved using the same key
 func (c *dbStorageClient) Set(ctx context.Context, key string, value []byte) error {
-	_, err := c.setQuery.ExecContext(ctx, key, value, value)
-	return err
+	key = c.keys.encode(key)
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.retry(ctx, func() error {
+		if err := c.sizeGuard.makeRoom(ctx, c.db, c.tableName); err != nil {
+			return err
+		}
+		return c.set(ctx, c.setQuery, key, value)
+	})
+}
+
+func (c *dbStorageClient) set(ctx context.Context, setQuery *sql.Stmt, key string, value []byte) error {
+	if c.namespace == "" {
+		_, err := setQuery.ExecContext(ctx, key, value, value)
+		return err
+	}
+	result, err := setQuery.ExecContext(ctx, key, value, c.namespace, value, c.namespace)
+	if err != nil {
+		return err
+	}
+	// the update is skipped when the key exists in another namespace
+	return c.checkWritten(result, key)
 }
 
 // Delete will delete data associated with the specified key
 func (c *dbStorageClient) Delete(ctx context.Context, key string) error {
-	_, err := c.deleteQuery.ExecContext(ctx, key)
-	return err
+	key = c.keys.encode(key)
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.retry(ctx, func() error {
+		_, err := c.delete(ctx, c.deleteQuery, c.getQuery, key)
+		return err
+	})
+}
+
+// delete deletes the key and returns the number of deleted rows
+func (c *dbStorageClient) delete(ctx context.Context, deleteQuery *sql.Stmt, getQuery *sql.Stmt, key string) (int64, error) {
+	if c.namespace == "" {
+		result, err := deleteQuery.ExecContext(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+	result, err := deleteQuery.ExecContext(ctx, key, c.namespace)
+	if err != nil {
+		return 0, err
+	}
+	if err = c.checkWritten(result, key); err == nil {
+		return 1, nil
+	}
+	// nothing was deleted, which is only a collision if the key exists
+	value, getErr := get(ctx, getQuery, key)
+	if getErr != nil {
+		return 0, getErr
+	}
+	if value == nil {
+		return 0, nil
+	}
+	return 0, err
+}
+
+// SetWithResult stores data like Set, and reports whether the key was inserted or updated
+func (c *dbStorageClient) SetWithResult(ctx context.Context, key string, value []byte) (WriteResult, error) {
+	key = c.keys.encode(key)
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	defer release()
+	var result WriteResult
+	err = c.retry(ctx, func() (err error) {
+		if err = c.sizeGuard.makeRoom(ctx, c.db, c.tableName); err != nil {
+			return err
+		}
+		result, err = c.setWithResult(ctx, key, value)
+		return err
+	})
+	return result, err
+}
+
+func (c *dbStorageClient) setWithResult(ctx context.Context, key string, value []byte) (WriteResult, error) {
+	insertQuery, insertArgs := fmt.Sprintf(insertQueryText, c.tableName), []interface{}{key, value}
+	updateQuery, updateArgs := fmt.Sprintf(updateQueryText, c.tableName), []interface{}{value, key}
+	if c.namespace != "" {
+		insertQuery, insertArgs = fmt.Sprintf(strictInsertQueryText, c.tableName), append(insertArgs, c.namespace)
+		updateQuery, updateArgs = fmt.Sprintf(strictUpdateQueryText, c.tableName), append(updateArgs, c.namespace)
+	}
+	// An upsert affects one row either way, so the insert and the update are told apart by running them in turn.
+	// They are retried if the key is deleted in between.
+	for {
+		if affected, err := c.exec(ctx, insertQuery, insertArgs...); err != nil || affected > 0 {
+			return WriteResult{Inserted: affected}, err
+		}
+		if affected, err := c.exec(ctx, updateQuery, updateArgs...); err != nil || affected > 0 {
+			return WriteResult{Updated: affected}, err
+		}
+		if c.namespace != "" {
+			existing, err := get(ctx, c.getQuery, key)
+			if err != nil {
+				return WriteResult{}, err
+			}
+			if existing != nil {
+				return WriteResult{}, fmt.Errorf("%w: key %q of table %s", errNamespaceCollision, key, c.tableName)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return WriteResult{}, err
+		}
+	}
+}
+
+func (c *dbStorageClient) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	result, err := c.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteWithResult deletes data like Delete, and reports whether the key was deleted
+func (c *dbStorageClient) DeleteWithResult(ctx context.Context, key string) (WriteResult, error) {
+	key = c.keys.encode(key)
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	defer release()
+	var deleted int64
+	err = c.retry(ctx, func() (err error) {
+		deleted, err = c.delete(ctx, c.deleteQuery, c.getQuery, key)
+		return err
+	})
+	return WriteResult{Deleted: deleted}, err
+}
+
+// checkWritten returns an error when a write of the key in strict mode did not affect any row
+func (c *dbStorageClient) checkWritten(result sql.Result, key string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: key %q of table %s", errNamespaceCollision, key, c.tableName)
+	}
+	return nil
+}
+
+// FindByValuePrefix returns the keys of this client whose value starts with prefix, in key order
+func (c *dbStorageClient) FindByValuePrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	if prefix == nil {
+		// a nil slice would be bound as NULL and match nothing
+		prefix = []byte{}
+	}
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var keys []string
+	err = c.retry(ctx, func() (err error) {
+		keys, err = c.findByValuePrefix(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (c *dbStorageClient) findByValuePrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	rows, err := c.readDB.QueryContext(ctx, fmt.Sprintf(findByValuePrefixQueryText, c.tableName), len(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var stored string
+		if err = rows.Scan(&stored); err != nil {
+			return nil, err
+		}
+		key, err := c.keys.decode(stored)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode key %q of table %s: %w", stored, c.tableName, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
 }
 
-// Batch executes the specified operations in order. Get operation results are updated in place
+// Begin starts a transaction over the keys of this client. With SQLite, a transaction reading before writing can fail
+// to write if another connection wrote in the meantime; open the database with _txlock=immediate to lock it upfront.
+func (c *dbStorageClient) Begin(ctx context.Context) (Tx, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &dbStorageTx{tx: tx, client: c, release: release}, nil
+}
+
+// Batch executes the specified operations in order. Get operation results are updated in place.
+// Consecutive Set operations are written together by multi-row upserts, unless the batch runs in transactions.
 func (c *dbStorageClient) Batch(ctx context.Context, ops ...storage.Operation) error {
+	if c.batchChunkSize > 0 {
+		return c.batchInTransactions(ctx, ops)
+	}
 	var err error
-	for _, op := range ops {
+	for i := 0; i < len(ops); i++ {
+		op := ops[i]
 		switch op.Type {
 		case storage.Get:
 			op.Value, err = c.Get(ctx, op.Key)
 		case storage.Set:
-			err = c.Set(ctx, op.Key, op.Value)
+			// consecutive sets are written together
+			n := 1
+			for i+n < len(ops) && ops[i+n].Type == storage.Set {
+				n++
+			}
+			err = c.setAll(ctx, ops[i:i+n])
+			i += n - 1
 		case storage.Delete:
 			err = c.Delete(ctx, op.Key)
 		default:
@@ -115,6 +521,155 @@ func (c *dbStorageClient) Batch(ctx context.Context, ops ...storage.Operation) e
 	return err
 }
 
+// batchInTransactions runs the operations in transactions of up to batchChunkSize operations each. Every
+// transaction is all-or-nothing, but a failing one leaves the previous ones committed.
+func (c *dbStorageClient) batchInTransactions(ctx context.Context, ops []storage.Operation) error {
+	for len(ops) > 0 {
+		chunk := ops
+		if len(chunk) > c.batchChunkSize {
+			chunk = chunk[:c.batchChunkSize]
+		}
+		if err := c.batchInTransaction(ctx, chunk); err != nil {
+			return err
+		}
+		ops = ops[len(chunk):]
+	}
+	return nil
+}
+
+func (c *dbStorageClient) batchInTransaction(ctx context.Context, ops []storage.Operation) (err error) {
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	for _, op := range ops {
+		switch op.Type {
+		case storage.Get:
+			op.Value, err = tx.Get(ctx, op.Key)
+		case storage.Set:
+			err = tx.Set(ctx, op.Key, op.Value)
+		case storage.Delete:
+			err = tx.Delete(ctx, op.Key)
+		default:
+			return errors.New("wrong operation type")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAll stores the values of set operations. Outside of strict mode, they are written by multi-row upserts of
+// up to maxBulkSetRows keys each. Every statement is atomic, but a failure leaves the previous ones applied.
+func (c *dbStorageClient) setAll(ctx context.Context, ops []storage.Operation) error {
+	if c.namespace != "" || len(ops) == 1 {
+		// strict mode needs the outcome of every key to report collisions
+		for _, op := range ops {
+			if err := c.Set(ctx, op.Key, op.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ops = lastSetPerKey(ops)
+	for len(ops) > 0 {
+		chunk := ops
+		if len(chunk) > maxBulkSetRows {
+			chunk = chunk[:maxBulkSetRows]
+		}
+		if err := c.bulkSet(ctx, chunk); err != nil {
+			return err
+		}
+		ops = ops[len(chunk):]
+	}
+	return nil
+}
+
+func (c *dbStorageClient) bulkSet(ctx context.Context, ops []storage.Operation) error {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	rows := strings.TrimSuffix(strings.Repeat("(?,?),", len(ops)), ",")
+	query := fmt.Sprintf(bulkSetQueryText, c.tableName, rows)
+	args := make([]interface{}, 0, 2*len(ops))
+	for _, op := range ops {
+		args = append(args, c.keys.encode(op.Key), op.Value)
+	}
+	return c.retry(ctx, func() error {
+		if err := c.sizeGuard.makeRoom(ctx, c.db, c.tableName); err != nil {
+			return err
+		}
+		_, err := c.db.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// lastSetPerKey keeps the last set operation of every key, as an upsert cannot write the same row twice
+func lastSetPerKey(ops []storage.Operation) []storage.Operation {
+	index := make(map[string]int, len(ops))
+	unique := make([]storage.Operation, 0, len(ops))
+	for _, op := range ops {
+		if i, ok := index[op.Key]; ok {
+			unique[i] = op
+			continue
+		}
+		index[op.Key] = len(unique)
+		unique = append(unique, op)
+	}
+	return unique
+}
+
+type dbStorageTx struct {
+	tx     *sql.Tx
+	client *dbStorageClient
+	// release gives the connection held by the transaction back to the limiter
+	release     func()
+	releaseOnce sync.Once
+}
+
+// Get will retrieve data from storage that corresponds to the specified key
+func (t *dbStorageTx) Get(ctx context.Context, key string) ([]byte, error) {
+	return get(ctx, t.tx.StmtContext(ctx, t.client.getQuery), t.client.keys.encode(key))
+}
+
+// Set will store data. The data can be retrieved using the same key
+func (t *dbStorageTx) Set(ctx context.Context, key string, value []byte) error {
+	if err := t.client.sizeGuard.makeRoom(ctx, t.tx, t.client.tableName); err != nil {
+		return err
+	}
+	return t.client.set(ctx, t.tx.StmtContext(ctx, t.client.setQuery), t.client.keys.encode(key), value)
+}
+
+// Delete will delete data associated with the specified key
+func (t *dbStorageTx) Delete(ctx context.Context, key string) error {
+	key = t.client.keys.encode(key)
+	_, err := t.client.delete(ctx, t.tx.StmtContext(ctx, t.client.deleteQuery), t.tx.StmtContext(ctx, t.client.getQuery), key)
+	return err
+}
+
+// Commit makes the changes of the transaction visible to other clients
+func (t *dbStorageTx) Commit() error {
+	defer t.releaseOnce.Do(t.release)
+	return t.tx.Commit()
+}
+
+// Rollback discards the changes of the transaction
+func (t *dbStorageTx) Rollback() error {
+	defer t.releaseOnce.Do(t.release)
+	return t.tx.Rollback()
+}
+
 // Close will close the database
 func (c *dbStorageClient) Close(_ context.Context) error {
 	if err := c.setQuery.Close(); err != nil {
@@ -126,5 +681,8 @@ func (c *dbStorageClient) Close(_ context.Context) error {
 	if err := c.getQuery.Close(); err != nil {
 		return err
 	}
+	if c.readQuery != c.getQuery {
+		return c.readQuery.Close()
+	}
 	return nil
 }
@@ -0,0 +1,412 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+)
+
+func TestClientFindByValuePrefix(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_find")
+
+	require.NoError(t, client.Set(ctx, "text", []byte("checkpoint-1")))
+	require.NoError(t, client.Set(ctx, "other", []byte("offset")))
+	require.NoError(t, client.Set(ctx, "binary", []byte{0x00, 0xff, 0x10}))
+	require.NoError(t, client.Set(ctx, "binary2", []byte{0x00, 0xff}))
+	require.NoError(t, client.Set(ctx, "binary3", []byte{0x00, 0xfe, 0x10}))
+
+	// Another client must not see the keys of this one
+	other := newTestClient(t, client.db, "receiver_nop_other")
+	require.NoError(t, other.Set(ctx, "foreign", []byte{0x00, 0xff}))
+
+	tests := []struct {
+		name   string
+		prefix []byte
+		want   []string
+	}{
+		{
+			name:   "text prefix",
+			prefix: []byte("check"),
+			want:   []string{"text"},
+		},
+		{
+			name:   "binary prefix",
+			prefix: []byte{0x00, 0xff},
+			want:   []string{"binary", "binary2"},
+		},
+		{
+			name:   "longer than value",
+			prefix: []byte{0x00, 0xff, 0x10, 0x00},
+			want:   nil,
+		},
+		{
+			name:   "no match",
+			prefix: []byte("nothing"),
+			want:   nil,
+		},
+		{
+			name:   "empty prefix",
+			prefix: nil,
+			want:   []string{"binary", "binary2", "binary3", "other", "text"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := client.FindByValuePrefix(ctx, tt.prefix)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys)
+		})
+	}
+}
+
+func TestClientTransactionCommit(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_tx")
+	require.NoError(t, client.Set(ctx, "counter", []byte{1}))
+	require.NoError(t, client.Set(ctx, "stale", []byte("x")))
+
+	tx, err := client.Begin(ctx)
+	require.NoError(t, err)
+
+	value, err := tx.Get(ctx, "counter")
+	require.NoError(t, err)
+	require.NoError(t, tx.Set(ctx, "counter", []byte{value[0] + 1}))
+	require.NoError(t, tx.Delete(ctx, "stale"))
+
+	// The transaction sees its own changes
+	value, err = tx.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2}, value)
+
+	require.NoError(t, tx.Commit())
+
+	value, err = client.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2}, value)
+	value, err = client.Get(ctx, "stale")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	assert.Error(t, tx.Rollback())
+}
+
+func TestClientTransactionRollback(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_tx")
+	require.NoError(t, client.Set(ctx, "counter", []byte{1}))
+
+	tx, err := client.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Set(ctx, "counter", []byte{2}))
+	require.NoError(t, tx.Set(ctx, "new", []byte("value")))
+	require.NoError(t, tx.Rollback())
+
+	value, err := client.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, value)
+	value, err = client.Get(ctx, "new")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	assert.Error(t, tx.Commit())
+}
+
+func TestClientWriteResults(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_results")
+
+	result, err := client.SetWithResult(ctx, "checkpoint", []byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, WriteResult{Inserted: 1}, result)
+
+	result, err = client.SetWithResult(ctx, "checkpoint", []byte("2"))
+	require.NoError(t, err)
+	assert.Equal(t, WriteResult{Updated: 1}, result)
+
+	result, err = client.SetWithResult(ctx, "checkpoint", []byte("2"))
+	require.NoError(t, err)
+	assert.Equal(t, WriteResult{Updated: 1}, result)
+
+	value, err := client.Get(ctx, "checkpoint")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	result, err = client.DeleteWithResult(ctx, "checkpoint")
+	require.NoError(t, err)
+	assert.Equal(t, WriteResult{Deleted: 1}, result)
+
+	result, err = client.DeleteWithResult(ctx, "checkpoint")
+	require.NoError(t, err)
+	assert.Equal(t, WriteResult{}, result)
+
+	result, err = client.SetWithResult(ctx, "checkpoint", []byte("3"))
+	require.NoError(t, err)
+	assert.Equal(t, WriteResult{Inserted: 1}, result)
+}
+
+func TestClientConnectionAcquireTimeout(t *testing.T) {
+	require.NoError(t, view.Register(MetricViews()...))
+	waits := viewSum(t, mConnectionWaits.Name())
+	timeouts := viewSum(t, mConnectionTimeouts.Name())
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	db.SetMaxOpenConns(1)
+	client, err := newClient(ctx, db, "receiver_nop_limited", clientOptions{
+		autoCreateTable: true,
+		limiter:         newConnectionLimiter(1, 10*time.Millisecond),
+	})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+
+	// The transaction holds the only connection
+	tx, err := client.Begin(ctx)
+	require.NoError(t, err)
+
+	_, err = client.Get(ctx, "key")
+	assert.ErrorIs(t, err, errConnectionAcquireTimeout)
+	assert.ErrorIs(t, client.Set(ctx, "key", []byte("value")), errConnectionAcquireTimeout)
+	assert.Equal(t, waits+2, viewSum(t, mConnectionWaits.Name()))
+	assert.Equal(t, timeouts+2, viewSum(t, mConnectionTimeouts.Name()))
+
+	require.NoError(t, tx.Commit())
+	// Releasing twice must not free a slot held by another operation
+	assert.Error(t, tx.Rollback())
+
+	require.NoError(t, client.Set(ctx, "key", []byte("value")))
+	value, err := client.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestClientConnectionAcquireContextDone(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	client, err := newClient(ctx, db, "receiver_nop_limited", clientOptions{
+		autoCreateTable: true,
+		limiter:         newConnectionLimiter(1, 0),
+	})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+
+	tx, err := client.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	cancelCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Get(cancelCtx, "key")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClientBatchSetChunks(t *testing.T) {
+	ctx := context.Background()
+	for _, n := range []int{2, maxBulkSetRows - 1, maxBulkSetRows, maxBulkSetRows + 1, 2*maxBulkSetRows + 1} {
+		t.Run(fmt.Sprintf("%d sets", n), func(t *testing.T) {
+			client := newTestClient(t, newTestDB(t), "receiver_nop_bulk")
+			// the last key exists already and is updated
+			require.NoError(t, client.Set(ctx, fmt.Sprintf("key-%d", n-1), []byte("old")))
+
+			require.NoError(t, client.Batch(ctx, setOperations(n, "value")...))
+			for i := 0; i < n; i++ {
+				value, err := client.Get(ctx, fmt.Sprintf("key-%d", i))
+				require.NoError(t, err)
+				assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), value, "key-%d", i)
+			}
+			keys, err := client.FindByValuePrefix(ctx, nil)
+			require.NoError(t, err)
+			assert.Len(t, keys, n)
+		})
+	}
+}
+
+func TestClientBatchSetOrder(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_bulk")
+
+	get := storage.GetOperation("a")
+	require.NoError(t, client.Batch(ctx,
+		storage.SetOperation("a", []byte("1")),
+		storage.SetOperation("b", []byte("1")),
+		storage.SetOperation("a", []byte("2")),
+		get,
+		storage.DeleteOperation("b"),
+		storage.SetOperation("b", []byte("2")),
+		storage.SetOperation("c", nil),
+	))
+	assert.Equal(t, []byte("2"), get.Value)
+
+	for key, want := range map[string][]byte{"a": []byte("2"), "b": []byte("2"), "c": nil} {
+		value, err := client.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, want, value, key)
+	}
+}
+
+func TestClientBatchInTransactions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	client, err := newClient(ctx, db, "receiver_nop_chunks", clientOptions{autoCreateTable: true, batchChunkSize: 10})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+
+	// a batch larger than the chunk size is applied entirely
+	ops := setOperations(25, "value")
+	get := storage.GetOperation("key-3")
+	ops = append(ops, storage.DeleteOperation("key-0"), get)
+	require.NoError(t, client.Batch(ctx, ops...))
+	assert.Equal(t, []byte("value-3"), get.Value)
+	keys, err := client.FindByValuePrefix(ctx, []byte("value"))
+	require.NoError(t, err)
+	assert.Len(t, keys, 24)
+
+	// a failing operation rolls its chunk back, and leaves the previous chunks committed
+	ops = setOperations(25, "next")
+	ops[22].Type = storage.Delete + 1
+	assert.Error(t, client.Batch(ctx, ops...))
+	for i := 0; i < 25; i++ {
+		value, err := client.Get(ctx, fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		switch {
+		case i < 20:
+			assert.Equal(t, []byte(fmt.Sprintf("next-%d", i)), value, "key-%d", i)
+		case i < 22:
+			assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), value, "key-%d", i)
+		}
+	}
+
+	// the transactions release their connection
+	require.NoError(t, client.Set(ctx, "after", []byte("batch")))
+}
+
+func BenchmarkClientBatchSet(b *testing.B) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(b, err)
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/foo.db?_busy_timeout=10000&_journal=WAL&_sync=NORMAL", tempDir))
+	require.NoError(b, err)
+	defer db.Close()
+	client, err := newClient(ctx, db, "receiver_nop_bench", clientOptions{autoCreateTable: true})
+	require.NoError(b, err)
+	defer client.Close(ctx)
+
+	ops := setOperations(1000, "value")
+	b.Run("bulk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, client.Batch(ctx, ops...))
+		}
+	})
+	b.Run("individual", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, op := range ops {
+				require.NoError(b, client.Set(ctx, op.Key, op.Value))
+			}
+		}
+	})
+}
+
+func setOperations(n int, prefix string) []storage.Operation {
+	ops := make([]storage.Operation, n)
+	for i := range ops {
+		ops[i] = storage.SetOperation(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("%s-%d", prefix, i)))
+	}
+	return ops
+}
+
+func TestClientKeyEncoding(t *testing.T) {
+	ctx := context.Background()
+	binaryKeys := []string{"\x00", "a\x00b", "\xff\xfe\x80", "\x00\xff", "text"}
+	for _, encoding := range []keyEncoding{keyEncodingHex, keyEncodingBase64} {
+		t.Run(string(encoding), func(t *testing.T) {
+			db := newTestDB(t)
+			client, err := newClient(ctx, db, "receiver_nop_keys", clientOptions{autoCreateTable: true, keyEncoding: encoding})
+			require.NoError(t, err)
+			defer client.Close(ctx)
+
+			for i, key := range binaryKeys {
+				require.NoError(t, client.Set(ctx, key, []byte{byte(i)}))
+			}
+			require.NoError(t, client.Batch(ctx, storage.SetOperation("\x00batch", []byte("b")), storage.SetOperation("\xffbatch", []byte("b"))))
+			for i, key := range binaryKeys {
+				value, err := client.Get(ctx, key)
+				require.NoError(t, err)
+				assert.Equal(t, []byte{byte(i)}, value, "%q", key)
+			}
+
+			// the keys are stored encoded and decoded when listed
+			var stored string
+			require.NoError(t, db.QueryRowContext(ctx, "select key from receiver_nop_keys where value=?", []byte{1}).Scan(&stored))
+			assert.Equal(t, encoding.encode("a\x00b"), stored)
+			keys, err := client.FindByValuePrefix(ctx, nil)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, append([]string{"\x00batch", "\xffbatch"}, binaryKeys...), keys)
+
+			tx, err := client.Begin(ctx)
+			require.NoError(t, err)
+			require.NoError(t, tx.Delete(ctx, "\x00"))
+			value, err := tx.Get(ctx, "\x00")
+			require.NoError(t, err)
+			assert.Nil(t, value)
+			require.NoError(t, tx.Commit())
+
+			result, err := client.DeleteWithResult(ctx, "\xff\xfe\x80")
+			require.NoError(t, err)
+			assert.Equal(t, WriteResult{Deleted: 1}, result)
+			value, err = client.Get(ctx, "\x00\xff")
+			require.NoError(t, err)
+			assert.Equal(t, []byte{3}, value)
+		})
+	}
+}
+
+func viewSum(t *testing.T, name string) float64 {
+	rows, err := view.RetrieveData(name)
+	require.NoError(t, err)
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[0].Data.(*view.SumData).Value
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/foo.db?_busy_timeout=10000&_journal=WAL&_sync=NORMAL", tempDir))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+func newTestClient(t *testing.T, db *sql.DB, tableName string) *dbStorageClient {
+	client, err := newClient(context.Background(), db, tableName, clientOptions{autoCreateTable: true})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close(context.Background())
+	})
+	return client
+}
@@ -0,0 +1,58 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+const (
+	// compactTimeout bounds the compaction run on shutdown, so that a large database does not hold up the collector.
+	// An interrupted vacuum leaves the database as it was.
+	compactTimeout = 30 * time.Second
+
+	// the write-ahead log is truncated once its content is written to the database
+	checkpointTruncate = "PRAGMA wal_checkpoint(TRUNCATE)"
+	vacuum             = "vacuum"
+)
+
+// compactDatabases checkpoints the write-ahead log of the databases, and rebuilds them to release the pages left free by
+// the deleted entries, so that the next start opens compact files. Only SQLite databases are compacted.
+func (ds *databaseStorage) compactDatabases(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, compactTimeout)
+	defer cancel()
+	var errs error
+	for i, db := range ds.dbs {
+		if err := compactDB(ctx, db); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("failed to compact database %d: %w", i, err))
+		}
+	}
+	return errs
+}
+
+func compactDB(ctx context.Context, db *sql.DB) error {
+	// the checkpoint lets vacuum start from a short log, and the one after it truncates the log vacuum wrote
+	for _, statement := range []string{checkpointTruncate, vacuum, checkpointTruncate} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+)
+
+func TestCompactDB(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	path := filepath.Join(tempDir, "foo.db")
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=10000&_journal=WAL&_sync=NORMAL", path))
+	require.NoError(t, err)
+	defer db.Close()
+	client := newTestClient(t, db, "receiver_nop_compact")
+
+	fillAndEmpty(t, client)
+	walBefore, sizeBefore := fileSize(t, path+"-wal"), fileSize(t, path)
+	require.Greater(t, walBefore, int64(0))
+
+	require.NoError(t, compactDB(ctx, db))
+	// the write-ahead log is checkpointed and truncated while the database is still open
+	assert.Equal(t, int64(0), fileSize(t, path+"-wal"))
+	assert.Less(t, fileSize(t, path), sizeBefore+walBefore)
+	value, err := client.Get(ctx, "kept")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestExtensionCompactOnShutdown(t *testing.T) {
+	sizes := map[bool]int64{}
+	for _, compact := range []bool{false, true} {
+		ctx := context.Background()
+		tempDir, err := ioutil.TempDir("", "")
+		require.NoError(t, err)
+		path := filepath.Join(tempDir, "foo.db")
+
+		f := NewFactory()
+		cfg := f.CreateDefaultConfig().(*Config)
+		cfg.DriverName = "sqlite3"
+		cfg.DataSource = fmt.Sprintf("file:%s?_busy_timeout=10000&_journal=WAL&_sync=NORMAL", path)
+		cfg.CompactOnShutdown = compact
+		require.NoError(t, cfg.Validate())
+		extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+		require.NoError(t, err)
+		se := extension.(*databaseStorage)
+		require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+
+		client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("my_component"), "")
+		require.NoError(t, err)
+		fillAndEmpty(t, client.(*dbStorageClient))
+		require.NoError(t, client.Close(ctx))
+		require.NoError(t, se.Shutdown(ctx))
+		sizes[compact] = fileSize(t, path)
+	}
+	// the pages of the deleted entries are released by the compaction only
+	assert.Less(t, sizes[true]*4, sizes[false], "compacted %d bytes, not compacted %d bytes", sizes[true], sizes[false])
+}
+
+// fillAndEmpty writes a thousand entries of a kilobyte and deletes them, leaving a single small entry
+func fillAndEmpty(t *testing.T, client *dbStorageClient) {
+	ctx := context.Background()
+	value := bytes.Repeat([]byte{'x'}, 1024)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("key-%d", i), value))
+	}
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, client.Delete(ctx, fmt.Sprintf("key-%d", i)))
+	}
+	require.NoError(t, client.Set(ctx, "kept", []byte("value")))
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info.Size()
+}
@@ -16,24 +16,274 @@ package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-c
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"go.opentelemetry.io/collector/config"
 )
 
+const (
+	tableCreationLazy  = "lazy"
+	tableCreationEager = "eager"
+)
+
+// tableNamePattern matches the names of the tables of the clients, interpolated in the statements
+var tableNamePattern = regexp.MustCompile(`^\w+$`)
+
 // Config defines configuration for dbstorage extension.
 type Config struct {
 	config.ExtensionSettings `mapstructure:",squash"`
 	DriverName               string `mapstructure:"driver,omitempty"`
 	DataSource               string `mapstructure:"datasource,omitempty"`
+	// DataSources spreads the keys of every client across several databases with consistent hashing,
+	// instead of storing them in the single DataSource. Datasources can be appended without moving
+	// most of the keys, but existing keys are not migrated. Optional, exclusive with DataSource.
+	DataSources []string `mapstructure:"datasources,omitempty"`
+	// DataSourceSecret reads the datasource from an environment variable or a file when the extension starts,
+	// so that its credentials are not part of the configuration. Optional, exclusive with DataSource and DataSources.
+	DataSourceSecret SecretSettings `mapstructure:"datasource_secret,omitempty"`
+	// PasswordSecret reads a password from an environment variable or a file when the extension starts, and
+	// substitutes it for the {password} placeholder of the datasources. Optional.
+	PasswordSecret SecretSettings `mapstructure:"password_secret,omitempty"`
+	// AutoCreateTable controls whether the table backing a client is created when it does not exist yet.
+	// Disable it when the database user is not allowed to run DDL statements and the tables are provisioned upfront.
+	AutoCreateTable bool `mapstructure:"auto_create_table"`
+	// CreateIndexes controls whether secondary indexes are created along with the tables, such as the index on the
+	// updated_at column ordering the evictions of MaxDBSize. The key column is always indexed by its primary key.
+	CreateIndexes bool `mapstructure:"create_indexes"`
+	// TableCreation is when the tables of the clients are created: "lazy" creates the table of a client when it is
+	// first requested, so that no table is created for components that never use the storage; "eager" also creates
+	// the Tables when the extension starts, so that schema and permission problems surface before the components
+	// start. Optional, "lazy" by default.
+	TableCreation string `mapstructure:"table_creation,omitempty"`
+	// Tables are the tables created when the extension starts with eager table creation, named like the tables
+	// of the clients: <kind>_<component type>_<component name>[_<client name>].
+	Tables []string `mapstructure:"tables,omitempty"`
+	// SchemaSetup controls how the tables are set up when several collectors start against the same database.
+	SchemaSetup SchemaSetupSettings `mapstructure:"schema_setup,omitempty"`
+	// DurabilityProfile tunes the SQLite journal and disk synchronization settings: "fast", "balanced" or "safe".
+	// It overrides the matching settings of the datasource. Optional, only supported with the sqlite3 driver.
+	DurabilityProfile string `mapstructure:"durability_profile,omitempty"`
+	// MaxOpenConnections bounds the number of open connections to the database. Optional, unbounded by default.
+	MaxOpenConnections int `mapstructure:"max_open_connections,omitempty"`
+	// ConnectionAcquireTimeout bounds the time an operation waits for a connection when all of them are in use.
+	// It only applies when MaxOpenConnections is set. Optional, operations wait until their context is done by default.
+	ConnectionAcquireTimeout time.Duration `mapstructure:"connection_acquire_timeout,omitempty"`
+	// ProbeOnStart makes the extension fail to start unless a Set/Get/Delete round-trip on a temporary key
+	// succeeds against every database, catching misconfigurations before the collector goes live.
+	ProbeOnStart bool `mapstructure:"probe_on_start,omitempty"`
+	// StrictNamespaces records the client that wrote each key, and fails the writes of a client to the keys of
+	// another one. Clients can share a table when their names only differ by spaces or underscores.
+	// Tables created without strict mode cannot be used with it. Optional, disabled by default.
+	StrictNamespaces bool `mapstructure:"strict_namespaces,omitempty"`
+	// SnapshotInterval is the interval at which a copy of the database is written to SnapshotPath, for backups.
+	// Optional, only supported with the sqlite3 driver. Snapshots are only taken on demand by default.
+	SnapshotInterval time.Duration `mapstructure:"snapshot_interval,omitempty"`
+	// SnapshotPath is the file the periodic snapshots are written to. Required when SnapshotInterval is set.
+	SnapshotPath string `mapstructure:"snapshot_path,omitempty"`
+	// CompactOnShutdown checkpoints the write-ahead log and vacuums the database on shutdown, so that the next start
+	// opens a compact file. Optional, only supported with the sqlite3 driver.
+	CompactOnShutdown bool `mapstructure:"compact_on_shutdown,omitempty"`
+	// LogOperations logs the Get, Set, Delete and Batch operations of the clients at the debug level, with their
+	// key, namespace and latency, to debug a component thrashing the storage. Values are not logged.
+	// Optional, disabled by default.
+	LogOperations bool `mapstructure:"log_operations,omitempty"`
+	// Reconnect pings a database whose connection failed until it answers, with a backoff, before the statements
+	// failing meanwhile are retried. Optional, disabled by default.
+	Reconnect ReconnectSettings `mapstructure:"reconnect,omitempty"`
+	// MaxRetries is the number of times a statement failing with a transient error, such as a busy database,
+	// a deadlock or a reset connection, is run again. Transactions are not retried. Optional, 0 by default.
+	MaxRetries int `mapstructure:"max_retries,omitempty"`
+	// SingleWriter routes the writes of all the clients through a single connection, and the reads through a
+	// separate pool, so that concurrent writers in the collector do not fail with busy database errors.
+	// It switches the database to the write-ahead log. Optional, only supported with the sqlite3 driver.
+	SingleWriter bool `mapstructure:"single_writer,omitempty"`
+	// KeyEncoding stores the keys hex or base64 encoded, so that binary keys, e.g. holding null bytes or invalid
+	// UTF-8, round-trip whatever the driver and the collation of the key column: "hex" or "base64".
+	// Changing it makes the keys stored before unreachable. Optional, keys are stored as is by default.
+	KeyEncoding string `mapstructure:"key_encoding,omitempty"`
+	// BatchChunkSize runs the operations of a batch in transactions of up to that many operations, so that each
+	// transaction is all-or-nothing. A failing transaction leaves the previous ones of the batch committed.
+	// Optional, the operations of a batch run on their own by default. Not supported with several datasources.
+	BatchChunkSize int `mapstructure:"batch_chunk_size,omitempty"`
+	// WriteBehind queues the Sets and Deletes of the clients and writes them in batches. Optional, disabled by default.
+	WriteBehind WriteBehindSettings `mapstructure:"write_behind,omitempty"`
+	// MaxDBSize is the size in bytes the database must not exceed, so that it does not fill the disk.
+	// Optional, only supported with the sqlite3 driver. The size is unbounded by default.
+	MaxDBSize int64 `mapstructure:"max_db_size,omitempty"`
+	// EvictionPolicy is applied to the writes made once MaxDBSize is reached: "reject" fails them, "evict-oldest"
+	// deletes the least recently written entries of the client writing until the database fits. Optional, "reject" by default.
+	EvictionPolicy string `mapstructure:"eviction_policy,omitempty"`
+}
+
+// WriteBehindSettings configures the write-behind queue of the clients. Queued writes are read back right away,
+// but they are lost if the collector crashes before they are written: the settings trade durability for throughput.
+type WriteBehindSettings struct {
+	// FlushInterval is the maximum time a write waits in the queue. Longer intervals coalesce more writes to the
+	// same keys, but lose more of them on a crash. The queue is disabled when it is 0.
+	FlushInterval time.Duration `mapstructure:"flush_interval,omitempty"`
+	// MaxPending writes the queue as soon as it holds writes to that many keys. Optional, unbounded by default.
+	MaxPending int `mapstructure:"max_pending,omitempty"`
+}
+
+// ReconnectSettings configures the reconnection to a database whose connection failed. The clients of a database
+// detecting the failure together share a single reconnection, and the jitter keeps the collectors of a fleet from
+// reconnecting in lockstep.
+type ReconnectSettings struct {
+	// InitialInterval is the delay after the first failed ping, doubled after every following one.
+	// Reconnection is disabled when it is 0.
+	InitialInterval time.Duration `mapstructure:"initial_interval,omitempty"`
+	// MaxInterval caps the delay between two pings. Optional, 30s by default.
+	MaxInterval time.Duration `mapstructure:"max_interval,omitempty"`
+	// Jitter is the fraction of every delay that is randomized, between 0 and 1: a delay d is drawn in
+	// [d*(1-Jitter), d]. Optional, the delays are not randomized by default.
+	Jitter float64 `mapstructure:"jitter,omitempty"`
+	// MaxElapsedTime bounds the time spent reconnecting, after which the failed statements return their error.
+	// Optional, 1m by default.
+	MaxElapsedTime time.Duration `mapstructure:"max_elapsed_time,omitempty"`
+}
+
+// SchemaSetupSettings configures the creation of the tables, their columns and indexes, which may collide with
+// the ones of other collectors starting against the same database.
+type SchemaSetupSettings struct {
+	// MaxRetries is the number of times the setup of a table is run again when it conflicts with the one of
+	// another collector, e.g. when both add the same column. Optional, 3 by default.
+	MaxRetries int `mapstructure:"max_retries,omitempty"`
 }
 
 func (cfg *Config) Validate() error {
-	if cfg.DataSource == "" {
+	if cfg.DataSource == "" && len(cfg.DataSources) == 0 && !cfg.DataSourceSecret.isSet() {
 		return fmt.Errorf(fmt.Sprintf("missing datasource for %s", cfg.ID()))
 	}
+	if cfg.DataSourceSecret.isSet() && (cfg.DataSource != "" || len(cfg.DataSources) > 0) {
+		return fmt.Errorf("datasource secret and datasources are exclusive for %s", cfg.ID())
+	}
+	if err := cfg.DataSourceSecret.validate(); err != nil {
+		return fmt.Errorf("invalid datasource secret for %s: %w", cfg.ID(), err)
+	}
+	if err := cfg.PasswordSecret.validate(); err != nil {
+		return fmt.Errorf("invalid password secret for %s: %w", cfg.ID(), err)
+	}
+	if cfg.PasswordSecret.isSet() && !cfg.DataSourceSecret.isSet() {
+		for _, dataSource := range cfg.dataSourceNames() {
+			if !strings.Contains(dataSource, passwordPlaceholder) {
+				return fmt.Errorf("missing %s placeholder in datasource for %s", passwordPlaceholder, cfg.ID())
+			}
+		}
+	}
+	if cfg.DataSource != "" && len(cfg.DataSources) > 0 {
+		return fmt.Errorf("datasource and datasources are exclusive for %s", cfg.ID())
+	}
+	for _, dataSource := range cfg.DataSources {
+		if dataSource == "" {
+			return fmt.Errorf("empty datasource in datasources for %s", cfg.ID())
+		}
+	}
 	if cfg.DriverName == "" {
 		return fmt.Errorf(fmt.Sprintf("missing driver name for %s", cfg.ID()))
 	}
+	if cfg.DriverName == pgxDriverName {
+		// the statements use the ? placeholders and the blob type of SQLite, which PostgreSQL rejects
+		return fmt.Errorf("driver %s for %s is not supported, the statements use the %s dialect", pgxDriverName, cfg.ID(), sqliteDriverName)
+	}
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("negative max retries for %s", cfg.ID())
+	}
+	if cfg.Reconnect.InitialInterval < 0 || cfg.Reconnect.MaxInterval < 0 || cfg.Reconnect.MaxElapsedTime < 0 {
+		return fmt.Errorf("negative reconnect interval for %s", cfg.ID())
+	}
+	if cfg.Reconnect.Jitter < 0 || cfg.Reconnect.Jitter > 1 {
+		return fmt.Errorf("reconnect jitter for %s must be between 0 and 1", cfg.ID())
+	}
+	switch cfg.TableCreation {
+	case "", tableCreationLazy:
+		if len(cfg.Tables) > 0 {
+			return fmt.Errorf("tables for %s require %s table creation", cfg.ID(), tableCreationEager)
+		}
+	case tableCreationEager:
+		if len(cfg.Tables) == 0 {
+			return fmt.Errorf("missing tables for %s table creation for %s", tableCreationEager, cfg.ID())
+		}
+		for _, table := range cfg.Tables {
+			if !tableNamePattern.MatchString(table) {
+				return fmt.Errorf("invalid table name %q for %s", table, cfg.ID())
+			}
+		}
+	default:
+		return fmt.Errorf("unknown table creation %q for %s", cfg.TableCreation, cfg.ID())
+	}
+	if cfg.SchemaSetup.MaxRetries < 0 {
+		return fmt.Errorf("negative schema setup max retries for %s", cfg.ID())
+	}
+	if cfg.MaxOpenConnections < 0 {
+		return fmt.Errorf("negative max open connections for %s", cfg.ID())
+	}
+	if cfg.SnapshotInterval < 0 {
+		return fmt.Errorf("negative snapshot interval for %s", cfg.ID())
+	}
+	if cfg.SnapshotInterval > 0 {
+		if cfg.SnapshotPath == "" {
+			return fmt.Errorf("missing snapshot path for %s", cfg.ID())
+		}
+		if cfg.DriverName != sqliteDriverName {
+			return fmt.Errorf("snapshots for %s require the %s driver", cfg.ID(), sqliteDriverName)
+		}
+	}
+	if cfg.CompactOnShutdown && cfg.DriverName != sqliteDriverName {
+		return fmt.Errorf("compaction on shutdown for %s requires the %s driver", cfg.ID(), sqliteDriverName)
+	}
+	if !keyEncoding(cfg.KeyEncoding).valid() {
+		return fmt.Errorf("unknown key encoding %q for %s", cfg.KeyEncoding, cfg.ID())
+	}
+	if cfg.BatchChunkSize < 0 {
+		return fmt.Errorf("negative batch chunk size for %s", cfg.ID())
+	}
+	if cfg.BatchChunkSize > 0 && len(cfg.DataSources) > 1 {
+		return fmt.Errorf("batch chunk size for %s is not supported with several datasources", cfg.ID())
+	}
+	if cfg.WriteBehind.FlushInterval < 0 {
+		return fmt.Errorf("negative write-behind flush interval for %s", cfg.ID())
+	}
+	if cfg.WriteBehind.MaxPending < 0 {
+		return fmt.Errorf("negative write-behind max pending for %s", cfg.ID())
+	}
+	if cfg.MaxDBSize < 0 {
+		return fmt.Errorf("negative max db size for %s", cfg.ID())
+	}
+	if cfg.MaxDBSize > 0 && cfg.DriverName != sqliteDriverName {
+		return fmt.Errorf("max db size for %s requires the %s driver", cfg.ID(), sqliteDriverName)
+	}
+	if cfg.EvictionPolicy != "" && cfg.EvictionPolicy != evictionPolicyReject && cfg.EvictionPolicy != evictionPolicyEvictOldest {
+		return fmt.Errorf("unknown eviction policy %q for %s", cfg.EvictionPolicy, cfg.ID())
+	}
+	if cfg.SingleWriter && cfg.DriverName != sqliteDriverName {
+		return fmt.Errorf("single writer mode for %s requires the %s driver", cfg.ID(), sqliteDriverName)
+	}
+	if cfg.DurabilityProfile != "" {
+		if _, ok := durabilityPragmas[cfg.DurabilityProfile]; !ok {
+			return fmt.Errorf("unknown durability profile %q for %s", cfg.DurabilityProfile, cfg.ID())
+		}
+		if cfg.DriverName != sqliteDriverName {
+			return fmt.Errorf("durability profile for %s requires the %s driver", cfg.ID(), sqliteDriverName)
+		}
+	}
 
 	return nil
 }
+
+// eagerTables returns the tables to create when the extension starts
+func (cfg *Config) eagerTables() []string {
+	if cfg.TableCreation != tableCreationEager {
+		return nil
+	}
+	return cfg.Tables
+}
+
+// dataSourceNames returns the datasources the keys are stored in, before their secrets are resolved
+func (cfg *Config) dataSourceNames() []string {
+	if len(cfg.DataSources) > 0 {
+		return cfg.DataSources
+	}
+	return []string{cfg.DataSource}
+}
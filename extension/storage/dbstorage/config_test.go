@@ -15,7 +15,9 @@
 package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
 import (
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,6 +33,11 @@ func TestConfig_Validate(t *testing.T) {
 			Config{DataSource: "foo"},
 			errors.New("missing driver name for /blah"),
 		},
+		{
+			"Unsupported pgx driver",
+			Config{DriverName: "pgx", DataSource: "bar"},
+			errors.New("driver pgx for /blah is not supported, the statements use the sqlite3 dialect"),
+		},
 		{
 			"Missing datasource",
 			Config{DriverName: "foo"},
@@ -41,6 +48,176 @@ func TestConfig_Validate(t *testing.T) {
 			Config{DriverName: "foo", DataSource: "bar"},
 			nil,
 		},
+		{
+			"Unknown durability profile",
+			Config{DriverName: "sqlite3", DataSource: "bar", DurabilityProfile: "reckless"},
+			errors.New("unknown durability profile \"reckless\" for /blah"),
+		},
+		{
+			"Durability profile without sqlite",
+			Config{DriverName: "mysql", DataSource: "bar", DurabilityProfile: "safe"},
+			errors.New("durability profile for /blah requires the sqlite3 driver"),
+		},
+		{
+			"valid durability profile",
+			Config{DriverName: "sqlite3", DataSource: "bar", DurabilityProfile: "balanced"},
+			nil,
+		},
+		{
+			"Datasource and datasources",
+			Config{DriverName: "foo", DataSource: "bar", DataSources: []string{"bar", "baz"}},
+			errors.New("datasource and datasources are exclusive for /blah"),
+		},
+		{
+			"Empty datasource in datasources",
+			Config{DriverName: "foo", DataSources: []string{"bar", ""}},
+			errors.New("empty datasource in datasources for /blah"),
+		},
+		{
+			"valid datasources",
+			Config{DriverName: "foo", DataSources: []string{"bar", "baz"}},
+			nil,
+		},
+		{
+			"valid datasource secret",
+			Config{DriverName: "foo", DataSourceSecret: SecretSettings{Env: "DSN"}},
+			nil,
+		},
+		{
+			"Datasource secret and datasource",
+			Config{DriverName: "foo", DataSource: "bar", DataSourceSecret: SecretSettings{File: "dsn"}},
+			errors.New("datasource secret and datasources are exclusive for /blah"),
+		},
+		{
+			"Datasource secret with env and file",
+			Config{DriverName: "foo", DataSourceSecret: SecretSettings{Env: "DSN", File: "dsn"}},
+			fmt.Errorf("invalid datasource secret for /blah: %w", errors.New("env and file are exclusive")),
+		},
+		{
+			"Password secret with env and file",
+			Config{DriverName: "foo", DataSource: "bar:{password}", PasswordSecret: SecretSettings{Env: "PASSWORD", File: "password"}},
+			fmt.Errorf("invalid password secret for /blah: %w", errors.New("env and file are exclusive")),
+		},
+		{
+			"Password secret without placeholder",
+			Config{DriverName: "foo", DataSources: []string{"bar:{password}", "baz"}, PasswordSecret: SecretSettings{Env: "PASSWORD"}},
+			errors.New("missing {password} placeholder in datasource for /blah"),
+		},
+		{
+			"valid eager table creation",
+			Config{DriverName: "foo", DataSource: "bar", TableCreation: "eager", Tables: []string{"receiver_filelog_"}},
+			nil,
+		},
+		{
+			"Unknown table creation",
+			Config{DriverName: "foo", DataSource: "bar", TableCreation: "deferred"},
+			errors.New("unknown table creation \"deferred\" for /blah"),
+		},
+		{
+			"Eager table creation without tables",
+			Config{DriverName: "foo", DataSource: "bar", TableCreation: "eager"},
+			errors.New("missing tables for eager table creation for /blah"),
+		},
+		{
+			"Tables with lazy table creation",
+			Config{DriverName: "foo", DataSource: "bar", Tables: []string{"receiver_filelog_"}},
+			errors.New("tables for /blah require eager table creation"),
+		},
+		{
+			"Invalid table name",
+			Config{DriverName: "foo", DataSource: "bar", TableCreation: "eager", Tables: []string{"receiver_filelog_; drop table x"}},
+			errors.New("invalid table name \"receiver_filelog_; drop table x\" for /blah"),
+		},
+		{
+			"Negative schema setup max retries",
+			Config{DriverName: "mysql", DataSource: "bar", SchemaSetup: SchemaSetupSettings{MaxRetries: -1}},
+			errors.New("negative schema setup max retries for /blah"),
+		},
+		{
+			"Snapshot interval without path",
+			Config{DriverName: "sqlite3", DataSource: "bar", SnapshotInterval: time.Minute},
+			errors.New("missing snapshot path for /blah"),
+		},
+		{
+			"Snapshot interval without sqlite",
+			Config{DriverName: "mysql", DataSource: "bar", SnapshotInterval: time.Minute, SnapshotPath: "backup.db"},
+			errors.New("snapshots for /blah require the sqlite3 driver"),
+		},
+		{
+			"Compaction without sqlite",
+			Config{DriverName: "mysql", DataSource: "bar", CompactOnShutdown: true},
+			errors.New("compaction on shutdown for /blah requires the sqlite3 driver"),
+		},
+		{
+			"valid snapshot interval",
+			Config{DriverName: "sqlite3", DataSource: "bar", SnapshotInterval: time.Minute, SnapshotPath: "backup.db"},
+			nil,
+		},
+		{
+			"Negative max retries",
+			Config{DriverName: "foo", DataSource: "bar", MaxRetries: -1},
+			errors.New("negative max retries for /blah"),
+		},
+		{
+			"Negative reconnect interval",
+			Config{DriverName: "foo", DataSource: "bar", Reconnect: ReconnectSettings{InitialInterval: time.Second, MaxInterval: -time.Second}},
+			errors.New("negative reconnect interval for /blah"),
+		},
+		{
+			"Reconnect jitter out of range",
+			Config{DriverName: "foo", DataSource: "bar", Reconnect: ReconnectSettings{InitialInterval: time.Second, Jitter: 1.5}},
+			errors.New("reconnect jitter for /blah must be between 0 and 1"),
+		},
+		{
+			"Single writer without sqlite",
+			Config{DriverName: "mysql", DataSource: "bar", SingleWriter: true},
+			errors.New("single writer mode for /blah requires the sqlite3 driver"),
+		},
+		{
+			"Negative max open connections",
+			Config{DriverName: "foo", DataSource: "bar", MaxOpenConnections: -1},
+			errors.New("negative max open connections for /blah"),
+		},
+		{
+			"Unknown key encoding",
+			Config{DriverName: "foo", DataSource: "bar", KeyEncoding: "base32"},
+			errors.New("unknown key encoding \"base32\" for /blah"),
+		},
+		{
+			"Negative batch chunk size",
+			Config{DriverName: "sqlite3", DataSource: "bar", BatchChunkSize: -1},
+			errors.New("negative batch chunk size for /blah"),
+		},
+		{
+			"Batch chunk size with several datasources",
+			Config{DriverName: "sqlite3", DataSources: []string{"foo", "bar"}, BatchChunkSize: 10},
+			errors.New("batch chunk size for /blah is not supported with several datasources"),
+		},
+		{
+			"Negative write-behind flush interval",
+			Config{DriverName: "sqlite3", DataSource: "bar", WriteBehind: WriteBehindSettings{FlushInterval: -time.Second}},
+			errors.New("negative write-behind flush interval for /blah"),
+		},
+		{
+			"Negative write-behind max pending",
+			Config{DriverName: "sqlite3", DataSource: "bar", WriteBehind: WriteBehindSettings{FlushInterval: time.Second, MaxPending: -1}},
+			errors.New("negative write-behind max pending for /blah"),
+		},
+		{
+			"Negative max db size",
+			Config{DriverName: "sqlite3", DataSource: "bar", MaxDBSize: -1},
+			errors.New("negative max db size for /blah"),
+		},
+		{
+			"Max db size without sqlite",
+			Config{DriverName: "mysql", DataSource: "bar", MaxDBSize: 1024},
+			errors.New("max db size for /blah requires the sqlite3 driver"),
+		},
+		{
+			"Unknown eviction policy",
+			Config{DriverName: "sqlite3", DataSource: "bar", MaxDBSize: 1024, EvictionPolicy: "lru"},
+			errors.New("unknown eviction policy \"lru\" for /blah"),
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,87 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// DurabilityFast favors throughput: the database is not synced to disk, so the
+	// latest writes can be lost, or the database corrupted, on a power loss or an OS crash.
+	DurabilityFast = "fast"
+	// DurabilityBalanced syncs the database at checkpoints: the latest writes can be lost on a
+	// power loss or an OS crash, but the database stays consistent.
+	DurabilityBalanced = "balanced"
+	// DurabilitySafe syncs every commit to disk, so committed writes survive a power loss or an OS crash.
+	DurabilitySafe = "safe"
+
+	sqliteDriverName = "sqlite3"
+	pgxDriverName    = "pgx"
+)
+
+// durabilityPragmas are the SQLite pragmas applied to every connection for each durability profile
+var durabilityPragmas = map[string][]string{
+	DurabilityFast: {
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=OFF",
+		"PRAGMA wal_autocheckpoint=10000",
+	},
+	DurabilityBalanced: {
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+		"PRAGMA wal_autocheckpoint=1000",
+	},
+	DurabilitySafe: {
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=FULL",
+		"PRAGMA wal_autocheckpoint=1000",
+	},
+}
+
+// sqliteConnector opens SQLite connections and runs the given pragmas on each of them.
+// Pragmas such as synchronous only apply to the connection they run on, so running them
+// once on the pool would leave the other connections with the defaults.
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func newSQLiteConnector(dsn string, pragmas []string) *sqliteConnector {
+	return &sqliteConnector{
+		dsn: dsn,
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				for _, pragma := range pragmas {
+					if _, err := conn.Exec(pragma, nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (c *sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -19,48 +19,218 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
 type databaseStorage struct {
-	driverName     string
-	datasourceName string
-	logger         *zap.Logger
-	db             *sql.DB
+	driverName      string
+	datasourceNames []string
+	// the secrets of the datasources are resolved when the extension starts
+	dsnSecret       SecretSettings
+	passwordSecret  SecretSettings
+	autoCreateTable bool
+	createIndexes   bool
+	schemaSetup     SchemaSetupSettings
+	eagerTables     []string
+	durability      string
+	maxConns        int
+	probeOnStart    bool
+	strict          bool
+	maxRetries      int
+	reconnect       ReconnectSettings
+	singleWriter    bool
+	keyEncoding     keyEncoding
+	sizeGuard       *sizeGuard
+	batchChunkSize  int
+	writeBehind     WriteBehindSettings
+	snapshotEvery   time.Duration
+	snapshotPath    string
+	compact         bool
+	logOperations   bool
+	limiters        []*connectionLimiter
+	logger          *zap.Logger
+	// dbs are the databases opened for each datasource, in the same order
+	dbs []*sql.DB
+	// readDBs are the pools reading the databases in single writer mode, in the same order
+	readDBs []*sql.DB
+	// reconnectors reconnect the databases, in the same order
+	reconnectors []*reconnector
+	// writeBehinds are the write-behind clients not closed yet, flushed on shutdown
+	writeBehinds     map[*writeBehindClient]struct{}
+	writeBehindsLock sync.Mutex
+	// done stops the periodic snapshots
+	done      chan struct{}
+	snapshots sync.WaitGroup
 }
 
 // Ensure this storage extension implements the appropriate interface
 var _ storage.Extension = (*databaseStorage)(nil)
 
 func newDBStorage(logger *zap.Logger, config *Config) (component.Extension, error) {
+	datasourceNames := config.dataSourceNames()
+	// the connection limit applies to each database
+	limiters := make([]*connectionLimiter, len(datasourceNames))
+	for i := range limiters {
+		limiters[i] = newConnectionLimiter(config.MaxOpenConnections, config.ConnectionAcquireTimeout)
+	}
 	return &databaseStorage{
-		driverName:     config.DriverName,
-		datasourceName: config.DataSource,
-		logger:         logger,
+		driverName:      config.DriverName,
+		datasourceNames: datasourceNames,
+		dsnSecret:       config.DataSourceSecret,
+		passwordSecret:  config.PasswordSecret,
+		autoCreateTable: config.AutoCreateTable,
+		createIndexes:   config.CreateIndexes,
+		schemaSetup:     config.SchemaSetup,
+		eagerTables:     config.eagerTables(),
+		durability:      config.DurabilityProfile,
+		maxConns:        config.MaxOpenConnections,
+		probeOnStart:    config.ProbeOnStart,
+		strict:          config.StrictNamespaces,
+		maxRetries:      config.MaxRetries,
+		reconnect:       config.Reconnect,
+		singleWriter:    config.SingleWriter,
+		keyEncoding:     keyEncoding(config.KeyEncoding),
+		sizeGuard:       newSizeGuard(config.MaxDBSize, config.EvictionPolicy),
+		batchChunkSize:  config.BatchChunkSize,
+		writeBehind:     config.WriteBehind,
+		writeBehinds:    map[*writeBehindClient]struct{}{},
+		snapshotEvery:   config.SnapshotInterval,
+		snapshotPath:    config.SnapshotPath,
+		compact:         config.CompactOnShutdown,
+		logOperations:   config.LogOperations,
+		limiters:        limiters,
+		logger:          logger,
 	}, nil
 }
 
-// Start opens a connection to the database
-func (ds *databaseStorage) Start(context.Context, component.Host) error {
-	db, err := sql.Open(ds.driverName, ds.datasourceName)
+// Start resolves the secrets of the datasources, opens a connection to the databases, probes them if configured to,
+// and creates the tables of eager table creation
+func (ds *databaseStorage) Start(ctx context.Context, _ component.Host) error {
+	datasourceNames, secrets, err := ds.resolveDataSources()
 	if err != nil {
 		return err
 	}
+	for i, datasourceName := range datasourceNames {
+		db, err := ds.open(datasourceName)
+		if err != nil {
+			return redactError(err, secrets)
+		}
+		ds.dbs = append(ds.dbs, db)
+		ds.reconnectors = append(ds.reconnectors, newReconnector(db, ds.reconnect))
 
-	if err := db.Ping(); err != nil {
+		if ds.singleWriter {
+			readDB, err := ds.openSingleWriter(ctx, db, datasourceName)
+			if err != nil {
+				return redactError(err, secrets)
+			}
+			ds.readDBs = append(ds.readDBs, readDB)
+		}
+
+		if ds.probeOnStart {
+			// the datasource is not part of the error, as it may hold credentials
+			if err = probe(ctx, db, ds.autoCreateTable); err != nil {
+				return fmt.Errorf("probe of datasource %d failed: %w", i, redactError(err, secrets))
+			}
+		}
+	}
+	if err = ds.createEagerTables(ctx); err != nil {
 		return err
 	}
-	ds.db = db
+
+	if ds.snapshotEvery > 0 {
+		ds.done = make(chan struct{})
+		ds.snapshots.Add(1)
+		go func() {
+			defer ds.snapshots.Done()
+			ds.snapshotPeriodically(ds.snapshotEvery, ds.snapshotPath, ds.done)
+		}()
+	}
+	return nil
+}
+
+// createEagerTables sets the tables of eager table creation up in every database, like the first client
+// requesting them would
+func (ds *databaseStorage) createEagerTables(ctx context.Context) error {
+	opts := clientOptions{
+		autoCreateTable: ds.autoCreateTable,
+		createIndexes:   ds.createIndexes,
+		sizeGuard:       ds.sizeGuard,
+		schemaSetup:     ds.schemaSetup,
+	}
+	for _, table := range ds.eagerTables {
+		for _, db := range ds.dbs {
+			if err := setupTable(ctx, db, table, ds.strict, opts); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-// Shutdown closes the connection to the database
-func (ds *databaseStorage) Shutdown(context.Context) error {
-	return ds.db.Close()
+func (ds *databaseStorage) open(datasourceName string) (*sql.DB, error) {
+	var db *sql.DB
+	if pragmas, ok := durabilityPragmas[ds.durability]; ok {
+		db = sql.OpenDB(newSQLiteConnector(datasourceName, pragmas))
+	} else {
+		var err error
+		if db, err = sql.Open(ds.driverName, datasourceName); err != nil {
+			return nil, err
+		}
+	}
+
+	db.SetMaxOpenConns(ds.maxConns)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openSingleWriter restricts the database to a single connection, serializing the writes, and opens the pool
+// the reads are made from. The write-ahead log lets the reads run while the writer is busy.
+func (ds *databaseStorage) openSingleWriter(ctx context.Context, db *sql.DB, datasourceName string) (*sql.DB, error) {
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode=WAL"); err != nil {
+		return nil, err
+	}
+	return ds.open(datasourceName)
+}
+
+// Shutdown writes the queued writes of the clients still open, compacts the databases if configured to, and closes
+// the connections to the databases
+func (ds *databaseStorage) Shutdown(ctx context.Context) error {
+	var errs error
+	ds.writeBehindsLock.Lock()
+	writeBehinds := make([]*writeBehindClient, 0, len(ds.writeBehinds))
+	for wb := range ds.writeBehinds {
+		writeBehinds = append(writeBehinds, wb)
+	}
+	ds.writeBehindsLock.Unlock()
+	for _, wb := range writeBehinds {
+		errs = multierr.Append(errs, wb.Close(ctx))
+	}
+
+	if ds.done != nil {
+		close(ds.done)
+		ds.snapshots.Wait()
+	}
+
+	if ds.compact {
+		errs = multierr.Append(errs, ds.compactDatabases(ctx))
+	}
+
+	for _, db := range append(ds.dbs, ds.readDBs...) {
+		errs = multierr.Append(errs, db.Close())
+	}
+	return errs
 }
 
 // GetClient returns a storage client for an individual component
@@ -72,7 +242,65 @@ func (ds *databaseStorage) GetClient(ctx context.Context, kind component.Kind, e
 		fullName = fmt.Sprintf("%s_%s_%s_%s", kindString(kind), ent.Type(), ent.Name(), name)
 	}
 	fullName = strings.ReplaceAll(fullName, " ", "")
-	return newClient(ctx, ds.db, fullName)
+
+	var namespace string
+	if ds.strict {
+		// unlike the table name, the namespace tells apart every client
+		namespace = fmt.Sprintf("%q %q %q %q", kindString(kind), ent.Type(), ent.Name(), name)
+	}
+
+	shards := make([]*dbStorageClient, len(ds.dbs))
+	for i, db := range ds.dbs {
+		var readDB *sql.DB
+		if ds.singleWriter {
+			readDB = ds.readDBs[i]
+		}
+		client, err := newClient(ctx, db, fullName, clientOptions{
+			autoCreateTable: ds.autoCreateTable,
+			createIndexes:   ds.createIndexes,
+			limiter:         ds.limiters[i],
+			namespace:       namespace,
+			maxRetries:      ds.maxRetries,
+			reconnector:     ds.reconnectors[i],
+			readDB:          readDB,
+			keyEncoding:     ds.keyEncoding,
+			sizeGuard:       ds.sizeGuard,
+			batchChunkSize:  ds.batchChunkSize,
+			schemaSetup:     ds.schemaSetup,
+		})
+		if err != nil {
+			for _, shard := range shards[:i] {
+				shard.Close(ctx)
+			}
+			return nil, err
+		}
+		shards[i] = client
+	}
+	var client DBClient = shards[0]
+	if len(shards) > 1 {
+		client = &shardedClient{ring: newHashRing(ds.datasourceNames), shards: shards}
+	}
+	if ds.writeBehind.FlushInterval > 0 {
+		client = ds.newWriteBehindClient(client)
+	}
+	if ds.logOperations {
+		client = newLoggingClient(client, ds.logger, fullName)
+	}
+	return client, nil
+}
+
+// newWriteBehindClient wraps the client in a write-behind queue, which is flushed on shutdown unless it is closed before
+func (ds *databaseStorage) newWriteBehindClient(client DBClient) *writeBehindClient {
+	ds.writeBehindsLock.Lock()
+	defer ds.writeBehindsLock.Unlock()
+	var wb *writeBehindClient
+	wb = newWriteBehindClient(client, ds.writeBehind.FlushInterval, ds.writeBehind.MaxPending, ds.logger, func() {
+		ds.writeBehindsLock.Lock()
+		defer ds.writeBehindsLock.Unlock()
+		delete(ds.writeBehinds, wb)
+	})
+	ds.writeBehinds[wb] = struct{}{}
+	return wb
 }
 
 func kindString(k component.Kind) string {
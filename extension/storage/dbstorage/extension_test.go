@@ -15,9 +15,12 @@
 package dbstorage
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"sync"
 	"testing"
 
@@ -106,6 +109,513 @@ func TestExtensionIntegrity(t *testing.T) {
 	wg.Wait()
 }
 
+func TestExtensionSharedKeysReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+	se := newTestExtension(t)
+	err := se.Start(context.Background(), componenttest.NewNopHost())
+	defer se.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	// Both clients are obtained for the same component, so they share keys
+	writer, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("shared"), "")
+	require.NoError(t, err)
+	defer writer.Close(ctx)
+	reader, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("shared"), "")
+	require.NoError(t, err)
+	defer reader.Close(ctx)
+
+	// require must not be called from the goroutines, they report their first failure instead
+	const workers = 4
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				value := []byte(fmt.Sprintf("%s-%d", key, j))
+				if err := writer.Set(ctx, key, value); err != nil {
+					errs <- fmt.Errorf("set %s: %w", key, err)
+					return
+				}
+
+				got, err := reader.Get(ctx, key)
+				if err != nil {
+					errs <- fmt.Errorf("get %s: %w", key, err)
+					return
+				}
+				if !bytes.Equal(value, got) {
+					errs <- fmt.Errorf("get %s: got %q, want %q", key, got, value)
+					return
+				}
+
+				if err = reader.Delete(ctx, key); err != nil {
+					errs <- fmt.Errorf("delete %s: %w", key, err)
+					return
+				}
+
+				got, err = writer.Get(ctx, key)
+				if err != nil {
+					errs <- fmt.Errorf("get deleted %s: %w", key, err)
+					return
+				}
+				if got != nil {
+					errs <- fmt.Errorf("get deleted %s: got %q, want nil", key, got)
+					return
+				}
+			}
+		}(fmt.Sprintf("key%d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestExtensionWithoutAutoCreateTable(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+
+	// Create the database file, the read-only connections below cannot create it
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/foo.db", tempDir))
+	require.NoError(t, err)
+	_, err = db.Exec("create table if not exists receiver_nop_provisioned (key text primary key, value blob)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// A read-only connection denies DDL statements
+	readOnlyDataSource := fmt.Sprintf("file:%s/foo.db?mode=ro", tempDir)
+
+	f := NewFactory()
+	cfg := f.CreateDefaultConfig().(*Config)
+	assert.True(t, cfg.AutoCreateTable)
+	cfg.DriverName = "sqlite3"
+	cfg.DataSource = readOnlyDataSource
+	cfg.AutoCreateTable = false
+	extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+	se := extension.(storage.Extension)
+	require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+	defer se.Shutdown(ctx)
+
+	_, err = se.GetClient(ctx, component.KindReceiver, newTestEntity("missing"), "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "table receiver_nop_missing is missing and auto_create_table is disabled")
+
+	client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("provisioned"), "")
+	require.NoError(t, err)
+	v, err := client.Get(ctx, "foo")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	require.NoError(t, client.Close(ctx))
+}
+
+func TestExtensionStrictNamespaces(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+
+	f := NewFactory()
+	cfg := f.CreateDefaultConfig().(*Config)
+	cfg.DriverName = "sqlite3"
+	cfg.DataSource = fmt.Sprintf("file:%s/foo.db?_busy_timeout=10000&_journal=WAL&_sync=NORMAL", tempDir)
+	cfg.StrictNamespaces = true
+	extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+	se := extension.(storage.Extension)
+	require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+	defer se.Shutdown(ctx)
+
+	// Both clients are backed by the receiver_nop_a_b table
+	client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("a_b"), "")
+	require.NoError(t, err)
+	defer client.Close(ctx)
+	colliding, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("a"), "b")
+	require.NoError(t, err)
+	defer colliding.Close(ctx)
+	same, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("a_b"), "")
+	require.NoError(t, err)
+	defer same.Close(ctx)
+
+	require.NoError(t, client.Set(ctx, "checkpoint", []byte("1")))
+
+	err = colliding.Set(ctx, "checkpoint", []byte("2"))
+	assert.ErrorIs(t, err, errNamespaceCollision)
+	assert.ErrorIs(t, colliding.Delete(ctx, "checkpoint"), errNamespaceCollision)
+	_, err = colliding.(DBClient).SetWithResult(ctx, "checkpoint", []byte("2"))
+	assert.ErrorIs(t, err, errNamespaceCollision)
+	_, err = colliding.(DBClient).DeleteWithResult(ctx, "checkpoint")
+	assert.ErrorIs(t, err, errNamespaceCollision)
+	tx, err := colliding.(DBClient).Begin(ctx)
+	require.NoError(t, err)
+	assert.ErrorIs(t, tx.Set(ctx, "checkpoint", []byte("2")), errNamespaceCollision)
+	require.NoError(t, tx.Rollback())
+
+	value, err := client.Get(ctx, "checkpoint")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	// Clients of the same namespace share their keys
+	require.NoError(t, same.Set(ctx, "checkpoint", []byte("3")))
+	value, err = client.Get(ctx, "checkpoint")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), value)
+
+	// Deleting a missing key is not a collision
+	require.NoError(t, colliding.Delete(ctx, "missing"))
+	require.NoError(t, client.Delete(ctx, "checkpoint"))
+	require.NoError(t, colliding.Set(ctx, "checkpoint", []byte("4")))
+}
+
+func TestExtensionSingleWriter(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+
+	f := NewFactory()
+	cfg := f.CreateDefaultConfig().(*Config)
+	cfg.DriverName = "sqlite3"
+	// Without a busy timeout, concurrent writes from several connections fail right away
+	cfg.DataSource = fmt.Sprintf("file:%s/foo.db?_busy_timeout=0", tempDir)
+	cfg.SingleWriter = true
+	require.NoError(t, cfg.Validate())
+	extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+	se := extension.(storage.Extension)
+	require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+	defer se.Shutdown(ctx)
+
+	const numClients, numWrites = 10, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, numClients*numWrites*2)
+	for i := 0; i < numClients; i++ {
+		client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity(fmt.Sprintf("client%d", i)), "")
+		require.NoError(t, err)
+		defer client.Close(ctx)
+
+		wg.Add(1)
+		go func(client storage.Client) {
+			defer wg.Done()
+			for j := 0; j < numWrites; j++ {
+				key := fmt.Sprintf("key%d", j)
+				if err := client.Set(ctx, key, []byte(key)); err != nil {
+					errs <- err
+				}
+				if _, err := client.Get(ctx, key); err != nil {
+					errs <- err
+				}
+			}
+		}(client)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var journalMode string
+	require.NoError(t, extension.(*databaseStorage).readDBs[0].QueryRow("PRAGMA journal_mode").Scan(&journalMode))
+	assert.Equal(t, "wal", journalMode)
+}
+
+func TestExtensionProbeOnStart(t *testing.T) {
+	tests := []struct {
+		name            string
+		autoCreateTable bool
+		provisioned     bool
+		readOnly        bool
+		wantErr         string
+	}{
+		{
+			name:            "auto created table",
+			autoCreateTable: true,
+		},
+		{
+			name:        "provisioned table",
+			provisioned: true,
+		},
+		{
+			name:    "missing table",
+			wantErr: "probe of datasource 0 failed: table extension_db_storage_probe is missing",
+		},
+		{
+			name:        "read-only database",
+			provisioned: true,
+			readOnly:    true,
+			wantErr:     "probe of datasource 0 failed: attempt to write a readonly database",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tempDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/foo.db", tempDir))
+			require.NoError(t, err)
+			defer db.Close()
+			if tt.provisioned {
+				_, err = db.Exec("create table extension_db_storage_probe (key text primary key, value blob)")
+				require.NoError(t, err)
+			}
+
+			f := NewFactory()
+			cfg := f.CreateDefaultConfig().(*Config)
+			cfg.DriverName = "sqlite3"
+			cfg.DataSource = fmt.Sprintf("file:%s/foo.db", tempDir)
+			if tt.readOnly {
+				cfg.DataSource += "?mode=ro"
+			}
+			cfg.AutoCreateTable = tt.autoCreateTable
+			cfg.ProbeOnStart = true
+			extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+			require.NoError(t, err)
+			err = extension.Start(ctx, componenttest.NewNopHost())
+			defer extension.Shutdown(ctx)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var tables int
+			require.NoError(t, db.QueryRow("select count(*) from sqlite_master where name = 'extension_db_storage_probe'").Scan(&tables))
+			if !tt.provisioned {
+				// the table created for the probe is dropped
+				assert.Equal(t, 0, tables)
+				return
+			}
+			// the probe key is removed from the provisioned table
+			assert.Equal(t, 1, tables)
+			var keys int
+			require.NoError(t, db.QueryRow("select count(*) from extension_db_storage_probe").Scan(&keys))
+			assert.Equal(t, 0, keys)
+		})
+	}
+}
+
+func TestExtensionDurabilityProfile(t *testing.T) {
+	tests := []struct {
+		profile        string
+		journalMode    string
+		synchronous    int
+		walCheckpoints int
+	}{
+		{profile: DurabilityFast, journalMode: "wal", synchronous: 0, walCheckpoints: 10000},
+		{profile: DurabilityBalanced, journalMode: "wal", synchronous: 1, walCheckpoints: 1000},
+		{profile: DurabilitySafe, journalMode: "wal", synchronous: 2, walCheckpoints: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			ctx := context.Background()
+			tempDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+
+			f := NewFactory()
+			cfg := f.CreateDefaultConfig().(*Config)
+			cfg.DriverName = "sqlite3"
+			// The profile overrides the settings of the datasource
+			cfg.DataSource = fmt.Sprintf("file:%s/foo.db?_journal=DELETE&_sync=EXTRA", tempDir)
+			cfg.DurabilityProfile = tt.profile
+			require.NoError(t, cfg.Validate())
+			extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+			require.NoError(t, err)
+			require.NoError(t, extension.Start(ctx, componenttest.NewNopHost()))
+			defer extension.Shutdown(ctx)
+
+			db := extension.(*databaseStorage).dbs[0]
+			// Check several connections of the pool
+			db.SetMaxIdleConns(3)
+			conns := make([]*sql.Conn, 3)
+			for i := range conns {
+				conns[i], err = db.Conn(ctx)
+				require.NoError(t, err)
+			}
+			for _, conn := range conns {
+				var journalMode string
+				var synchronous, walCheckpoints int
+				require.NoError(t, conn.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode))
+				require.NoError(t, conn.QueryRowContext(ctx, "PRAGMA synchronous").Scan(&synchronous))
+				require.NoError(t, conn.QueryRowContext(ctx, "PRAGMA wal_autocheckpoint").Scan(&walCheckpoints))
+				assert.Equal(t, tt.journalMode, journalMode)
+				assert.Equal(t, tt.synchronous, synchronous)
+				assert.Equal(t, tt.walCheckpoints, walCheckpoints)
+				require.NoError(t, conn.Close())
+			}
+
+			client, err := extension.(storage.Extension).GetClient(ctx, component.KindReceiver, newTestEntity("durable"), "")
+			require.NoError(t, err)
+			require.NoError(t, client.Set(ctx, "key", []byte("value")))
+			require.NoError(t, client.Close(ctx))
+		})
+	}
+}
+
+func TestExtensionIndexes(t *testing.T) {
+	tests := []struct {
+		name          string
+		createIndexes bool
+		want          map[string]string
+	}{
+		{
+			name:          "default",
+			createIndexes: true,
+			want:          map[string]string{"sqlite_autoindex_receiver_nop_indexed_1": "pk", "receiver_nop_indexed_updated_at": "c"},
+		},
+		{
+			name: "without secondary indexes",
+			want: map[string]string{"sqlite_autoindex_receiver_nop_indexed_1": "pk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dataSource := newTestDataSource(t)
+			f := NewFactory()
+			cfg := f.CreateDefaultConfig().(*Config)
+			assert.True(t, cfg.CreateIndexes)
+			cfg.DriverName = "sqlite3"
+			cfg.DataSource = dataSource
+			cfg.MaxDBSize = 1 << 20
+			cfg.CreateIndexes = tt.createIndexes
+			extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+			require.NoError(t, err)
+			se := extension.(storage.Extension)
+			require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+			defer se.Shutdown(ctx)
+			client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("indexed"), "")
+			require.NoError(t, err)
+			defer client.Close(ctx)
+
+			db, err := sql.Open("sqlite3", dataSource)
+			require.NoError(t, err)
+			defer db.Close()
+			rows, err := db.Query("select name, origin from pragma_index_list('receiver_nop_indexed')")
+			require.NoError(t, err)
+			defer rows.Close()
+			indexes := map[string]string{}
+			for rows.Next() {
+				var name, origin string
+				require.NoError(t, rows.Scan(&name, &origin))
+				indexes[name] = origin
+			}
+			require.NoError(t, rows.Err())
+			assert.Equal(t, tt.want, indexes)
+		})
+	}
+}
+
+func TestExtensionTablePerKind(t *testing.T) {
+	ctx := context.Background()
+	se := newTestExtension(t)
+	require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+	defer se.Shutdown(ctx)
+
+	// The same component ID used by a receiver and an exporter gets a table for each kind
+	receiver, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("same"), "")
+	require.NoError(t, err)
+	defer receiver.Close(ctx)
+	exporter, err := se.GetClient(ctx, component.KindExporter, newTestEntity("same"), "")
+	require.NoError(t, err)
+	defer exporter.Close(ctx)
+
+	assert.Equal(t, "receiver_nop_same", receiver.(*dbStorageClient).tableName)
+	assert.Equal(t, "exporter_nop_same", exporter.(*dbStorageClient).tableName)
+
+	require.NoError(t, receiver.Set(ctx, "checkpoint", []byte("receiver")))
+	value, err := exporter.Get(ctx, "checkpoint")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestExtensionTableCreation(t *testing.T) {
+	tests := []struct {
+		name          string
+		tableCreation string
+		tables        []string
+		wantAtStart   []string
+	}{
+		{
+			name:        "lazy by default",
+			wantAtStart: nil,
+		},
+		{
+			name:          "eager",
+			tableCreation: tableCreationEager,
+			tables:        []string{"receiver_nop_eager", "exporter_nop_eager_queue"},
+			wantAtStart:   []string{"exporter_nop_eager_queue", "receiver_nop_eager"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dataSource := newTestDataSource(t)
+			f := NewFactory()
+			cfg := f.CreateDefaultConfig().(*Config)
+			cfg.DriverName = "sqlite3"
+			cfg.DataSource = dataSource
+			cfg.TableCreation = tt.tableCreation
+			cfg.Tables = tt.tables
+			// the probe table is dropped once the probe completes
+			cfg.ProbeOnStart = true
+			require.NoError(t, cfg.Validate())
+			extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+			require.NoError(t, err)
+			se := extension.(storage.Extension)
+			require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+			defer se.Shutdown(ctx)
+
+			db, err := sql.Open("sqlite3", dataSource)
+			require.NoError(t, err)
+			defer db.Close()
+			assert.Equal(t, tt.wantAtStart, tableNames(t, db))
+
+			// the table of a client is created on first use, no table is created for the other components
+			client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("used"), "")
+			require.NoError(t, err)
+			defer client.Close(ctx)
+			want := append([]string{"receiver_nop_used"}, tt.wantAtStart...)
+			sort.Strings(want)
+			assert.Equal(t, want, tableNames(t, db))
+		})
+	}
+}
+
+func TestExtensionEagerTableCreationWithoutAutoCreateTable(t *testing.T) {
+	ctx := context.Background()
+	f := NewFactory()
+	cfg := f.CreateDefaultConfig().(*Config)
+	cfg.DriverName = "sqlite3"
+	cfg.DataSource = newTestDataSource(t)
+	cfg.AutoCreateTable = false
+	cfg.TableCreation = tableCreationEager
+	cfg.Tables = []string{"receiver_nop_missing"}
+	extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+
+	// the tables must be provisioned, which is checked when the extension starts
+	err = extension.Start(ctx, componenttest.NewNopHost())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "table receiver_nop_missing is missing and auto_create_table is disabled")
+	require.NoError(t, extension.Shutdown(ctx))
+}
+
+func tableNames(t *testing.T, db *sql.DB) []string {
+	rows, err := db.Query("select name from sqlite_master where type = 'table' order by name")
+	require.NoError(t, err)
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	return names
+}
+
 func newTestExtension(t *testing.T) storage.Extension {
 	tempDir, err := ioutil.TempDir("", "")
 	require.NoError(t, err)
@@ -17,6 +17,7 @@ package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-c
 import (
 	"context"
 
+	"go.opencensus.io/stats/view"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/extension/extensionhelper"
@@ -27,6 +28,8 @@ const typeStr config.Type = "db_storage"
 
 // NewFactory creates a factory for DBStorage extension.
 func NewFactory() component.ExtensionFactory {
+	_ = view.Register(MetricViews()...)
+
 	return extensionhelper.NewFactory(
 		typeStr,
 		createDefaultConfig,
@@ -36,6 +39,11 @@ func NewFactory() component.ExtensionFactory {
 func createDefaultConfig() config.Extension {
 	return &Config{
 		ExtensionSettings: config.NewExtensionSettings(config.NewComponentID(typeStr)),
+		AutoCreateTable:   true,
+		CreateIndexes:     true,
+		SchemaSetup: SchemaSetupSettings{
+			MaxRetries: defaultSchemaSetupRetries,
+		},
 	}
 }
 
@@ -0,0 +1,59 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// keyEncoding is the encoding of the keys stored in the key column: "hex", "base64", or empty to store them as is
+type keyEncoding string
+
+const (
+	keyEncodingHex    keyEncoding = "hex"
+	keyEncodingBase64 keyEncoding = "base64"
+)
+
+// valid tells whether the encoding is supported
+func (e keyEncoding) valid() bool {
+	return e == "" || e == keyEncodingHex || e == keyEncodingBase64
+}
+
+// encode returns the stored form of a key
+func (e keyEncoding) encode(key string) string {
+	switch e {
+	case keyEncodingHex:
+		return hex.EncodeToString([]byte(key))
+	case keyEncodingBase64:
+		return base64.StdEncoding.EncodeToString([]byte(key))
+	}
+	return key
+}
+
+// decode returns the key of its stored form
+func (e keyEncoding) decode(stored string) (string, error) {
+	var key []byte
+	var err error
+	switch e {
+	case keyEncodingHex:
+		key, err = hex.DecodeString(stored)
+	case keyEncodingBase64:
+		key, err = base64.StdEncoding.DecodeString(stored)
+	default:
+		return stored, nil
+	}
+	return string(key), err
+}
@@ -0,0 +1,76 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opencensus.io/stats"
+)
+
+var errConnectionAcquireTimeout = errors.New("timed out waiting for a database connection")
+
+// connectionLimiter bounds the number of operations holding a connection of the pool, so that waiting
+// for a connection when all of them are in use can be bounded in time and measured.
+// A nil connectionLimiter does not limit anything.
+type connectionLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newConnectionLimiter(maxConnections int, timeout time.Duration) *connectionLimiter {
+	if maxConnections <= 0 {
+		return nil
+	}
+	return &connectionLimiter{
+		slots:   make(chan struct{}, maxConnections),
+		timeout: timeout,
+	}
+}
+
+// acquire waits for a connection to be available, and returns the function releasing it
+func (l *connectionLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	stats.Record(ctx, mConnectionWaits.M(1))
+	var timeout <-chan time.Time
+	if l.timeout > 0 {
+		timer := time.NewTimer(l.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		stats.Record(ctx, mConnectionTimeouts.M(1))
+		return nil, errConnectionAcquireTimeout
+	}
+}
+
+func (l *connectionLimiter) release() {
+	<-l.slots
+}
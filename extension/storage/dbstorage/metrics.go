@@ -0,0 +1,57 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	mConnectionWaits    = stats.Int64("db_storage_connection_waits", "Number of operations that waited for a database connection because all of them were in use", stats.UnitDimensionless)
+	mConnectionTimeouts = stats.Int64("db_storage_connection_timeouts", "Number of operations that timed out waiting for a database connection", stats.UnitDimensionless)
+	mReconnectAttempts  = stats.Int64("db_storage_reconnect_attempts", "Number of pings made to reconnect a database whose connection failed", stats.UnitDimensionless)
+	mReconnects         = stats.Int64("db_storage_reconnects", "Number of reconnections to a database whose connection failed", stats.UnitDimensionless)
+)
+
+// MetricViews return the metrics views according to given telemetry level.
+func MetricViews() []*view.View {
+	return []*view.View{
+		{
+			Name:        mConnectionWaits.Name(),
+			Measure:     mConnectionWaits,
+			Description: mConnectionWaits.Description(),
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        mConnectionTimeouts.Name(),
+			Measure:     mConnectionTimeouts,
+			Description: mConnectionTimeouts.Description(),
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        mReconnectAttempts.Name(),
+			Measure:     mReconnectAttempts,
+			Description: mReconnectAttempts.Description(),
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        mReconnects.Name(),
+			Measure:     mReconnects,
+			Description: mReconnects.Description(),
+			Aggregation: view.Sum(),
+		},
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.uber.org/zap"
+)
+
+// loggingClient logs the operations of a client at the debug level, with their key, duration and outcome. Values
+// are not logged, only their size. Clients are only wrapped when the operations are logged, so that the logging
+// costs nothing otherwise.
+type loggingClient struct {
+	client DBClient
+	logger *zap.Logger
+}
+
+// Ensure the logging client implements the same interface as the one it wraps
+var _ DBClient = (*loggingClient)(nil)
+
+// newLoggingClient wraps the client of a namespace, named after the table of the component
+func newLoggingClient(client DBClient, logger *zap.Logger, namespace string) *loggingClient {
+	return &loggingClient{client: client, logger: logger.With(zap.String("namespace", namespace))}
+}
+
+func (c *loggingClient) log(op string, start time.Time, err error, fields ...zap.Field) {
+	fields = append(fields, zap.String("op", op), zap.Duration("latency", time.Since(start)))
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	c.logger.Debug("Storage operation", fields...)
+}
+
+// Get will retrieve data from storage that corresponds to the specified key
+func (c *loggingClient) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	value, err := c.client.Get(ctx, key)
+	c.log("get", start, err, zap.String("key", key), zap.Bool("found", value != nil), zap.Int("value_bytes", len(value)))
+	return value, err
+}
+
+// Set will store data. The data can be retrieved using the same key
+func (c *loggingClient) Set(ctx context.Context, key string, value []byte) error {
+	start := time.Now()
+	err := c.client.Set(ctx, key, value)
+	c.log("set", start, err, zap.String("key", key), zap.Int("value_bytes", len(value)))
+	return err
+}
+
+// Delete will delete data associated with the specified key
+func (c *loggingClient) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := c.client.Delete(ctx, key)
+	c.log("delete", start, err, zap.String("key", key))
+	return err
+}
+
+// SetWithResult stores data like Set, and reports whether the key was inserted or updated
+func (c *loggingClient) SetWithResult(ctx context.Context, key string, value []byte) (WriteResult, error) {
+	start := time.Now()
+	result, err := c.client.SetWithResult(ctx, key, value)
+	c.log("set", start, err, zap.String("key", key), zap.Int("value_bytes", len(value)))
+	return result, err
+}
+
+// DeleteWithResult deletes data like Delete, and reports whether the key was deleted
+func (c *loggingClient) DeleteWithResult(ctx context.Context, key string) (WriteResult, error) {
+	start := time.Now()
+	result, err := c.client.DeleteWithResult(ctx, key)
+	c.log("delete", start, err, zap.String("key", key))
+	return result, err
+}
+
+// Batch executes the specified operations in order. Get operation results are updated in place
+func (c *loggingClient) Batch(ctx context.Context, ops ...storage.Operation) error {
+	start := time.Now()
+	err := c.client.Batch(ctx, ops...)
+	c.log("batch", start, err, zap.Int("operations", len(ops)))
+	return err
+}
+
+// FindByValuePrefix returns the keys whose value starts with prefix
+func (c *loggingClient) FindByValuePrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	return c.client.FindByValuePrefix(ctx, prefix)
+}
+
+// Stats describes the entries of the wrapped client
+func (c *loggingClient) Stats(ctx context.Context) (StoreStats, error) {
+	return c.client.Stats(ctx)
+}
+
+// Begin starts a transaction on the wrapped client, whose operations are not logged
+func (c *loggingClient) Begin(ctx context.Context) (Tx, error) {
+	return c.client.Begin(ctx)
+}
+
+// Close closes the wrapped client
+func (c *loggingClient) Close(ctx context.Context) error {
+	return c.client.Close(ctx)
+}
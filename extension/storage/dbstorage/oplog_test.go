@@ -0,0 +1,73 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestExtensionLogOperations(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			ctx := context.Background()
+			tempDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			core, logs := observer.New(zap.DebugLevel)
+
+			cfg := NewFactory().CreateDefaultConfig().(*Config)
+			cfg.DriverName = "sqlite3"
+			cfg.DataSource = fmt.Sprintf("file:%s/foo.db?_busy_timeout=10000&_journal=WAL&_sync=NORMAL", tempDir)
+			cfg.LogOperations = enabled
+			extension, err := newDBStorage(zap.New(core), cfg)
+			require.NoError(t, err)
+			require.NoError(t, extension.Start(ctx, componenttest.NewNopHost()))
+			defer extension.Shutdown(ctx)
+
+			client, err := extension.(*databaseStorage).GetClient(ctx, component.KindReceiver, newTestEntity("oplog"), "")
+			require.NoError(t, err)
+			require.NoError(t, client.Set(ctx, "key", []byte("secret value")))
+			_, err = client.Get(ctx, "key")
+			require.NoError(t, err)
+			require.NoError(t, client.Delete(ctx, "key"))
+			require.NoError(t, client.Close(ctx))
+
+			entries := logs.FilterMessage("Storage operation").All()
+			if !enabled {
+				assert.Empty(t, entries)
+				return
+			}
+			require.Len(t, entries, 3)
+			for i, op := range []string{"set", "get", "delete"} {
+				fields := entries[i].ContextMap()
+				assert.Equal(t, op, fields["op"])
+				assert.Equal(t, "key", fields["key"])
+				assert.Equal(t, "receiver_nop_oplog", fields["namespace"])
+				assert.Contains(t, fields, "latency")
+				assert.NotContains(t, fmt.Sprint(fields), "secret value")
+			}
+			assert.Equal(t, int64(len("secret value")), entries[0].ContextMap()["value_bytes"])
+		})
+	}
+}
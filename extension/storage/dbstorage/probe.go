@@ -0,0 +1,61 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// probeTableName is the table the probe writes to. It must be provisioned when auto_create_table is disabled.
+	probeTableName = "extension_db_storage_probe"
+	dropTable      = "drop table if exists %s"
+)
+
+// probe checks that the database is usable by running a Set/Get/Delete round-trip on a temporary key.
+// The key is deleted afterwards, and so is the probe table when the extension is allowed to create it.
+func probe(ctx context.Context, db *sql.DB, autoCreateTable bool) (err error) {
+	client, err := newClient(ctx, db, probeTableName, clientOptions{autoCreateTable: autoCreateTable})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := client.Close(ctx); err == nil {
+			err = closeErr
+		}
+		if autoCreateTable && err == nil {
+			_, err = db.ExecContext(ctx, fmt.Sprintf(dropTable, probeTableName))
+		}
+	}()
+
+	key := "probe_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	value := []byte(key)
+	if err = client.Set(ctx, key, value); err != nil {
+		return err
+	}
+	got, err := client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, value) {
+		return fmt.Errorf("read %q back from the probe key instead of %q", got, value)
+	}
+	return client.Delete(ctx, key)
+}
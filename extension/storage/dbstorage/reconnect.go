@@ -0,0 +1,128 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+)
+
+const (
+	defaultReconnectMaxInterval    = 30 * time.Second
+	defaultReconnectMaxElapsedTime = time.Minute
+)
+
+// reconnector pings a database whose connection failed until it answers. The callers detecting the failure while a
+// reconnection is in progress wait for its outcome instead of pinging the database too.
+type reconnector struct {
+	db       *sql.DB
+	settings ReconnectSettings
+	// random returns a number in [0, 1) randomizing the delays
+	random func() float64
+
+	lock sync.Mutex
+	// current is the reconnection in progress, nil when there is none
+	current *reconnection
+}
+
+// reconnection is the outcome of a reconnection, set once done is closed
+type reconnection struct {
+	done chan struct{}
+	err  error
+}
+
+// newReconnector returns the reconnector of a database, nil when reconnection is disabled
+func newReconnector(db *sql.DB, settings ReconnectSettings) *reconnector {
+	if settings.InitialInterval <= 0 {
+		return nil
+	}
+	if settings.MaxInterval <= 0 {
+		settings.MaxInterval = defaultReconnectMaxInterval
+	}
+	if settings.MaxElapsedTime <= 0 {
+		settings.MaxElapsedTime = defaultReconnectMaxElapsedTime
+	}
+	return &reconnector{db: db, settings: settings, random: rand.Float64}
+}
+
+// reconnect waits for the database to answer a ping, starting a reconnection unless one is in progress. The
+// reconnection goes on when the context of the caller is done, for the other callers waiting for it.
+func (r *reconnector) reconnect(ctx context.Context) error {
+	r.lock.Lock()
+	current := r.current
+	if current == nil {
+		current = &reconnection{done: make(chan struct{})}
+		r.current = current
+		go func() {
+			current.err = r.ping()
+			r.lock.Lock()
+			r.current = nil
+			r.lock.Unlock()
+			close(current.done)
+		}()
+	}
+	r.lock.Unlock()
+
+	select {
+	case <-current.done:
+		return current.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ping pings the database with a backoff until it answers or the maximum elapsed time is reached
+func (r *reconnector) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.settings.MaxElapsedTime)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		stats.Record(ctx, mReconnectAttempts.M(1))
+		err := r.db.PingContext(ctx)
+		if err == nil {
+			stats.Record(ctx, mReconnects.M(1))
+			return nil
+		}
+		timer := time.NewTimer(r.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the delay after a failed ping, counted from 0: the initial interval doubled after every ping up to
+// the maximum interval, less a random fraction of up to the jitter
+func (r *reconnector) delay(attempt int) time.Duration {
+	delay, max := r.settings.InitialInterval, r.settings.MaxInterval
+	for i := 0; i < attempt && delay < max; i++ {
+		// doubling past the maximum could overflow
+		if delay > max/2 {
+			delay = max
+		} else {
+			delay *= 2
+		}
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay - time.Duration(r.settings.Jitter*r.random()*float64(delay))
+}
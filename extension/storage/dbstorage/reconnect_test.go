@@ -0,0 +1,162 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyDriver fails to connect while its database is down, counting the attempts
+type flakyDriver struct {
+	down  int32
+	opens int32
+}
+
+func (d *flakyDriver) Open(string) (driver.Conn, error) {
+	atomic.AddInt32(&d.opens, 1)
+	if atomic.LoadInt32(&d.down) != 0 {
+		return nil, errors.New("connection refused")
+	}
+	return flakyConn{}, nil
+}
+
+type flakyConn struct{}
+
+func (flakyConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (flakyConn) Close() error                        { return nil }
+func (flakyConn) Begin() (driver.Tx, error)           { return nil, errors.New("not implemented") }
+
+var testFlakyDriver = &flakyDriver{}
+
+func init() {
+	sql.Register("dbstorage_flaky", testFlakyDriver)
+}
+
+func TestReconnectorDelay(t *testing.T) {
+	r := newReconnector(nil, ReconnectSettings{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second})
+	r.random = func() float64 { return 0.5 }
+	var delays []time.Duration
+	for attempt := 0; attempt < 6; attempt++ {
+		delays = append(delays, r.delay(attempt))
+	}
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second,
+	}, delays)
+	assert.Equal(t, time.Second, r.delay(1000))
+
+	// the jitter takes up to its fraction off every delay
+	r.settings.Jitter = 0.2
+	assert.Equal(t, 90*time.Millisecond, r.delay(0))
+	assert.Equal(t, 900*time.Millisecond, r.delay(4))
+	r.random = func() float64 { return 0 }
+	assert.Equal(t, time.Second, r.delay(4))
+}
+
+func TestReconnectorDefaults(t *testing.T) {
+	assert.Nil(t, newReconnector(nil, ReconnectSettings{}))
+	r := newReconnector(nil, ReconnectSettings{InitialInterval: time.Second})
+	assert.Equal(t, defaultReconnectMaxInterval, r.settings.MaxInterval)
+	assert.Equal(t, defaultReconnectMaxElapsedTime, r.settings.MaxElapsedTime)
+}
+
+func TestReconnectorDeduplicated(t *testing.T) {
+	db, err := sql.Open("dbstorage_flaky", "")
+	require.NoError(t, err)
+	defer db.Close()
+	atomic.StoreInt32(&testFlakyDriver.down, 1)
+	atomic.StoreInt32(&testFlakyDriver.opens, 0)
+	r := newReconnector(db, ReconnectSettings{InitialInterval: 10 * time.Millisecond, MaxInterval: 20 * time.Millisecond})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = r.reconnect(context.Background())
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(&testFlakyDriver.down, 0)
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	// a single reconnection pinged the database every 20ms at most, instead of every caller pinging it
+	opens := atomic.LoadInt32(&testFlakyDriver.opens)
+	assert.Greater(t, opens, int32(1))
+	assert.Less(t, opens, int32(len(errs)))
+	assert.Nil(t, r.current)
+}
+
+func TestReconnectorMaxElapsedTime(t *testing.T) {
+	db, err := sql.Open("dbstorage_flaky", "")
+	require.NoError(t, err)
+	defer db.Close()
+	atomic.StoreInt32(&testFlakyDriver.down, 1)
+	defer atomic.StoreInt32(&testFlakyDriver.down, 0)
+	r := newReconnector(db, ReconnectSettings{InitialInterval: 10 * time.Millisecond, MaxElapsedTime: 50 * time.Millisecond})
+
+	assert.EqualError(t, r.reconnect(context.Background()), "connection refused")
+
+	// callers stop waiting when their context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, r.reconnect(ctx), context.Canceled)
+}
+
+func TestClientRetryReconnects(t *testing.T) {
+	db, err := sql.Open("dbstorage_flaky", "")
+	require.NoError(t, err)
+	defer db.Close()
+	atomic.StoreInt32(&testFlakyDriver.down, 0)
+	atomic.StoreInt32(&testFlakyDriver.opens, 0)
+	client := &dbStorageClient{
+		maxRetries:  1,
+		reconnector: newReconnector(db, ReconnectSettings{InitialInterval: 10 * time.Millisecond}),
+	}
+
+	// a connection error reconnects the database before the statement is retried
+	calls := 0
+	require.NoError(t, client.retry(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return driver.ErrBadConn
+		}
+		return nil
+	}))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&testFlakyDriver.opens))
+
+	// other errors do not
+	calls = 0
+	assert.Error(t, client.retry(context.Background(), func() error {
+		calls++
+		return errors.New("constraint violation")
+	}))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&testFlakyDriver.opens))
+}
@@ -0,0 +1,87 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// retryDelay is the delay before the first retry, doubled on every following one
+const retryDelay = 10 * time.Millisecond
+
+// PostgreSQL error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgSerializationFailure    = "40001"
+	pgDeadlockDetected        = "40P01"
+	pgConnectionExceptionCode = "08"
+)
+
+// isRetryable tells whether an error is transient, such as a lock held by another connection, a deadlock,
+// a serialization failure or a reset connection, so that running the statement again may succeed.
+// Other errors, e.g. constraint violations, are permanent.
+func isRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected) {
+		return true
+	}
+	return isConnectionError(err)
+}
+
+// isConnectionError tells whether an error comes from a failed or lost connection to the database
+func isConnectionError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, pgConnectionExceptionCode)
+	}
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retry runs op until it succeeds, fails with a permanent error, or maxRetries retries have been made
+func retry(ctx context.Context, maxRetries int, op func() error) error {
+	return retryIf(ctx, maxRetries, isRetryable, op)
+}
+
+// retryIf runs op until it succeeds, fails with an error that is not retryable, or maxRetries retries have been made
+func retryIf(ctx context.Context, maxRetries int, retryable func(error) bool, op func() error) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= maxRetries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "sqlite busy", err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: true},
+		{name: "sqlite locked", err: sqlite3.Error{Code: sqlite3.ErrLocked}, want: true},
+		{name: "sqlite constraint", err: sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}},
+		{name: "sqlite read-only", err: sqlite3.Error{Code: sqlite3.ErrReadonly}},
+		{name: "wrapped sqlite busy", err: fmt.Errorf("set: %w", sqlite3.Error{Code: sqlite3.ErrBusy}), want: true},
+		{name: "postgres serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "postgres deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "postgres connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "postgres unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "postgres undefined table", err: &pgconn.PgError{Code: "42P01"}},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: true},
+		{name: "canceled", err: context.Canceled},
+		{name: "other", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryable(tt.err))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	constraint := sqlite3.Error{Code: sqlite3.ErrConstraint}
+
+	calls := 0
+	err := retry(context.Background(), 3, func() error {
+		calls++
+		if calls < 3 {
+			return busy
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// permanent errors fail fast
+	calls = 0
+	err = retry(context.Background(), 3, func() error {
+		calls++
+		return constraint
+	})
+	assert.Equal(t, constraint, err)
+	assert.Equal(t, 1, calls)
+
+	// retries are bounded
+	calls = 0
+	err = retry(context.Background(), 2, func() error {
+		calls++
+		return busy
+	})
+	assert.Equal(t, busy, err)
+	assert.Equal(t, 3, calls)
+
+	// no retries by default
+	calls = 0
+	err = retry(context.Background(), 0, func() error {
+		calls++
+		return busy
+	})
+	assert.Equal(t, busy, err)
+	assert.Equal(t, 1, calls)
+}
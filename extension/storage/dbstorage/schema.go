@@ -0,0 +1,71 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// defaultSchemaSetupRetries is the default number of times a conflicting schema setup is run again
+const defaultSchemaSetupRetries = 3
+
+// PostgreSQL error codes of the schema statements colliding with the ones of another connection. Even with
+// "if not exists", concurrent creations of the same table collide on the unique index of the catalog.
+const (
+	pgUniqueViolation = "23505"
+	pgDuplicateTable  = "42P07"
+	pgDuplicateColumn = "42701"
+	pgDuplicateObject = "42710"
+)
+
+// schemaQueryer runs the statements setting up the schema
+type schemaQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// isSchemaConflict tells whether a schema statement failed because another collector was setting up the same
+// table, so that running the setup again finds the objects already created. Transient errors conflict as well.
+func isSchemaConflict(err error) bool {
+	if isRetryable(err) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		msg := sqliteErr.Error()
+		return strings.Contains(msg, "duplicate column name") || strings.Contains(msg, "already exists")
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgUniqueViolation || pgErr.Code == pgDuplicateTable ||
+			pgErr.Code == pgDuplicateColumn || pgErr.Code == pgDuplicateObject
+	}
+	return false
+}
+
+// setupSchema runs the statements setting up the table of a client, which may collide with the ones of other
+// collectors starting against the same database. The setups conflicting are run again, finding the objects the
+// other collectors created.
+func setupSchema(ctx context.Context, db *sql.DB, settings SchemaSetupSettings, setup func(q schemaQueryer) error) error {
+	return retryIf(ctx, settings.MaxRetries, isSchemaConflict, func() error {
+		return setup(db)
+	})
+}
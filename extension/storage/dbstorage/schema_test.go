@@ -0,0 +1,137 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.uber.org/zap"
+)
+
+func TestIsSchemaConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "sqlite generic error", err: sqlite3.Error{Code: sqlite3.ErrError}},
+		{name: "sqlite busy", err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: true},
+		{name: "postgres unique violation", err: &pgconn.PgError{Code: "23505"}, want: true},
+		{name: "postgres duplicate table", err: &pgconn.PgError{Code: "42P07"}, want: true},
+		{name: "postgres duplicate column", err: &pgconn.PgError{Code: "42701"}, want: true},
+		{name: "postgres duplicate object", err: &pgconn.PgError{Code: "42710"}, want: true},
+		{name: "postgres deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "postgres insufficient privilege", err: &pgconn.PgError{Code: "42501"}},
+		{name: "other", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isSchemaConflict(tt.err))
+		})
+	}
+
+	// the messages of SQLite tell the conflicts apart from other generic errors
+	db := newTestDB(t)
+	_, err := db.Exec("create table conflict (key text)")
+	require.NoError(t, err)
+	_, err = db.Exec("alter table conflict add column key text")
+	assert.True(t, isSchemaConflict(err))
+	_, err = db.Exec("create table conflict (key text)")
+	assert.True(t, isSchemaConflict(err))
+	_, err = db.Exec("create table")
+	assert.False(t, isSchemaConflict(err))
+}
+
+func TestSetupSchemaRetries(t *testing.T) {
+	conflict := &pgconn.PgError{Code: pgDuplicateColumn}
+	db := newTestDB(t)
+
+	setups := 0
+	err := setupSchema(context.Background(), db, SchemaSetupSettings{MaxRetries: 2}, func(schemaQueryer) error {
+		setups++
+		if setups < 3 {
+			return conflict
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, setups)
+
+	setups = 0
+	err = setupSchema(context.Background(), db, SchemaSetupSettings{}, func(schemaQueryer) error {
+		setups++
+		return conflict
+	})
+	assert.Equal(t, conflict, err)
+	assert.Equal(t, 1, setups)
+}
+
+func TestExtensionConcurrentStarts(t *testing.T) {
+	ctx := context.Background()
+	dataSource := fmt.Sprintf("file:%s/foo.db", t.TempDir())
+
+	// the collectors start together, and set up the same tables, with the columns and indexes of max_db_size
+	const collectors = 8
+	var wg sync.WaitGroup
+	errs := make([]error, collectors)
+	for i := 0; i < collectors; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = startAndGetClients(ctx, dataSource)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func startAndGetClients(ctx context.Context, dataSource string) error {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.DriverName = "sqlite3"
+	cfg.DataSource = dataSource
+	cfg.MaxDBSize = 1 << 30
+	extension, err := newDBStorage(zap.NewNop(), cfg)
+	if err != nil {
+		return err
+	}
+	se := extension.(storage.Extension)
+	if err = se.Start(ctx, componenttest.NewNopHost()); err != nil {
+		return err
+	}
+	defer se.Shutdown(ctx)
+	for _, name := range []string{"first", "second", "third"} {
+		client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity(name), "")
+		if err != nil {
+			return err
+		}
+		if err = client.Close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// passwordPlaceholder is replaced by the password secret in the datasources
+	passwordPlaceholder = "{password}"
+	redacted            = "[REDACTED]"
+)
+
+// SecretSettings locates a secret kept out of the configuration, read when the extension starts.
+// Exactly one of Env and File must be set.
+type SecretSettings struct {
+	// Env is the name of the environment variable holding the secret.
+	Env string `mapstructure:"env,omitempty"`
+	// File is the path of the file holding the secret. Trailing line breaks are ignored.
+	File string `mapstructure:"file,omitempty"`
+}
+
+func (s SecretSettings) isSet() bool {
+	return s.Env != "" || s.File != ""
+}
+
+func (s SecretSettings) validate() error {
+	if s.Env != "" && s.File != "" {
+		return errors.New("env and file are exclusive")
+	}
+	return nil
+}
+
+// resolve reads the secret. The errors do not hold its value.
+func (s SecretSettings) resolve() (string, error) {
+	if s.Env != "" {
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return value, nil
+	}
+	content, err := ioutil.ReadFile(s.File)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// resolveDataSources returns the datasources to open, with the secrets resolved, and the secrets themselves
+func (ds *databaseStorage) resolveDataSources() ([]string, []string, error) {
+	dataSources := ds.datasourceNames
+	var secrets []string
+	if ds.dsnSecret.isSet() {
+		dataSource, err := ds.dsnSecret.resolve()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read the datasource secret: %w", err)
+		}
+		dataSources = []string{dataSource}
+		secrets = append(secrets, dataSource)
+	}
+	if ds.passwordSecret.isSet() {
+		password, err := ds.passwordSecret.resolve()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read the password secret: %w", err)
+		}
+		resolved := make([]string, len(dataSources))
+		for i, dataSource := range dataSources {
+			resolved[i] = strings.ReplaceAll(dataSource, passwordPlaceholder, password)
+		}
+		dataSources = resolved
+		secrets = append(secrets, password)
+	}
+	return dataSources, secrets, nil
+}
+
+// redactError replaces the secrets in the message of an error, as drivers may include the datasource in their errors.
+// The redacted error does not wrap the original one, which still holds them.
+func redactError(err error, secrets []string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, secret := range secrets {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, redacted)
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,177 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.uber.org/multierr"
+)
+
+const shardWeight = 100 // the number of points in the ring for each shard
+
+var errShardedTransaction = errors.New("transactions are not supported when keys are sharded across several datasources")
+
+// ringItem connects a position in the ring with the index of a shard.
+type ringItem struct {
+	pos   uint32
+	shard int
+}
+
+// hashRing is a consistent hash ring routing keys to shards. A key belongs to the shard of the first
+// item at or after its position, so adding a shard only moves the keys now owned by its items.
+type hashRing struct {
+	items []ringItem
+}
+
+// newHashRing builds an immutable ring, positioning the shards on the hash of their identifiers.
+func newHashRing(ids []string) *hashRing {
+	var items []ringItem
+	positions := map[uint32]bool{} // tracking the used positions
+	for shard, id := range ids {
+		for i := 0; i < shardWeight; i++ {
+			pos := crc32.ChecksumIEEE([]byte(id + "#" + strconv.Itoa(i)))
+			// if this position is occupied already, skip this item
+			if positions[pos] {
+				continue
+			}
+			positions[pos] = true
+			items = append(items, ringItem{pos: pos, shard: shard})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].pos < items[j].pos
+	})
+	return &hashRing{items: items}
+}
+
+// shardFor returns the index of the shard responsible for the given key
+func (h *hashRing) shardFor(key string) int {
+	pos := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(h.items), func(i int) bool {
+		return h.items[i].pos >= pos
+	})
+	// past the highest position, the ring wraps around to the first item
+	if i == len(h.items) {
+		i = 0
+	}
+	return h.items[i].shard
+}
+
+// shardedClient spreads the keys of a client across several databases, each holding a table of the same name.
+type shardedClient struct {
+	ring   *hashRing
+	shards []*dbStorageClient
+}
+
+// Ensure the sharded client implements the same interface as the single database one
+var _ DBClient = (*shardedClient)(nil)
+
+func (c *shardedClient) shardFor(key string) *dbStorageClient {
+	return c.shards[c.ring.shardFor(key)]
+}
+
+// Get will retrieve data from storage that corresponds to the specified key
+func (c *shardedClient) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.shardFor(key).Get(ctx, key)
+}
+
+// Set will store data. The data can be retrieved using the same key
+func (c *shardedClient) Set(ctx context.Context, key string, value []byte) error {
+	return c.shardFor(key).Set(ctx, key, value)
+}
+
+// Delete will delete data associated with the specified key
+func (c *shardedClient) Delete(ctx context.Context, key string) error {
+	return c.shardFor(key).Delete(ctx, key)
+}
+
+// SetWithResult stores data like Set, and reports whether the key was inserted or updated
+func (c *shardedClient) SetWithResult(ctx context.Context, key string, value []byte) (WriteResult, error) {
+	return c.shardFor(key).SetWithResult(ctx, key, value)
+}
+
+// DeleteWithResult deletes data like Delete, and reports whether the key was deleted
+func (c *shardedClient) DeleteWithResult(ctx context.Context, key string) (WriteResult, error) {
+	return c.shardFor(key).DeleteWithResult(ctx, key)
+}
+
+// Batch executes the specified operations in order. Get operation results are updated in place
+func (c *shardedClient) Batch(ctx context.Context, ops ...storage.Operation) error {
+	var err error
+	for _, op := range ops {
+		switch op.Type {
+		case storage.Get:
+			op.Value, err = c.Get(ctx, op.Key)
+		case storage.Set:
+			err = c.Set(ctx, op.Key, op.Value)
+		case storage.Delete:
+			err = c.Delete(ctx, op.Key)
+		default:
+			return errors.New("wrong operation type")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// FindByValuePrefix returns the keys of this client whose value starts with prefix, in key order
+func (c *shardedClient) FindByValuePrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	var keys []string
+	for _, shard := range c.shards {
+		shardKeys, err := shard.FindByValuePrefix(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, shardKeys...)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Stats describes the entries of this client across the shards
+func (c *shardedClient) Stats(ctx context.Context) (StoreStats, error) {
+	var stats StoreStats
+	for _, shard := range c.shards {
+		shardStats, err := shard.Stats(ctx)
+		if err != nil {
+			return StoreStats{}, err
+		}
+		stats = stats.merge(shardStats)
+	}
+	return stats, nil
+}
+
+// Begin is not supported: the keys of a transaction may live in different databases
+func (c *shardedClient) Begin(context.Context) (Tx, error) {
+	return nil, errShardedTransaction
+}
+
+// Close will close the clients of every shard
+func (c *shardedClient) Close(ctx context.Context) error {
+	var errs error
+	for _, shard := range c.shards {
+		errs = multierr.Append(errs, shard.Close(ctx))
+	}
+	return errs
+}
@@ -0,0 +1,136 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+)
+
+func TestHashRingDeterministic(t *testing.T) {
+	ids := []string{"file:a.db", "file:b.db", "file:c.db"}
+	ring := newHashRing(ids)
+	again := newHashRing(ids)
+
+	counts := make([]int, len(ids))
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		shard := ring.shardFor(key)
+		assert.Equal(t, shard, again.shardFor(key))
+		counts[shard]++
+	}
+	// every shard gets a share of the keys
+	for shard, count := range counts {
+		assert.Greater(t, count, 500, "shard %d", shard)
+	}
+}
+
+func TestHashRingAddShard(t *testing.T) {
+	ring := newHashRing([]string{"file:a.db", "file:b.db"})
+	grown := newHashRing([]string{"file:a.db", "file:b.db", "file:c.db"})
+
+	var moved int
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		before, after := ring.shardFor(key), grown.shardFor(key)
+		if before != after {
+			// keys only move to the new shard
+			assert.Equal(t, 2, after)
+			moved++
+		}
+	}
+	assert.Greater(t, moved, 0)
+	assert.Less(t, moved, 1500)
+}
+
+func TestShardedExtension(t *testing.T) {
+	ctx := context.Background()
+	dataSources := []string{newTestDataSource(t), newTestDataSource(t)}
+
+	f := NewFactory()
+	cfg := f.CreateDefaultConfig().(*Config)
+	cfg.DriverName = "sqlite3"
+	cfg.DataSources = dataSources
+	require.NoError(t, cfg.Validate())
+	extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+	se := extension.(*databaseStorage)
+	require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+	defer se.Shutdown(ctx)
+
+	client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("my_component"), "")
+	require.NoError(t, err)
+	defer client.Close(ctx)
+	sharded, ok := client.(*shardedClient)
+	require.True(t, ok)
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%02d", i)
+		require.NoError(t, client.Set(ctx, keys[i], []byte("value")))
+	}
+
+	// Each key is stored in the database it is routed to, and only there
+	perShard := make([]int, len(dataSources))
+	for _, key := range keys {
+		shard := sharded.ring.shardFor(key)
+		perShard[shard]++
+		for i, shardClient := range sharded.shards {
+			value, err := shardClient.Get(ctx, key)
+			require.NoError(t, err)
+			if i == shard {
+				assert.Equal(t, []byte("value"), value)
+			} else {
+				assert.Nil(t, value)
+			}
+		}
+
+		value, err := client.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+	}
+	for shard, count := range perShard {
+		assert.Greater(t, count, 0, "shard %d", shard)
+	}
+
+	found, err := sharded.FindByValuePrefix(ctx, []byte("val"))
+	require.NoError(t, err)
+	assert.Equal(t, keys, found)
+
+	stats, err := sharded.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(keys)), stats.Entries)
+
+	require.NoError(t, client.Delete(ctx, keys[0]))
+	value, err := client.Get(ctx, keys[0])
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	_, err = sharded.Begin(ctx)
+	assert.ErrorIs(t, err, errShardedTransaction)
+}
+
+func newTestDataSource(t *testing.T) string {
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	return fmt.Sprintf("file:%s/foo.db?_busy_timeout=10000&_journal=WAL&_sync=NORMAL", tempDir)
+}
@@ -0,0 +1,127 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	evictionPolicyReject      = "reject"
+	evictionPolicyEvictOldest = "evict-oldest"
+
+	// evictionBatch is the number of entries evicted at a time, until the database fits
+	evictionBatch = 10
+
+	// sqliteNowMillis is the current time in milliseconds since the epoch
+	sqliteNowMillis      = "cast((julianday('now') - 2440587.5) * 86400000 as integer)"
+	checkUpdatedAtColumn = "select updated_at from %s where 1=0"
+	addUpdatedAtColumn   = "alter table %s add column updated_at integer not null default 0"
+	// The triggers stamp the rows on every write, so that the statements writing them do not have to
+	createInsertTrigger = "create trigger if not exists %[1]s_updated_at_insert after insert on %[1]s " +
+		"begin update %[1]s set updated_at = " + sqliteNowMillis + " where rowid = new.rowid; end"
+	createUpdateTrigger = "create trigger if not exists %[1]s_updated_at_update after update of value on %[1]s " +
+		"begin update %[1]s set updated_at = " + sqliteNowMillis + " where rowid = new.rowid; end"
+	createUpdatedAtIndex = "create index if not exists %[1]s_updated_at on %[1]s (updated_at)"
+	// the pages on the free list are reused by the next writes, so they do not count
+	usedSizeQuery        = "select (page_count - freelist_count) * page_size from pragma_page_count(), pragma_freelist_count(), pragma_page_size()"
+	evictOldestQueryText = "delete from %[1]s where rowid in (select rowid from %[1]s order by updated_at, rowid limit ?)"
+)
+
+// errDatabaseFull is returned by the writes made once the database reached its maximum size
+var errDatabaseFull = errors.New("database reached max_db_size")
+
+// execQueryer runs statements on a database or in a transaction
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sizeGuard keeps a SQLite database within a maximum size, by rejecting the writes made once it is reached,
+// or by evicting the least recently written entries of the table being written to.
+type sizeGuard struct {
+	maxSize int64
+	evict   bool
+}
+
+func newSizeGuard(maxSize int64, policy string) *sizeGuard {
+	if maxSize == 0 {
+		return nil
+	}
+	return &sizeGuard{maxSize: maxSize, evict: policy == evictionPolicyEvictOldest}
+}
+
+// prepareTable adds the updated_at column ordering the evictions to the table, the triggers maintaining it and,
+// when createIndex is set, an index on it. When the extension is not allowed to run DDL statements, it only checks
+// that the column has been provisioned.
+func (g *sizeGuard) prepareTable(ctx context.Context, q schemaQueryer, tableName string, autoCreateTable bool, createIndex bool) error {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(checkUpdatedAtColumn, tableName))
+	if err == nil {
+		err = rows.Close()
+	} else if autoCreateTable {
+		_, err = q.ExecContext(ctx, fmt.Sprintf(addUpdatedAtColumn, tableName))
+	}
+	if err != nil {
+		return fmt.Errorf("table %s lacks the updated_at column required by max_db_size: %w", tableName, err)
+	}
+	if !autoCreateTable {
+		return nil
+	}
+	statements := []string{createInsertTrigger, createUpdateTrigger}
+	if createIndex {
+		statements = append(statements, createUpdatedAtIndex)
+	}
+	for _, statement := range statements {
+		if _, err = q.ExecContext(ctx, fmt.Sprintf(statement, tableName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeRoom is called before writing to the table. Once the database reached its maximum size, it either fails
+// with errDatabaseFull, or evicts the oldest entries of the table until the database fits again.
+func (g *sizeGuard) makeRoom(ctx context.Context, q execQueryer, tableName string) error {
+	if g == nil {
+		return nil
+	}
+	for {
+		var used int64
+		if err := q.QueryRowContext(ctx, usedSizeQuery).Scan(&used); err != nil {
+			return err
+		}
+		if used < g.maxSize {
+			return nil
+		}
+		if !g.evict {
+			return fmt.Errorf("%w: %d bytes used out of %d", errDatabaseFull, used, g.maxSize)
+		}
+		result, err := q.ExecContext(ctx, fmt.Sprintf(evictOldestQueryText, tableName), evictionBatch)
+		if err != nil {
+			return err
+		}
+		evicted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if evicted == 0 {
+			// the space is used by other tables
+			return fmt.Errorf("%w: %d bytes used out of %d, and table %s is empty", errDatabaseFull, used, g.maxSize, tableName)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMaxDBSize = 64 * 1024
+
+func TestSizeGuardReject(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	client, err := newClient(ctx, db, "receiver_nop_size", clientOptions{
+		autoCreateTable: true,
+		sizeGuard:       newSizeGuard(testMaxDBSize, evictionPolicyReject),
+	})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+
+	value := bytes.Repeat([]byte{'x'}, 1024)
+	var written int
+	for ; written < 1000; written++ {
+		if err = client.Set(ctx, fmt.Sprintf("key-%d", written), value); err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, errDatabaseFull)
+	assert.Greater(t, written, 10)
+
+	_, err = client.SetWithResult(ctx, "other", value)
+	assert.ErrorIs(t, err, errDatabaseFull)
+	tx, err := client.Begin(ctx)
+	require.NoError(t, err)
+	assert.ErrorIs(t, tx.Set(ctx, "other", value), errDatabaseFull)
+	require.NoError(t, tx.Rollback())
+
+	// the stored entries can still be read and deleted, which makes room again
+	got, err := client.Get(ctx, "key-0")
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+	for i := 0; i < written; i++ {
+		require.NoError(t, client.Delete(ctx, fmt.Sprintf("key-%d", i)))
+	}
+	require.NoError(t, client.Set(ctx, "other", value))
+}
+
+func TestSizeGuardEvictOldest(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	client, err := newClient(ctx, db, "receiver_nop_size", clientOptions{
+		autoCreateTable: true,
+		sizeGuard:       newSizeGuard(testMaxDBSize, evictionPolicyEvictOldest),
+	})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+
+	value := bytes.Repeat([]byte{'x'}, 1024)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("old-%d", i), value))
+	}
+	// rewriting a key makes it the most recent one
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, client.Set(ctx, "old-0", value))
+	time.Sleep(5 * time.Millisecond)
+
+	evicted := false
+	for i := 0; i < 1000 && !evicted; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("new-%d", i), value))
+		got, err := client.Get(ctx, "old-1")
+		require.NoError(t, err)
+		evicted = got == nil
+	}
+	require.True(t, evicted)
+
+	got, err := client.Get(ctx, "old-0")
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+	got, err = client.Get(ctx, "old-10")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	// the database stays close to the limit however much is written
+	for i := 0; i < 200; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("more-%d", i), value))
+	}
+	var used int64
+	require.NoError(t, db.QueryRowContext(ctx, usedSizeQuery).Scan(&used))
+	assert.Less(t, used, int64(2*testMaxDBSize))
+	got, err = client.Get(ctx, "more-199")
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+}
+
+func TestSizeGuardProvisionedTable(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	_, err := db.ExecContext(ctx, "create table receiver_nop_size (key text primary key, value blob)")
+	require.NoError(t, err)
+
+	_, err = newClient(ctx, db, "receiver_nop_size", clientOptions{sizeGuard: newSizeGuard(testMaxDBSize, evictionPolicyReject)})
+	assert.Error(t, err)
+
+	_, err = db.ExecContext(ctx, "alter table receiver_nop_size add column updated_at integer not null default 0")
+	require.NoError(t, err)
+	client, err := newClient(ctx, db, "receiver_nop_size", clientOptions{sizeGuard: newSizeGuard(testMaxDBSize, evictionPolicyReject)})
+	require.NoError(t, err)
+	require.NoError(t, client.Close(ctx))
+}
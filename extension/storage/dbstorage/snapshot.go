@@ -0,0 +1,86 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const vacuumInto = "vacuum into ?"
+
+// Snapshotter is implemented by the extension. Components that want to back up the storage
+// type-assert the extension they obtained from the host.
+type Snapshotter interface {
+	// Snapshot writes a consistent copy of the database to the given file, replacing it if it exists.
+	// With several datasources, the copy of each database is written to the path suffixed with its index.
+	Snapshot(ctx context.Context, path string) error
+}
+
+// Ensure the extension can be snapshotted
+var _ Snapshotter = (*databaseStorage)(nil)
+
+// Snapshot writes a consistent copy of the database to the given file, replacing it if it exists.
+// Only SQLite databases can be snapshotted.
+func (ds *databaseStorage) Snapshot(ctx context.Context, path string) error {
+	if ds.driverName != sqliteDriverName {
+		return fmt.Errorf("snapshots require the %s driver", sqliteDriverName)
+	}
+	for i, db := range ds.dbs {
+		target := path
+		if len(ds.dbs) > 1 {
+			target = fmt.Sprintf("%s-%d", path, i)
+		}
+		if err := snapshot(ctx, db, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot vacuums the database into a temporary file, which is then renamed to the target so that
+// the previous snapshot is only replaced by a complete one.
+func snapshot(ctx context.Context, db *sql.DB, path string) error {
+	tmpPath := path + ".tmp"
+	// vacuum into refuses to overwrite a file, e.g. left over by an interrupted snapshot
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, vacuumInto, tmpPath); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// snapshotPeriodically takes a snapshot at every interval until done is closed
+func (ds *databaseStorage) snapshotPeriodically(interval time.Duration, path string, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := ds.Snapshot(context.Background(), path); err != nil {
+				ds.logger.Warn("Failed to snapshot the database", zap.Error(err))
+			}
+		}
+	}
+}
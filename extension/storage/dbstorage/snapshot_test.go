@@ -0,0 +1,102 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+)
+
+func TestExtensionSnapshot(t *testing.T) {
+	ctx := context.Background()
+	se := newTestExtension(t)
+	require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+	defer se.Shutdown(ctx)
+
+	client, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("my_component"), "")
+	require.NoError(t, err)
+	defer client.Close(ctx)
+	require.NoError(t, client.Set(ctx, "checkpoint", []byte("1")))
+
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	path := filepath.Join(tempDir, "snapshot.db")
+	snapshotter, ok := se.(Snapshotter)
+	require.True(t, ok)
+	require.NoError(t, snapshotter.Snapshot(ctx, path))
+	assert.Equal(t, []byte("1"), readSnapshot(t, path, "receiver_nop_my_component", "checkpoint"))
+
+	// A new snapshot replaces the previous one
+	require.NoError(t, client.Set(ctx, "checkpoint", []byte("2")))
+	require.NoError(t, snapshotter.Snapshot(ctx, path))
+	assert.Equal(t, []byte("2"), readSnapshot(t, path, "receiver_nop_my_component", "checkpoint"))
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtensionPeriodicSnapshot(t *testing.T) {
+	ctx := context.Background()
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	path := filepath.Join(tempDir, "snapshot.db")
+
+	f := NewFactory()
+	cfg := f.CreateDefaultConfig().(*Config)
+	cfg.DriverName = "sqlite3"
+	cfg.DataSource = newTestDataSource(t)
+	cfg.SnapshotInterval = 10 * time.Millisecond
+	cfg.SnapshotPath = path
+	require.NoError(t, cfg.Validate())
+	extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, extension.Start(ctx, componenttest.NewNopHost()))
+
+	client, err := extension.(*databaseStorage).GetClient(ctx, component.KindReceiver, newTestEntity("my_component"), "")
+	require.NoError(t, err)
+	require.NoError(t, client.Set(ctx, "checkpoint", []byte("1")))
+	require.NoError(t, client.Close(ctx))
+
+	assert.Eventually(t, func() bool {
+		db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+		if err != nil {
+			return false
+		}
+		defer db.Close()
+		var value []byte
+		err = db.QueryRow("select value from receiver_nop_my_component where key = ?", "checkpoint").Scan(&value)
+		return err == nil && string(value) == "1"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, extension.Shutdown(ctx))
+}
+
+func readSnapshot(t *testing.T, path string, table string, key string) []byte {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	require.NoError(t, err)
+	defer db.Close()
+	var value []byte
+	require.NoError(t, db.QueryRow("select value from "+table+" where key = ?", key).Scan(&value))
+	return value
+}
@@ -0,0 +1,110 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	// the size counts the bytes of the stored keys and values, as the database lays them out differently
+	statsQueryText = "select count(*), coalesce(sum(length(key) + coalesce(length(value), 0)), 0) from %s"
+	// the rows written before updated_at was added hold 0, they have no known write time
+	statsWithUpdatedAtQueryText = "select count(*), coalesce(sum(length(key) + coalesce(length(value), 0)), 0), " +
+		"min(nullif(updated_at, 0)), max(nullif(updated_at, 0)) from %s"
+	statsNamespaceFilter = " where namespace=?"
+)
+
+// StoreStats describes the entries stored by a client
+type StoreStats struct {
+	// Entries is the number of keys
+	Entries int64
+	// Size is the approximate number of bytes of the keys and values, without the overhead of the database
+	Size int64
+	// Oldest and Newest are the times of the least and most recent writes of the entries. They are zero when the
+	// table has no updated_at column, which max_db_size adds, or when no entry has a known write time.
+	Oldest time.Time
+	Newest time.Time
+}
+
+// merge adds the entries described by other, as if they were stored by the same client
+func (s StoreStats) merge(other StoreStats) StoreStats {
+	s.Entries += other.Entries
+	s.Size += other.Size
+	if !other.Oldest.IsZero() && (s.Oldest.IsZero() || other.Oldest.Before(s.Oldest)) {
+		s.Oldest = other.Oldest
+	}
+	if other.Newest.After(s.Newest) {
+		s.Newest = other.Newest
+	}
+	return s
+}
+
+// Stats describes the entries of this client. In strict mode, only the entries of its namespace are counted.
+func (c *dbStorageClient) Stats(ctx context.Context) (StoreStats, error) {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return StoreStats{}, err
+	}
+	defer release()
+	var stats StoreStats
+	err = c.retry(ctx, func() (err error) {
+		stats, err = c.stats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (c *dbStorageClient) stats(ctx context.Context) (StoreStats, error) {
+	// the write times are only known when the table has been given the updated_at column
+	hasUpdatedAt := false
+	if rows, err := c.readDB.QueryContext(ctx, fmt.Sprintf(checkUpdatedAtColumn, c.tableName)); err == nil {
+		hasUpdatedAt = true
+		if err = rows.Close(); err != nil {
+			return StoreStats{}, err
+		}
+	}
+
+	query := statsQueryText
+	if hasUpdatedAt {
+		query = statsWithUpdatedAtQueryText
+	}
+	query = fmt.Sprintf(query, c.tableName)
+	var args []interface{}
+	if c.namespace != "" {
+		query += statsNamespaceFilter
+		args = append(args, c.namespace)
+	}
+
+	var stats StoreStats
+	var oldest, newest sql.NullInt64
+	dest := []interface{}{&stats.Entries, &stats.Size}
+	if hasUpdatedAt {
+		dest = append(dest, &oldest, &newest)
+	}
+	if err := c.readDB.QueryRowContext(ctx, query, args...).Scan(dest...); err != nil {
+		return StoreStats{}, err
+	}
+	if oldest.Valid {
+		stats.Oldest = time.Unix(0, oldest.Int64*int64(time.Millisecond))
+	}
+	if newest.Valid {
+		stats.Newest = time.Unix(0, newest.Int64*int64(time.Millisecond))
+	}
+	return stats, nil
+}
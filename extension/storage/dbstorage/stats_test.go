@@ -0,0 +1,122 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestClientStats(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_stats")
+
+	stats, err := client.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, StoreStats{}, stats)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	// overwriting a key does not add an entry
+	require.NoError(t, client.Set(ctx, "key-0", []byte("value")))
+	require.NoError(t, client.Delete(ctx, "key-9"))
+
+	stats, err = client.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), stats.Entries)
+	assert.Equal(t, int64(9*len("key-0value")), stats.Size)
+	// the table has no updated_at column
+	assert.True(t, stats.Oldest.IsZero())
+	assert.True(t, stats.Newest.IsZero())
+}
+
+func TestClientStatsWriteTimes(t *testing.T) {
+	ctx := context.Background()
+	client, err := newClient(ctx, newTestDB(t), "receiver_nop_stats", clientOptions{
+		autoCreateTable: true,
+		sizeGuard:       newSizeGuard(testMaxDBSize, evictionPolicyReject),
+	})
+	require.NoError(t, err)
+	defer client.Close(ctx)
+
+	before := time.Now().Add(-time.Second)
+	require.NoError(t, client.Set(ctx, "first", []byte("value")))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, client.Set(ctx, "second", []byte("value")))
+
+	stats, err := client.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Entries)
+	assert.True(t, stats.Oldest.After(before), "oldest %v", stats.Oldest)
+	assert.True(t, stats.Newest.After(stats.Oldest), "newest %v, oldest %v", stats.Newest, stats.Oldest)
+	assert.False(t, stats.Newest.After(time.Now().Add(time.Second)), "newest %v", stats.Newest)
+}
+
+func TestClientStatsNamespace(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	newNamespaceClient := func(namespace string) *dbStorageClient {
+		client, err := newClient(ctx, db, "receiver_nop_a_b", clientOptions{autoCreateTable: true, namespace: namespace})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			client.Close(ctx)
+		})
+		return client
+	}
+	client, colliding := newNamespaceClient("a_b"), newNamespaceClient("a b")
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	require.NoError(t, colliding.Set(ctx, "other", []byte("value")))
+
+	// the clients sharing the table only count the entries of their namespace
+	stats, err := client.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Entries)
+	stats, err = colliding.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Entries)
+}
+
+func TestWriteBehindStats(t *testing.T) {
+	ctx := context.Background()
+	client := newWriteBehindClient(newTestClient(t, newTestDB(t), "receiver_nop_queued"), time.Hour, 0, zap.NewNop(), nil)
+	defer client.Close(ctx)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, client.Set(ctx, fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	// the pending writes are counted
+	stats, err := client.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), stats.Entries)
+}
+
+func TestStoreStatsMerge(t *testing.T) {
+	early, late := time.Unix(100, 0), time.Unix(200, 0)
+	stats := StoreStats{}.
+		merge(StoreStats{Entries: 2, Size: 20, Oldest: late, Newest: late}).
+		merge(StoreStats{Entries: 1, Size: 10}).
+		merge(StoreStats{Entries: 3, Size: 30, Oldest: early, Newest: early})
+	assert.Equal(t, StoreStats{Entries: 6, Size: 60, Oldest: early, Newest: late}, stats)
+}
@@ -0,0 +1,248 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/storage/dbstorage"
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// pendingWrite is the last Set or Delete of a key that has not been written yet
+type pendingWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// writeBehindClient queues the Sets and Deletes of a client, keeping the last write of every key, and writes them
+// in batches periodically, when the queue is full, and on Close. Reads are served from the queue first, so that
+// they see the pending writes. The queued writes are lost if the collector crashes.
+type writeBehindClient struct {
+	client     DBClient
+	maxPending int
+	logger     *zap.Logger
+	// onClose is called once the client is closed
+	onClose func()
+
+	lock sync.Mutex
+	// pending are the writes queued since the last flush
+	pending map[string]pendingWrite
+	// flushing are the writes of the flush in progress, still served to the reads until they are written
+	flushing map[string]pendingWrite
+	// flushLock lets one flush run at a time
+	flushLock sync.Mutex
+
+	// done stops the periodic flushes
+	done      chan struct{}
+	flushes   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Ensure the write-behind client implements the same interface as the one it wraps
+var _ DBClient = (*writeBehindClient)(nil)
+
+func newWriteBehindClient(client DBClient, interval time.Duration, maxPending int, logger *zap.Logger, onClose func()) *writeBehindClient {
+	c := &writeBehindClient{
+		client:     client,
+		maxPending: maxPending,
+		logger:     logger,
+		onClose:    onClose,
+		pending:    map[string]pendingWrite{},
+		done:       make(chan struct{}),
+	}
+	c.flushes.Add(1)
+	go c.flushPeriodically(interval)
+	return c
+}
+
+func (c *writeBehindClient) flushPeriodically(interval time.Duration) {
+	defer c.flushes.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.flush(context.Background()); err != nil {
+				c.logger.Warn("Failed to write the queued writes, retrying at the next flush", zap.Error(err))
+			}
+		}
+	}
+}
+
+// flush writes the pending writes in a batch. When it fails, they are queued again unless overwritten meanwhile.
+func (c *writeBehindClient) flush(ctx context.Context) error {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+
+	c.lock.Lock()
+	writes := c.pending
+	c.pending = map[string]pendingWrite{}
+	c.flushing = writes
+	c.lock.Unlock()
+	if len(writes) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ops := make([]storage.Operation, len(keys))
+	for i, key := range keys {
+		if write := writes[key]; write.deleted {
+			ops[i] = storage.DeleteOperation(key)
+		} else {
+			ops[i] = storage.SetOperation(key, write.value)
+		}
+	}
+	err := c.client.Batch(ctx, ops...)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err != nil {
+		for key, write := range writes {
+			if _, ok := c.pending[key]; !ok {
+				c.pending[key] = write
+			}
+		}
+	}
+	c.flushing = nil
+	return err
+}
+
+// queue adds a write to the queue, and flushes it when it is full
+func (c *writeBehindClient) queue(ctx context.Context, key string, write pendingWrite) error {
+	c.lock.Lock()
+	c.pending[key] = write
+	full := c.maxPending > 0 && len(c.pending) >= c.maxPending
+	c.lock.Unlock()
+	if full {
+		return c.flush(ctx)
+	}
+	return nil
+}
+
+// Get will retrieve data from storage that corresponds to the specified key, including pending writes
+func (c *writeBehindClient) Get(ctx context.Context, key string) ([]byte, error) {
+	c.lock.Lock()
+	write, ok := c.pending[key]
+	if !ok {
+		write, ok = c.flushing[key]
+	}
+	c.lock.Unlock()
+	if !ok {
+		return c.client.Get(ctx, key)
+	}
+	if write.deleted {
+		return nil, nil
+	}
+	return append([]byte(nil), write.value...), nil
+}
+
+// Set will queue data to be stored. The data can be retrieved using the same key right away
+func (c *writeBehindClient) Set(ctx context.Context, key string, value []byte) error {
+	// the caller may reuse its buffer once Set returns
+	return c.queue(ctx, key, pendingWrite{value: append([]byte(nil), value...)})
+}
+
+// Delete will queue the deletion of data associated with the specified key
+func (c *writeBehindClient) Delete(ctx context.Context, key string) error {
+	return c.queue(ctx, key, pendingWrite{deleted: true})
+}
+
+// Batch executes the specified operations in order. Get operation results are updated in place
+func (c *writeBehindClient) Batch(ctx context.Context, ops ...storage.Operation) error {
+	var err error
+	for _, op := range ops {
+		switch op.Type {
+		case storage.Get:
+			op.Value, err = c.Get(ctx, op.Key)
+		case storage.Set:
+			err = c.Set(ctx, op.Key, op.Value)
+		case storage.Delete:
+			err = c.Delete(ctx, op.Key)
+		default:
+			return errors.New("wrong operation type")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// FindByValuePrefix writes the pending writes, then returns the keys whose value starts with prefix
+func (c *writeBehindClient) FindByValuePrefix(ctx context.Context, prefix []byte) ([]string, error) {
+	if err := c.flush(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.FindByValuePrefix(ctx, prefix)
+}
+
+// Stats writes the pending writes, then describes the entries of the wrapped client
+func (c *writeBehindClient) Stats(ctx context.Context) (StoreStats, error) {
+	if err := c.flush(ctx); err != nil {
+		return StoreStats{}, err
+	}
+	return c.client.Stats(ctx)
+}
+
+// Begin writes the pending writes, then starts a transaction, which is not queued
+func (c *writeBehindClient) Begin(ctx context.Context) (Tx, error) {
+	if err := c.flush(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.Begin(ctx)
+}
+
+// SetWithResult writes the pending writes, then stores data right away to report its outcome
+func (c *writeBehindClient) SetWithResult(ctx context.Context, key string, value []byte) (WriteResult, error) {
+	if err := c.flush(ctx); err != nil {
+		return WriteResult{}, err
+	}
+	return c.client.SetWithResult(ctx, key, value)
+}
+
+// DeleteWithResult writes the pending writes, then deletes data right away to report its outcome
+func (c *writeBehindClient) DeleteWithResult(ctx context.Context, key string) (WriteResult, error) {
+	if err := c.flush(ctx); err != nil {
+		return WriteResult{}, err
+	}
+	return c.client.DeleteWithResult(ctx, key)
+}
+
+// Close writes the pending writes and closes the wrapped client. Closing the client again does nothing
+func (c *writeBehindClient) Close(ctx context.Context) error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.flushes.Wait()
+		err = multierr.Append(c.flush(ctx), c.client.Close(ctx))
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
@@ -0,0 +1,194 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.uber.org/zap"
+)
+
+// countingClient counts the batches written through a client, and fails them when err is set
+type countingClient struct {
+	DBClient
+	batches int
+	ops     int
+	err     error
+}
+
+func (c *countingClient) Batch(ctx context.Context, ops ...storage.Operation) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.batches++
+	c.ops += len(ops)
+	return c.DBClient.Batch(ctx, ops...)
+}
+
+func TestWriteBehindCoalescing(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	counting := &countingClient{DBClient: newTestClient(t, db, "receiver_nop_queued")}
+	wb := newWriteBehindClient(counting, time.Hour, 0, zap.NewNop(), nil)
+	// the writes are read back through another client, as closing the queue closes the one it wraps
+	client := newTestClient(t, db, "receiver_nop_queued")
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, wb.Set(ctx, fmt.Sprintf("key-%d", i%5), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.NoError(t, wb.Delete(ctx, "key-4"))
+	assert.Equal(t, 0, counting.batches)
+	value, err := client.Get(ctx, "key-0")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	// the last write of every key is written in a single batch
+	require.NoError(t, wb.Close(ctx))
+	assert.Equal(t, 1, counting.batches)
+	assert.Equal(t, 5, counting.ops)
+	for i := 0; i < 4; i++ {
+		value, err := client.Get(ctx, fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%d", 95+i)), value)
+	}
+	value, err = client.Get(ctx, "key-4")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	// closing again does nothing
+	require.NoError(t, wb.Close(ctx))
+	assert.Equal(t, 1, counting.batches)
+}
+
+func TestWriteBehindReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_queued")
+	require.NoError(t, client.Set(ctx, "stored", []byte("old")))
+	wb := newWriteBehindClient(client, time.Hour, 0, zap.NewNop(), nil)
+	defer wb.Close(ctx)
+
+	buffer := []byte("new")
+	require.NoError(t, wb.Set(ctx, "queued", buffer))
+	// the queue does not share the buffer of the caller
+	buffer[0] = 'x'
+	require.NoError(t, wb.Delete(ctx, "stored"))
+
+	value, err := wb.Get(ctx, "queued")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), value)
+	value, err = wb.Get(ctx, "stored")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	get := storage.GetOperation("queued")
+	require.NoError(t, wb.Batch(ctx, storage.SetOperation("batched", []byte("b")), get))
+	assert.Equal(t, []byte("new"), get.Value)
+
+	// the reads of the wrapped client see the writes once they are flushed
+	keys, err := wb.FindByValuePrefix(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"batched", "queued"}, keys)
+	value, err = client.Get(ctx, "stored")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestWriteBehindMaxPending(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingClient{DBClient: newTestClient(t, newTestDB(t), "receiver_nop_queued")}
+	wb := newWriteBehindClient(counting, time.Hour, 10, zap.NewNop(), nil)
+	defer wb.Close(ctx)
+
+	for i := 0; i < 25; i++ {
+		require.NoError(t, wb.Set(ctx, fmt.Sprintf("key-%d", i), []byte("value")))
+	}
+	assert.Equal(t, 2, counting.batches)
+	assert.Equal(t, 20, counting.ops)
+}
+
+func TestWriteBehindPeriodicFlush(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestDB(t), "receiver_nop_queued")
+	wb := newWriteBehindClient(client, 10*time.Millisecond, 0, zap.NewNop(), nil)
+	defer wb.Close(ctx)
+
+	require.NoError(t, wb.Set(ctx, "key", []byte("value")))
+	assert.Eventually(t, func() bool {
+		value, err := client.Get(ctx, "key")
+		return err == nil && value != nil
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWriteBehindFailedFlush(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	counting := &countingClient{DBClient: newTestClient(t, db, "receiver_nop_queued"), err: errors.New("unavailable")}
+	wb := newWriteBehindClient(counting, time.Hour, 0, zap.NewNop(), nil)
+
+	require.NoError(t, wb.Set(ctx, "key", []byte("first")))
+	assert.Error(t, wb.flush(ctx))
+	require.NoError(t, wb.Set(ctx, "other", []byte("value")))
+
+	// the failed writes are kept for the next flush
+	counting.err = nil
+	require.NoError(t, wb.Close(ctx))
+	assert.Equal(t, 2, counting.ops)
+	value, err := newTestClient(t, db, "receiver_nop_queued").Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), value)
+}
+
+func TestExtensionWriteBehindShutdown(t *testing.T) {
+	ctx := context.Background()
+	dataSource := newTestDataSource(t)
+	f := NewFactory()
+	cfg := f.CreateDefaultConfig().(*Config)
+	cfg.DriverName = "sqlite3"
+	cfg.DataSource = dataSource
+	cfg.WriteBehind = WriteBehindSettings{FlushInterval: time.Hour}
+	extension, err := f.CreateExtension(ctx, componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+	se := extension.(storage.Extension)
+	require.NoError(t, se.Start(ctx, componenttest.NewNopHost()))
+
+	closed, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("closed"), "")
+	require.NoError(t, err)
+	require.NoError(t, closed.Set(ctx, "key", []byte("closed")))
+	require.NoError(t, closed.Close(ctx))
+	open, err := se.GetClient(ctx, component.KindReceiver, newTestEntity("open"), "")
+	require.NoError(t, err)
+	require.NoError(t, open.Set(ctx, "key", []byte("open")))
+
+	// the writes of the client left open are written on shutdown
+	require.NoError(t, se.Shutdown(ctx))
+	db, err := sql.Open("sqlite3", dataSource)
+	require.NoError(t, err)
+	defer db.Close()
+	for _, name := range []string{"closed", "open"} {
+		var value []byte
+		require.NoError(t, db.QueryRow(fmt.Sprintf("select value from receiver_nop_%s where key='key'", name)).Scan(&value))
+		assert.Equal(t, []byte(name), value)
+	}
+}
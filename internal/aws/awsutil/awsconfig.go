@@ -33,10 +33,16 @@ type AWSSessionSettings struct {
 	Region string `mapstructure:"region"`
 	// Local mode to skip EC2 instance metadata check.
 	LocalMode bool `mapstructure:"local_mode"`
+	// Never call the EC2 instance metadata service: the region and the credentials must be configured.
+	NoIMDS bool `mapstructure:"no_imds"`
 	// Amazon Resource Name (ARN) of the AWS resource running the collector.
 	ResourceARN string `mapstructure:"resource_arn"`
 	// IAM role to upload segments to a different account.
 	RoleARN string `mapstructure:"role_arn"`
+	// External ID required by the trust policy of the IAM role to assume it.
+	ExternalID string `mapstructure:"external_id"`
+	// Resolve the FIPS endpoints of the AWS services, unless Endpoint is set.
+	UseFIPSEndpoint bool `mapstructure:"use_fips_endpoint"`
 }
 
 func CreateDefaultSessionConfig() AWSSessionSettings {
@@ -49,7 +55,10 @@ func CreateDefaultSessionConfig() AWSSessionSettings {
 		ProxyAddress:          "",
 		Region:                "",
 		LocalMode:             false,
+		NoIMDS:                false,
 		ResourceARN:           "",
 		RoleARN:               "",
+		ExternalID:            "",
+		UseFIPSEndpoint:       false,
 	}
 }
@@ -31,8 +31,10 @@ func TestDefaultSessionConfig(t *testing.T) {
 		ProxyAddress:          "",
 		Region:                "",
 		LocalMode:             false,
+		NoIMDS:                false,
 		ResourceARN:           "",
 		RoleARN:               "",
+		ExternalID:            "",
 	}
 	assert.Equal(t, expectedCfg, CreateDefaultSessionConfig())
 }
@@ -17,9 +17,11 @@ package awsutil // import "github.com/open-telemetry/opentelemetry-collector-con
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -31,11 +33,12 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"go.uber.org/zap"
+	"golang.org/x/net/http/httpproxy"
 	"golang.org/x/net/http2"
 )
 
 type ConnAttr interface {
-	newAWSSession(logger *zap.Logger, roleArn string, region string) (*session.Session, error)
+	newAWSSession(logger *zap.Logger, roleArn string, externalID string, region string, noIMDS bool) (*session.Session, error)
 	getEC2Region(s *session.Session) (string, error)
 }
 
@@ -46,6 +49,16 @@ func (c *Conn) getEC2Region(s *session.Session) (string, error) {
 	return ec2metadata.New(s).Region()
 }
 
+// Environment variables set up by IAM roles for service accounts on EKS
+const (
+	webIdentityTokenFileEnvVar = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	roleARNEnvVar              = "AWS_ROLE_ARN"
+	roleSessionNameEnvVar      = "AWS_ROLE_SESSION_NAME"
+)
+
+// ec2MetadataDisabledEnvVar disables the EC2 instance metadata service in the AWS SDK when it is "true"
+const ec2MetadataDisabledEnvVar = "AWS_EC2_METADATA_DISABLED"
+
 // AWS STS endpoint constants
 const (
 	STSEndpointPrefix         = "https://sts."
@@ -63,8 +76,7 @@ func newHTTPClient(logger *zap.Logger, maxIdle int, requestTimeout int, noVerify
 		InsecureSkipVerify: noVerify,
 	}
 
-	finalProxyAddress := getProxyAddress(proxyAddress)
-	proxyURL, err := getProxyURL(finalProxyAddress)
+	proxy, err := getProxyFunc(proxyAddress)
 	if err != nil {
 		logger.Error("unable to obtain proxy URL", zap.Error(err))
 		return nil, err
@@ -72,7 +84,7 @@ func newHTTPClient(logger *zap.Logger, maxIdle int, requestTimeout int, noVerify
 	transport := &http.Transport{
 		MaxIdleConnsPerHost: maxIdle,
 		TLSClientConfig:     tls,
-		Proxy:               http.ProxyURL(proxyURL),
+		Proxy:               proxy,
 	}
 
 	// is not enabled by default as we configure TLSClientConfig for supporting SSL to data plane.
@@ -97,6 +109,25 @@ func getProxyAddress(proxyAddress string) string {
 	return finalProxyAddress
 }
 
+// getProxyFunc returns the proxy of the requests: proxyAddress, or the HTTPS_PROXY environment variable when it is
+// empty, and the HTTP_PROXY environment variable for plain HTTP requests when neither is set. The hosts of the
+// NO_PROXY environment variable, as well as localhost, are not proxied.
+func getProxyFunc(proxyAddress string) (func(*http.Request) (*url.URL, error), error) {
+	proxyURL, err := getProxyURL(getProxyAddress(proxyAddress))
+	if err != nil {
+		return nil, err
+	}
+	config := httpproxy.FromEnvironment()
+	if proxyURL != nil {
+		config.HTTPProxy = proxyURL.String()
+		config.HTTPSProxy = proxyURL.String()
+	}
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
 func getProxyURL(finalProxyAddress string) (*url.URL, error) {
 	var proxyURL *url.URL
 	var err error
@@ -126,6 +157,10 @@ func GetAWSConfigSession(logger *zap.Logger, cn ConnAttr, cfg *AWSSessionSetting
 	} else if cfg.Region != "" {
 		awsRegion = cfg.Region
 		logger.Debug("Fetch region from commandline/config file", zap.String("region", awsRegion))
+	} else if cfg.NoIMDS || isEC2MetadataDisabled() {
+		msg := "Cannot fetch region variable from config file or environment variables, and the EC2 metadata is disabled."
+		logger.Error(msg, zap.Bool("no_imds", cfg.NoIMDS))
+		return nil, nil, awserr.New("NoAwsRegion", msg, nil)
 	} else if !cfg.NoVerifySSL {
 		var es *session.Session
 		es, err = GetDefaultSession(logger)
@@ -145,7 +180,7 @@ func GetAWSConfigSession(logger *zap.Logger, cn ConnAttr, cfg *AWSSessionSetting
 		logger.Error(msg)
 		return nil, nil, awserr.New("NoAwsRegion", msg, nil)
 	}
-	s, err = cn.newAWSSession(logger, cfg.RoleARN, awsRegion)
+	s, err = cn.newAWSSession(logger, cfg.RoleARN, cfg.ExternalID, awsRegion, cfg.NoIMDS)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -157,17 +192,32 @@ func GetAWSConfigSession(logger *zap.Logger, cn ConnAttr, cfg *AWSSessionSetting
 		Endpoint:               aws.String(cfg.Endpoint),
 		HTTPClient:             http,
 	}
+	if cfg.UseFIPSEndpoint {
+		config.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
 	return config, s, nil
 }
 
+// CheckFIPSEndpoint returns an error when the AWS service, identified by the endpoints ID of its package, e.g.
+// cloudwatchlogs.EndpointsID, has no FIPS endpoint in the region.
+func CheckFIPSEndpoint(service string, region string) error {
+	_, err := endpoints.DefaultResolver().EndpointFor(service, region, func(options *endpoints.Options) {
+		options.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+		options.StrictMatching = true
+	})
+	if err != nil {
+		return fmt.Errorf("no FIPS endpoint of %s in region %q: %w", service, region, err)
+	}
+	return nil
+}
+
 // ProxyServerTransport configures HTTP transport for TCP Proxy Server.
 func ProxyServerTransport(logger *zap.Logger, config *AWSSessionSettings) (*http.Transport, error) {
 	tls := &tls.Config{
 		InsecureSkipVerify: config.NoVerifySSL,
 	}
 
-	proxyAddr := getProxyAddress(config.ProxyAddress)
-	proxyURL, err := getProxyURL(proxyAddr)
+	proxy, err := getProxyFunc(config.ProxyAddress)
 	if err != nil {
 		logger.Error("unable to obtain proxy URL", zap.Error(err))
 		return nil, err
@@ -180,7 +230,7 @@ func ProxyServerTransport(logger *zap.Logger, config *AWSSessionSettings) (*http
 		MaxIdleConns:        config.NumberOfWorkers,
 		MaxIdleConnsPerHost: config.NumberOfWorkers,
 		IdleConnTimeout:     idleConnTimeout,
-		Proxy:               http.ProxyURL(proxyURL),
+		Proxy:               proxy,
 		TLSClientConfig:     tls,
 
 		// If not disabled the transport will add a gzip encoding header
@@ -193,16 +243,27 @@ func ProxyServerTransport(logger *zap.Logger, config *AWSSessionSettings) (*http
 	return transport, nil
 }
 
-func (c *Conn) newAWSSession(logger *zap.Logger, roleArn string, region string) (*session.Session, error) {
+func (c *Conn) newAWSSession(logger *zap.Logger, roleArn string, externalID string, region string, noIMDS bool) (*session.Session, error) {
 	var s *session.Session
 	var err error
-	if roleArn == "" {
-		s, err = GetDefaultSession(logger)
+	if webIdentityRoleArn := getWebIdentityRoleARN(roleArn); webIdentityRoleArn != "" {
+		webIdentityCreds, _ := getWebIdentityCreds(logger, region, webIdentityRoleArn)
+
+		s, err = session.NewSession(&aws.Config{
+			Credentials: webIdentityCreds,
+		})
+
+		if err != nil {
+			logger.Error("Error in creating session object : ", zap.Error(err))
+			return s, err
+		}
+	} else if roleArn == "" {
+		s, err = getBaseSession(logger, noIMDS)
 		if err != nil {
 			return s, err
 		}
 	} else {
-		stsCreds, _ := getSTSCreds(logger, region, roleArn)
+		stsCreds, _ := getSTSCreds(logger, region, roleArn, externalID, noIMDS)
 
 		s, err = session.NewSession(&aws.Config{
 			Credentials: stsCreds,
@@ -219,13 +280,13 @@ func (c *Conn) newAWSSession(logger *zap.Logger, roleArn string, region string)
 // getSTSCreds gets STS credentials from regional endpoint. ErrCodeRegionDisabledException is received if the
 // STS regional endpoint is disabled. In this case STS credentials are fetched from STS primary regional endpoint
 // in the respective AWS partition.
-func getSTSCreds(logger *zap.Logger, region string, roleArn string) (*credentials.Credentials, error) {
-	t, err := GetDefaultSession(logger)
+func getSTSCreds(logger *zap.Logger, region string, roleArn string, externalID string, noIMDS bool) (*credentials.Credentials, error) {
+	t, err := getBaseSession(logger, noIMDS)
 	if err != nil {
 		return nil, err
 	}
 
-	stsCred := getSTSCredsFromRegionEndpoint(logger, t, region, roleArn)
+	stsCred := getSTSCredsFromRegionEndpoint(logger, t, region, roleArn, externalID)
 	// Make explicit call to fetch credentials.
 	_, err = stsCred.Get()
 	if err != nil {
@@ -234,18 +295,60 @@ func getSTSCreds(logger *zap.Logger, region string, roleArn string) (*credential
 			switch aerr.Code() {
 			case sts.ErrCodeRegionDisabledException:
 				logger.Error("Region ", zap.String("region", region), zap.String("error", aerr.Error()))
-				stsCred = getSTSCredsFromPrimaryRegionEndpoint(logger, t, roleArn, region)
+				stsCred = getSTSCredsFromPrimaryRegionEndpoint(logger, t, roleArn, externalID, region)
 			}
 		}
 	}
 	return stsCred, err
 }
 
+// getWebIdentityRoleARN returns the role to assume with the web identity token of AWS_WEB_IDENTITY_TOKEN_FILE, as
+// set up by IAM roles for service accounts: the configured role when AWS_ROLE_ARN is not set or is the same role,
+// and AWS_ROLE_ARN when no role is configured. It returns an empty string when there is no token file, or when the
+// configured role is a different one, assumed with the credentials of AWS_ROLE_ARN.
+func getWebIdentityRoleARN(roleArn string) string {
+	if os.Getenv(webIdentityTokenFileEnvVar) == "" {
+		return ""
+	}
+	envRoleArn := os.Getenv(roleARNEnvVar)
+	if roleArn == "" {
+		return envRoleArn
+	}
+	if envRoleArn == "" || envRoleArn == roleArn {
+		return roleArn
+	}
+	return ""
+}
+
+// getWebIdentityCreds gets the credentials of roleArn from the regional STS endpoint, with the web identity token
+// of AWS_WEB_IDENTITY_TOKEN_FILE. They are fetched explicitly so that a missing token file or a role that does not
+// trust the identity provider are logged when the session is created.
+func getWebIdentityCreds(logger *zap.Logger, region string, roleArn string) (*credentials.Credentials, error) {
+	t, err := GetDefaultSession(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenFile := os.Getenv(webIdentityTokenFileEnvVar)
+	regionalEndpoint := getSTSRegionalEndpoint(region)
+	st := sts.New(t, &aws.Config{Region: aws.String(region), Endpoint: &regionalEndpoint})
+	logger.Info("Using web identity credentials", zap.String("roleARN", roleArn), zap.String("tokenFile", tokenFile),
+		zap.String("endpoint", st.Endpoint))
+	webIdentityCreds := credentials.NewCredentials(stscreds.NewWebIdentityRoleProvider(st, roleArn,
+		os.Getenv(roleSessionNameEnvVar), tokenFile))
+	// Make explicit call to fetch credentials.
+	if _, err = webIdentityCreds.Get(); err != nil {
+		logger.Error("Unable to assume the role with the web identity token", zap.String("roleARN", roleArn),
+			zap.String("tokenFile", tokenFile), zap.Error(err))
+	}
+	return webIdentityCreds, err
+}
+
 // getSTSCredsFromRegionEndpoint fetches STS credentials for provided roleARN from regional endpoint.
 // AWS STS recommends that you provide both the Region and endpoint when you make calls to a Regional endpoint.
 // Reference: https://docs.aws.amazon.com/IAM/latest/UserGuide/id_credentials_temp_enable-regions.html#id_credentials_temp_enable-regions_writing_code
 func getSTSCredsFromRegionEndpoint(logger *zap.Logger, sess *session.Session, region string,
-	roleArn string) *credentials.Credentials {
+	roleArn string, externalID string) *credentials.Credentials {
 	regionalEndpoint := getSTSRegionalEndpoint(region)
 	// if regionalEndpoint is "", the STS endpoint is Global endpoint for classic regions except ap-east-1 - (HKG)
 	// for other opt-in regions, region value will create STS regional endpoint.
@@ -253,21 +356,30 @@ func getSTSCredsFromRegionEndpoint(logger *zap.Logger, sess *session.Session, re
 	c := &aws.Config{Region: aws.String(region), Endpoint: &regionalEndpoint}
 	st := sts.New(sess, c)
 	logger.Info("STS Endpoint ", zap.String("endpoint", st.Endpoint))
-	return stscreds.NewCredentialsWithClient(st, roleArn)
+	return stscreds.NewCredentialsWithClient(st, roleArn, withExternalID(externalID))
+}
+
+// withExternalID sets the external ID passed when assuming the role, if any
+func withExternalID(externalID string) func(*stscreds.AssumeRoleProvider) {
+	return func(provider *stscreds.AssumeRoleProvider) {
+		if externalID != "" {
+			provider.ExternalID = aws.String(externalID)
+		}
+	}
 }
 
 // getSTSCredsFromPrimaryRegionEndpoint fetches STS credentials for provided roleARN from primary region endpoint in
 // the respective partition.
-func getSTSCredsFromPrimaryRegionEndpoint(logger *zap.Logger, t *session.Session, roleArn string,
+func getSTSCredsFromPrimaryRegionEndpoint(logger *zap.Logger, t *session.Session, roleArn string, externalID string,
 	region string) *credentials.Credentials {
 	logger.Info("Credentials for provided RoleARN being fetched from STS primary region endpoint.")
 	partitionID := getPartition(region)
 	if partitionID == endpoints.AwsPartitionID {
-		return getSTSCredsFromRegionEndpoint(logger, t, endpoints.UsEast1RegionID, roleArn)
+		return getSTSCredsFromRegionEndpoint(logger, t, endpoints.UsEast1RegionID, roleArn, externalID)
 	} else if partitionID == endpoints.AwsCnPartitionID {
-		return getSTSCredsFromRegionEndpoint(logger, t, endpoints.CnNorth1RegionID, roleArn)
+		return getSTSCredsFromRegionEndpoint(logger, t, endpoints.CnNorth1RegionID, roleArn, externalID)
 	} else if partitionID == endpoints.AwsUsGovPartitionID {
-		return getSTSCredsFromRegionEndpoint(logger, t, endpoints.UsGovWest1RegionID, roleArn)
+		return getSTSCredsFromRegionEndpoint(logger, t, endpoints.UsGovWest1RegionID, roleArn, externalID)
 	}
 
 	return nil
@@ -294,6 +406,30 @@ func GetDefaultSession(logger *zap.Logger) (*session.Session, error) {
 	return result, nil
 }
 
+// getBaseSession returns the default session or, with noIMDS, a session whose credentials are only read from the
+// environment variables and the shared credentials file, so that the EC2 instance metadata is never called.
+func getBaseSession(logger *zap.Logger, noIMDS bool) (*session.Session, error) {
+	if !noIMDS {
+		return GetDefaultSession(logger)
+	}
+	result, serr := session.NewSession(&aws.Config{
+		Credentials: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvProvider{},
+			&credentials.SharedCredentialsProvider{},
+		}),
+	})
+	if serr != nil {
+		logger.Error("Error in creating session object ", zap.Error(serr))
+		return result, serr
+	}
+	return result, nil
+}
+
+// isEC2MetadataDisabled tells whether the EC2 instance metadata service is disabled for the AWS SDK
+func isEC2MetadataDisabled() bool {
+	return strings.EqualFold(os.Getenv(ec2MetadataDisabledEnvVar), "true")
+}
+
 // getPartition return AWS Partition for the provided region.
 func getPartition(region string) string {
 	p, _ := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
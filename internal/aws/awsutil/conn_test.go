@@ -16,14 +16,23 @@ package awsutil
 
 import (
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -45,7 +54,7 @@ func (c *mockConn) getEC2Region(s *session.Session) (string, error) {
 	return ec2Region, nil
 }
 
-func (c *mockConn) newAWSSession(logger *zap.Logger, roleArn string, region string) (*session.Session, error) {
+func (c *mockConn) newAWSSession(logger *zap.Logger, roleArn string, externalID string, region string, noIMDS bool) (*session.Session, error) {
 	return c.sn, nil
 }
 
@@ -64,6 +73,20 @@ func TestEC2Session(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+// local mode does not disable the ec2 meta data service, NoIMDS does
+func TestEC2SessionLocalMode(t *testing.T) {
+	logger := zap.NewNop()
+	sessionCfg := CreateDefaultSessionConfig()
+	sessionCfg.LocalMode = true
+	m := new(mockConn)
+	m.On("getEC2Region", nil).Return("").Once()
+	m.sn, _ = session.NewSession()
+	cfg, _, err := GetAWSConfigSession(logger, m, &sessionCfg)
+	require.NoError(t, err)
+	assert.Equal(t, ec2Region, *cfg.Region)
+	m.AssertExpectations(t)
+}
+
 // fetch region value from environment variable
 func TestRegionEnv(t *testing.T) {
 	logger := zap.NewNop()
@@ -118,6 +141,75 @@ func TestGetAWSConfigSessionWithEC2RegionErr(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+// blockingConn looks the region up in an unreachable EC2 metadata service
+type blockingConn struct {
+	mockConn
+	unblock chan struct{}
+}
+
+func (c *blockingConn) getEC2Region(s *session.Session) (string, error) {
+	<-c.unblock
+	return "", errors.New("EC2 metadata unreachable")
+}
+
+func TestGetAWSConfigSessionWithEC2MetadataDisabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		noIMDS bool
+		env    string
+	}{
+		{name: "no IMDS", noIMDS: true},
+		{name: "environment variable", env: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := stashEnv()
+			defer popEnv(env)
+			os.Setenv(ec2MetadataDisabledEnvVar, tt.env)
+			sessionCfg := CreateDefaultSessionConfig()
+			sessionCfg.NoIMDS = tt.noIMDS
+			m := &blockingConn{unblock: make(chan struct{})}
+			defer close(m.unblock)
+
+			done := make(chan error, 1)
+			go func() {
+				_, _, err := GetAWSConfigSession(zap.NewNop(), m, &sessionCfg)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				var awsErr awserr.Error
+				require.True(t, errors.As(err, &awsErr))
+				assert.Equal(t, "NoAwsRegion", awsErr.Code())
+				assert.Contains(t, awsErr.Message(), "EC2 metadata is disabled")
+			case <-time.After(5 * time.Second):
+				t.Fatal("the region was looked up in the EC2 metadata")
+			}
+		})
+	}
+}
+
+func TestGetBaseSessionNoIMDS(t *testing.T) {
+	env := stashEnv()
+	defer popEnv(env)
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/fake/credentials")
+
+	s, err := getBaseSession(zap.NewNop(), true)
+	require.NoError(t, err)
+	_, err = s.Config.Credentials.Get()
+	var awsErr awserr.Error
+	require.True(t, errors.As(err, &awsErr))
+	assert.Equal(t, "NoCredentialProviders", awsErr.Code())
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	s, err = getBaseSession(zap.NewNop(), true)
+	require.NoError(t, err)
+	value, err := s.Config.Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, credentials.EnvProviderName, value.ProviderName)
+}
+
 func TestNewAWSSessionWithErr(t *testing.T) {
 	logger := zap.NewNop()
 	roleArn := "fake_arn"
@@ -127,11 +219,11 @@ func TestNewAWSSessionWithErr(t *testing.T) {
 	os.Setenv("AWS_EC2_METADATA_DISABLED", "true")
 	os.Setenv("AWS_STS_REGIONAL_ENDPOINTS", "fake")
 	conn := &Conn{}
-	se, err := conn.newAWSSession(logger, roleArn, region)
+	se, err := conn.newAWSSession(logger, roleArn, "", region, false)
 	assert.NotNil(t, err)
 	assert.Nil(t, se)
 	roleArn = ""
-	se, err = conn.newAWSSession(logger, roleArn, region)
+	se, err = conn.newAWSSession(logger, roleArn, "", region, false)
 	assert.NotNil(t, err)
 	assert.Nil(t, se)
 	os.Setenv("AWS_SDK_LOAD_CONFIG", "true")
@@ -151,10 +243,10 @@ func TestGetSTSCredsFromPrimaryRegionEndpoint(t *testing.T) {
 	regions := []string{"us-east-1", "us-gov-west-1", "cn-north-1"}
 
 	for _, region := range regions {
-		creds := getSTSCredsFromPrimaryRegionEndpoint(logger, session, "", region)
+		creds := getSTSCredsFromPrimaryRegionEndpoint(logger, session, "", "", region)
 		assert.NotNil(t, creds)
 	}
-	creds := getSTSCredsFromPrimaryRegionEndpoint(logger, session, "", "fake_region")
+	creds := getSTSCredsFromPrimaryRegionEndpoint(logger, session, "", "", "fake_region")
 	assert.Nil(t, creds)
 }
 
@@ -171,15 +263,171 @@ func TestGetSTSCreds(t *testing.T) {
 	logger := zap.NewNop()
 	region := "fake_region"
 	roleArn := ""
-	_, err := getSTSCreds(logger, region, roleArn)
+	_, err := getSTSCreds(logger, region, roleArn, "", false)
 	assert.Nil(t, err)
 	env := stashEnv()
 	defer popEnv(env)
 	os.Setenv("AWS_STS_REGIONAL_ENDPOINTS", "fake")
-	_, err = getSTSCreds(logger, region, roleArn)
+	_, err = getSTSCreds(logger, region, roleArn, "", false)
 	assert.NotNil(t, err)
 }
 
+func TestWithExternalID(t *testing.T) {
+	provider := &stscreds.AssumeRoleProvider{}
+	withExternalID("")(provider)
+	assert.Nil(t, provider.ExternalID)
+	withExternalID("central-logging")(provider)
+	assert.Equal(t, "central-logging", *provider.ExternalID)
+}
+
+func TestGetWebIdentityRoleARN(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokenFile  string
+		envRoleArn string
+		roleArn    string
+		want       string
+	}{
+		{name: "no token file", envRoleArn: "arn:aws:iam::123456789012:role/irsa"},
+		{name: "environment role", tokenFile: "/var/run/secrets/token", envRoleArn: "arn:aws:iam::123456789012:role/irsa", want: "arn:aws:iam::123456789012:role/irsa"},
+		{name: "configured role", tokenFile: "/var/run/secrets/token", roleArn: "arn:aws:iam::123456789012:role/logs", want: "arn:aws:iam::123456789012:role/logs"},
+		{name: "same roles", tokenFile: "/var/run/secrets/token", envRoleArn: "arn:aws:iam::123456789012:role/logs", roleArn: "arn:aws:iam::123456789012:role/logs", want: "arn:aws:iam::123456789012:role/logs"},
+		{name: "chained roles", tokenFile: "/var/run/secrets/token", envRoleArn: "arn:aws:iam::123456789012:role/irsa", roleArn: "arn:aws:iam::210987654321:role/logs"},
+		{name: "no role", tokenFile: "/var/run/secrets/token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(webIdentityTokenFileEnvVar, tt.tokenFile)
+			t.Setenv(roleARNEnvVar, tt.envRoleArn)
+			assert.Equal(t, tt.want, getWebIdentityRoleARN(tt.roleArn))
+		})
+	}
+}
+
+func TestNewAWSSessionWithWebIdentity(t *testing.T) {
+	logger := zap.NewNop()
+	env := stashEnv()
+	defer popEnv(env)
+	os.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	os.Setenv(webIdentityTokenFileEnvVar, "/fake/token")
+	os.Setenv(roleARNEnvVar, "arn:aws:iam::123456789012:role/irsa")
+
+	conn := &Conn{}
+	se, err := conn.newAWSSession(logger, "", "", "us-west-2", false)
+	require.NoError(t, err)
+	// the token is read by the web identity provider, before any call to STS
+	_, err = se.Config.Credentials.Get()
+	var awsErr awserr.Error
+	require.True(t, errors.As(err, &awsErr))
+	assert.Equal(t, stscreds.ErrCodeWebIdentity, awsErr.Code())
+	assert.Contains(t, awsErr.Error(), "/fake/token")
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	var connects []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodConnect {
+			connects = append(connects, r.Host)
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer proxy.Close()
+	recorded := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), connects...)
+	}
+
+	tests := []struct {
+		name         string
+		proxyAddress string
+		env          map[string]string
+		want         []string
+	}{
+		{
+			name:         "proxy address",
+			proxyAddress: proxy.URL,
+			want:         []string{"logs.us-east-1.amazonaws.com:443"},
+		},
+		{
+			name: "HTTPS_PROXY",
+			env:  map[string]string{"HTTPS_PROXY": proxy.URL},
+			want: []string{"logs.us-east-1.amazonaws.com:443"},
+		},
+		{
+			name:         "NO_PROXY",
+			proxyAddress: proxy.URL,
+			env:          map[string]string{"NO_PROXY": ".amazonaws.com"},
+		},
+		{
+			name: "no proxy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			connects = nil
+			mu.Unlock()
+			for _, key := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			client, err := newHTTPClient(zap.NewNop(), 1, 1, false, tt.proxyAddress)
+			require.NoError(t, err)
+			resp, err := client.Get("https://logs.us-east-1.amazonaws.com/")
+			if err == nil {
+				resp.Body.Close()
+			}
+			assert.Equal(t, tt.want, recorded())
+		})
+	}
+}
+
+func TestGetProxyFunc(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("HTTP_PROXY", "http://plain-proxy:3128")
+	t.Setenv("NO_PROXY", "")
+
+	proxy, err := getProxyFunc("")
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "http://logs.us-east-1.amazonaws.com/", nil)
+	proxyURL, err := proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://plain-proxy:3128", proxyURL.String())
+
+	_, err = getProxyFunc("://invalid")
+	assert.Error(t, err)
+}
+
+func TestGetAWSConfigSessionUseFIPSEndpoint(t *testing.T) {
+	sessionCfg := CreateDefaultSessionConfig()
+	sessionCfg.Region = "us-west-2"
+	sessionCfg.UseFIPSEndpoint = true
+	m := &mockConn{}
+	m.sn, _ = session.NewSession()
+
+	cfg, s, err := GetAWSConfigSession(zap.NewNop(), m, &sessionCfg)
+	require.NoError(t, err)
+	assert.Equal(t, "https://sts-fips.us-west-2.amazonaws.com", sts.New(s, cfg).Endpoint)
+
+	sessionCfg.UseFIPSEndpoint = false
+	cfg, s, err = GetAWSConfigSession(zap.NewNop(), m, &sessionCfg)
+	require.NoError(t, err)
+	assert.NotContains(t, sts.New(s, cfg).Endpoint, "fips")
+}
+
+func TestCheckFIPSEndpoint(t *testing.T) {
+	assert.NoError(t, CheckFIPSEndpoint(sts.EndpointsID, "us-east-1"))
+	assert.NoError(t, CheckFIPSEndpoint(sts.EndpointsID, "us-gov-west-1"))
+
+	err := CheckFIPSEndpoint(sts.EndpointsID, "eu-west-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no FIPS endpoint of sts in region "eu-west-1"`)
+}
+
 func stashEnv() []string {
 	env := os.Environ()
 	os.Clearenv()
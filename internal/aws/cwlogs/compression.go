@@ -0,0 +1,99 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwlogs // import "github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// errCodeSerializationException is returned by CloudWatch Logs for a request body it cannot read
+	errCodeSerializationException = "SerializationException"
+
+	// defaultCompressionThreshold is the minimum size in bytes of the compressed request bodies when it is not set:
+	// gzip saves little on smaller bodies, if anything, for the CPU it takes.
+	defaultCompressionThreshold = 1024
+)
+
+// requestCompression gzips the bodies of the PutLogEvents requests, until the endpoint rejects a compressed request.
+// It is shared by the copies of a client.
+type requestCompression struct {
+	// disabled is set once the endpoint rejected a compressed request
+	disabled int32
+	// threshold is the minimum size in bytes of the compressed bodies, the smaller ones are sent as is
+	threshold int64
+}
+
+// WithRequestCompression gzips the bodies of the PutLogEvents requests of at least threshold bytes, or 1024 bytes
+// when it is not positive, which cuts the bandwidth of large requests. The SDK does not compress the requests of
+// CloudWatch Logs itself. When the endpoint rejects a compressed request, the request is sent again uncompressed,
+// and the client stops compressing.
+func WithRequestCompression(enabled bool, threshold int) ClientOption {
+	return func(client *Client) {
+		if !enabled {
+			return
+		}
+		if threshold <= 0 {
+			threshold = defaultCompressionThreshold
+		}
+		client.compression = &requestCompression{threshold: int64(threshold)}
+	}
+}
+
+// rejected tells whether the error is the endpoint rejecting a compressed request, disabling the compression.
+func (c *requestCompression) rejected(err error) bool {
+	if c == nil {
+		return false
+	}
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok || reqErr.StatusCode() != http.StatusUnsupportedMediaType && reqErr.Code() != errCodeSerializationException {
+		return false
+	}
+	atomic.StoreInt32(&c.disabled, 1)
+	return true
+}
+
+func newRequestCompressionHandler(c *requestCompression) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "otel.collector.RequestCompressionHandler",
+		Fn: func(r *request.Request) {
+			if r.Operation.Name != putLogEventsOperation || r.Error != nil || atomic.LoadInt32(&c.disabled) != 0 {
+				return
+			}
+			if n, err := aws.SeekerLen(r.GetBody()); err != nil || n < c.threshold {
+				return
+			}
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			if _, err := io.Copy(zw, r.GetBody()); err != nil {
+				return
+			}
+			if err := zw.Close(); err != nil {
+				return
+			}
+			// the compressed body is signed after the build handlers
+			r.SetBufferBody(buf.Bytes())
+			r.HTTPRequest.Header.Set("Content-Encoding", "gzip")
+		},
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwlogs
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.uber.org/zap"
+)
+
+// compressionServer is a fake CloudWatch Logs endpoint recording the Content-Encoding of the PutLogEvents requests
+type compressionServer struct {
+	sync.Mutex
+	encodings []string
+	bodies    []string
+	// rejectGzip answers the compressed requests like an endpoint that does not accept them
+	rejectGzip bool
+}
+
+func (s *compressionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	encoding := r.Header.Get("Content-Encoding")
+	s.encodings = append(s.encodings, encoding)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if encoding == "gzip" && s.rejectGzip {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		_, _ = w.Write([]byte(`{"__type":"UnsupportedMediaTypeException","message":"Unsupported Content-Encoding"}`))
+		return
+	}
+	body := r.Body
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	content, _ := ioutil.ReadAll(body)
+	s.bodies = append(s.bodies, string(content))
+	_, _ = w.Write([]byte(`{"nextSequenceToken":"1234"}`))
+}
+
+func newCompressionClient(t *testing.T, url string, enabled bool, threshold int) *Client {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(url),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	require.NoError(t, err)
+	return NewClient(zap.NewNop(), &aws.Config{}, component.BuildInfo{}, "", sess, WithRequestCompression(enabled, threshold))
+}
+
+func newCompressionInput() *cloudwatchlogs.PutLogEventsInput {
+	return newCompressionInputWithMessage("hello")
+}
+
+func newCompressionInputWithMessage(message string) *cloudwatchlogs.PutLogEventsInput {
+	return &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String("group"),
+		LogStreamName: aws.String("stream"),
+		LogEvents:     []*cloudwatchlogs.InputLogEvent{{Timestamp: aws.Int64(1609763415000), Message: aws.String(message)}},
+	}
+}
+
+func TestRequestCompression(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		server := &compressionServer{}
+		httpServer := httptest.NewServer(server)
+		client := newCompressionClient(t, httpServer.URL, enabled, 1)
+
+		token, err := client.PutLogEvents(newCompressionInput(), defaultRetryCount)
+		httpServer.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "1234", aws.StringValue(token))
+		require.Len(t, server.encodings, 1)
+		if enabled {
+			assert.Equal(t, "gzip", server.encodings[0])
+		} else {
+			assert.Empty(t, server.encodings[0])
+		}
+		require.Len(t, server.bodies, 1)
+		assert.Contains(t, server.bodies[0], `"message":"hello"`)
+	}
+}
+
+func TestRequestCompressionRejected(t *testing.T) {
+	server := &compressionServer{rejectGzip: true}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := newCompressionClient(t, httpServer.URL, true, 1)
+
+	// the rejected request is sent again uncompressed, without using up a retry
+	token, err := client.PutLogEvents(newCompressionInput(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "1234", aws.StringValue(token))
+	token, err = client.PutLogEvents(newCompressionInput(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "1234", aws.StringValue(token))
+	assert.Equal(t, []string{"gzip", "", ""}, server.encodings)
+}
+
+func TestRequestCompressionThreshold(t *testing.T) {
+	server := &compressionServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	// the default threshold of 1024 bytes
+	client := newCompressionClient(t, httpServer.URL, true, 0)
+
+	// the small bodies are sent uncompressed, the large ones compressed
+	_, err := client.PutLogEvents(newCompressionInput(), 0)
+	require.NoError(t, err)
+	large := strings.Repeat("a", defaultCompressionThreshold)
+	_, err = client.PutLogEvents(newCompressionInputWithMessage(large), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "gzip"}, server.encodings)
+	require.Len(t, server.bodies, 2)
+	assert.Contains(t, server.bodies[0], `"message":"hello"`)
+	assert.Contains(t, server.bodies[1], large)
+}
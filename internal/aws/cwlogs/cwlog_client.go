@@ -15,8 +15,16 @@
 package cwlogs // import "github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -26,6 +34,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 	"go.opentelemetry.io/collector/component"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs/handler"
 )
@@ -36,41 +45,164 @@ const (
 	// this is the retry count, the total attempts will be at most retry count + 1.
 	defaultRetryCount          = 1
 	errCodeThrottlingException = "ThrottlingException"
+	putLogEventsOperation      = "PutLogEvents"
 )
 
+// handledResponseFields are the fields of the PutLogEvents response used by the client
+var handledResponseFields = map[string]bool{
+	"nextSequenceToken":     true,
+	"rejectedLogEventsInfo": true,
+}
+
 // Possible exceptions are combination of common errors (https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/CommonErrors.html)
 // and API specific erros (e.g. https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html#API_PutLogEvents_Errors)
 type Client struct {
 	svc    cloudwatchlogsiface.CloudWatchLogsAPI
 	logger *zap.Logger
+	// creations holds a slot for each log group and log stream creation in flight, nil when they are unbounded.
+	// Pushers copy the client, the channel is shared by the copies.
+	creations chan struct{}
+	// skew is measured from the responses of the servers, shared by the copies. It is nil when not measured.
+	skew *clockSkew
+	// groups are the settings applied to the log groups, shared by the copies. It is nil when the log groups are
+	// left as is.
+	groups *logGroupSettings
+	// compression gzips the PutLogEvents requests, shared by the copies. It is nil when they are not compressed.
+	compression *requestCompression
+}
+
+// logGroupSettings are the settings applied to the log groups, along with the existing log groups already
+// reconciled with them.
+type logGroupSettings struct {
+	retentionDays  int64
+	forceRetention bool
+	kmsKeyARN      string
+	tags           map[string]*string
+	// reconciled holds the names of the log groups already created or reconciled
+	reconciled sync.Map
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithMaxConcurrentCreations bounds the number of CreateStream calls creating log groups and log streams at the
+// same time, e.g. when many pushers start together, so that the burst does not get throttled. The pushes are not
+// bounded. Creations are unbounded when max is 0.
+func WithMaxConcurrentCreations(max int) ClientOption {
+	return func(client *Client) {
+		if max > 0 {
+			client.creations = make(chan struct{}, max)
+		}
+	}
+}
+
+// WithLogRetention sets the retention, in days, of the log groups created by the client, which CloudWatch Logs keeps
+// forever by default. With force, the retention of the existing log groups the client creates log streams in is set
+// too, once per log group. The retention is left as is when days is 0.
+func WithLogRetention(days int64, force bool) ClientOption {
+	return func(client *Client) {
+		if days > 0 {
+			groups := client.groupSettings()
+			groups.retentionDays, groups.forceRetention = days, force
+		}
+	}
+}
+
+// WithKMSKey associates the KMS key with the log groups created by the client, so that their events are encrypted
+// with it. The existing log groups the client creates log streams in are not changed, but a warning is logged once
+// per log group when they are not encrypted with the key. The log groups are not encrypted when keyARN is empty.
+func WithKMSKey(keyARN string) ClientOption {
+	return func(client *Client) {
+		if keyARN != "" {
+			client.groupSettings().kmsKeyARN = keyARN
+		}
+	}
+}
+
+// WithLogGroupTags sets the tags of the log groups created by the client, e.g. for cost allocation. The existing log
+// groups are not tagged.
+func WithLogGroupTags(tags map[string]string) ClientOption {
+	return func(client *Client) {
+		if len(tags) > 0 {
+			client.groupSettings().tags = aws.StringMap(tags)
+		}
+	}
+}
+
+func (client *Client) groupSettings() *logGroupSettings {
+	if client.groups == nil {
+		client.groups = &logGroupSettings{}
+	}
+	return client.groups
 }
 
 //Create a log client based on the actual cloudwatch logs client.
-func newCloudWatchLogClient(svc cloudwatchlogsiface.CloudWatchLogsAPI, logger *zap.Logger) *Client {
+func newCloudWatchLogClient(svc cloudwatchlogsiface.CloudWatchLogsAPI, logger *zap.Logger, opts ...ClientOption) *Client {
 	logClient := &Client{svc: svc,
 		logger: logger}
+	for _, opt := range opts {
+		opt(logClient)
+	}
 	return logClient
 }
 
 // NewClient create Client
-func NewClient(logger *zap.Logger, awsConfig *aws.Config, buildInfo component.BuildInfo, logGroupName string, sess *session.Session) *Client {
+func NewClient(logger *zap.Logger, awsConfig *aws.Config, buildInfo component.BuildInfo, logGroupName string, sess *session.Session, opts ...ClientOption) *Client {
 	client := cloudwatchlogs.New(sess, awsConfig)
 	client.Handlers.Build.PushBackNamed(handler.RequestStructuredLogHandler)
 	client.Handlers.Build.PushFrontNamed(newCollectorUserAgentHandler(buildInfo, logGroupName))
-	return newCloudWatchLogClient(client, logger)
+	client.Handlers.Unmarshal.PushFrontNamed(newUnhandledResponseFieldsHandler(logger))
+	skew := &clockSkew{}
+	client.Handlers.Complete.PushBackNamed(newClockSkewHandler(skew))
+	logClient := newCloudWatchLogClient(client, logger, opts...)
+	logClient.skew = skew
+	if logClient.compression != nil {
+		client.Handlers.Build.PushBackNamed(newRequestCompressionHandler(logClient.compression))
+	}
+	return logClient
+}
+
+// ClockSkew returns how far the clock of the CloudWatch Logs servers is ahead of the local one, as measured from
+// the Date header of the last response, with a precision of a second. It is 0 until a response is received.
+func (client *Client) ClockSkew() time.Duration {
+	if client.skew == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&client.skew.nanos))
 }
 
 //PutLogEvents mainly handles different possible error could be returned from server side, and retries them
 //if necessary.
 func (client *Client) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput, retryCnt int) (*string, error) {
+	return client.PutLogEventsWithContext(context.Background(), input, retryCnt)
+}
+
+// PutLogEventsWithContext is PutLogEvents with a context, which interrupts the request and stops the retries when
+// it is cancelled.
+func (client *Client) PutLogEventsWithContext(ctx context.Context, input *cloudwatchlogs.PutLogEventsInput, retryCnt int) (*string, error) {
 	var response *cloudwatchlogs.PutLogEventsOutput
 	var err error
 	var token = input.SequenceToken
+	// fallback is set once a compressed request was rejected, and sent again uncompressed
+	fallback := false
 
 	for i := 0; i <= retryCnt; i++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return token, ctxErr
+		}
 		input.SequenceToken = token
-		response, err = client.svc.PutLogEvents(input)
+		response, err = client.svc.PutLogEventsWithContext(ctx, input)
 		if err != nil {
+			// the request was interrupted, it may have been written already
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return token, ctxErr
+			}
+			if !fallback && client.compression.rejected(err) {
+				client.logger.Warn("cwlog_client: The compressed PutLogEvents request is rejected, sending the requests uncompressed", zap.Error(err))
+				fallback = true
+				i--
+				continue
+			}
 			awsErr, ok := err.(awserr.Error)
 			if !ok {
 				client.logger.Error("Cannot cast PutLogEvents error into awserr.Error.", zap.Error(err))
@@ -82,12 +214,11 @@ func (client *Client) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput, retr
 				return token, err
 			case *cloudwatchlogs.InvalidSequenceTokenException: //Resend log events with new sequence token when InvalidSequenceTokenException happens
 				client.logger.Warn("cwlog_client: Error occurs in PutLogEvents, will search the next token and retry the request", zap.Error(e))
-				token = e.ExpectedSequenceToken
+				token = expectedSequenceToken(e.ExpectedSequenceToken, e.Message())
 				continue
-			case *cloudwatchlogs.DataAlreadyAcceptedException: //Skip batch if DataAlreadyAcceptedException happens
-				client.logger.Warn("cwlog_client: Error occurs in PutLogEvents, drop this request and continue to the next request", zap.Error(e))
-				token = e.ExpectedSequenceToken
-				return token, err
+			case *cloudwatchlogs.DataAlreadyAcceptedException: //The batch was written already, e.g. by a retried request
+				client.logger.Info("cwlog_client: The events of the PutLogEvents request were already accepted", zap.Error(e))
+				return expectedSequenceToken(e.ExpectedSequenceToken, e.Message()), nil
 			case *cloudwatchlogs.OperationAbortedException: //Retry request if OperationAbortedException happens
 				client.logger.Warn("cwlog_client: Error occurs in PutLogEvents, will retry the request", zap.Error(e))
 				return token, err
@@ -144,6 +275,13 @@ func (client *Client) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput, retr
 
 //Prepare the readiness for the log group and log stream.
 func (client *Client) CreateStream(logGroup, streamName *string) (token string, e error) {
+	if client.creations != nil {
+		// wait for a slot, the creations beyond the bound queue up
+		client.creations <- struct{}{}
+		defer func() { <-client.creations }()
+	}
+	// created is set when the log group is created by this call
+	created := false
 	//CreateLogStream / CreateLogGroup
 	_, err := client.svc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
 		LogGroupName:  logGroup,
@@ -152,10 +290,18 @@ func (client *Client) CreateStream(logGroup, streamName *string) (token string,
 	if err != nil {
 		client.logger.Debug("cwlog_client: creating stream fail", zap.Error(err))
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
-			_, err = client.svc.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
-				LogGroupName: logGroup,
-			})
+			input := &cloudwatchlogs.CreateLogGroupInput{LogGroupName: logGroup}
+			if client.groups != nil {
+				input.Tags = client.groups.tags
+			}
+			_, err = client.svc.CreateLogGroup(input)
 			if err == nil {
+				created = true
+				client.configureLogGroup(logGroup)
+			}
+			// The log group may have been created concurrently, e.g. by another pusher, since the first attempt.
+			// The stream still needs to be created in that case.
+			if err == nil || isResourceAlreadyExists(err) {
 				_, err = client.svc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
 					LogGroupName:  logGroup,
 					LogStreamName: streamName,
@@ -164,21 +310,184 @@ func (client *Client) CreateStream(logGroup, streamName *string) (token string,
 		}
 	}
 
-	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
-			return "", nil
-		}
+	if err != nil && !isResourceAlreadyExists(err) {
 		client.logger.Debug("CreateLogStream / CreateLogGroup has errors.", zap.String("LogGroupName", *logGroup), zap.String("LogStreamName", *streamName), zap.Error(e))
 		return token, err
 	}
+	if !created {
+		client.reconcileLogGroup(logGroup)
+	}
 
 	//After a log stream is created the token is always empty.
 	return "", nil
 }
 
+// configureLogGroup applies the settings to a log group created by the client. Failures are logged rather than
+// returned, since the events can still be sent to the log group.
+func (client *Client) configureLogGroup(logGroup *string) {
+	if client.groups == nil {
+		return
+	}
+	client.groups.reconciled.Store(*logGroup, true)
+	if client.groups.retentionDays > 0 {
+		client.putRetentionPolicy(logGroup)
+	}
+	if client.groups.kmsKeyARN != "" {
+		_, err := client.svc.AssociateKmsKey(&cloudwatchlogs.AssociateKmsKeyInput{
+			LogGroupName: logGroup,
+			KmsKeyId:     aws.String(client.groups.kmsKeyARN),
+		})
+		if err != nil {
+			client.logger.Warn("Failed to associate the KMS key with the log group", zap.String("LogGroupName", *logGroup),
+				zap.String("KmsKeyId", client.groups.kmsKeyARN), zap.Error(err))
+		}
+	}
+}
+
+// reconcileLogGroup applies the settings to an existing log group, the first time the client creates a log stream
+// in it: the retention is set when it is forced, and a warning is logged when the log group is not encrypted with
+// the KMS key.
+func (client *Client) reconcileLogGroup(logGroup *string) {
+	if client.groups == nil {
+		return
+	}
+	if _, loaded := client.groups.reconciled.LoadOrStore(*logGroup, true); loaded {
+		return
+	}
+	if client.groups.retentionDays > 0 && client.groups.forceRetention {
+		client.putRetentionPolicy(logGroup)
+	}
+	if client.groups.kmsKeyARN != "" {
+		client.checkKMSKey(logGroup)
+	}
+}
+
+func (client *Client) putRetentionPolicy(logGroup *string) {
+	_, err := client.svc.PutRetentionPolicy(&cloudwatchlogs.PutRetentionPolicyInput{
+		LogGroupName:    logGroup,
+		RetentionInDays: aws.Int64(client.groups.retentionDays),
+	})
+	if err != nil {
+		client.logger.Warn("Failed to set the retention of the log group", zap.String("LogGroupName", *logGroup),
+			zap.Int64("RetentionInDays", client.groups.retentionDays), zap.Error(err))
+	}
+}
+
+// checkKMSKey logs a warning when an existing log group is not encrypted with the KMS key. Its key is not replaced,
+// as the events already sent may only be readable with it.
+func (client *Client) checkKMSKey(logGroup *string) {
+	output, err := client.svc.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: logGroup,
+	})
+	if err != nil {
+		client.logger.Warn("Failed to check the KMS key of the log group", zap.String("LogGroupName", *logGroup),
+			zap.Error(err))
+		return
+	}
+	for _, group := range output.LogGroups {
+		if aws.StringValue(group.LogGroupName) != *logGroup {
+			continue
+		}
+		if keyARN := aws.StringValue(group.KmsKeyId); keyARN != client.groups.kmsKeyARN {
+			client.logger.Warn("The existing log group is not encrypted with the configured KMS key",
+				zap.String("LogGroupName", *logGroup), zap.String("KmsKeyId", keyARN),
+				zap.String("ConfiguredKmsKeyId", client.groups.kmsKeyARN))
+		}
+		return
+	}
+}
+
+// expectedSequenceTokenPattern matches the expected sequence token in the messages of the sequence token errors,
+// e.g. "The given sequenceToken is invalid. The next expected sequenceToken is: 4960...", in case the error does
+// not hold it in its field.
+var expectedSequenceTokenPattern = regexp.MustCompile(`sequenceToken is: (\S+)`)
+
+// expectedSequenceToken returns the sequence token expected by CloudWatch Logs for the next request, from the
+// field of the error or from its message. It is nil when the log stream has no event yet.
+func expectedSequenceToken(field *string, message string) *string {
+	if field != nil {
+		return field
+	}
+	match := expectedSequenceTokenPattern.FindStringSubmatch(message)
+	if match == nil || match[1] == "null" {
+		return nil
+	}
+	return aws.String(match[1])
+}
+
+func isResourceAlreadyExists(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException
+}
+
+// newUnhandledResponseFieldsHandler logs at debug level the non-empty fields of successful PutLogEvents responses
+// other than the ones handled by PutLogEvents, so that changes of the server behavior are visible. The SDK drops
+// the fields it does not know when unmarshalling, so they are read from the raw response body.
+func newUnhandledResponseFieldsHandler(logger *zap.Logger) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "otel.collector.UnhandledResponseFieldsHandler",
+		Fn: func(r *request.Request) {
+			if r.Operation.Name != putLogEventsOperation || r.HTTPResponse == nil || r.HTTPResponse.Body == nil ||
+				!logger.Core().Enabled(zapcore.DebugLevel) {
+				return
+			}
+			body, err := ioutil.ReadAll(r.HTTPResponse.Body)
+			r.HTTPResponse.Body.Close()
+			// the body is restored for the unmarshal handler of the SDK
+			r.HTTPResponse.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if err != nil {
+				return
+			}
+			var fields map[string]json.RawMessage
+			if json.Unmarshal(body, &fields) != nil {
+				return
+			}
+			for name, value := range fields {
+				if handledResponseFields[name] || isEmptyJSON(value) {
+					continue
+				}
+				logger.Debug("cwlog_client: PutLogEvents response has a field that is not handled",
+					zap.String("Field", name), zap.ByteString("Value", value))
+			}
+		},
+	}
+}
+
+// clockSkew is the difference between the clock of the servers and the local one
+type clockSkew struct {
+	// nanos is accessed atomically
+	nanos int64
+}
+
+// newClockSkewHandler measures the clock skew from the Date header of every response, including the failed ones
+func newClockSkewHandler(skew *clockSkew) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "otel.collector.ClockSkewHandler",
+		Fn: func(r *request.Request) {
+			if r.HTTPResponse == nil {
+				return
+			}
+			date, err := http.ParseTime(r.HTTPResponse.Header.Get("Date"))
+			if err != nil {
+				return
+			}
+			atomic.StoreInt64(&skew.nanos, int64(date.Sub(time.Now())))
+		},
+	}
+}
+
+// isEmptyJSON tells whether a JSON value is null, an empty string, an empty object or an empty array
+func isEmptyJSON(value json.RawMessage) bool {
+	switch string(bytes.TrimSpace(value)) {
+	case "null", `""`, "{}", "[]":
+		return true
+	}
+	return false
+}
+
 func newCollectorUserAgentHandler(buildInfo component.BuildInfo, logGroupName string) request.NamedHandler {
 	fn := request.MakeAddToUserAgentHandler(collectorDistribution, buildInfo.Version)
-	if matchContainerInsightsPattern(logGroupName) {
+	if IsContainerInsightsLogGroup(logGroupName) {
 		fn = request.MakeAddToUserAgentHandler(collectorDistribution, buildInfo.Version, "ContainerInsights")
 	}
 	return request.NamedHandler{
@@ -187,7 +496,9 @@ func newCollectorUserAgentHandler(buildInfo component.BuildInfo, logGroupName st
 	}
 }
 
-func matchContainerInsightsPattern(logGroupName string) bool {
+// IsContainerInsightsLogGroup tells whether the log group holds Container Insights data,
+// which changes the user agent of the requests made for it.
+func IsContainerInsightsLogGroup(logGroupName string) bool {
 	regexP := "^/aws/.*containerinsights/.*/(performance|prometheus)$"
 	r, _ := regexp.Compile(regexP)
 	return r.MatchString(logGroupName)
@@ -17,19 +17,28 @@ package cwlogs
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/component"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func newAlwaysPassMockLogClient(putLogEventsFunc func(args mock.Arguments)) *Client {
@@ -62,6 +71,10 @@ func (svc *mockCloudWatchLogsClient) PutLogEvents(input *cloudwatchlogs.PutLogEv
 	return args.Get(0).(*cloudwatchlogs.PutLogEventsOutput), args.Error(1)
 }
 
+func (svc *mockCloudWatchLogsClient) PutLogEventsWithContext(_ aws.Context, input *cloudwatchlogs.PutLogEventsInput, _ ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	return svc.PutLogEvents(input)
+}
+
 func (svc *mockCloudWatchLogsClient) CreateLogGroup(input *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
 	args := svc.Called(input)
 	return args.Get(0).(*cloudwatchlogs.CreateLogGroupOutput), args.Error(1)
@@ -72,6 +85,21 @@ func (svc *mockCloudWatchLogsClient) CreateLogStream(input *cloudwatchlogs.Creat
 	return args.Get(0).(*cloudwatchlogs.CreateLogStreamOutput), args.Error(1)
 }
 
+func (svc *mockCloudWatchLogsClient) PutRetentionPolicy(input *cloudwatchlogs.PutRetentionPolicyInput) (*cloudwatchlogs.PutRetentionPolicyOutput, error) {
+	args := svc.Called(input)
+	return args.Get(0).(*cloudwatchlogs.PutRetentionPolicyOutput), args.Error(1)
+}
+
+func (svc *mockCloudWatchLogsClient) AssociateKmsKey(input *cloudwatchlogs.AssociateKmsKeyInput) (*cloudwatchlogs.AssociateKmsKeyOutput, error) {
+	args := svc.Called(input)
+	return args.Get(0).(*cloudwatchlogs.AssociateKmsKeyOutput), args.Error(1)
+}
+
+func (svc *mockCloudWatchLogsClient) DescribeLogGroups(input *cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	args := svc.Called(input)
+	return args.Get(0).(*cloudwatchlogs.DescribeLogGroupsOutput), args.Error(1)
+}
+
 func (svc *mockCloudWatchLogsClient) DescribeLogStreams(input *cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
 	args := svc.Called(input)
 	return args.Get(0).(*cloudwatchlogs.DescribeLogStreamsOutput), args.Error(1)
@@ -211,12 +239,52 @@ func TestPutLogEvents_DataAlreadyAcceptedException(t *testing.T) {
 	svc.On("PutLogEvents", putLogEventsInput).Return(putLogEventsOutput, awsErr).Once()
 
 	client := newCloudWatchLogClient(svc, logger)
-	tokenP, _ := client.PutLogEvents(putLogEventsInput, defaultRetryCount)
+	tokenP, err := client.PutLogEvents(putLogEventsInput, defaultRetryCount)
 
+	// the batch was written already
 	svc.AssertExpectations(t)
+	assert.NoError(t, err)
 	assert.Equal(t, expectedNextSequenceToken, *tokenP)
 }
 
+func TestExpectedSequenceToken(t *testing.T) {
+	field := "1234"
+	assert.Equal(t, &field, expectedSequenceToken(&field, "The next expected sequenceToken is: 5678"))
+	assert.Equal(t, aws.String("49612345678901234567890"),
+		expectedSequenceToken(nil, "The given sequenceToken is invalid. The next expected sequenceToken is: 49612345678901234567890"))
+	// the log stream has no event yet
+	assert.Nil(t, expectedSequenceToken(nil, "The given sequenceToken is invalid. The next expected sequenceToken is: null"))
+	assert.Nil(t, expectedSequenceToken(nil, "Rate exceeded"))
+}
+
+func TestPutLogEvents_InvalidSequenceTokenExceptionMessage(t *testing.T) {
+	svc := new(mockCloudWatchLogsClient)
+	putLogEventsInput := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  &logGroup,
+		LogStreamName: &logStreamName,
+		SequenceToken: &previousSequenceToken,
+	}
+	var tokens []*string
+	awsErr := &cloudwatchlogs.InvalidSequenceTokenException{
+		Message_: aws.String("The given sequenceToken is invalid. The next expected sequenceToken is: " + expectedNextSequenceToken),
+	}
+	svc.On("PutLogEvents", putLogEventsInput).Return(new(cloudwatchlogs.PutLogEventsOutput), awsErr).Once().Run(func(args mock.Arguments) {
+		tokens = append(tokens, args.Get(0).(*cloudwatchlogs.PutLogEventsInput).SequenceToken)
+	})
+	svc.On("PutLogEvents", putLogEventsInput).Return(&cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("2222")}, nil).Once().Run(func(args mock.Arguments) {
+		tokens = append(tokens, args.Get(0).(*cloudwatchlogs.PutLogEventsInput).SequenceToken)
+	})
+
+	client := newCloudWatchLogClient(svc, zap.NewNop())
+	tokenP, err := client.PutLogEvents(putLogEventsInput, defaultRetryCount)
+
+	svc.AssertExpectations(t)
+	require.NoError(t, err)
+	assert.Equal(t, "2222", *tokenP)
+	// the batch is resent with the token parsed from the message
+	assert.Equal(t, []*string{&previousSequenceToken, &expectedNextSequenceToken}, tokens)
+}
+
 func TestPutLogEvents_OperationAbortedException(t *testing.T) {
 	logger := zap.NewNop()
 	svc := new(mockCloudWatchLogsClient)
@@ -410,6 +478,265 @@ func TestCreateStream_CreateLogStream_ResourceNotFound(t *testing.T) {
 	assert.Equal(t, emptySequenceToken, token)
 }
 
+func TestCreateStream_CreateLogGroup_ResourceAlreadyExists(t *testing.T) {
+	logger := zap.NewNop()
+	svc := new(mockCloudWatchLogsClient)
+
+	svc.On("CreateLogStream",
+		&cloudwatchlogs.CreateLogStreamInput{LogGroupName: &logGroup, LogStreamName: &logStreamName}).Return(
+		new(cloudwatchlogs.CreateLogStreamOutput), &cloudwatchlogs.ResourceNotFoundException{}).Once()
+
+	svc.On("CreateLogGroup",
+		&cloudwatchlogs.CreateLogGroupInput{LogGroupName: &logGroup}).Return(
+		new(cloudwatchlogs.CreateLogGroupOutput), &cloudwatchlogs.ResourceAlreadyExistsException{})
+
+	svc.On("CreateLogStream",
+		&cloudwatchlogs.CreateLogStreamInput{LogGroupName: &logGroup, LogStreamName: &logStreamName}).Return(
+		new(cloudwatchlogs.CreateLogStreamOutput), nil).Once()
+
+	client := newCloudWatchLogClient(svc, logger)
+	token, err := client.CreateStream(&logGroup, &logStreamName)
+
+	svc.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, emptySequenceToken, token)
+}
+
+func TestCreateStream_LogRetention(t *testing.T) {
+	retentionInput := &cloudwatchlogs.PutRetentionPolicyInput{LogGroupName: &logGroup, RetentionInDays: aws.Int64(7)}
+
+	t.Run("created log group", func(t *testing.T) {
+		svc := new(mockCloudWatchLogsClient)
+		svc.On("CreateLogStream", mock.Anything).Return(
+			new(cloudwatchlogs.CreateLogStreamOutput), &cloudwatchlogs.ResourceNotFoundException{}).Once()
+		svc.On("CreateLogGroup", mock.Anything).Return(new(cloudwatchlogs.CreateLogGroupOutput), nil).Once()
+		svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+		svc.On("PutRetentionPolicy", retentionInput).Return(new(cloudwatchlogs.PutRetentionPolicyOutput), nil).Once()
+
+		client := newCloudWatchLogClient(svc, zap.NewNop(), WithLogRetention(7, true))
+		_, err := client.CreateStream(&logGroup, &logStreamName)
+		require.NoError(t, err)
+		// the retention of the group is not set again for its other streams
+		_, err = client.CreateStream(&logGroup, aws.String("otherStream"))
+		require.NoError(t, err)
+		svc.AssertExpectations(t)
+	})
+
+	t.Run("existing log group", func(t *testing.T) {
+		svc := new(mockCloudWatchLogsClient)
+		svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+
+		client := newCloudWatchLogClient(svc, zap.NewNop(), WithLogRetention(7, false))
+		_, err := client.CreateStream(&logGroup, &logStreamName)
+		require.NoError(t, err)
+		svc.AssertNotCalled(t, "PutRetentionPolicy", mock.Anything)
+	})
+
+	t.Run("forced on existing log group", func(t *testing.T) {
+		svc := new(mockCloudWatchLogsClient)
+		svc.On("CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{LogGroupName: &logGroup, LogStreamName: &logStreamName}).Return(
+			new(cloudwatchlogs.CreateLogStreamOutput), &cloudwatchlogs.ResourceAlreadyExistsException{})
+		svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+		svc.On("PutRetentionPolicy", retentionInput).Return(
+			new(cloudwatchlogs.PutRetentionPolicyOutput), errors.New("AccessDeniedException")).Once()
+
+		client := newCloudWatchLogClient(svc, zap.NewNop(), WithLogRetention(7, true))
+		// a failure to set the retention does not fail the creation
+		_, err := client.CreateStream(&logGroup, &logStreamName)
+		require.NoError(t, err)
+		// pushers share the reconciled groups of the client they copy
+		copied := *client
+		_, err = copied.CreateStream(&logGroup, aws.String("otherStream"))
+		require.NoError(t, err)
+		svc.AssertExpectations(t)
+	})
+}
+
+func TestCreateStream_KMSKey(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+	t.Run("created log group", func(t *testing.T) {
+		svc := new(mockCloudWatchLogsClient)
+		svc.On("CreateLogStream", mock.Anything).Return(
+			new(cloudwatchlogs.CreateLogStreamOutput), &cloudwatchlogs.ResourceNotFoundException{}).Once()
+		svc.On("CreateLogGroup", mock.Anything).Return(new(cloudwatchlogs.CreateLogGroupOutput), nil).Once()
+		svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+		svc.On("AssociateKmsKey", &cloudwatchlogs.AssociateKmsKeyInput{LogGroupName: &logGroup, KmsKeyId: &keyARN}).Return(
+			new(cloudwatchlogs.AssociateKmsKeyOutput), nil).Once()
+
+		client := newCloudWatchLogClient(svc, zap.NewNop(), WithKMSKey(keyARN))
+		_, err := client.CreateStream(&logGroup, &logStreamName)
+		require.NoError(t, err)
+		// the key of the created group is not checked for its other streams
+		_, err = client.CreateStream(&logGroup, aws.String("otherStream"))
+		require.NoError(t, err)
+		svc.AssertExpectations(t)
+	})
+
+	t.Run("existing log group", func(t *testing.T) {
+		svc := new(mockCloudWatchLogsClient)
+		svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+		svc.On("DescribeLogGroups", &cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: &logGroup}).Return(
+			&cloudwatchlogs.DescribeLogGroupsOutput{LogGroups: []*cloudwatchlogs.LogGroup{
+				{LogGroupName: aws.String(logGroup + "-other")},
+				{LogGroupName: &logGroup, KmsKeyId: aws.String("arn:aws:kms:us-east-1:123456789012:key/other")},
+			}}, nil).Once()
+
+		core, logs := observer.New(zapcore.WarnLevel)
+		client := newCloudWatchLogClient(svc, zap.New(core), WithKMSKey(keyARN), WithLogRetention(7, false))
+		_, err := client.CreateStream(&logGroup, &logStreamName)
+		require.NoError(t, err)
+		_, err = client.CreateStream(&logGroup, aws.String("otherStream"))
+		require.NoError(t, err)
+		svc.AssertExpectations(t)
+		svc.AssertNotCalled(t, "AssociateKmsKey", mock.Anything)
+		// the key of an existing log group is not replaced
+		require.Equal(t, 1, logs.Len())
+		assert.Equal(t, "The existing log group is not encrypted with the configured KMS key", logs.All()[0].Message)
+		assert.Equal(t, "arn:aws:kms:us-east-1:123456789012:key/other", logs.All()[0].ContextMap()["KmsKeyId"])
+	})
+}
+
+func TestCreateStream_LogGroupTags(t *testing.T) {
+	svc := new(mockCloudWatchLogsClient)
+	svc.On("CreateLogStream", &cloudwatchlogs.CreateLogStreamInput{LogGroupName: &logGroup, LogStreamName: &logStreamName}).Return(
+		new(cloudwatchlogs.CreateLogStreamOutput), &cloudwatchlogs.ResourceNotFoundException{}).Once()
+	svc.On("CreateLogGroup", &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: &logGroup,
+		Tags:         map[string]*string{"team": aws.String("payments"), "cost-center": aws.String("1234")},
+	}).Return(new(cloudwatchlogs.CreateLogGroupOutput), nil).Once()
+	svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+
+	client := newCloudWatchLogClient(svc, zap.NewNop(), WithLogGroupTags(map[string]string{"team": "payments", "cost-center": "1234"}))
+	_, err := client.CreateStream(&logGroup, &logStreamName)
+	require.NoError(t, err)
+	// the existing log groups are not tagged
+	_, err = client.CreateStream(aws.String("existingGroup"), &logStreamName)
+	require.NoError(t, err)
+	svc.AssertExpectations(t)
+	svc.AssertNumberOfCalls(t, "CreateLogGroup", 1)
+}
+
+// racingCloudWatchLogsClient keeps track of the created groups and streams, and holds the callers that find the
+// log group missing until all of them did, so that they all race to create the group and the stream.
+type racingCloudWatchLogsClient struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	mu           sync.Mutex
+	groups       map[string]bool
+	streams      map[string]bool
+	missingGroup sync.WaitGroup
+}
+
+func (svc *racingCloudWatchLogsClient) CreateLogGroup(input *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if svc.groups[*input.LogGroupName] {
+		return nil, &cloudwatchlogs.ResourceAlreadyExistsException{}
+	}
+	svc.groups[*input.LogGroupName] = true
+	return new(cloudwatchlogs.CreateLogGroupOutput), nil
+}
+
+func (svc *racingCloudWatchLogsClient) CreateLogStream(input *cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	svc.mu.Lock()
+	if !svc.groups[*input.LogGroupName] {
+		svc.mu.Unlock()
+		svc.missingGroup.Done()
+		svc.missingGroup.Wait()
+		return nil, &cloudwatchlogs.ResourceNotFoundException{}
+	}
+	defer svc.mu.Unlock()
+	if svc.streams[*input.LogStreamName] {
+		return nil, &cloudwatchlogs.ResourceAlreadyExistsException{}
+	}
+	svc.streams[*input.LogStreamName] = true
+	return new(cloudwatchlogs.CreateLogStreamOutput), nil
+}
+
+func TestCreateStream_ConcurrentCreation(t *testing.T) {
+	concurrency := 5
+	svc := &racingCloudWatchLogsClient{groups: map[string]bool{}, streams: map[string]bool{}}
+	svc.missingGroup.Add(concurrency)
+	client := newCloudWatchLogClient(svc, zap.NewNop())
+
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			_, err := client.CreateStream(&logGroup, &logStreamName)
+			errs <- err
+		}()
+	}
+	for i := 0; i < concurrency; i++ {
+		assert.NoError(t, <-errs)
+	}
+	assert.True(t, svc.groups[logGroup])
+	assert.True(t, svc.streams[logStreamName])
+}
+
+// slowCloudWatchLogsClient records the highest number of log streams being created at the same time
+type slowCloudWatchLogsClient struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+	created     int
+}
+
+func (svc *slowCloudWatchLogsClient) CreateLogStream(*cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	svc.lock.Lock()
+	svc.inFlight++
+	if svc.inFlight > svc.maxInFlight {
+		svc.maxInFlight = svc.inFlight
+	}
+	svc.lock.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	svc.lock.Lock()
+	svc.inFlight--
+	svc.created++
+	svc.lock.Unlock()
+	return new(cloudwatchlogs.CreateLogStreamOutput), nil
+}
+
+func TestCreateStream_MaxConcurrentCreations(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxCreations int
+		wantMax      int
+	}{
+		{name: "bounded", maxCreations: 3, wantMax: 3},
+		{name: "single", maxCreations: 1, wantMax: 1},
+		{name: "unbounded", maxCreations: 0, wantMax: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &slowCloudWatchLogsClient{}
+			client := newCloudWatchLogClient(svc, zap.NewNop(), WithMaxConcurrentCreations(tt.maxCreations))
+
+			const streams = 50
+			var wg sync.WaitGroup
+			for i := 0; i < streams; i++ {
+				wg.Add(1)
+				// the pushers hold copies of the client, which share the bound
+				go func(client Client, i int) {
+					defer wg.Done()
+					_, err := client.CreateStream(&logGroup, aws.String(fmt.Sprintf("stream-%d", i)))
+					assert.NoError(t, err)
+				}(*client, i)
+			}
+			wg.Wait()
+
+			assert.Equal(t, streams, svc.created)
+			assert.LessOrEqual(t, svc.maxInFlight, tt.wantMax)
+			if tt.maxCreations > 0 {
+				// the bound is reached, the creations are not serialized further
+				assert.Equal(t, tt.wantMax, svc.maxInFlight)
+			}
+		})
+	}
+}
+
 type UnknownError struct {
 	otherField string
 }
@@ -496,3 +823,68 @@ func TestUserAgent(t *testing.T) {
 		})
 	}
 }
+
+func TestNewClientFIPSEndpoint(t *testing.T) {
+	session, _ := session.NewSession()
+	cwlog := NewClient(zap.NewNop(), &aws.Config{Region: aws.String("us-east-1"), UseFIPSEndpoint: endpoints.FIPSEndpointStateEnabled},
+		component.BuildInfo{}, "", session)
+	logClient := cwlog.svc.(*cloudwatchlogs.CloudWatchLogs)
+	assert.Equal(t, "https://logs-fips.us-east-1.amazonaws.com", logClient.Endpoint)
+
+	cwlog = NewClient(zap.NewNop(), &aws.Config{Region: aws.String("us-east-1")}, component.BuildInfo{}, "", session)
+	logClient = cwlog.svc.(*cloudwatchlogs.CloudWatchLogs)
+	assert.Equal(t, "https://logs.us-east-1.amazonaws.com", logClient.Endpoint)
+}
+
+func TestUnhandledResponseFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	session, _ := session.NewSession()
+	cwlog := NewClient(zap.New(core), &aws.Config{}, component.BuildInfo{}, "", session)
+	logClient := cwlog.svc.(*cloudwatchlogs.CloudWatchLogs)
+
+	body := `{"nextSequenceToken":"49590302","rejectedLogEventsInfo":null,"newField":{"a":1},"emptyField":[]}`
+	output := &cloudwatchlogs.PutLogEventsOutput{}
+	req := logClient.NewRequest(&request.Operation{Name: "PutLogEvents", HTTPMethod: "POST", HTTPPath: "/"}, &cloudwatchlogs.PutLogEventsInput{}, output)
+	req.HTTPResponse = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+
+	logClient.Handlers.Unmarshal.Run(req)
+	assert.NoError(t, req.Error)
+	// the response is still unmarshalled by the SDK
+	assert.Equal(t, "49590302", *output.NextSequenceToken)
+
+	entries := logs.FilterField(zap.String("Field", "newField")).All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.Equal(t, 1, logs.Len())
+}
+
+func TestClockSkew(t *testing.T) {
+	session, _ := session.NewSession()
+	cwlog := NewClient(zap.NewNop(), &aws.Config{}, component.BuildInfo{}, "", session)
+	logClient := cwlog.svc.(*cloudwatchlogs.CloudWatchLogs)
+	assert.Equal(t, time.Duration(0), cwlog.ClockSkew())
+
+	respond := func(header http.Header) {
+		req := logClient.NewRequest(&request.Operation{Name: "PutLogEvents", HTTPMethod: "POST", HTTPPath: "/"}, &cloudwatchlogs.PutLogEventsInput{}, nil)
+		req.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Header: header}
+		logClient.Handlers.Complete.Run(req)
+	}
+
+	respond(http.Header{"Date": []string{time.Now().Add(3 * time.Hour).UTC().Format(http.TimeFormat)}})
+	// the date has a precision of a second
+	assert.InDelta(t, float64(3*time.Hour), float64(cwlog.ClockSkew()), float64(2*time.Second))
+	// the copies of the client made by the pushers share the measure
+	copied := *cwlog
+	assert.Equal(t, cwlog.ClockSkew(), copied.ClockSkew())
+
+	respond(http.Header{"Date": []string{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}})
+	assert.InDelta(t, float64(-time.Hour), float64(copied.ClockSkew()), float64(2*time.Second))
+
+	// responses without a valid date keep the last measure
+	respond(http.Header{"Date": []string{"yesterday"}})
+	assert.InDelta(t, float64(-time.Hour), float64(cwlog.ClockSkew()), float64(2*time.Second))
+}
@@ -0,0 +1,57 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwlogs // import "github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	mBatchBytes          = stats.Int64("awscloudwatchlogs_batch_bytes", "Size of the PutLogEvents requests, with the overhead of every event", stats.UnitBytes)
+	mBatchEvents         = stats.Int64("awscloudwatchlogs_batch_events", "Number of events of the PutLogEvents requests", stats.UnitDimensionless)
+	mPutLogEventsLatency = stats.Float64("awscloudwatchlogs_put_log_events_latency", "Duration of the PutLogEvents calls, retries included", stats.UnitMilliseconds)
+)
+
+// MetricViews returns the views of the histograms of the PutLogEvents requests, by the tag keys set with
+// WithMetricTags. The log stream must not be one of them, to bound the cardinality.
+func MetricViews(tagKeys ...tag.Key) []*view.View {
+	return []*view.View{
+		{
+			Name:        mBatchBytes.Name(),
+			Measure:     mBatchBytes,
+			Description: mBatchBytes.Description(),
+			TagKeys:     tagKeys,
+			// up to maxRequestPayloadBytes
+			Aggregation: view.Distribution(1024, 4096, 16384, 65536, 262144, 1048576),
+		},
+		{
+			Name:        mBatchEvents.Name(),
+			Measure:     mBatchEvents,
+			Description: mBatchEvents.Description(),
+			TagKeys:     tagKeys,
+			// up to maxRequestEventCount
+			Aggregation: view.Distribution(1, 10, 100, 1000, 10000),
+		},
+		{
+			Name:        mPutLogEventsLatency.Name(),
+			Measure:     mPutLogEventsLatency,
+			Description: mPutLogEventsLatency.Description(),
+			TagKeys:     tagKeys,
+			Aggregation: view.Distribution(10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000),
+		},
+	}
+}
@@ -15,6 +15,9 @@
 package cwlogs // import "github.com/open-telemetry/opentelemetry-collector-contrib/internal/aws/cwlogs"
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"sort"
 	"sync"
@@ -22,6 +25,8 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"go.uber.org/zap"
 )
 
@@ -36,6 +41,9 @@ const (
 
 	minPusherIntervalMs = 200 // 5 TPS
 
+	// maxRetainedBatches bounds the batches of the failed pushes kept to push them again, about 10MB
+	maxRetainedBatches = 10
+
 	truncatedSuffix = "[Truncated...]"
 
 	eventTimestampLimitInPast  = 14 * 24 * time.Hour //None of the log events in the batch can be older than 14 days
@@ -124,9 +132,11 @@ func newEventBatch(logGroupName, logStreamName *string) *eventBatch {
 	}
 }
 
+// exceedsLimit tells whether adding an event of nextByteTotal bytes, per event overhead included, would make the
+// batch exceed the PutLogEvents limits: maxRequestEventCount events, or maxRequestPayloadBytes bytes.
 func (batch eventBatch) exceedsLimit(nextByteTotal int) bool {
 	return len(batch.putLogEventsInput.LogEvents) == cap(batch.putLogEventsInput.LogEvents) ||
-		batch.byteTotal+nextByteTotal > maxEventPayloadBytes
+		batch.byteTotal+nextByteTotal > maxRequestPayloadBytes
 }
 
 // isActive checks whether the eventBatch spans more than 24 hours. Returns
@@ -157,6 +167,22 @@ func (batch *eventBatch) append(event *Event) {
 	}
 }
 
+// hash identifies the events of the batch by their timestamps and messages, in order.
+func (batch *eventBatch) hash() [sha256.Size]byte {
+	h := sha256.New()
+	var buf [8]byte
+	for _, event := range batch.putLogEventsInput.LogEvents {
+		binary.BigEndian.PutUint64(buf[:], uint64(aws.Int64Value(event.Timestamp)))
+		h.Write(buf[:])
+		binary.BigEndian.PutUint64(buf[:], uint64(len(aws.StringValue(event.Message))))
+		h.Write(buf[:])
+		h.Write([]byte(aws.StringValue(event.Message)))
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
 // Sort the log events based on the timestamp.
 func (batch *eventBatch) sortLogEvents() {
 	inputLogEvents := batch.putLogEventsInput.LogEvents
@@ -180,7 +206,7 @@ func (inputLogEvents ByTimestamp) Less(i, j int) bool {
 // Pusher is created by log group and log stream
 type Pusher interface {
 	AddLogEntry(logEvent *Event) error
-	ForceFlush() error
+	ForceFlush(ctx context.Context) error
 }
 
 // Struct of logPusher implemented Pusher interface.
@@ -198,13 +224,65 @@ type logPusher struct {
 	streamToken      string // no init value
 	svcStructuredLog Client
 	retryCnt         int
+	// sortEvents sorts the events of a batch by timestamp before pushing it
+	sortEvents bool
+	// retainFailedBatches keeps the batches of the failed pushes to push them again before the next batch
+	retainFailedBatches bool
+	// failedBatches are the batches of the failed pushes, oldest first, guarded by pushLock
+	failedBatches []*eventBatch
+	// deduplicate skips the batches identical to the last batch accepted by CloudWatch Logs
+	deduplicate bool
+	// lastAccepted is the hash of the last batch accepted by CloudWatch Logs, guarded by pushLock
+	lastAccepted *[sha256.Size]byte
+	// metricTags are the tags of the histograms of the PutLogEvents requests
+	metricTags []tag.Mutator
+}
+
+// PusherOption configures a Pusher
+type PusherOption func(*logPusher)
+
+// WithSortByTimestamp tells whether the events of a batch are sorted by timestamp before they are pushed, as
+// PutLogEvents rejects the batches out of chronological order. The sort is stable, and enabled by default; disable
+// it when the events are known to be added in order.
+func WithSortByTimestamp(sort bool) PusherOption {
+	return func(pusher *logPusher) {
+		pusher.sortEvents = sort
+	}
+}
+
+// WithRetainFailedBatches tells whether the batches of the failed pushes are kept, to push them again before the next
+// batch, until they are accepted. Disabled by default, as an exporter retrying the failed exports adds their events
+// again; enable it when the events of a failed push are not added again. The batches rejected as invalid are not kept,
+// and at most maxRetainedBatches batches are, dropping the oldest.
+func WithRetainFailedBatches(retain bool) PusherOption {
+	return func(pusher *logPusher) {
+		pusher.retainFailedBatches = retain
+	}
+}
+
+// WithDeduplication tells whether a batch identical to the last batch accepted on the log stream is skipped, e.g. when
+// an export is retried after the push of another log stream failed. The pushes which succeeded but returned an error,
+// e.g. a timeout, are recognized by CloudWatch Logs, which rejects the retried batch as already accepted. Disabled by
+// default, as the events of identical successive batches, with the same timestamps and messages, are dropped.
+func WithDeduplication(deduplicate bool) PusherOption {
+	return func(pusher *logPusher) {
+		pusher.deduplicate = deduplicate
+	}
+}
+
+// WithMetricTags sets the tags of the histograms of the PutLogEvents requests, e.g. the log group, whose keys are
+// given to MetricViews.
+func WithMetricTags(mutators ...tag.Mutator) PusherOption {
+	return func(pusher *logPusher) {
+		pusher.metricTags = mutators
+	}
 }
 
 // NewPusher creates a logPusher instance
 func NewPusher(logGroupName, logStreamName *string, retryCnt int,
-	svcStructuredLog Client, logger *zap.Logger) Pusher {
+	svcStructuredLog Client, logger *zap.Logger, opts ...PusherOption) Pusher {
 
-	pusher := newLogPusher(logGroupName, logStreamName, svcStructuredLog, logger)
+	pusher := newLogPusher(logGroupName, logStreamName, svcStructuredLog, logger, opts...)
 
 	pusher.retryCnt = defaultRetryCount
 	if retryCnt > 0 {
@@ -216,12 +294,16 @@ func NewPusher(logGroupName, logStreamName *string, retryCnt int,
 
 // Only create a logPusher, but not start the instance.
 func newLogPusher(logGroupName, logStreamName *string,
-	svcStructuredLog Client, logger *zap.Logger) *logPusher {
+	svcStructuredLog Client, logger *zap.Logger, opts ...PusherOption) *logPusher {
 	pusher := &logPusher{
 		logGroupName:     logGroupName,
 		logStreamName:    logStreamName,
 		svcStructuredLog: svcStructuredLog,
 		logger:           logger,
+		sortEvents:       true,
+	}
+	for _, opt := range opts {
+		opt(pusher)
 	}
 	pusher.logEventBatch = newEventBatch(logGroupName, logStreamName)
 
@@ -243,32 +325,84 @@ func (p *logPusher) AddLogEntry(logEvent *Event) error {
 		}
 		prevBatch := p.addLogEvent(logEvent)
 		if prevBatch != nil {
-			err = p.pushEventBatch(prevBatch)
+			err = p.pushBatches(context.Background(), prevBatch)
 		}
 	}
 	return err
 }
 
-func (p *logPusher) ForceFlush() error {
-	prevBatch := p.renewEventBatch()
-	if prevBatch != nil {
-		return p.pushEventBatch(prevBatch)
-	}
-	return nil
+// ForceFlush pushes the batches kept from the failed pushes, then the current batch. With WithRetainFailedBatches,
+// the batches not accepted are kept, and the next flush pushes them again. Cancelling the context interrupts the
+// push, and its error is returned.
+func (p *logPusher) ForceFlush(ctx context.Context) error {
+	return p.pushBatches(ctx, p.renewEventBatch())
 }
 
-func (p *logPusher) pushEventBatch(req interface{}) error {
+// pushBatches pushes the batches kept from the failed pushes, oldest first, then batch unless it is nil. It stops at
+// the first failure, keeping the batches not pushed when retainFailedBatches is set.
+func (p *logPusher) pushBatches(ctx context.Context, batch *eventBatch) error {
 	p.pushLock.Lock()
 	defer p.pushLock.Unlock()
 
+	batches := p.failedBatches
+	p.failedBatches = nil
+	if batch != nil {
+		batches = append(batches, batch)
+	}
+	for i, b := range batches {
+		err := p.pushEventBatch(ctx, b)
+		if err == nil {
+			continue
+		}
+		if p.retainFailedBatches {
+			p.retainBatches(batches[i:], err)
+		}
+		return err
+	}
+	return nil
+}
+
+// retainBatches keeps the batches of a failed push, except the batch rejected as invalid which would fail again.
+func (p *logPusher) retainBatches(batches []*eventBatch, err error) {
+	if _, ok := err.(*cloudwatchlogs.InvalidParameterException); ok {
+		p.logger.Error("logpusher: drop the log events rejected as invalid",
+			zap.Int("NumOfLogEvents", len(batches[0].putLogEventsInput.LogEvents)), zap.Error(err))
+		batches = batches[1:]
+	}
+	if dropped := len(batches) - maxRetainedBatches; dropped > 0 {
+		numOfLogEvents := 0
+		for _, b := range batches[:dropped] {
+			numOfLogEvents += len(b.putLogEventsInput.LogEvents)
+		}
+		p.logger.Warn("logpusher: drop the oldest log events of the failed pushes",
+			zap.Int("NumOfLogEvents", numOfLogEvents), zap.Int("maxRetainedBatches", maxRetainedBatches))
+		batches = batches[dropped:]
+	}
+	p.failedBatches = batches
+}
+
+// pushEventBatch pushes a batch, with pushLock held.
+func (p *logPusher) pushEventBatch(ctx context.Context, req interface{}) error {
 	// http://docs.aws.amazon.com/goto/SdkForGoV1/logs-2014-03-28/PutLogEvents
 	// The log events in the batch must be in chronological ordered by their
 	// timestamp (the time the event occurred, expressed as the number of milliseconds
 	// since Jan 1, 1970 00:00:00 UTC).
 	logEventBatch := req.(*eventBatch)
-	logEventBatch.sortLogEvents()
+	if p.sortEvents {
+		logEventBatch.sortLogEvents()
+	}
 	putLogEventsInput := logEventBatch.putLogEventsInput
 
+	var hash [sha256.Size]byte
+	if p.deduplicate {
+		hash = logEventBatch.hash()
+		if p.lastAccepted != nil && *p.lastAccepted == hash {
+			p.logger.Info("logpusher: skip the log events identical to the last accepted ones.",
+				zap.Int("NumOfLogEvents", len(putLogEventsInput.LogEvents)))
+			return nil
+		}
+	}
+
 	if p.streamToken == "" {
 		var err error
 		// log part and retry logic are already done inside the CreateStream
@@ -289,9 +423,17 @@ func (p *logPusher) pushEventBatch(req interface{}) error {
 
 	var tmpToken *string
 	var err error
-	tmpToken, err = p.svcStructuredLog.PutLogEvents(putLogEventsInput, p.retryCnt)
+	tmpToken, err = p.svcStructuredLog.PutLogEventsWithContext(ctx, putLogEventsInput, p.retryCnt)
+	_ = stats.RecordWithTags(context.Background(), p.metricTags,
+		mBatchBytes.M(int64(logEventBatch.byteTotal)),
+		mBatchEvents.M(int64(len(putLogEventsInput.LogEvents))),
+		mPutLogEventsLatency.M(float64(time.Since(startTime))/float64(time.Millisecond)))
 
 	if err != nil {
+		// the token expected by CloudWatch Logs is kept for the next batch when the retries ran out
+		if tmpToken != nil {
+			p.streamToken = *tmpToken
+		}
 		return err
 	}
 
@@ -303,9 +445,18 @@ func (p *logPusher) pushEventBatch(req interface{}) error {
 	if tmpToken != nil {
 		p.streamToken = *tmpToken
 	}
+	if p.deduplicate {
+		p.lastAccepted = &hash
+	}
 	diff := time.Since(startTime)
 	if timeLeft := minPusherIntervalMs*time.Millisecond - diff; timeLeft > 0 {
-		time.Sleep(timeLeft)
+		// the batch was accepted, the cancellation only cuts the wait short
+		timer := time.NewTimer(timeLeft)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
 	}
 	return nil
 }
@@ -15,6 +15,7 @@
 package cwlogs
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -25,9 +26,14 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"go.uber.org/zap"
 )
 
@@ -54,7 +60,7 @@ func TestConcurrentPushAndFlush(t *testing.T) {
 				emfPusher.AddLogEntry(NewEvent(current, fmt.Sprintf("batch-%d-%d", ii, j)))
 			}
 			time.Sleep(1000 * time.Millisecond)
-			emfPusher.ForceFlush()
+			emfPusher.ForceFlush(context.Background())
 			wg.Done()
 		}(i)
 	}
@@ -173,6 +179,36 @@ func TestLogEventBatch_sortLogEvents(t *testing.T) {
 //  pusher Mocks
 //
 
+func TestPusher_sequenceTokenRecovery(t *testing.T) {
+	svc := new(mockCloudWatchLogsClient)
+	svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), &cloudwatchlogs.ResourceAlreadyExistsException{})
+	var pushes []string
+	record := func(args mock.Arguments) {
+		input := args.Get(0).(*cloudwatchlogs.PutLogEventsInput)
+		pushes = append(pushes, fmt.Sprintf("%s:%s", aws.StringValue(input.SequenceToken), *input.LogEvents[0].Message))
+	}
+	// another writer advanced the sequence token of the existing stream
+	svc.On("PutLogEvents", mock.Anything).Return(new(cloudwatchlogs.PutLogEventsOutput), &cloudwatchlogs.InvalidSequenceTokenException{
+		Message_: aws.String("The given sequenceToken is invalid. The next expected sequenceToken is: 1111"),
+	}).Once().Run(record)
+	svc.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("2222")}, nil).Once().Run(record)
+	// a retried request wrote the batch already
+	svc.On("PutLogEvents", mock.Anything).Return(new(cloudwatchlogs.PutLogEventsOutput), &cloudwatchlogs.DataAlreadyAcceptedException{
+		ExpectedSequenceToken: aws.String("3333"),
+	}).Once().Run(record)
+	svc.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("4444")}, nil).Once().Run(record)
+
+	p := newLogPusher(&logGroup, &logStreamName, *newCloudWatchLogClient(svc, zap.NewNop()), zap.NewNop())
+	p.retryCnt = defaultRetryCount
+	for _, message := range []string{"first", "second", "third"} {
+		assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, message)))
+		assert.NoError(t, p.ForceFlush(context.Background()))
+	}
+	svc.AssertExpectations(t)
+	assert.Equal(t, []string{":first", "1111:first", "2222:second", "3333:third"}, pushes)
+	assert.Equal(t, "4444", p.streamToken)
+}
+
 // Need to remove the tmp state folder after testing.
 func newMockPusher() (*logPusher, string) {
 	logger := zap.NewNop()
@@ -251,6 +287,243 @@ func TestAddLogEventWithValidation(t *testing.T) {
 	p.AddLogEntry(logEvent)
 	assert.Equal(t, expectedTruncatedContent, *logEvent.InputLogEvent.Message)
 
+	// the truncated event leaves room in the batch for more events
 	logEvent = NewEvent(timestampMs, "")
-	assert.NotNil(t, p.addLogEvent(logEvent))
+	assert.Nil(t, p.addLogEvent(logEvent))
+}
+
+func TestPusher_requestEventCountLimit(t *testing.T) {
+	var requests []int
+	svc := newAlwaysPassMockLogClient(func(args mock.Arguments) {
+		requests = append(requests, len(args.Get(0).(*cloudwatchlogs.PutLogEventsInput).LogEvents))
+	})
+	p := newLogPusher(&logGroup, &logStreamName, *svc, zap.NewNop())
+
+	for i := 0; i < 15000; i++ {
+		assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, msg)))
+	}
+	assert.NoError(t, p.ForceFlush(context.Background()))
+	assert.Equal(t, []int{maxRequestEventCount, 5000}, requests)
+}
+
+func TestPusher_requestPayloadBytesLimit(t *testing.T) {
+	var requests []int
+	svc := newAlwaysPassMockLogClient(func(args mock.Arguments) {
+		input := args.Get(0).(*cloudwatchlogs.PutLogEventsInput)
+		payloadBytes := 0
+		for _, event := range input.LogEvents {
+			payloadBytes += len(*event.Message) + perEventHeaderBytes
+		}
+		assert.LessOrEqual(t, payloadBytes, maxRequestPayloadBytes)
+		requests = append(requests, len(input.LogEvents))
+	})
+	p := newLogPusher(&logGroup, &logStreamName, *svc, zap.NewNop())
+
+	// the largest events fill a request four at a time, with their overhead
+	largest := strings.Repeat("a", defaultMaxEventPayloadBytes-perEventHeaderBytes)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, largest)))
+	}
+	assert.NoError(t, p.ForceFlush(context.Background()))
+	assert.Equal(t, []int{4, 4, 2}, requests)
+
+	// a full batch has no room left for a single byte
+	requests = nil
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, largest)))
+	}
+	assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, "a")))
+	assert.NoError(t, p.ForceFlush(context.Background()))
+	assert.Equal(t, []int{4, 1}, requests)
+}
+
+func TestPusher_sortByTimestamp(t *testing.T) {
+	shuffled := []int64{5, 1, 4, 1, 3, 2}
+	tests := []struct {
+		name string
+		opts []PusherOption
+		want []int64
+	}{
+		{name: "default", want: []int64{1, 1, 2, 3, 4, 5}},
+		{name: "sorted", opts: []PusherOption{WithSortByTimestamp(true)}, want: []int64{1, 1, 2, 3, 4, 5}},
+		{name: "unsorted", opts: []PusherOption{WithSortByTimestamp(false)}, want: shuffled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pushed []int64
+			var messages []string
+			svc := newAlwaysPassMockLogClient(func(args mock.Arguments) {
+				for _, event := range args.Get(0).(*cloudwatchlogs.PutLogEventsInput).LogEvents {
+					pushed = append(pushed, *event.Timestamp-timestampMs)
+					messages = append(messages, *event.Message)
+				}
+			})
+			p := newLogPusher(&logGroup, &logStreamName, *svc, zap.NewNop(), tt.opts...)
+
+			for i, offset := range shuffled {
+				assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs+offset, fmt.Sprintf("message%d", i))))
+			}
+			assert.NoError(t, p.ForceFlush(context.Background()))
+			assert.Equal(t, tt.want, pushed)
+			if tt.name == "default" {
+				// the sort is stable
+				assert.Equal(t, []string{"message1", "message3", "message5", "message4", "message2", "message0"}, messages)
+			}
+		})
+	}
+}
+
+func TestPusher_retainFailedBatches(t *testing.T) {
+	svc := new(mockCloudWatchLogsClient)
+	svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+	var pushes [][]string
+	record := func(args mock.Arguments) {
+		input := args.Get(0).(*cloudwatchlogs.PutLogEventsInput)
+		var messages []string
+		for _, event := range input.LogEvents {
+			messages = append(messages, *event.Message)
+		}
+		pushes = append(pushes, messages)
+	}
+	svc.On("PutLogEvents", mock.Anything).Return(new(cloudwatchlogs.PutLogEventsOutput), &cloudwatchlogs.ServiceUnavailableException{}).Once().Run(record)
+	svc.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("1111")}, nil).Run(record)
+
+	p := newLogPusher(&logGroup, &logStreamName, *newCloudWatchLogClient(svc, zap.NewNop()), zap.NewNop(), WithRetainFailedBatches(true))
+	assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, "first")))
+	assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, "second")))
+	assert.Error(t, p.ForceFlush(context.Background()))
+	assert.Equal(t, "", p.streamToken)
+
+	// the failed batch is pushed again before the events added since, once
+	assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, "third")))
+	assert.NoError(t, p.ForceFlush(context.Background()))
+	assert.NoError(t, p.ForceFlush(context.Background()))
+	assert.Equal(t, [][]string{{"first", "second"}, {"first", "second"}, {"third"}}, pushes)
+	assert.Equal(t, "1111", p.streamToken)
+	assert.Empty(t, p.failedBatches)
+}
+
+func TestPusher_failedBatchesDroppedByDefault(t *testing.T) {
+	svc := new(mockCloudWatchLogsClient)
+	svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+	svc.On("PutLogEvents", mock.Anything).Return(new(cloudwatchlogs.PutLogEventsOutput), &cloudwatchlogs.ServiceUnavailableException{}).Once()
+
+	p := newLogPusher(&logGroup, &logStreamName, *newCloudWatchLogClient(svc, zap.NewNop()), zap.NewNop())
+	assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, msg)))
+	assert.Error(t, p.ForceFlush(context.Background()))
+	assert.NoError(t, p.ForceFlush(context.Background()))
+	svc.AssertNumberOfCalls(t, "PutLogEvents", 1)
+}
+
+func TestPusher_retainBatches(t *testing.T) {
+	p := newLogPusher(&logGroup, &logStreamName, Client{}, zap.NewNop(), WithRetainFailedBatches(true))
+	var batches []*eventBatch
+	for i := 0; i < maxRetainedBatches+2; i++ {
+		batches = append(batches, newEventBatch(&logGroup, &logStreamName))
+	}
+
+	p.retainBatches(batches, &cloudwatchlogs.ServiceUnavailableException{})
+	// the oldest batches are dropped
+	assert.Equal(t, batches[2:], p.failedBatches)
+
+	// the batch rejected as invalid is dropped
+	p.retainBatches(batches[:3], &cloudwatchlogs.InvalidParameterException{})
+	assert.Equal(t, batches[1:3], p.failedBatches)
+}
+
+func TestPusher_deduplication(t *testing.T) {
+	svc := new(mockCloudWatchLogsClient)
+	svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+	var pushes []string
+	record := func(args mock.Arguments) {
+		input := args.Get(0).(*cloudwatchlogs.PutLogEventsInput)
+		pushes = append(pushes, fmt.Sprintf("%s:%s", aws.StringValue(input.SequenceToken), *input.LogEvents[0].Message))
+	}
+	svc.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("1111")}, nil).Once().Run(record)
+	// the batch is written, but the response times out
+	svc.On("PutLogEvents", mock.Anything).Return(new(cloudwatchlogs.PutLogEventsOutput), awserr.New(request.ErrCodeResponseTimeout, "timeout", nil)).Once().Run(record)
+	svc.On("PutLogEvents", mock.Anything).Return(new(cloudwatchlogs.PutLogEventsOutput), &cloudwatchlogs.DataAlreadyAcceptedException{
+		ExpectedSequenceToken: aws.String("2222"),
+	}).Once().Run(record)
+	svc.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("3333")}, nil).Once().Run(record)
+
+	p := newLogPusher(&logGroup, &logStreamName, *newCloudWatchLogClient(svc, zap.NewNop()), zap.NewNop(), WithDeduplication(true))
+	push := func(message string) error {
+		assert.NoError(t, p.AddLogEntry(NewEvent(timestampMs, message)))
+		return p.ForceFlush(context.Background())
+	}
+	assert.NoError(t, push("first"))
+	assert.Error(t, push("second"))
+	// the retried batch is recognized as accepted by CloudWatch Logs, which tells the next token
+	assert.NoError(t, push("second"))
+	assert.Equal(t, "2222", p.streamToken)
+	// the batch identical to the last accepted one is not sent again
+	assert.NoError(t, push("second"))
+	assert.NoError(t, push("third"))
+
+	svc.AssertExpectations(t)
+	assert.Equal(t, []string{":first", "1111:second", "1111:second", "2222:third"}, pushes)
+	assert.Equal(t, "3333", p.streamToken)
+}
+
+func TestEventBatch_hash(t *testing.T) {
+	newBatch := func(messages ...string) *eventBatch {
+		batch := newEventBatch(&logGroup, &logStreamName)
+		for _, message := range messages {
+			batch.append(NewEvent(timestampMs, message))
+		}
+		return batch
+	}
+	assert.Equal(t, newBatch("a", "b").hash(), newBatch("a", "b").hash())
+	assert.NotEqual(t, newBatch("a", "b").hash(), newBatch("b", "a").hash())
+	// the messages are delimited
+	assert.NotEqual(t, newBatch("ab", "c").hash(), newBatch("a", "bc").hash())
+
+	other := newBatch("a")
+	other.putLogEventsInput.LogEvents[0].Timestamp = aws.Int64(timestampMs + 1)
+	assert.NotEqual(t, newBatch("a").hash(), other.hash())
+}
+
+func TestPusher_metrics(t *testing.T) {
+	groupKey := tag.MustNewKey("log_group")
+	views := MetricViews(groupKey)
+	require.NoError(t, view.Register(views...))
+	defer view.Unregister(views...)
+
+	svc := newAlwaysPassMockLogClient(func(args mock.Arguments) {})
+	p := newLogPusher(&logGroup, &logStreamName, *svc, zap.NewNop(), WithMetricTags(tag.Upsert(groupKey, logGroup)))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.AddLogEntry(NewEvent(timestampMs, msg)))
+	}
+	require.NoError(t, p.ForceFlush(context.Background()))
+
+	for _, v := range views {
+		rows, err := view.RetrieveData(v.Name)
+		require.NoError(t, err, v.Name)
+		require.Len(t, rows, 1, v.Name)
+		assert.Equal(t, []tag.Tag{{Key: groupKey, Value: logGroup}}, rows[0].Tags)
+		assert.Equal(t, int64(1), rows[0].Data.(*view.DistributionData).Count)
+	}
+	rows, _ := view.RetrieveData(mBatchEvents.Name())
+	assert.Equal(t, float64(3), rows[0].Data.(*view.DistributionData).Mean)
+	rows, _ = view.RetrieveData(mBatchBytes.Name())
+	assert.Equal(t, float64(3*(len(msg)+perEventHeaderBytes)), rows[0].Data.(*view.DistributionData).Mean)
+}
+
+func TestPusher_forceFlushCancelled(t *testing.T) {
+	svc := new(mockCloudWatchLogsClient)
+	svc.On("CreateLogStream", mock.Anything).Return(new(cloudwatchlogs.CreateLogStreamOutput), nil)
+	svc.On("PutLogEvents", mock.Anything).Return(&cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("1111")}, nil)
+
+	p := newLogPusher(&logGroup, &logStreamName, *newCloudWatchLogClient(svc, zap.NewNop()), zap.NewNop(), WithRetainFailedBatches(true))
+	require.NoError(t, p.AddLogEntry(NewEvent(timestampMs, msg)))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, p.ForceFlush(ctx))
+	svc.AssertNotCalled(t, "PutLogEvents", mock.Anything)
+
+	// the batch is pushed by the next flush
+	require.NoError(t, p.ForceFlush(context.Background()))
+	svc.AssertNumberOfCalls(t, "PutLogEvents", 1)
+	assert.Equal(t, "1111", p.streamToken)
 }